@@ -2,18 +2,110 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/server"
 )
 
 func main() {
+	planMode := flag.Bool("plan", false, "print the changes CRS would make and exit without applying them")
+	planOut := flag.String("plan-out", "", "write the plan as JSON to this file (with -plan)")
+	applyFile := flag.String("apply", "", "apply the actions from a JSON plan file and exit")
+	rollingReboot := flag.Bool("rolling-reboot", false, "drain, reboot and restore the nodes one at a time and exit")
+	rebootNodes := flag.String("reboot-nodes", "", "comma-separated nodes to reboot in this order (with -rolling-reboot), all nodes when empty")
+	listenAddress := flag.String("listen", ":9190", "address of the HTTP server for /metrics and the admin API, empty to disable")
+	flag.Parse()
+
 	srv, err := server.New()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := srv.Run(context.Background()); err != nil {
-		log.Fatal(err)
+	switch {
+	case *planMode:
+		if err := runPlan(srv, *planOut); err != nil {
+			log.Fatal(err)
+		}
+
+	case *applyFile != "":
+		if err := runApply(srv, *applyFile); err != nil {
+			log.Fatal(err)
+		}
+
+	case *rollingReboot:
+		if err := runRollingReboot(srv, *rebootNodes); err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		if err := srv.Run(context.Background(), *listenAddress); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func runPlan(srv *server.Server, planOut string) error {
+	plan, err := srv.PlanCRS()
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(plan.Table())
+
+	if planOut == "" {
+		return nil
+	}
+
+	data, err := plan.JSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	if err := os.WriteFile(planOut, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write plan to %s: %w", planOut, err)
+	}
+
+	fmt.Printf("Plan written to %s\n", planOut)
+	return nil
+}
+
+func runApply(srv *server.Server, planFile string) error {
+	data, err := os.ReadFile(planFile)
+	if err != nil {
+		return fmt.Errorf("failed to read plan %s: %w", planFile, err)
+	}
+
+	plan, err := server.ParsePlan(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(plan.Table())
+
+	return srv.RunExclusive(func() error {
+		return srv.ApplyPlan(plan)
+	})
+}
+
+func runRollingReboot(srv *server.Server, rebootNodes string) error {
+	var nodes []string
+	for _, node := range strings.Split(rebootNodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
 	}
+
+	// Interrupting stops the reboot between steps, the drain of the current node stays in place
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return srv.RunExclusive(func() error {
+		return srv.RollingReboot(ctx, nodes)
+	})
 }
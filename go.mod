@@ -5,10 +5,12 @@ go 1.25
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/hashicorp/consul/api v1.32.1
+	github.com/prometheus/client_golang v1.23.2
 	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.10.0
 	github.com/vitalvas/gokit v0.18.0
 	golang.org/x/sync v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -34,7 +36,6 @@ require (
 	golang.org/x/exp v0.0.0-20250813145105-42675adae3e6 // indirect
 	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/armon/go-metrics => github.com/hashicorp/go-metrics v0.4.1
@@ -0,0 +1,25 @@
+package consul
+
+import "fmt"
+
+const apiAuthKey = "crs/config/api/auth"
+
+type apiAuth struct {
+	Token string `json:"token"`
+}
+
+// GetAPIToken returns the bearer token of the admin API, or an empty string when none is set
+func (c *Consul) GetAPIToken() (string, error) {
+	var auth apiAuth
+
+	found, err := c.getJSON(apiAuthKey, &auth)
+	if err != nil || !found {
+		return "", err
+	}
+
+	if auth.Token == "" {
+		return "", fmt.Errorf("%s has an empty token", apiAuthKey)
+	}
+
+	return auth.Token, nil
+}
@@ -0,0 +1,83 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	autoScalerConfigPrefix = "crs/config/autoscaler/"
+	autoScalerStatePrefix  = "crs/state/autoscaler/"
+)
+
+// AutoScalerGroup defines a group of instances cloned from a template
+type AutoScalerGroup struct {
+	Name         string   `json:"name"`
+	TemplateVMID int      `json:"template_vmid"`
+	Min          int      `json:"min"`
+	Max          int      `json:"max"`
+	Desired      int      `json:"desired"`
+	Nodes        []string `json:"nodes"`
+	FullClone    bool     `json:"full_clone"`
+	Storage      string   `json:"storage"`
+	Pool         string   `json:"pool"`
+	ScaleOutCPU  float64  `json:"scale_out_cpu"`
+	ScaleInCPU   float64  `json:"scale_in_cpu"`
+	Cooldown     int      `json:"cooldown"` // Seconds between scaling decisions
+}
+
+// AutoScalerState is the scaling decision of a group, kept so that it survives a leader change
+type AutoScalerState struct {
+	Desired       int       `json:"desired"`
+	ConfigDesired int       `json:"config_desired"` // Desired count from the group definition when this state was written
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// GetAutoScalerGroups returns all instance groups stored under crs/config/autoscaler/.
+// The group name defaults to the last key segment.
+func (c *Consul) GetAutoScalerGroups() ([]AutoScalerGroup, error) {
+	pairs, _, err := c.client.KV().List(autoScalerConfigPrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from consul: %w", autoScalerConfigPrefix, err)
+	}
+
+	groups := make([]AutoScalerGroup, 0, len(pairs))
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, autoScalerConfigPrefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		var group AutoScalerGroup
+		if err := json.Unmarshal(pair.Value, &group); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		if group.Name == "" {
+			group.Name = name
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// GetAutoScalerState returns the stored state of an instance group, or nil when there is none
+func (c *Consul) GetAutoScalerState(name string) (*AutoScalerState, error) {
+	var state AutoScalerState
+
+	found, err := c.getJSON(autoScalerStatePrefix+name, &state)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// PutAutoScalerState stores the state of an instance group
+func (c *Consul) PutAutoScalerState(name string, state AutoScalerState) error {
+	return c.putJSON(autoScalerStatePrefix+name, state)
+}
@@ -0,0 +1,22 @@
+package consul
+
+const budgetConfigKey = "crs/config/budget"
+
+// BudgetConfig limits how many HA groups, HA resources and guest configs CRS changes, going over it trips the circuit breaker
+type BudgetConfig struct {
+	MaxPerCycle  int `json:"max_per_cycle"`  // Changes in one cycle, 0 disables the limit
+	MaxPerWindow int `json:"max_per_window"` // Changes within the sliding window, 0 disables the limit
+	Window       int `json:"window"`         // Length of the sliding window in seconds
+}
+
+// GetBudgetConfig returns the change budget configuration, or nil when it is not set
+func (c *Consul) GetBudgetConfig() (*BudgetConfig, error) {
+	var config BudgetConfig
+
+	found, err := c.getJSON(budgetConfigKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
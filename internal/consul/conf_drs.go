@@ -0,0 +1,22 @@
+package consul
+
+const drsConfigKey = "crs/config/drs"
+
+// DRSConfig configures the Dynamic Resource Scheduler
+type DRSConfig struct {
+	Enabled               bool `json:"enabled"`
+	Aggressiveness        int  `json:"aggressiveness"`
+	MaxMigrationsPerCycle int  `json:"max_migrations_per_cycle"`
+}
+
+// GetDRSConfig returns the DRS configuration, or nil when it is not set
+func (c *Consul) GetDRSConfig() (*DRSConfig, error) {
+	var config DRSConfig
+
+	found, err := c.getJSON(drsConfigKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
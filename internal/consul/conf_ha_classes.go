@@ -0,0 +1,21 @@
+package consul
+
+const haClassesConfigKey = "crs/config/ha-classes"
+
+// HAClass holds the HA resource limits of VMs and containers tagged crs-ha-<class>, omitted values keep the Proxmox default
+type HAClass struct {
+	MaxRestart  *int `json:"max_restart"`
+	MaxRelocate *int `json:"max_relocate"`
+}
+
+// GetHAClasses returns the HA classes by name, or nil when they are not set
+func (c *Consul) GetHAClasses() (map[string]HAClass, error) {
+	var classes map[string]HAClass
+
+	found, err := c.getJSON(haClassesConfigKey, &classes)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return classes, nil
+}
@@ -0,0 +1,52 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const maintenanceWindowPrefix = "crs/config/maintenance/"
+
+// MaintenanceWindow is a planned maintenance of a node, either weekly or on one date.
+// Times are wall clock times in the window's time zone, an end before the start ends the window on the next day.
+type MaintenanceWindow struct {
+	Name            string `json:"name"`
+	Node            string `json:"node"`
+	Weekday         string `json:"weekday"` // Weekly window, monday to sunday
+	Date            string `json:"date"`    // One-time window, YYYY-MM-DD
+	Start           string `json:"start"`   // HH:MM
+	End             string `json:"end"`     // HH:MM
+	Timezone        string `json:"timezone"`
+	PreDrainMinutes int    `json:"pre_drain_minutes"` // The node is drained this long before the window starts
+}
+
+// GetMaintenanceWindows returns all maintenance windows stored under crs/config/maintenance/.
+// The window name defaults to the last key segment.
+func (c *Consul) GetMaintenanceWindows() ([]MaintenanceWindow, error) {
+	pairs, _, err := c.client.KV().List(maintenanceWindowPrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from consul: %w", maintenanceWindowPrefix, err)
+	}
+
+	windows := make([]MaintenanceWindow, 0, len(pairs))
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, maintenanceWindowPrefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		var window MaintenanceWindow
+		if err := json.Unmarshal(pair.Value, &window); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		if window.Name == "" {
+			window.Name = name
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
@@ -0,0 +1,43 @@
+package consul
+
+const (
+	nodeClassesConfigKey = "crs/config/node-classes"
+	nodesConfigKey       = "crs/config/nodes"
+)
+
+// NodeClass is a capacity class of Proxmox nodes, like hosts of the same size
+type NodeClass struct {
+	Weight int `json:"weight"` // Relative capacity of the nodes in the class, 1-100
+}
+
+// NodeAttributes describe a Proxmox node to the scheduler
+type NodeAttributes struct {
+	Class    string `json:"class"`    // Capacity class, its weight applies unless the node has its own
+	Weight   int    `json:"weight"`   // Relative capacity, 1-100, 0 uses the class weight or 1
+	Rack     string `json:"rack"`     // Informational, reported in the node metrics
+	Excluded bool   `json:"excluded"` // CRS places no guests on the node and never shuts it down
+}
+
+// GetNodeClasses returns the node capacity classes by name, or nil when they are not set
+func (c *Consul) GetNodeClasses() (map[string]NodeClass, error) {
+	var classes map[string]NodeClass
+
+	found, err := c.getJSON(nodeClassesConfigKey, &classes)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return classes, nil
+}
+
+// GetNodeAttributes returns the attributes of the Proxmox nodes by node name, or nil when they are not set
+func (c *Consul) GetNodeAttributes() (map[string]NodeAttributes, error) {
+	var nodes map[string]NodeAttributes
+
+	found, err := c.getJSON(nodesConfigKey, &nodes)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,23 @@
+package consul
+
+const poolsConfigKey = "crs/config/pools"
+
+// PoolPolicy places the guests of a Proxmox pool in a dedicated crs-pool-<name> HA group
+type PoolPolicy struct {
+	AllowedNodes   []string `json:"allowed_nodes"`    // Nodes the guests may run on, all nodes when empty
+	PreferredNodes []string `json:"preferred_nodes"`  // Nodes HA runs the guests on while they are available
+	HAClass        string   `json:"ha_class"`         // HA class of guests without a crs-ha-<class> tag
+	MaxMemoryShare float64  `json:"max_memory_share"` // Share of the cluster memory the running guests may use, 0 for no limit
+}
+
+// GetPoolPolicies returns the pool policies by pool name, or nil when they are not set
+func (c *Consul) GetPoolPolicies() (map[string]PoolPolicy, error) {
+	var policies map[string]PoolPolicy
+
+	found, err := c.getJSON(poolsConfigKey, &policies)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,25 @@
+package consul
+
+const powerConfigKey = "crs/config/power"
+
+// PowerConfig configures power management, which shuts down spare nodes while the cluster is idle
+type PowerConfig struct {
+	Enabled        bool     `json:"enabled"`
+	MinOnlineNodes int      `json:"min_online_nodes"`
+	LowLoad        float64  `json:"low_load"`  // A node is shut down when the others stay below this load with one of them failed
+	HighLoad       float64  `json:"high_load"` // A node is woken when the online nodes exceed this load with one of them failed
+	Cooldown       int      `json:"cooldown"`  // Seconds between the end of a power change and the next shutdown
+	Nodes          []string `json:"nodes"`     // Nodes that may be shut down, all nodes when empty
+}
+
+// GetPowerConfig returns the power management configuration, or nil when it is not set
+func (c *Consul) GetPowerConfig() (*PowerConfig, error) {
+	var config PowerConfig
+
+	found, err := c.getJSON(powerConfigKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,26 @@
+package consul
+
+// SchedulerConfigKey holds the scheduler tunables, CRS watches it and reloads on every change
+const SchedulerConfigKey = "crs/config/scheduler"
+
+// SchedulerConfig holds the runtime tunables of the reconcile loop, omitted values keep their defaults
+type SchedulerConfig struct {
+	PeriodicSeconds        int `json:"periodic_seconds"`
+	APIRateLimitMillis     int `json:"api_rate_limit_ms"`
+	MaxNodePriority        int `json:"max_node_priority"`
+	CDROMDetachUptimeHours int `json:"cdrom_detach_uptime_hours"`
+	HAStateMaxAttempts     int `json:"ha_state_max_attempts"`
+	HAStateWaitSeconds     int `json:"ha_state_wait_seconds"`
+}
+
+// GetSchedulerConfig returns the scheduler tunables, or nil when they are not set
+func (c *Consul) GetSchedulerConfig() (*SchedulerConfig, error) {
+	var config SchedulerConfig
+
+	found, err := c.getJSON(SchedulerConfigKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,22 @@
+package consul
+
+const startupTiersConfigKey = "crs/config/startup-tiers"
+
+// StartupTier holds the Proxmox startup setting of VMs and containers tagged crs-tier-<tier>
+type StartupTier struct {
+	Order int `json:"order"` // Boot order, lower orders start first and shut down last
+	Up    int `json:"up"`    // Seconds to wait after starting a guest before the next order, 0 for none
+	Down  int `json:"down"`  // Seconds to wait for a guest to shut down, 0 for the Proxmox default
+}
+
+// GetStartupTiers returns the startup tiers by name, or nil when they are not set
+func (c *Consul) GetStartupTiers() (map[string]StartupTier, error) {
+	var tiers map[string]StartupTier
+
+	found, err := c.getJSON(startupTiersConfigKey, &tiers)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return tiers, nil
+}
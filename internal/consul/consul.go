@@ -9,8 +9,11 @@ type Consul struct {
 }
 
 func New() (*Consul, error) {
-	consulConfig := api.DefaultConfig()
+	return NewWithConfig(api.DefaultConfig())
+}
 
+// NewWithConfig returns a client of the Consul agent described by consulConfig
+func NewWithConfig(consulConfig *api.Config) (*Consul, error) {
 	client, err := api.NewClient(consulConfig)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,200 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	eventsPrefix  = "crs/events/"
+	eventsConfKey = "crs/config/events"
+)
+
+// Event results
+const (
+	EventResultApplied       = "applied"
+	EventResultFailed        = "failed"
+	EventResultTaskSucceeded = "task-succeeded"
+	EventResultTaskFailed    = "task-failed"
+	EventResultTaskTimedOut  = "task-timed-out"
+	EventResultTaskExpired   = "task-expired"
+	EventResultInvalid       = "invalid"
+)
+
+// Event is a journal entry of a change CRS made to the cluster
+type Event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Resource string    `json:"resource"`
+	ID       string    `json:"id"`
+	Node     string    `json:"node,omitempty"`
+	VMID     int       `json:"vmid,omitempty"`
+	Before   string    `json:"before,omitempty"`
+	After    string    `json:"after,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	UPID     string    `json:"upid,omitempty"`
+}
+
+// EventFilter selects journal entries, zero values match everything
+type EventFilter struct {
+	VMID     int
+	Resource string
+	Kind     string
+	Limit    int // Newest entries to return, 0 returns all
+}
+
+// EventsConfig configures the retention of the event journal
+type EventsConfig struct {
+	RetentionHours int `json:"retention_hours"`
+	MaxEvents      int `json:"max_events"`
+}
+
+// Match reports whether the event passes the filter
+func (f EventFilter) Match(event Event) bool {
+	switch {
+	case f.VMID != 0 && event.VMID != f.VMID:
+		return false
+	case f.Resource != "" && event.Resource != f.Resource:
+		return false
+	case f.Kind != "" && event.Kind != f.Kind:
+		return false
+	}
+
+	return true
+}
+
+// eventSequence tells apart the events this process records with the same time
+var eventSequence atomic.Uint32
+
+// eventKey returns the journal key of an event. Keys sort in time order, and events with the same time
+// in the order they were recorded.
+func eventKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d-%010d", eventsPrefix, t.UnixNano(), eventSequence.Add(1))
+}
+
+// eventKeyTime returns the time encoded in a journal key. Keys written before the sequence was added have no suffix.
+func eventKeyTime(key string) (time.Time, bool) {
+	timestamp, sequence, found := strings.Cut(strings.TrimPrefix(key, eventsPrefix), "-")
+	if found {
+		if _, err := strconv.ParseUint(sequence, 10, 32); err != nil {
+			return time.Time{}, false
+		}
+	}
+
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+// expiredEventKeys returns the keys older than maxAge and the oldest keys beyond maxEvents.
+// Keys that do not belong to the journal are never returned.
+func expiredEventKeys(keys []string, now time.Time, maxAge time.Duration, maxEvents int) []string {
+	valid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := eventKeyTime(key); ok {
+			valid = append(valid, key)
+		}
+	}
+	sort.Strings(valid)
+
+	var expired []string
+	for i, key := range valid {
+		created, _ := eventKeyTime(key)
+		if now.Sub(created) > maxAge || len(valid)-i > maxEvents {
+			expired = append(expired, key)
+		}
+	}
+
+	return expired
+}
+
+// GetEventsConfig returns the journal retention settings, or nil when they are not set
+func (c *Consul) GetEventsConfig() (*EventsConfig, error) {
+	var config EventsConfig
+
+	found, err := c.getJSON(eventsConfKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// PutEvent appends an event to the journal
+func (c *Consul) PutEvent(event Event) error {
+	return c.putJSON(eventKey(event.Time), event)
+}
+
+// ListEvents returns the journal entries matching the filter, newest first
+func (c *Consul) ListEvents(filter EventFilter) ([]Event, error) {
+	pairs, _, err := c.client.KV().List(eventsPrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events from consul: %w", err)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key > pairs[j].Key
+	})
+
+	events := []Event{}
+	for _, pair := range pairs {
+		var event Event
+		if err := json.Unmarshal(pair.Value, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		if !filter.Match(event) {
+			continue
+		}
+
+		events = append(events, event)
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+	}
+
+	return events, nil
+}
+
+// PruneEvents deletes journal entries older than maxAge and the oldest entries beyond maxEvents.
+// It returns the number of deleted entries.
+func (c *Consul) PruneEvents(maxAge time.Duration, maxEvents int) (int, error) {
+	keys, _, err := c.client.KV().Keys(eventsPrefix, "", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list event keys from consul: %w", err)
+	}
+
+	expired := expiredEventKeys(keys, time.Now(), maxAge, maxEvents)
+
+	// Consul transactions are limited to 64 operations
+	for start := 0; start < len(expired); start += 64 {
+		end := min(start+64, len(expired))
+
+		ops := make(api.TxnOps, 0, end-start)
+		for _, key := range expired[start:end] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVDelete, Key: key}})
+		}
+
+		ok, _, _, err := c.client.Txn().Txn(ops, nil)
+		if err != nil {
+			return start, fmt.Errorf("failed to delete events from consul: %w", err)
+		}
+		if !ok {
+			return start, fmt.Errorf("consul rejected the event deletion")
+		}
+	}
+
+	return len(expired), nil
+}
@@ -0,0 +1,104 @@
+package consul
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventKey(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC)
+
+	key := eventKey(created)
+	assert.Regexp(t, `^crs/events/01714564800000000042-[0-9]{10}$`, key)
+
+	parsed, ok := eventKeyTime(key)
+	assert.True(t, ok)
+	assert.True(t, created.Equal(parsed))
+
+	parsed, ok = eventKeyTime("crs/events/01714564800000000042")
+	assert.True(t, ok, "keys without a sequence are still valid")
+	assert.True(t, created.Equal(parsed))
+
+	_, ok = eventKeyTime("crs/events/not-a-time")
+	assert.False(t, ok)
+
+	_, ok = eventKeyTime("crs/events/01714564800000000042-x")
+	assert.False(t, ok)
+
+	assert.Less(t, eventKey(created), eventKey(created.Add(time.Second)), "keys sort in time order")
+	assert.Less(t, eventKey(created), eventKey(created), "keys with the same time sort in recording order")
+}
+
+func TestPutEventSameTime(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string]bool)
+
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPut {
+			stored[strings.TrimPrefix(r.URL.Path, "/v1/kv/")] = true
+		}
+		w.Write([]byte("true"))
+	}))
+	defer consulServer.Close()
+
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+	c := &Consul{client: client}
+
+	// Changes made in one step share the time they were decided at
+	now := time.Now()
+	require.NoError(t, c.PutEvent(Event{Time: now, Kind: "update", Resource: "node", ID: "pve1"}))
+	require.NoError(t, c.PutEvent(Event{Time: now, Kind: "update", Resource: "node", ID: "pve2"}))
+
+	assert.Len(t, stored, 2, "the second event must not overwrite the first")
+}
+
+func TestExpiredEventKeys(t *testing.T) {
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	keys := []string{
+		eventKey(now.Add(-time.Hour)),
+		eventKey(now.Add(-48 * time.Hour)),
+		eventKey(now.Add(-2 * time.Hour)),
+		eventKey(now.Add(-3 * time.Hour)),
+		"crs/events/garbage",
+	}
+
+	assert.Equal(t, []string{keys[1]}, expiredEventKeys(keys, now, 24*time.Hour, 10), "older than the retention")
+	assert.Equal(t, []string{keys[1], keys[3]}, expiredEventKeys(keys, now, 72*time.Hour, 2), "oldest beyond the limit")
+	assert.Empty(t, expiredEventKeys(keys, now, 72*time.Hour, 10))
+	assert.Empty(t, expiredEventKeys(nil, now, time.Hour, 10))
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	event := Event{Kind: "migrate", Resource: "vm", VMID: 100}
+
+	tests := []struct {
+		name     string
+		filter   EventFilter
+		expected bool
+	}{
+		{name: "empty filter", filter: EventFilter{}, expected: true},
+		{name: "same VM", filter: EventFilter{VMID: 100}, expected: true},
+		{name: "other VM", filter: EventFilter{VMID: 101}, expected: false},
+		{name: "same resource and kind", filter: EventFilter{Resource: "vm", Kind: "migrate"}, expected: true},
+		{name: "other resource", filter: EventFilter{Resource: "ha-group"}, expected: false},
+		{name: "other kind", filter: EventFilter{Kind: "update"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.Match(event))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// getJSON decodes the JSON document stored at key into v.
+// It returns false without error when the key does not exist.
+func (c *Consul) getJSON(key string, v interface{}) (bool, error) {
+	pair, _, err := c.client.KV().Get(key, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s from consul: %w", key, err)
+	}
+
+	if pair == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(pair.Value, v); err != nil {
+		return false, fmt.Errorf("failed to unmarshal %s: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// putJSON stores v as a JSON document at key
+func (c *Consul) putJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", key, err)
+	}
+
+	if _, err := c.client.KV().Put(&api.KVPair{Key: key, Value: data}, nil); err != nil {
+		return fmt.Errorf("failed to put %s to consul: %w", key, err)
+	}
+
+	return nil
+}
+
+// WatchKey calls onChange every time the value of key changes, including its creation and deletion,
+// until ctx is cancelled. Errors are retried after retryInterval.
+func (c *Consul) WatchKey(ctx context.Context, key string, retryInterval time.Duration, onChange func()) {
+	var lastIndex uint64
+	var lastValue []byte
+	var initialized bool
+
+	for ctx.Err() == nil {
+		options := (&api.QueryOptions{WaitIndex: lastIndex}).WithContext(ctx)
+
+		pair, meta, err := c.client.KV().Get(key, options)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		// The index can go backwards after a Consul snapshot restore, start over in that case
+		if meta.LastIndex < lastIndex {
+			lastIndex = 0
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		var value []byte
+		if pair != nil {
+			value = pair.Value
+		}
+
+		if initialized && !bytes.Equal(value, lastValue) {
+			onChange()
+		}
+
+		lastValue = value
+		initialized = true
+	}
+}
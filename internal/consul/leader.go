@@ -24,17 +24,30 @@ func (c *Consul) GetLeader(key string, interval int) (bool, string, error) {
 		Session: sessionID,
 	}, nil)
 	if err != nil {
+		c.destroySession(sessionID)
 		return false, "", err
 	}
 
+	if !isLeader {
+		// Sessions that did not win the lock are useless, don't leave them behind until the TTL expires
+		c.destroySession(sessionID)
+		return false, "", nil
+	}
+
 	return isLeader, sessionID, nil
 }
 
-func (c *Consul) RenewLeader(sessionID string, interval int) error {
+// RenewLeader keeps the leader session alive until doneCh is closed or the session can not be renewed anymore.
+// Closing doneCh destroys the session, which releases the leader key for standby instances.
+func (c *Consul) RenewLeader(sessionID string, interval int, doneCh <-chan struct{}) error {
 	return c.client.Session().RenewPeriodic(
 		fmt.Sprintf("%ds", interval),
 		sessionID,
 		nil,
-		nil,
+		doneCh,
 	)
 }
+
+func (c *Consul) destroySession(sessionID string) {
+	_, _ = c.client.Session().Destroy(sessionID, nil)
+}
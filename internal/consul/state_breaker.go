@@ -0,0 +1,40 @@
+package consul
+
+import (
+	"fmt"
+	"time"
+)
+
+const breakerStateKey = "crs/state/breaker"
+
+// BreakerState records why the circuit breaker tripped, it halts automation until an operator acknowledges it
+type BreakerState struct {
+	TrippedAt time.Time `json:"tripped_at"`
+	Reason    string    `json:"reason"`
+}
+
+// GetBreakerState returns the tripped circuit breaker, or nil when it is not tripped
+func (c *Consul) GetBreakerState() (*BreakerState, error) {
+	var state BreakerState
+
+	found, err := c.getJSON(breakerStateKey, &state)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// PutBreakerState stores the tripped circuit breaker, so every CRS instance honours it after a leader change
+func (c *Consul) PutBreakerState(state BreakerState) error {
+	return c.putJSON(breakerStateKey, state)
+}
+
+// DeleteBreakerState resets the circuit breaker
+func (c *Consul) DeleteBreakerState() error {
+	if _, err := c.client.KV().Delete(breakerStateKey, nil); err != nil {
+		return fmt.Errorf("failed to delete %s from consul: %w", breakerStateKey, err)
+	}
+
+	return nil
+}
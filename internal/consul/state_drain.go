@@ -0,0 +1,115 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	drainStatePrefix = "crs/state/drain/"
+	drainConfigKey   = "crs/config/drain"
+)
+
+// Drain statuses
+const (
+	DrainStatusDraining = "draining"
+	DrainStatusDrained  = "drained"
+
+	// A drain with Restore set has ended and displaced VMs return to the node
+	DrainStatusRestoring = "restoring"
+)
+
+// DrainConfig limits the migrations a node drain runs at the same time
+type DrainConfig struct {
+	MaxPerSource int `json:"max_per_source"`
+	MaxPerTarget int `json:"max_per_target"`
+}
+
+// DrainMigration is a migration started by a node drain whose task has not finished yet
+type DrainMigration struct {
+	VMID      int       `json:"vmid"`
+	Type      string    `json:"type"` // Cluster resource type, qemu or lxc
+	Name      string    `json:"name"`
+	Target    string    `json:"target"`
+	UPID      string    `json:"upid"`
+	Online    bool      `json:"online"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// DrainBlocker is a guest that keeps a node from being drained
+type DrainBlocker struct {
+	VMID   int    `json:"vmid"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// DrainState is the progress of a node drain requested through the admin API, by a maintenance window or a rolling reboot
+type DrainState struct {
+	Node        string           `json:"node"`
+	Status      string           `json:"status"`
+	Origin      string           `json:"origin,omitempty"`      // What requested the drain, like the admin API or a maintenance window
+	Window      string           `json:"window,omitempty"`      // Maintenance window that requested the drain
+	Restore     bool             `json:"restore,omitempty"`     // Displaced VMs return to the node when the drain ends
+	Maintenance bool             `json:"maintenance,omitempty"` // CRS put the node into HA maintenance for the drain
+	RequestedAt time.Time        `json:"requested_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	Migrated    int              `json:"migrated"`
+	Failures    map[int]int      `json:"failures,omitempty"` // Failed migration attempts by VMID
+	InFlight    []DrainMigration `json:"in_flight"`
+	Blockers    []DrainBlocker   `json:"blockers"`
+	Displaced   []DrainMigration `json:"displaced,omitempty"` // Prefer-group VMs moved by a drain with Restore set
+}
+
+// GetDrainConfig returns the drain limits, or nil when they are not set
+func (c *Consul) GetDrainConfig() (*DrainConfig, error) {
+	var config DrainConfig
+
+	found, err := c.getJSON(drainConfigKey, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// GetDrainStates returns the state of every node drain stored under crs/state/drain/
+func (c *Consul) GetDrainStates() ([]DrainState, error) {
+	pairs, _, err := c.client.KV().List(drainStatePrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from consul: %w", drainStatePrefix, err)
+	}
+
+	states := make([]DrainState, 0, len(pairs))
+	for _, pair := range pairs {
+		node := strings.TrimPrefix(pair.Key, drainStatePrefix)
+		if node == "" || strings.Contains(node, "/") {
+			continue
+		}
+
+		var state DrainState
+		if err := json.Unmarshal(pair.Value, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		state.Node = node
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// PutDrainState stores the state of a node drain
+func (c *Consul) PutDrainState(state DrainState) error {
+	return c.putJSON(drainStatePrefix+state.Node, state)
+}
+
+// DeleteDrainState removes the state of a node drain
+func (c *Consul) DeleteDrainState(node string) error {
+	if _, err := c.client.KV().Delete(drainStatePrefix+node, nil); err != nil {
+		return fmt.Errorf("failed to delete %s%s from consul: %w", drainStatePrefix, node, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const migrationStatePrefix = "crs/state/migration/"
+
+// MigrationState is a migration started by the maintenance evacuation, DRS, the affinity correction or a drain restore
+// whose task has not finished yet. Node drains track their own migrations in DrainState.
+type MigrationState struct {
+	VMID      int       `json:"vmid"`
+	Type      string    `json:"type"` // Cluster resource type, qemu or lxc
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	UPID      string    `json:"upid"`
+	Online    bool      `json:"online"`
+	WithDisks bool      `json:"with_disks,omitempty"`
+	Rehome    bool      `json:"rehome,omitempty"` // The guest moves to the prefer group of its new node once it arrived
+	Reason    string    `json:"reason"`
+	Attempts  int       `json:"attempts"`
+	TimedOut  bool      `json:"timed_out,omitempty"` // The migration outlived its timeout and was reported
+	StartedAt time.Time `json:"started_at"`
+}
+
+// GetMigrationStates returns every migration in flight stored under crs/state/migration/
+func (c *Consul) GetMigrationStates() ([]MigrationState, error) {
+	pairs, _, err := c.client.KV().List(migrationStatePrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from consul: %w", migrationStatePrefix, err)
+	}
+
+	states := make([]MigrationState, 0, len(pairs))
+	for _, pair := range pairs {
+		vmid, err := strconv.Atoi(strings.TrimPrefix(pair.Key, migrationStatePrefix))
+		if err != nil {
+			continue
+		}
+
+		var state MigrationState
+		if err := json.Unmarshal(pair.Value, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		state.VMID = vmid
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// PutMigrationState stores a migration in flight
+func (c *Consul) PutMigrationState(state MigrationState) error {
+	return c.putJSON(migrationStatePrefix+strconv.Itoa(state.VMID), state)
+}
+
+// DeleteMigrationState removes a migration that is no longer in flight
+func (c *Consul) DeleteMigrationState(vmid int) error {
+	if _, err := c.client.KV().Delete(migrationStatePrefix+strconv.Itoa(vmid), nil); err != nil {
+		return fmt.Errorf("failed to delete %s%d from consul: %w", migrationStatePrefix, vmid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package consul
+
+import "time"
+
+const pauseStateKey = "crs/state/pause"
+
+// PauseState records whether reconciliation was paused through the admin API
+type PauseState struct {
+	Paused bool      `json:"paused"`
+	Since  time.Time `json:"since"`
+}
+
+// GetPauseState returns the stored pause state, or nil when there is none
+func (c *Consul) GetPauseState() (*PauseState, error) {
+	var state PauseState
+
+	found, err := c.getJSON(pauseStateKey, &state)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// PutPauseState stores the pause state, so every CRS instance honours it after a leader change
+func (c *Consul) PutPauseState(state PauseState) error {
+	return c.putJSON(pauseStateKey, state)
+}
@@ -0,0 +1,64 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const powerStatePrefix = "crs/state/power/"
+
+// Power statuses of a node shut down by power management
+const (
+	PowerStatusShuttingDown = "shutting-down"
+	PowerStatusStandby      = "standby"
+	PowerStatusWaking       = "waking"
+)
+
+// PowerState is a node that power management shut down, or is shutting down or waking
+type PowerState struct {
+	Node      string    `json:"node"`
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// GetPowerStates returns the power state of every node stored under crs/state/power/
+func (c *Consul) GetPowerStates() ([]PowerState, error) {
+	pairs, _, err := c.client.KV().List(powerStatePrefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from consul: %w", powerStatePrefix, err)
+	}
+
+	states := make([]PowerState, 0, len(pairs))
+	for _, pair := range pairs {
+		node := strings.TrimPrefix(pair.Key, powerStatePrefix)
+		if node == "" || strings.Contains(node, "/") {
+			continue
+		}
+
+		var state PowerState
+		if err := json.Unmarshal(pair.Value, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", pair.Key, err)
+		}
+
+		state.Node = node
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// PutPowerState stores the power state of a node
+func (c *Consul) PutPowerState(state PowerState) error {
+	return c.putJSON(powerStatePrefix+state.Node, state)
+}
+
+// DeletePowerState removes the power state of a node
+func (c *Consul) DeletePowerState(node string) error {
+	if _, err := c.client.KV().Delete(powerStatePrefix+node, nil); err != nil {
+		return fmt.Errorf("failed to delete %s%s from consul: %w", powerStatePrefix, node, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "crs"
+
+// Result label values
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Migration label values
+const (
+	MigrationStarted   = "started"
+	MigrationSucceeded = "succeeded"
+	MigrationFailed    = "failed"
+)
+
+var (
+	CycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of a complete SetupCRS reconciliation cycle.",
+		Buckets:   []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+
+	StepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "step_duration_seconds",
+		Help:      "Duration of a SetupCRS step.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 1800},
+	}, []string{"step"})
+
+	StepRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "step_runs_total",
+		Help:      "SetupCRS step runs by result.",
+	}, []string{"step", "result"})
+
+	HAResourceChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ha_resource_changes_total",
+		Help:      "HA resources created, updated and deleted by CRS.",
+	}, []string{"operation"})
+
+	Migrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "migrations_total",
+		Help:      "Migrations started by CRS and their outcome, by guest type.",
+	}, []string{"type", "result"})
+
+	GroupGuests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "group_guests",
+		Help:      "VMs and containers per CRS HA group.",
+	}, []string{"group"})
+
+	PoolMemoryShare = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_memory_share",
+		Help:      "Share of the cluster memory assigned to the running guests of each pool with a policy.",
+	}, []string{"pool"})
+
+	NodeWeight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_weight",
+		Help:      "Weight of each node used for prefer group priorities, migration targets and balancing, with its class and rack.",
+	}, []string{"node", "class", "rack"})
+
+	NodeExcluded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_excluded",
+		Help:      "Whether a node is excluded from CRS placement.",
+	}, []string{"node"})
+
+	CriticalNotStarted = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "critical_guests_not_started",
+		Help:      "Guests with the crs-critical tag whose HA state is not started.",
+	})
+
+	StartupDiverged = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "startup_diverged_guests",
+		Help:      "Guests whose startup setting diverges from their startup tier, crs-critical tag or VM policy after the last cycle, by reason.",
+	}, []string{"reason"})
+
+	HealthGateHeld = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "health_gate_held",
+		Help:      "1 while an unhealthy cluster keeps CRS from making changes.",
+	})
+
+	BreakerTripped = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_tripped",
+		Help:      "1 while the tripped circuit breaker keeps CRS from making changes.",
+	})
+
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxmox_request_duration_seconds",
+		Help:      "Latency of Proxmox API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+
+	APIRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxmox_request_errors_total",
+		Help:      "Failed Proxmox API requests, including non-2xx responses.",
+	}, []string{"method", "endpoint"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveAPIRequest records the latency and outcome of a Proxmox API request
+func ObserveAPIRequest(method, endpoint string, duration time.Duration, failed bool) {
+	label := EndpointLabel(endpoint)
+
+	APIRequestDuration.WithLabelValues(method, label).Observe(duration.Seconds())
+	if failed {
+		APIRequestErrors.WithLabelValues(method, label).Inc()
+	}
+}
+
+// EndpointLabel replaces node names, IDs and other variable path segments of an API endpoint
+// with placeholders, so that the endpoint label has a bounded number of values.
+// Example: "nodes/pve1/qemu/100/status/start" becomes "nodes/{node}/qemu/{vmid}/status/start".
+func EndpointLabel(endpoint string) string {
+	endpoint, _, _ = strings.Cut(endpoint, "?")
+	segments := strings.Split(strings.Trim(endpoint, "/"), "/")
+
+	for i, segment := range segments {
+		var previous string
+		if i > 0 {
+			previous = segments[i-1]
+		}
+
+		switch {
+		case strings.HasPrefix(segment, "UPID:"):
+			segments[i] = "{upid}"
+		case isNumeric(segment):
+			segments[i] = "{vmid}"
+		case previous == "nodes":
+			segments[i] = "{node}"
+		case previous == "storage":
+			segments[i] = "{storage}"
+		case previous == "snapshot":
+			segments[i] = "{snapshot}"
+		case previous == "resources" && i > 1 && segments[i-2] == "ha":
+			segments[i] = "{sid}"
+		case previous == "groups" && i > 1 && segments[i-2] == "ha":
+			segments[i] = "{group}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isNumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
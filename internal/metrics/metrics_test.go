@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{"cluster/resources", "cluster/resources"},
+		{"nodes", "nodes"},
+		{"nodes/pve1/qemu/100/status/start", "nodes/{node}/qemu/{vmid}/status/start"},
+		{"nodes/pve1/lxc/200/snapshot/before-upgrade", "nodes/{node}/lxc/{vmid}/snapshot/{snapshot}"},
+		{"nodes/pve1/tasks/UPID:pve1:00001234:qmigrate:100:root@pam:/log?start=0&limit=1000", "nodes/{node}/tasks/{upid}/log"},
+		{"cluster/ha/resources/vm:100", "cluster/ha/resources/{sid}"},
+		{"cluster/ha/groups/crs-vm-pin-pve1", "cluster/ha/groups/{group}"},
+		{"storage/ceph", "storage/{storage}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			assert.Equal(t, tt.expected, EndpointLabel(tt.endpoint))
+		})
+	}
+}
+
+func TestObserveAPIRequest(t *testing.T) {
+	before := testutil.ToFloat64(APIRequestErrors.WithLabelValues("GET", "nodes/{node}/qemu"))
+
+	ObserveAPIRequest("GET", "nodes/pve1/qemu", 10*time.Millisecond, false)
+	ObserveAPIRequest("GET", "nodes/pve2/qemu", 10*time.Millisecond, true)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(APIRequestErrors.WithLabelValues("GET", "nodes/{node}/qemu")))
+}
@@ -11,8 +11,10 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
 )
 
 type Client struct {
@@ -76,7 +78,11 @@ func (c *Client) buildURL(endpoint string) string {
 		return ""
 	}
 
+	// Endpoints may carry a query string, e.g. "nodes/pve1/tasks/<upid>/log?limit=500"
+	endpoint, query, _ := strings.Cut(endpoint, "?")
+
 	u.Path = path.Join(u.Path, "api2/json", endpoint)
+	u.RawQuery = query
 	return u.String()
 }
 
@@ -119,6 +125,12 @@ func (c *Client) makeRequest(method, endpoint string, body io.Reader, auth bool)
 		return nil, fmt.Errorf("failed to build URL for endpoint: %s", endpoint)
 	}
 
+	started := time.Now()
+	failed := true
+	defer func() {
+		metrics.ObserveAPIRequest(method, endpoint, time.Since(started), failed)
+	}()
+
 	// Read body for logging and create a new reader
 	var bodyBytes []byte
 	var requestBody io.Reader
@@ -210,6 +222,7 @@ func (c *Client) makeRequest(method, endpoint string, body io.Reader, auth bool)
 	}
 
 	logging.Debugf("=== END REQUEST ===")
+	failed = false
 	return resp, nil
 }
 
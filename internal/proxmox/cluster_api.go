@@ -10,14 +10,55 @@ import (
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
 )
 
+// GetClusterStatus returns the cluster entry of the cluster status. Proxmox returns a list of the cluster
+// and its nodes, a single cluster object is accepted as well. A standalone node has no cluster entry and is quorate.
 func (c *Client) GetClusterStatus() (*ClusterStatus, error) {
-	var status ClusterStatus
-	if err := c.Get("cluster/status", &status); err != nil {
+	var data json.RawMessage
+	if err := c.Get("cluster/status", &data); err != nil {
 		return nil, fmt.Errorf("failed to get cluster status: %w", err)
 	}
 
-	logging.Debug("Retrieved cluster status")
-	return &status, nil
+	var status ClusterStatus
+	if trimmed := strings.TrimSpace(string(data)); !strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &status); err != nil {
+			return nil, fmt.Errorf("failed to parse cluster status: %w", err)
+		}
+
+		logging.Debug("Retrieved cluster status")
+		return &status, nil
+	}
+
+	// The cluster entry of the list carries the config version as a number
+	var entries []struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Nodes    int    `json:"nodes"`
+		Quorate  int    `json:"quorate"`
+		Expected int    `json:"expected_votes"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster status: %w", err)
+	}
+
+	nodes := 0
+	for _, entry := range entries {
+		switch entry.Type {
+		case "cluster":
+			logging.Debug("Retrieved cluster status")
+			return &ClusterStatus{
+				Type:     entry.Type,
+				Name:     entry.Name,
+				Nodes:    entry.Nodes,
+				Quorate:  entry.Quorate,
+				Expected: entry.Expected,
+			}, nil
+		case "node":
+			nodes++
+		}
+	}
+
+	logging.Debug("Retrieved cluster status of a standalone node")
+	return &ClusterStatus{Nodes: nodes, Quorate: 1}, nil
 }
 
 func (c *Client) GetClusterResources() ([]ClusterResource, error) {
@@ -57,6 +98,22 @@ func (c *Client) GetClusterTasks() ([]Task, error) {
 	return tasks, nil
 }
 
+func (c *Client) GetClusterNextID() (int, error) {
+	// The API returns the ID as a string
+	var nextID string
+	if err := c.Get("cluster/nextid", &nextID); err != nil {
+		return 0, fmt.Errorf("failed to get next VMID: %w", err)
+	}
+
+	vmid, err := strconv.Atoi(nextID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse next VMID %q: %w", nextID, err)
+	}
+
+	logging.Debugf("Retrieved next VMID %d", vmid)
+	return vmid, nil
+}
+
 func (c *Client) GetClusterHA() (*ClusterHA, error) {
 	var ha ClusterHA
 	if err := c.Get("cluster/ha", &ha); err != nil {
@@ -67,6 +124,28 @@ func (c *Client) GetClusterHA() (*ClusterHA, error) {
 	return &ha, nil
 }
 
+// GetClusterHAStatus returns the current HA manager status: quorum, CRM master, the LRM of every node and the services
+func (c *Client) GetClusterHAStatus() ([]ClusterHAStatus, error) {
+	var status []ClusterHAStatus
+	if err := c.Get("cluster/ha/status/current", &status); err != nil {
+		return nil, fmt.Errorf("failed to get cluster HA manager status: %w", err)
+	}
+
+	logging.Debugf("Retrieved %d cluster HA status entries", len(status))
+	return status, nil
+}
+
+// GetClusterPCIMappings returns the PCI resource mappings, which name equivalent devices on several nodes
+func (c *Client) GetClusterPCIMappings() ([]ClusterPCIMapping, error) {
+	var mappings []ClusterPCIMapping
+	if err := c.Get("cluster/mapping/pci", &mappings); err != nil {
+		return nil, fmt.Errorf("failed to get cluster PCI mappings: %w", err)
+	}
+
+	logging.Debugf("Retrieved %d cluster PCI mappings", len(mappings))
+	return mappings, nil
+}
+
 func (c *Client) GetClusterHAGroups() ([]ClusterHAGroup, error) {
 	var groups []ClusterHAGroup
 	if err := c.Get("cluster/ha/groups", &groups); err != nil {
@@ -156,10 +235,10 @@ func (c *Client) CreateClusterHAResource(resource ClusterHAResource) (string, er
 	if resource.Group != "" {
 		data.Set("group", resource.Group)
 	}
-	if resource.MaxRelocate > 0 {
+	if resource.MaxRelocate > 0 || resource.LimitsSet {
 		data.Set("max_relocate", strconv.Itoa(resource.MaxRelocate))
 	}
-	if resource.MaxRestart > 0 {
+	if resource.MaxRestart > 0 || resource.LimitsSet {
 		data.Set("max_restart", strconv.Itoa(resource.MaxRestart))
 	}
 	if resource.State != "" {
@@ -183,10 +262,10 @@ func (c *Client) UpdateClusterHAResource(resource ClusterHAResource) error {
 	if resource.Group != "" {
 		data.Set("group", resource.Group)
 	}
-	if resource.MaxRelocate > 0 {
+	if resource.MaxRelocate > 0 || resource.LimitsSet {
 		data.Set("max_relocate", strconv.Itoa(resource.MaxRelocate))
 	}
-	if resource.MaxRestart > 0 {
+	if resource.MaxRestart > 0 || resource.LimitsSet {
 		data.Set("max_restart", strconv.Itoa(resource.MaxRestart))
 	}
 	if resource.State != "" {
@@ -50,6 +50,54 @@ func TestGetClusterStatus(t *testing.T) {
 	assert.Equal(t, 1, status.Quorate)
 }
 
+func TestGetClusterStatusList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		quorate int
+		nodes   int
+	}{
+		{
+			name: "cluster",
+			data: `[
+				{"id": "cluster", "type": "cluster", "name": "lab", "nodes": 3, "quorate": 0, "version": 5},
+				{"id": "node/pve1", "type": "node", "name": "pve1", "online": 1, "local": 1, "nodeid": 1}
+			]`,
+			quorate: 0,
+			nodes:   3,
+		},
+		{
+			name:    "standalone node",
+			data:    `[{"id": "node/pve1", "type": "node", "name": "pve1", "online": 1, "local": 1}]`,
+			quorate: 1,
+			nodes:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"data": ` + tt.data + `}`))
+			}))
+			defer server.Close()
+
+			client := NewClient(&Config{
+				Endpoints: []string{server.URL},
+				Auth: AuthConfig{
+					Method:   "token",
+					APIToken: "test@pam!test=12345678-1234-1234-1234-123456789012",
+				},
+			})
+
+			status, err := client.GetClusterStatus()
+			require.NoError(t, err)
+			assert.Equal(t, tt.quorate, status.Quorate)
+			assert.Equal(t, tt.nodes, status.Nodes)
+		})
+	}
+}
+
 func TestGetClusterResources(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/api2/json/cluster/resources", r.URL.Path)
@@ -149,6 +197,85 @@ func TestGetClusterHAGroups(t *testing.T) {
 	assert.Equal(t, 1, groups[0].NoFailback)
 }
 
+func TestGetClusterHAStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api2/json/cluster/ha/status/current", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{
+			"data": [
+				{"id": "quorum", "type": "quorum", "node": "pve1", "status": "OK", "quorate": 1},
+				{"id": "master", "type": "master", "node": "pve1", "status": "pve1 (active, Mon Oct 12 10:00:00 2026)", "timestamp": 1791800000},
+				{"id": "lrm:pve2", "type": "lrm", "node": "pve2", "status": "pve2 (idle, Mon Oct 12 10:00:01 2026)", "timestamp": 1791800001},
+				{"id": "service:vm:100", "type": "service", "node": "pve1", "status": "started", "crm_state": "started", "state": "started"}
+			]
+		}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Endpoints: []string{server.URL},
+		Auth: AuthConfig{
+			Method:   "token",
+			APIToken: "test@pam!test=12345678-1234-1234-1234-123456789012",
+		},
+	}
+
+	client := NewClient(config)
+	status, err := client.GetClusterHAStatus()
+
+	require.NoError(t, err)
+	require.Len(t, status, 4)
+	assert.Equal(t, 1, status[0].Quorate)
+	assert.Equal(t, "master", status[1].Type)
+	assert.Equal(t, "pve2", status[2].Node)
+	assert.Equal(t, "pve2 (idle, Mon Oct 12 10:00:01 2026)", status[2].Status)
+	assert.Equal(t, "started", status[3].CRMState)
+}
+
+func TestGetClusterPCIMappings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api2/json/cluster/mapping/pci", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{
+			"data": [
+				{
+					"id": "gpu",
+					"description": "A4000",
+					"map": [
+						"node=pve2,path=0000:01:00.0,id=10de:24b0",
+						"node=pve1,path=0000:41:00.0,id=10de:24b0",
+						"id=10de:24b0,node=pve1,path=0000:42:00.0"
+					]
+				}
+			]
+		}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Endpoints: []string{server.URL},
+		Auth: AuthConfig{
+			Method:   "token",
+			APIToken: "test@pam!test=12345678-1234-1234-1234-123456789012",
+		},
+	}
+
+	client := NewClient(config)
+	mappings, err := client.GetClusterPCIMappings()
+
+	require.NoError(t, err)
+	require.Len(t, mappings, 1)
+	assert.Equal(t, "gpu", mappings[0].ID)
+	assert.Equal(t, "A4000", mappings[0].Description)
+	assert.Equal(t, []string{"pve1", "pve2"}, mappings[0].Nodes())
+}
+
 func TestCreateClusterHAGroup(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/api2/json/cluster/ha/groups", r.URL.Path)
@@ -269,6 +396,48 @@ func TestCreateClusterHAResource(t *testing.T) {
 	assert.NotEmpty(t, result)
 }
 
+func TestUpdateClusterHAResourceZeroLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource ClusterHAResource
+		relocate []string
+	}{
+		{
+			name:     "zero limits are left out",
+			resource: ClusterHAResource{SID: "vm:100", Group: "test-group"},
+		},
+		{
+			name:     "zero limits are sent when set",
+			resource: ClusterHAResource{SID: "vm:100", Group: "test-group", MaxRestart: 1, LimitsSet: true},
+			relocate: []string{"0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.NoError(t, r.ParseForm())
+				assert.Equal(t, http.MethodPut, r.Method)
+				assert.Equal(t, tt.relocate, r.PostForm["max_relocate"])
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"data": null}`))
+			}))
+			defer server.Close()
+
+			client := NewClient(&Config{
+				Endpoints: []string{server.URL},
+				Auth: AuthConfig{
+					Method:   "token",
+					APIToken: "test@pam!test=12345678-1234-1234-1234-123456789012",
+				},
+			})
+
+			require.NoError(t, client.UpdateClusterHAResource(tt.resource))
+		})
+	}
+}
+
 func TestDeleteClusterHAResource(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/api2/json/cluster/ha/resources/vm:100", r.URL.Path)
@@ -309,7 +478,10 @@ func TestGetTask(t *testing.T) {
 				"type": "qmstart",
 				"id": "100",
 				"user": "root@pam",
-				"status": "stopped"
+				"status": "stopped",
+				"starttime": 1700000000,
+				"endtime": 1700000042,
+				"exitstatus": "OK"
 			}
 		}`))
 	}))
@@ -332,6 +504,19 @@ func TestGetTask(t *testing.T) {
 	assert.Equal(t, "qmstart", task.Type)
 	assert.Equal(t, "100", task.ID)
 	assert.Equal(t, "stopped", task.Status)
+	assert.Equal(t, int64(1700000000), task.StartTime)
+	assert.Equal(t, int64(1700000042), task.EndTime)
+	assert.Equal(t, "OK", task.ExitCode)
+}
+
+func TestGetClusterNextID(t *testing.T) {
+	server, client := setupSimpleGETTest(t, "/api2/json/cluster/nextid", `{"data": "105"}`)
+	defer server.Close()
+
+	vmid, err := client.GetClusterNextID()
+
+	require.NoError(t, err)
+	assert.Equal(t, 105, vmid)
 }
 
 func TestStopTask(t *testing.T) {
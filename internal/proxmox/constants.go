@@ -3,4 +3,18 @@ package proxmox
 const (
 	HAMaxRelocate = 10
 	HAMaxRestart  = 10
+
+	// Task states, a finished task is stopped and carries an exit status
+	TaskStatusStopped = "stopped"
+	TaskExitOK        = "OK"
+
+	// Migration task types. Migrating an HA-managed guest only runs an hamigrate task that queues the migration,
+	// the HA manager then runs a qmigrate or vzmigrate task on the source node.
+	TaskTypeHAMigrate = "hamigrate"
+	TaskTypeQMigrate  = "qmigrate"
+	TaskTypeVZMigrate = "vzmigrate"
+
+	// Number of task log lines kept for failed tasks
+	TaskLogTailLines = 20
+	taskLogMaxLines  = 1000
 )
@@ -46,6 +46,9 @@ func (c *Client) CreateContainer(node string, vmid int, config ContainerConfig)
 	if config.Tags != "" {
 		data.Set("tags", config.Tags)
 	}
+	if config.Startup != "" {
+		data.Set("startup", config.Startup)
+	}
 	if config.Unprivileged {
 		data.Set("unprivileged", "1")
 	}
@@ -62,6 +65,51 @@ func (c *Client) CreateContainer(node string, vmid int, config ContainerConfig)
 	return taskID, nil
 }
 
+func (c *Client) GetContainerConfig(node string, vmid int) (*ContainerConfigRead, error) {
+	var config ContainerConfigRead
+	endpoint := fmt.Sprintf("nodes/%s/lxc/%d/config", node, vmid)
+	if err := c.Get(endpoint, &config); err != nil {
+		return nil, fmt.Errorf("failed to get container %d config on node %s: %w", vmid, node, err)
+	}
+
+	logging.Debugf("Retrieved container %d config on node %s", vmid, node)
+	return &config, nil
+}
+
+func (c *Client) UpdateContainerConfig(node string, vmid int, config ContainerConfig) error {
+	endpoint := fmt.Sprintf("nodes/%s/lxc/%d/config", node, vmid)
+	data := url.Values{}
+
+	if config.Hostname != "" {
+		data.Set("hostname", config.Hostname)
+	}
+	if config.Description != "" {
+		data.Set("description", config.Description)
+	}
+	if config.Memory > 0 {
+		data.Set("memory", strconv.Itoa(config.Memory))
+	}
+	if config.Swap > 0 {
+		data.Set("swap", strconv.Itoa(config.Swap))
+	}
+	if config.Cores > 0 {
+		data.Set("cores", strconv.Itoa(config.Cores))
+	}
+	if config.Tags != "" {
+		data.Set("tags", config.Tags)
+	}
+	if config.Startup != "" {
+		data.Set("startup", config.Startup)
+	}
+
+	if err := c.Put(endpoint, data, nil); err != nil {
+		return fmt.Errorf("failed to update container %d config on node %s: %w", vmid, node, err)
+	}
+
+	logging.Debugf("Updated container %d config on node %s", vmid, node)
+	return nil
+}
+
 func (c *Client) StartContainer(node string, vmid int) (string, error) {
 	endpoint := fmt.Sprintf("nodes/%s/lxc/%d/status/start", node, vmid)
 
@@ -128,6 +176,9 @@ func (c *Client) MigrateContainer(node string, vmid int, options MigrationOption
 	if options.WithDisks {
 		data.Set("with-local-disks", "1")
 	}
+	if options.Restart {
+		data.Set("restart", "1")
+	}
 
 	var taskID string
 	if err := c.Post(endpoint, data, &taskID); err != nil {
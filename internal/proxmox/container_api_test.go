@@ -83,6 +83,58 @@ func TestCreateContainer(t *testing.T) {
 	assert.Contains(t, taskID, "vzcreate")
 }
 
+func TestGetContainerConfig(t *testing.T) {
+	responseBody := `{
+		"data": {
+			"hostname": "web",
+			"memory": 2048,
+			"cores": 2,
+			"tags": "crs-critical",
+			"startup": "order=1",
+			"rootfs": "ceph:vm-200-disk-0,size=8G",
+			"mp0": "local-lvm:vm-200-disk-1,mp=/data,size=32G",
+			"mp1": "/srv/shared,mp=/shared",
+			"net0": "name=eth0,bridge=vmbr0,ip=dhcp",
+			"dev0": "/dev/ttyUSB0",
+			"digest": "abc"
+		}
+	}`
+
+	server, client := setupSimpleGETTest(t, "/api2/json/nodes/pve1/lxc/200/config", responseBody)
+	defer server.Close()
+
+	config, err := client.GetContainerConfig("pve1", 200)
+
+	require.NoError(t, err)
+	assert.Equal(t, "web", config.Hostname)
+	assert.Equal(t, "order=1", config.Startup)
+	assert.Equal(t, map[string]string{
+		"rootfs": "ceph:vm-200-disk-0,size=8G",
+		"mp0":    "local-lvm:vm-200-disk-1,mp=/data,size=32G",
+		"mp1":    "/srv/shared,mp=/shared",
+	}, config.Disks)
+	assert.Equal(t, map[string]string{"net0": "name=eth0,bridge=vmbr0,ip=dhcp"}, config.Networks)
+	assert.Equal(t, map[string]string{"dev0": "/dev/ttyUSB0"}, config.Devices)
+}
+
+func TestUpdateContainerConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertHTTPRequest(t, r, http.MethodPut, "/api2/json/nodes/pve1/lxc/200/config")
+
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "order=1", r.Form.Get("startup"))
+		assert.Empty(t, r.Form.Get("memory"))
+
+		writeJSONResponse(w, http.StatusOK, `{"data": null}`)
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	err := client.UpdateContainerConfig("pve1", 200, ContainerConfig{Startup: "order=1"})
+
+	require.NoError(t, err)
+}
+
 func TestStartContainer(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/api2/json/nodes/pve1/lxc/200/status/start", r.URL.Path)
@@ -146,6 +198,22 @@ func TestMigrateContainer(t *testing.T) {
 	assert.Contains(t, taskID, "vzmigrate")
 }
 
+func TestMigrateContainerWithRestart(t *testing.T) {
+	formValidation := func(t *testing.T, r *http.Request) {
+		assert.Equal(t, "pve2", r.Form.Get("target"))
+		assert.Equal(t, "1", r.Form.Get("restart"))
+		assert.Empty(t, r.Form.Get("online"))
+	}
+
+	server, client := setupFormPOSTTest(t, "/api2/json/nodes/pve1/lxc/200/migrate", formValidation, `{"data": "UPID:pve1:00001234:00005678:5F8A1234:vzmigrate:200:root@pam:"}`)
+	defer server.Close()
+
+	taskID, err := client.MigrateContainer("pve1", 200, MigrationOptions{Target: "pve2", Restart: true})
+
+	require.NoError(t, err)
+	assert.Contains(t, taskID, "vzmigrate")
+}
+
 func TestCloneContainer(t *testing.T) {
 	formValidation := func(t *testing.T, r *http.Request) {
 		assert.Equal(t, "201", r.Form.Get("newid"))
@@ -114,3 +114,26 @@ func (c *Client) WakeNode(node string) error {
 
 	return nil
 }
+
+// EnableNodeMaintenance puts a node into HA maintenance, like ha-manager crm-command node-maintenance enable.
+// The HA manager moves the HA services off the node and keeps them off until maintenance is disabled.
+func (c *Client) EnableNodeMaintenance(node string) error {
+	return c.nodeMaintenance(node, "enable")
+}
+
+// DisableNodeMaintenance takes a node out of HA maintenance, like ha-manager crm-command node-maintenance disable
+func (c *Client) DisableNodeMaintenance(node string) error {
+	return c.nodeMaintenance(node, "disable")
+}
+
+func (c *Client) nodeMaintenance(node, command string) error {
+	endpoint := fmt.Sprintf("cluster/ha/crm-command/node-maintenance/%s", command)
+	data := url.Values{}
+	data.Set("node", node)
+
+	if err := c.Post(endpoint, data, nil); err != nil {
+		return fmt.Errorf("failed to %s maintenance of node %s: %w", command, node, err)
+	}
+
+	return nil
+}
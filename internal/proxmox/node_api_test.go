@@ -275,3 +275,24 @@ func TestRebootNode(t *testing.T) {
 	require.NoError(t, err)
 	assert.Contains(t, taskID, "srvreboot")
 }
+
+func TestNodeMaintenance(t *testing.T) {
+	for command, call := range map[string]func(*Client, string) error{
+		"enable":  (*Client).EnableNodeMaintenance,
+		"disable": (*Client).DisableNodeMaintenance,
+	} {
+		t.Run(command, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assertHTTPRequest(t, r, "POST", "/api2/json/cluster/ha/crm-command/node-maintenance/"+command)
+
+				require.NoError(t, r.ParseForm())
+				assert.Equal(t, "pve1", r.Form.Get("node"))
+
+				writeJSONResponse(w, http.StatusOK, `{"data": null}`)
+			}))
+			defer server.Close()
+
+			require.NoError(t, call(createTestClient(server.URL), "pve1"))
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package proxmox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+)
+
+func (c *Client) GetTaskLog(node, upid string, start, limit int) ([]TaskLogEntry, error) {
+	var entries []TaskLogEntry
+	endpoint := fmt.Sprintf("nodes/%s/tasks/%s/log?start=%d&limit=%d", node, upid, start, limit)
+	if err := c.Get(endpoint, &entries); err != nil {
+		return nil, fmt.Errorf("failed to get log of task %s on node %s: %w", upid, node, err)
+	}
+
+	return entries, nil
+}
+
+// WaitForTask polls a task every interval until it stops or the timeout passes.
+// A timed out task keeps running in Proxmox, the result reports it with TimedOut set.
+// The error is only set when the task status cannot be read.
+func (c *Client) WaitForTask(node, upid string, timeout, interval time.Duration) (*TaskResult, error) {
+	started := time.Now()
+	deadline := started.Add(timeout)
+
+	for {
+		task, err := c.GetTask(node, upid)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &TaskResult{
+			UPID:     upid,
+			Node:     node,
+			Type:     task.Type,
+			Status:   task.Status,
+			ExitCode: task.ExitCode,
+			Duration: time.Since(started),
+		}
+
+		if task.Status == TaskStatusStopped {
+			if !result.Success() {
+				result.Log = c.taskLogTail(node, upid)
+			}
+			return result, nil
+		}
+
+		if time.Now().After(deadline) {
+			result.TimedOut = true
+			return result, nil
+		}
+
+		// The last poll happens at the deadline, not up to an interval after it
+		time.Sleep(min(interval, time.Until(deadline)))
+	}
+}
+
+// taskLogTail returns the last lines of a task log, a log that cannot be read is logged and skipped
+func (c *Client) taskLogTail(node, upid string) []string {
+	entries, err := c.GetTaskLog(node, upid, 0, taskLogMaxLines)
+	if err != nil {
+		logging.Warnf("Failed to read log of task %s: %v", upid, err)
+		return nil
+	}
+
+	if len(entries) > TaskLogTailLines {
+		entries = entries[len(entries)-TaskLogTailLines:]
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.T)
+	}
+
+	return lines
+}
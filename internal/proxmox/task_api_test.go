@@ -0,0 +1,114 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMigrateUPID = "UPID:pve1:00001234:00005678:5F8A1234:qmigrate:100:root@pam:"
+
+func TestGetTaskLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertHTTPRequest(t, r, http.MethodGet, "/api2/json/nodes/pve1/tasks/"+testMigrateUPID+"/log")
+		assert.Equal(t, "10", r.URL.Query().Get("start"))
+		assert.Equal(t, "50", r.URL.Query().Get("limit"))
+
+		writeJSONResponse(w, http.StatusOK, `{"data": [{"n": 11, "t": "starting migration"}, {"n": 12, "t": "migration finished"}]}`)
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	entries, err := client.GetTaskLog("pve1", testMigrateUPID, 10, 50)
+
+	require.NoError(t, err)
+	assert.Equal(t, []TaskLogEntry{{N: 11, T: "starting migration"}, {N: 12, T: "migration finished"}}, entries)
+}
+
+func TestWaitForTask(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []string
+		exit      string
+		logLines  int
+		success   bool
+		timedOut  bool
+		errSubstr string
+		logLength int
+	}{
+		{
+			name:     "finished OK",
+			statuses: []string{"running", "stopped"},
+			exit:     "OK",
+			success:  true,
+		},
+		{
+			name:      "failed with log",
+			statuses:  []string{"stopped"},
+			exit:      "migration aborted",
+			logLines:  30,
+			errSubstr: "migration aborted (line 30)",
+			logLength: TaskLogTailLines,
+		},
+		{
+			name:      "timed out",
+			statuses:  []string{"running"},
+			timedOut:  true,
+			errSubstr: "still running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var polls int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/log") {
+					lines := make([]string, 0, tt.logLines)
+					for i := 1; i <= tt.logLines; i++ {
+						lines = append(lines, fmt.Sprintf(`{"n": %d, "t": "line %d"}`, i, i))
+					}
+					writeJSONResponse(w, http.StatusOK, `{"data": [`+strings.Join(lines, ",")+`]}`)
+					return
+				}
+
+				status := tt.statuses[min(polls, len(tt.statuses)-1)]
+				polls++
+				writeJSONResponse(w, http.StatusOK, fmt.Sprintf(`{"data": {"upid": %q, "type": "qmigrate", "status": %q, "exitstatus": %q}}`, testMigrateUPID, status, tt.exit))
+			}))
+			defer server.Close()
+
+			client := createTestClient(server.URL)
+			result, err := client.WaitForTask("pve1", testMigrateUPID, 20*time.Millisecond, time.Millisecond)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.success, result.Success())
+			assert.Equal(t, tt.timedOut, result.TimedOut)
+			assert.Equal(t, "qmigrate", result.Type)
+			assert.Len(t, result.Log, tt.logLength)
+
+			if tt.errSubstr == "" {
+				assert.NoError(t, result.Err())
+			} else {
+				assert.ErrorContains(t, result.Err(), tt.errSubstr)
+			}
+		})
+	}
+}
+
+func TestWaitForTaskStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSONResponse(w, http.StatusInternalServerError, `{"errors": "boom"}`)
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	_, err := client.WaitForTask("pve1", testMigrateUPID, time.Second, time.Millisecond)
+
+	assert.Error(t, err)
+}
@@ -2,7 +2,11 @@ package proxmox
 
 import (
 	"encoding/json"
+	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -106,22 +110,84 @@ type ClusterResource struct {
 }
 
 type Task struct {
-	UPID      string    `json:"upid"`
-	Node      string    `json:"node"`
-	PID       int       `json:"pid"`
-	Type      string    `json:"type"`
-	ID        string    `json:"id"`
-	User      string    `json:"user"`
-	Status    string    `json:"status"`
-	StartTime time.Time `json:"starttime"`
-	EndTime   time.Time `json:"endtime"`
-	ExitCode  string    `json:"exitstatus"`
+	UPID      string `json:"upid"`
+	Node      string `json:"node"`
+	PID       int    `json:"pid"`
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	User      string `json:"user"`
+	Status    string `json:"status"`
+	StartTime int64  `json:"starttime"` // Unix timestamp
+	EndTime   int64  `json:"endtime"`   // Unix timestamp, zero while running
+	ExitCode  string `json:"exitstatus"`
+}
+
+type TaskLogEntry struct {
+	N int    `json:"n"`
+	T string `json:"t"`
+}
+
+// TaskResult is the outcome of waiting for a task. Log holds the tail of the task log and is only fetched for failed tasks.
+type TaskResult struct {
+	UPID     string
+	Node     string
+	Type     string
+	Status   string
+	ExitCode string
+	Duration time.Duration
+	TimedOut bool
+	Log      []string
+}
+
+// Success reports whether the task finished with exit status OK
+func (r *TaskResult) Success() bool {
+	return r.Status == TaskStatusStopped && r.ExitCode == TaskExitOK
+}
+
+// Err returns nil for successful tasks and otherwise an error with the exit status and the last log line
+func (r *TaskResult) Err() error {
+	switch {
+	case r.Success():
+		return nil
+	case r.TimedOut:
+		return fmt.Errorf("task %s still running after %s", r.UPID, r.Duration.Round(time.Second))
+	case len(r.Log) > 0:
+		return fmt.Errorf("task %s failed: %s (%s)", r.UPID, r.ExitCode, r.Log[len(r.Log)-1])
+	default:
+		return fmt.Errorf("task %s failed: %s", r.UPID, r.ExitCode)
+	}
+}
+
+// UPIDStartTime returns the start time of a task from its UPID, UPID:node:pid:pstart:starttime:type:id:user:,
+// or the zero time when the UPID is malformed
+func UPIDStartTime(upid string) time.Time {
+	fields := strings.Split(upid, ":")
+	if len(fields) < 5 || fields[0] != "UPID" {
+		return time.Time{}
+	}
+
+	started, err := strconv.ParseInt(fields[4], 16, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(started, 0)
 }
 
 type MigrationOptions struct {
 	Target    string `json:"target"`
 	Online    bool   `json:"online"`
 	WithDisks bool   `json:"with-local-disks"`
+	Restart   bool   `json:"restart"` // Containers only: shut down, migrate and start a running container
+}
+
+type CloneOptions struct {
+	NewID   int    `json:"newid"`
+	Name    string `json:"name,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Full    bool   `json:"full,omitempty"`
+	Storage string `json:"storage,omitempty"`
+	Pool    string `json:"pool,omitempty"`
 }
 
 type BackupOptions struct {
@@ -159,7 +225,8 @@ type VMConfigRead struct {
 	Networks    map[string]string `json:"networks"`
 	Tags        string            `json:"tags"`
 	Startup     string            `json:"startup"`
-	HostPCI     map[string]string `json:"-"` // PCIe passthrough devices (populated via UnmarshalJSON)
+	Meta        string            `json:"meta"` // Creation details such as ctime, set by Proxmox
+	HostPCI     map[string]string `json:"-"`    // PCIe passthrough devices (populated via UnmarshalJSON)
 }
 
 // UnmarshalJSON custom unmarshaling to capture hostpci devices and other dynamic fields
@@ -234,9 +301,72 @@ type ContainerConfig struct {
 	RootFS       string            `json:"rootfs"`
 	Networks     map[string]string `json:"networks"`
 	Tags         string            `json:"tags"`
+	Startup      string            `json:"startup"`
 	Unprivileged bool              `json:"unprivileged"`
 }
 
+type ContainerConfigRead struct {
+	Hostname    string            `json:"hostname"`
+	Description string            `json:"description"`
+	Memory      interface{}       `json:"memory"` // Can be string or int
+	Swap        interface{}       `json:"swap"`   // Can be string or int
+	Cores       interface{}       `json:"cores"`  // Can be string or int
+	Tags        string            `json:"tags"`
+	Startup     string            `json:"startup"`
+	Disks       map[string]string `json:"-"` // rootfs and mount points (populated via UnmarshalJSON)
+	Networks    map[string]string `json:"-"` // Network devices (populated via UnmarshalJSON)
+	Devices     map[string]string `json:"-"` // Device passthrough (populated via UnmarshalJSON)
+}
+
+// UnmarshalJSON custom unmarshaling to capture rootfs, mount points and other dynamic fields
+func (c *ContainerConfigRead) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	type Alias ContainerConfigRead
+	aux := &struct {
+		*Alias
+	}{
+		Alias: (*Alias)(c),
+	}
+
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	c.Disks = make(map[string]string)
+	c.Networks = make(map[string]string)
+	c.Devices = make(map[string]string)
+
+	var (
+		mountPointPattern = regexp.MustCompile(`^mp([0-9]+)$`)
+		networkPattern    = regexp.MustCompile(`^net([0-9]+)$`)
+		devicePattern     = regexp.MustCompile(`^dev([0-9]+)$`)
+	)
+
+	for key, value := range raw {
+		strValue, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == "rootfs" || mountPointPattern.MatchString(key):
+			// Root filesystem and mount points (mp0, mp1, etc.)
+			c.Disks[key] = strValue
+		case networkPattern.MatchString(key):
+			c.Networks[key] = strValue
+		case devicePattern.MatchString(key):
+			// Device passthrough (dev0, dev1, etc.)
+			c.Devices[key] = strValue
+		}
+	}
+
+	return nil
+}
+
 type StorageContent struct {
 	VolID  string `json:"volid"`
 	Format string `json:"format"`
@@ -258,6 +388,7 @@ type StorageStatus struct {
 }
 
 type ClusterStatus struct {
+	Type     string `json:"type"`
 	Name     string `json:"name"`
 	Version  string `json:"version"`
 	Local    int    `json:"local"`
@@ -283,6 +414,42 @@ type ClusterHA struct {
 	State   string `json:"state"`
 }
 
+// ClusterHAStatus is one entry of the HA manager status. Type is quorum, master, lrm or service,
+// Status is a summary such as "OK" for the quorum or "pve1 (active, Mon Oct 12 10:00:00 2026)" for an LRM.
+type ClusterHAStatus struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Node      string `json:"node"`
+	Status    string `json:"status"`
+	Quorate   int    `json:"quorate"`
+	CRMState  string `json:"crm_state"`
+	State     string `json:"state"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ClusterPCIMapping is a cluster resource mapping of PCI devices. Every Map entry describes the device
+// of one node, like "node=pve1,path=0000:01:00.0,id=10de:2204".
+type ClusterPCIMapping struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Map         []string `json:"map"`
+}
+
+// Nodes returns the nodes that provide a device of the mapping, sorted and without duplicates
+func (m ClusterPCIMapping) Nodes() []string {
+	var nodes []string
+	for _, entry := range m.Map {
+		for _, option := range strings.Split(entry, ",") {
+			if node, ok := strings.CutPrefix(strings.TrimSpace(option), "node="); ok && node != "" && !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	slices.Sort(nodes)
+	return nodes
+}
+
 type ClusterHAGroup struct {
 	Group      string `json:"group"`
 	Nodes      string `json:"nodes"`
@@ -303,6 +470,9 @@ type ClusterHAResource struct {
 	Node           string `json:"node"`
 	CRMState       string `json:"crm-state"`
 	RequestedState string `json:"request"`
+
+	// LimitsSet sends MaxRelocate and MaxRestart on create and update even when they are 0, otherwise only values above 0 are sent
+	LimitsSet bool `json:"limits_set,omitempty"`
 }
 
 type ClusterConfig struct {
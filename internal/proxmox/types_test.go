@@ -3,6 +3,7 @@ package proxmox
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -106,3 +107,9 @@ func TestVMConfigRead_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestUPIDStartTime(t *testing.T) {
+	assert.Equal(t, time.Unix(0x5F8A1234, 0), UPIDStartTime("UPID:pve1:00001234:00005678:5F8A1234:hamigrate:100:root@pam:"))
+	assert.True(t, UPIDStartTime("UPID:pve1:00001234").IsZero())
+	assert.True(t, UPIDStartTime("UPID:pve1:00001234:00005678:later:hamigrate:100:root@pam:").IsZero())
+}
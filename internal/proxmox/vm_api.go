@@ -152,17 +152,36 @@ func (c *Client) MigrateVM(node string, vmid int, options MigrationOptions) (str
 }
 
 func (c *Client) CloneVM(node string, vmid int, newid int, full bool) (string, error) {
+	return c.CloneVMWithOptions(node, vmid, CloneOptions{
+		NewID: newid,
+		Full:  full,
+	})
+}
+
+func (c *Client) CloneVMWithOptions(node string, vmid int, options CloneOptions) (string, error) {
 	endpoint := fmt.Sprintf("nodes/%s/qemu/%d/clone", node, vmid)
 	data := url.Values{}
-	data.Set("newid", strconv.Itoa(newid))
+	data.Set("newid", strconv.Itoa(options.NewID))
 
-	if full {
+	if options.Name != "" {
+		data.Set("name", options.Name)
+	}
+	if options.Target != "" {
+		data.Set("target", options.Target)
+	}
+	if options.Full {
 		data.Set("full", "1")
 	}
+	if options.Storage != "" {
+		data.Set("storage", options.Storage)
+	}
+	if options.Pool != "" {
+		data.Set("pool", options.Pool)
+	}
 
 	var taskID string
 	if err := c.Post(endpoint, data, &taskID); err != nil {
-		return "", fmt.Errorf("failed to clone VM %d to %d on node %s: %w", vmid, newid, node, err)
+		return "", fmt.Errorf("failed to clone VM %d to %d on node %s: %w", vmid, options.NewID, node, err)
 	}
 
 	return taskID, nil
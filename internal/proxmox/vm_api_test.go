@@ -155,6 +155,30 @@ func TestCloneVM(t *testing.T) {
 	assert.Contains(t, taskID, "qmclone")
 }
 
+func TestCloneVMWithOptions(t *testing.T) {
+	formValidation := func(t *testing.T, r *http.Request) {
+		assert.Equal(t, "101", r.Form.Get("newid"))
+		assert.Equal(t, "runner-101", r.Form.Get("name"))
+		assert.Equal(t, "pve2", r.Form.Get("target"))
+		assert.Equal(t, "local-lvm", r.Form.Get("storage"))
+		assert.Empty(t, r.Form.Get("full"))
+		assert.Empty(t, r.Form.Get("pool"))
+	}
+
+	server, client := setupFormPOSTTest(t, "/api2/json/nodes/pve1/qemu/100/clone", formValidation, `{"data": "UPID:pve1:00001234:00005678:5F8A1234:qmclone:100:root@pam:"}`)
+	defer server.Close()
+
+	taskID, err := client.CloneVMWithOptions("pve1", 100, CloneOptions{
+		NewID:   101,
+		Name:    "runner-101",
+		Target:  "pve2",
+		Storage: "local-lvm",
+	})
+
+	require.NoError(t, err)
+	assert.Contains(t, taskID, "qmclone")
+}
+
 func TestCreateVMSnapshot(t *testing.T) {
 	server, client := setupSnapshotTest(t, "/api2/json/nodes/pve1/qemu/100/snapshot", "UPID:pve1:00001234:00005678:5F8A1234:qmsnapshot:100:root@pam:")
 	defer server.Close()
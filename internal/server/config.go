@@ -0,0 +1,411 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+)
+
+// configNameRe limits the names of configured objects, such as instance groups, HA classes and maintenance windows,
+// to lowercase letters, digits and inner dashes. Names that become part of Proxmox tags, HA group names or VM names
+// are valid there, and the others are safe in Consul keys and log output.
+var configNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// crsConfig holds the CRS settings stored in Consul, defaults apply when a document is missing
+type crsConfig struct {
+	DRS          consul.DRSConfig
+	AutoScaler   []consul.AutoScalerGroup
+	Events       consul.EventsConfig
+	Scheduler    consul.SchedulerConfig
+	HAClasses    map[string]consul.HAClass
+	StartupTiers map[string]consul.StartupTier
+	Pools        map[string]consul.PoolPolicy
+	NodeClasses  map[string]consul.NodeClass
+	Nodes        map[string]consul.NodeAttributes
+	Drain        consul.DrainConfig
+	Power        consul.PowerConfig
+	Budget       consul.BudgetConfig
+
+	MaintenanceWindows []consul.MaintenanceWindow
+}
+
+// defaultCRSConfig returns the settings used when nothing is configured in Consul
+func defaultCRSConfig() *crsConfig {
+	return &crsConfig{
+		DRS: consul.DRSConfig{
+			Enabled:               false,
+			Aggressiveness:        drsDefaultAggressiveness,
+			MaxMigrationsPerCycle: drsDefaultMaxMigrations,
+		},
+		Events: consul.EventsConfig{
+			RetentionHours: eventsDefaultRetentionHours,
+			MaxEvents:      eventsDefaultMaxEvents,
+		},
+		Scheduler: consul.SchedulerConfig{
+			PeriodicSeconds:        periodicTime,
+			APIRateLimitMillis:     int(apiRateLimit / time.Millisecond),
+			MaxNodePriority:        crsMaxNodePriority,
+			CDROMDetachUptimeHours: cdromDetachUptimeHours,
+			HAStateMaxAttempts:     haStateMaxAttempts,
+			HAStateWaitSeconds:     int(haStateWaitInterval / time.Second),
+		},
+		HAClasses:    builtinHAClasses(),
+		StartupTiers: builtinStartupTiers(),
+		Power: consul.PowerConfig{
+			Enabled:        false,
+			MinOnlineNodes: powerDefaultMinOnlineNodes,
+			LowLoad:        powerDefaultLowLoad,
+			HighLoad:       powerDefaultHighLoad,
+			Cooldown:       powerDefaultCooldown,
+		},
+		Budget: consul.BudgetConfig{
+			Window: budgetDefaultWindow,
+		},
+		Drain: consul.DrainConfig{
+			MaxPerSource: drainDefaultMaxPerSource,
+			MaxPerTarget: drainDefaultMaxPerTarget,
+		},
+	}
+}
+
+// periodicInterval returns the time between reconciliation cycles
+func (s *Server) periodicInterval() time.Duration {
+	return time.Duration(s.currentConfig().Scheduler.PeriodicSeconds) * time.Second
+}
+
+// loadConfig refreshes the settings from Consul.
+// A document that fails to load or validate keeps its previous value, so a typo never stops reconciliation.
+// The documents are loaded into a copy that replaces the current settings as a whole,
+// so readers on other goroutines never see a half-loaded configuration.
+func (s *Server) loadConfig() {
+	config := *s.currentConfig()
+	s.loadSchedulerConfig(&config)
+	s.loadDRSConfig(&config)
+	s.loadAutoScalerConfig(&config)
+	s.loadEventsConfig(&config)
+	s.loadHAClassesConfig(&config)
+	s.loadStartupTiersConfig(&config)
+	s.loadPoolsConfig(&config)
+	s.loadNodesConfig(&config)
+	s.loadDrainConfig(&config)
+	s.loadPowerConfig(&config)
+	s.loadBudgetConfig(&config)
+	s.loadMaintenanceWindowsConfig(&config)
+
+	s.configMu.Lock()
+	s.config = &config
+	s.configMu.Unlock()
+
+	s.loadPauseState()
+	s.loadBreakerState()
+	s.loadDrainStates()
+	s.loadMigrationStates()
+	s.loadPowerStates()
+}
+
+// currentConfig returns the current settings. They are never modified after loadConfig published them,
+// so the result can be read without holding configMu.
+func (s *Server) currentConfig() *crsConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
+func (s *Server) loadSchedulerConfig(config *crsConfig) {
+	scheduler, err := s.consul.GetSchedulerConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load scheduler config, keeping previous settings: %v", err)
+	case scheduler == nil:
+		config.Scheduler = defaultCRSConfig().Scheduler
+	default:
+		if err := validateSchedulerConfig(scheduler); err != nil {
+			logging.Errorf("Invalid scheduler config in %s, keeping previous settings: %v", consul.SchedulerConfigKey, err)
+		} else {
+			config.Scheduler = *scheduler
+		}
+	}
+}
+
+func (s *Server) loadDRSConfig(config *crsConfig) {
+	drs, err := s.consul.GetDRSConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load DRS config, keeping previous settings: %v", err)
+	case drs == nil:
+		config.DRS = defaultCRSConfig().DRS
+	default:
+		if err := validateDRSConfig(drs); err != nil {
+			logging.Errorf("Invalid DRS config, keeping previous settings: %v", err)
+		} else {
+			config.DRS = *drs
+		}
+	}
+}
+
+func (s *Server) loadAutoScalerConfig(config *crsConfig) {
+	groups, err := s.consul.GetAutoScalerGroups()
+	if err != nil {
+		logging.Errorf("Failed to load auto scaler groups, keeping previous settings: %v", err)
+		return
+	}
+
+	previous := make(map[string]consul.AutoScalerGroup, len(config.AutoScaler))
+	for _, group := range config.AutoScaler {
+		previous[group.Name] = group
+	}
+
+	loaded := make([]consul.AutoScalerGroup, 0, len(groups))
+	for _, group := range groups {
+		if err := validateAutoScalerGroup(&group); err != nil {
+			logging.Errorf("Invalid auto scaler group %s, keeping previous settings: %v", group.Name, err)
+			if prev, ok := previous[group.Name]; ok {
+				loaded = append(loaded, prev)
+			}
+			continue
+		}
+
+		loaded = append(loaded, group)
+	}
+
+	config.AutoScaler = loaded
+}
+
+func (s *Server) loadEventsConfig(config *crsConfig) {
+	events, err := s.consul.GetEventsConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load event journal config, keeping previous settings: %v", err)
+	case events == nil:
+		config.Events = defaultCRSConfig().Events
+	default:
+		if err := validateEventsConfig(events); err != nil {
+			logging.Errorf("Invalid event journal config, keeping previous settings: %v", err)
+		} else {
+			config.Events = *events
+		}
+	}
+}
+
+func (s *Server) loadDrainConfig(config *crsConfig) {
+	drain, err := s.consul.GetDrainConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load drain config, keeping previous settings: %v", err)
+	case drain == nil:
+		config.Drain = defaultCRSConfig().Drain
+	default:
+		if err := validateDrainConfig(drain); err != nil {
+			logging.Errorf("Invalid drain config, keeping previous settings: %v", err)
+		} else {
+			config.Drain = *drain
+		}
+	}
+}
+
+func (s *Server) loadPowerConfig(config *crsConfig) {
+	power, err := s.consul.GetPowerConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load power management config, keeping previous settings: %v", err)
+	case power == nil:
+		config.Power = defaultCRSConfig().Power
+	default:
+		if err := validatePowerConfig(power); err != nil {
+			logging.Errorf("Invalid power management config, keeping previous settings: %v", err)
+		} else {
+			config.Power = *power
+		}
+	}
+}
+
+func (s *Server) loadBudgetConfig(config *crsConfig) {
+	budget, err := s.consul.GetBudgetConfig()
+	switch {
+	case err != nil:
+		logging.Errorf("Failed to load change budget config, keeping previous settings: %v", err)
+	case budget == nil:
+		config.Budget = defaultCRSConfig().Budget
+	default:
+		if err := validateBudgetConfig(budget); err != nil {
+			logging.Errorf("Invalid change budget config, keeping previous settings: %v", err)
+		} else {
+			config.Budget = *budget
+		}
+	}
+}
+
+// validateDRSConfig checks the DRS settings and fills in defaults for omitted values
+func validateDRSConfig(config *consul.DRSConfig) error {
+	if config.Aggressiveness == 0 {
+		config.Aggressiveness = drsDefaultAggressiveness
+	}
+
+	if _, ok := drsImbalanceThresholds[config.Aggressiveness]; !ok {
+		return fmt.Errorf("aggressiveness must be between 1 and %d, got %d", len(drsImbalanceThresholds), config.Aggressiveness)
+	}
+
+	if config.MaxMigrationsPerCycle < 0 {
+		return fmt.Errorf("max_migrations_per_cycle must not be negative, got %d", config.MaxMigrationsPerCycle)
+	}
+
+	if config.MaxMigrationsPerCycle == 0 {
+		config.MaxMigrationsPerCycle = drsDefaultMaxMigrations
+	}
+
+	return nil
+}
+
+// validateAutoScalerGroup checks an instance group definition and fills in defaults for omitted values
+func validateAutoScalerGroup(group *consul.AutoScalerGroup) error {
+	if !configNameRe.MatchString(group.Name) {
+		return fmt.Errorf("name %q must consist of lowercase letters, digits and dashes", group.Name)
+	}
+
+	if group.TemplateVMID <= 0 {
+		return fmt.Errorf("template_vmid is required")
+	}
+
+	if group.Min < 0 || group.Max < group.Min || group.Max == 0 {
+		return fmt.Errorf("invalid instance limits min=%d max=%d", group.Min, group.Max)
+	}
+
+	if group.Desired == 0 {
+		group.Desired = group.Min
+	}
+
+	if group.Desired < group.Min || group.Desired > group.Max {
+		return fmt.Errorf("desired %d must be between min %d and max %d", group.Desired, group.Min, group.Max)
+	}
+
+	if group.ScaleOutCPU < 0 || group.ScaleOutCPU > 1 || group.ScaleInCPU < 0 || group.ScaleInCPU > 1 {
+		return fmt.Errorf("scale_out_cpu and scale_in_cpu must be between 0 and 1")
+	}
+
+	if group.ScaleOutCPU > 0 && group.ScaleInCPU >= group.ScaleOutCPU {
+		return fmt.Errorf("scale_in_cpu %.2f must be lower than scale_out_cpu %.2f", group.ScaleInCPU, group.ScaleOutCPU)
+	}
+
+	if group.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative, got %d", group.Cooldown)
+	}
+
+	if group.Cooldown == 0 {
+		group.Cooldown = autoScalerDefaultCooldown
+	}
+
+	return nil
+}
+
+// validateEventsConfig checks the journal retention and fills in defaults for omitted values
+func validateEventsConfig(config *consul.EventsConfig) error {
+	if config.RetentionHours < 0 || config.MaxEvents < 0 {
+		return fmt.Errorf("retention_hours and max_events must not be negative")
+	}
+
+	if config.RetentionHours == 0 {
+		config.RetentionHours = eventsDefaultRetentionHours
+	}
+
+	if config.MaxEvents == 0 {
+		config.MaxEvents = eventsDefaultMaxEvents
+	}
+
+	return nil
+}
+
+// validateSchedulerConfig checks the scheduler tunables and fills in defaults for omitted values
+func validateSchedulerConfig(config *consul.SchedulerConfig) error {
+	defaults := defaultCRSConfig().Scheduler
+
+	limits := []struct {
+		name     string
+		value    *int
+		fallback int
+		min, max int
+	}{
+		{"periodic_seconds", &config.PeriodicSeconds, defaults.PeriodicSeconds, 10, 3600},
+		{"api_rate_limit_ms", &config.APIRateLimitMillis, defaults.APIRateLimitMillis, 1, 10000},
+		{"max_node_priority", &config.MaxNodePriority, defaults.MaxNodePriority, 10, 1000},
+		{"cdrom_detach_uptime_hours", &config.CDROMDetachUptimeHours, defaults.CDROMDetachUptimeHours, 1, 8760},
+		{"ha_state_max_attempts", &config.HAStateMaxAttempts, defaults.HAStateMaxAttempts, 1, 360},
+		{"ha_state_wait_seconds", &config.HAStateWaitSeconds, defaults.HAStateWaitSeconds, 1, 300},
+	}
+
+	for _, limit := range limits {
+		if *limit.value == 0 {
+			*limit.value = limit.fallback
+			continue
+		}
+
+		if *limit.value < limit.min || *limit.value > limit.max {
+			return fmt.Errorf("%s must be between %d and %d, got %d", limit.name, limit.min, limit.max, *limit.value)
+		}
+	}
+
+	return nil
+}
+
+// validateDrainConfig checks the drain migration limits and fills in defaults for omitted values
+func validateDrainConfig(config *consul.DrainConfig) error {
+	if config.MaxPerSource == 0 {
+		config.MaxPerSource = drainDefaultMaxPerSource
+	}
+
+	if config.MaxPerTarget == 0 {
+		config.MaxPerTarget = drainDefaultMaxPerTarget
+	}
+
+	if config.MaxPerSource < 1 || config.MaxPerSource > drainMaxMigrations || config.MaxPerTarget < 1 || config.MaxPerTarget > drainMaxMigrations {
+		return fmt.Errorf("max_per_source and max_per_target must be between 1 and %d, got %d and %d", drainMaxMigrations, config.MaxPerSource, config.MaxPerTarget)
+	}
+
+	return nil
+}
+
+// validatePowerConfig checks the power management settings and fills in defaults for omitted values
+func validatePowerConfig(config *consul.PowerConfig) error {
+	if config.MinOnlineNodes == 0 {
+		config.MinOnlineNodes = powerDefaultMinOnlineNodes
+	}
+
+	if config.LowLoad == 0 {
+		config.LowLoad = powerDefaultLowLoad
+	}
+
+	if config.HighLoad == 0 {
+		config.HighLoad = powerDefaultHighLoad
+	}
+
+	if config.Cooldown == 0 {
+		config.Cooldown = powerDefaultCooldown
+	}
+
+	switch {
+	case config.MinOnlineNodes < 2:
+		return fmt.Errorf("min_online_nodes must be at least 2, got %d", config.MinOnlineNodes)
+	case config.LowLoad <= 0 || config.HighLoad >= 1 || config.LowLoad >= config.HighLoad:
+		return fmt.Errorf("low_load and high_load must satisfy 0 < low_load < high_load < 1, got %.2f and %.2f", config.LowLoad, config.HighLoad)
+	case config.Cooldown < 0:
+		return fmt.Errorf("cooldown must not be negative, got %d", config.Cooldown)
+	}
+
+	return nil
+}
+
+// validateBudgetConfig checks the change budget and fills in defaults for omitted values
+func validateBudgetConfig(config *consul.BudgetConfig) error {
+	if config.Window == 0 {
+		config.Window = budgetDefaultWindow
+	}
+
+	if config.MaxPerCycle < 0 || config.MaxPerWindow < 0 || config.Window < 0 {
+		return fmt.Errorf("max_per_cycle, max_per_window and window must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestDefaultSchedulerConfig(t *testing.T) {
+	assert.Equal(t, consul.SchedulerConfig{
+		PeriodicSeconds:        30,
+		APIRateLimitMillis:     500,
+		MaxNodePriority:        1000,
+		CDROMDetachUptimeHours: 24,
+		HAStateMaxAttempts:     30,
+		HAStateWaitSeconds:     10,
+	}, defaultCRSConfig().Scheduler)
+}
+
+func TestValidateSchedulerConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   consul.SchedulerConfig
+		expected consul.SchedulerConfig
+		errMsg   string
+	}{
+		{
+			name:     "defaults for omitted values",
+			config:   consul.SchedulerConfig{PeriodicSeconds: 60},
+			expected: consul.SchedulerConfig{PeriodicSeconds: 60, APIRateLimitMillis: 500, MaxNodePriority: 1000, CDROMDetachUptimeHours: 24, HAStateMaxAttempts: 30, HAStateWaitSeconds: 10},
+		},
+		{
+			name:   "interval too short",
+			config: consul.SchedulerConfig{PeriodicSeconds: 1},
+			errMsg: "periodic_seconds must be between 10 and 3600, got 1",
+		},
+		{
+			name:   "negative rate limit",
+			config: consul.SchedulerConfig{APIRateLimitMillis: -5},
+			errMsg: "api_rate_limit_ms must be between 1 and 10000, got -5",
+		},
+		{
+			name:   "priority above Proxmox range",
+			config: consul.SchedulerConfig{MaxNodePriority: 5000},
+			errMsg: "max_node_priority must be between 10 and 1000, got 5000",
+		},
+		{
+			name:   "too many attempts",
+			config: consul.SchedulerConfig{HAStateMaxAttempts: 1000},
+			errMsg: "ha_state_max_attempts must be between 1 and 360, got 1000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			err := validateSchedulerConfig(&config)
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, config)
+		})
+	}
+}
+
+func TestSchedulerConfigMaxNodePriority(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}}
+	testServer.config.Scheduler.MaxNodePriority = 100
+
+	assert.Equal(t, "pve1:100,pve2:95", testServer.generateExpectedPreferNodes(nodes, "pve1"))
+}
+
+func TestWaitForCycle(t *testing.T) {
+	fake, consulServer := newFakeConsul()
+	defer consulServer.Close()
+
+	t.Setenv("CONSUL_HTTP_ADDR", consulServer.URL)
+	client, err := consul.New()
+	require.NoError(t, err)
+
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+	testServer.consul = client
+
+	interval := time.Hour
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	testServer.requestReconcile()
+	assert.True(t, testServer.waitForCycle(context.Background(), ticker, &interval), "a requested cycle runs at once")
+
+	// The changed interval is reloaded and picked up before waiting again
+	fake.put(consul.SchedulerConfigKey, []byte(`{"periodic_seconds": 10}`))
+	testServer.notifyConfigChange()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.False(t, testServer.waitForCycle(ctx, ticker, &interval), "a config change alone starts no cycle")
+	assert.Equal(t, 10*time.Second, interval)
+}
+
+func TestLoadConfigDuringAPIRequests(t *testing.T) {
+	fake, consulServer := newFakeConsul()
+	defer consulServer.Close()
+
+	fake.put("crs/config/startup-tiers", []byte(`{"infra":{"order":1,"up":60}}`))
+	fake.put("crs/config/ha-classes", []byte(`{"db":{"max_relocate":2}}`))
+
+	t.Setenv("CONSUL_HTTP_ADDR", consulServer.URL)
+	client, err := consul.New()
+	require.NoError(t, err)
+
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.consul = client
+	testServer.apiToken = "secret"
+
+	// Run with -race: the reloads replace the settings while the API reads them
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			testServer.loadConfig()
+		}
+	}()
+
+	for range 20 {
+		code, body := serveAdmin(t, testServer, http.MethodGet, "/vms/603", "secret")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "order=1", body["expected_startup"])
+	}
+	<-done
+
+	assert.Equal(t, consul.StartupTier{Order: 1, Up: 60}, testServer.currentConfig().StartupTiers["infra"])
+	assert.Contains(t, testServer.currentConfig().HAClasses, "db")
+}
@@ -1,49 +1,139 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
 )
 
-// SetupCRS orchestrates the complete CRS setup process
-func (s *Server) SetupCRS() error {
-	// Try to register CRS tag, but don't fail if it doesn't work
-	if err := s.ensureCRSTagRegistered(); err != nil {
-		logging.Warnf("Failed to register CRS tag (this may be expected): %v", err)
-	}
+// crsStep is one stage of a SetupCRS cycle
+type crsStep struct {
+	name     string // Metric label
+	desc     string // Prefix of the returned error
+	run      func() error
+	optional bool // A failed optional step is logged and the cycle continues
+	readOnly bool // Runs while the health gate or the circuit breaker holds the cycle
+}
 
-	if err := s.SetupVMPin(); err != nil {
-		return fmt.Errorf("setup VM pin: %w", err)
+// crsSteps returns the SetupCRS stages in execution order
+func (s *Server) crsSteps() []crsStep {
+	return []crsStep{
+		// Registering the CRS tags may be rejected by older Proxmox versions or limited permissions
+		{name: "register_tags", desc: "register CRS tags", run: s.ensureCRSTagRegistered, optional: true},
+		{name: "setup_vm_pin", desc: "setup VM pin", run: s.SetupVMPin},
+		{name: "setup_vm_prefer", desc: "setup VM prefer", run: s.SetupVMPrefer},
+		{name: "setup_pool_groups", desc: "setup pool groups", run: s.SetupPoolGroups},
+		{name: "cleanup_orphaned_ha_groups", desc: "cleanup orphaned HA groups", run: s.CleanupOrphanedHAGroups},
+		{name: "remove_skipped_vms", desc: "remove skipped VMs from CRS groups", run: s.RemoveSkippedVMsFromCRSGroups},
+		{name: "update_ha_status", desc: "update HA status", run: s.UpdateHAStatus},
+		{name: "check_migrations", desc: "check migrations", run: s.CheckMigrations},
+		{name: "handle_node_maintenance", desc: "handle node maintenance", run: s.HandleNodeMaintenance},
+		{name: "maintenance_windows", desc: "apply maintenance windows", run: s.ApplyMaintenanceWindows},
+		{name: "drain_nodes", desc: "drain nodes", run: s.DrainNodes},
+		{name: "manage_power", desc: "manage node power", run: s.ManagePower},
+		{name: "update_vm_meta", desc: "update VM metadata", run: s.UpdateVMMeta},
+		{name: "setup_vm_ha_resources", desc: "setup VM HA resources", run: s.SetupVMHAResources},
+		{name: "enforce_affinity_rules", desc: "enforce affinity rules", run: s.EnforceAffinityRules},
+		{name: "balance_resources", desc: "balance resources", run: s.BalanceResources},
+		{name: "scale_instance_groups", desc: "scale instance groups", run: s.ScaleInstanceGroups},
+		{name: "prune_events", desc: "prune events", run: s.PruneEvents, optional: true},
+		{name: "collect_metrics", desc: "collect guest metrics", run: s.collectGuestMetrics, optional: true, readOnly: true},
 	}
+}
 
-	if err := s.SetupVMPrefer(); err != nil {
-		return fmt.Errorf("setup VM prefer: %w", err)
-	}
+// SetupCRS orchestrates the complete CRS setup process.
+// While the cluster is unhealthy or the circuit breaker is tripped only read-only steps run,
+// see checkHealthGate and spendBudget. Once ctx, the leadership of the cycle, is cancelled
+// no further step runs and every change is refused.
+func (s *Server) SetupCRS(ctx context.Context) (err error) {
+	cycle := cycleStatus{StartedAt: time.Now()}
+	defer func() {
+		if s.isPlanning() {
+			return
+		}
+
+		cycle.FinishedAt = time.Now()
+		if breaker := s.getBreakerState(); breaker != nil {
+			cycle.Breaker = breaker.Reason
+		}
+		if err != nil {
+			cycle.Error = err.Error()
+		}
 
-	if err := s.CleanupOrphanedHAGroups(); err != nil {
-		return fmt.Errorf("cleanup orphaned HA groups: %w", err)
+		metrics.CycleDuration.Observe(cycle.FinishedAt.Sub(cycle.StartedAt).Seconds())
+		s.setLastCycle(cycle)
+	}()
+
+	s.setLeaderContext(ctx)
+	defer s.setLeaderContext(nil)
+
+	s.resetPolicyCache()
+	s.startCycleBudget()
+
+	healthy, err := s.checkHealthGate()
+	if err != nil {
+		return err
 	}
 
-	if err := s.RemoveSkippedVMsFromCRSGroups(); err != nil {
-		return fmt.Errorf("remove skipped VMs from CRS groups: %w", err)
+	if !healthy {
+		cycle.HealthGate = s.currentHealthProblem()
 	}
 
-	if err := s.UpdateHAStatus(); err != nil {
-		return fmt.Errorf("update HA status: %w", err)
+	for _, step := range s.crsSteps() {
+		if err := s.leadershipErr(); err != nil {
+			return fmt.Errorf("stopped before %s: %w", step.desc, err)
+		}
+
+		if !step.readOnly && (!healthy || s.breakerHolds()) {
+			continue
+		}
+
+		started := time.Now()
+		stepErr := step.run()
+		cycle.Steps = append(cycle.Steps, s.recordStep(step.name, time.Since(started), stepErr))
+
+		if stepErr == nil {
+			continue
+		}
+
+		if errors.Is(stepErr, errBreakerTripped) {
+			// The remaining steps are skipped
+			continue
+		}
+
+		if step.optional {
+			logging.Warnf("Failed to %s (this may be expected): %v", step.desc, stepErr)
+			continue
+		}
+
+		return fmt.Errorf("%s: %w", step.desc, stepErr)
 	}
 
-	if err := s.HandleNodeMaintenance(); err != nil {
-		return fmt.Errorf("handle node maintenance: %w", err)
+	return nil
+}
+
+// recordStep records the duration and result of a SetupCRS step in the metrics and returns its status.
+// Plan mode is not recorded in the metrics.
+func (s *Server) recordStep(name string, duration time.Duration, err error) stepStatus {
+	status := stepStatus{
+		Name:     name,
+		Result:   metrics.ResultSuccess,
+		Duration: duration.Seconds(),
 	}
 
-	if err := s.UpdateVMMeta(); err != nil {
-		return fmt.Errorf("update VM metadata: %w", err)
+	if err != nil {
+		status.Result = metrics.ResultFailure
+		status.Error = err.Error()
 	}
 
-	if err := s.SetupVMHAResources(); err != nil {
-		return fmt.Errorf("setup VM HA resources: %w", err)
+	if !s.isPlanning() {
+		metrics.StepDuration.WithLabelValues(name).Observe(status.Duration)
+		metrics.StepRuns.WithLabelValues(name, status.Result).Inc()
 	}
 
-	return nil
+	return status
 }
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/tools"
+)
+
+// affinityRules tracks VM placement for crs-affinity-<name> and crs-anti-affinity-<name> tags.
+// Members of an affinity group belong on one node, members of an anti-affinity group on different nodes.
+type affinityRules struct {
+	placement    map[int]string   // VMID -> node
+	affinity     map[string][]int // Rule name -> member VMIDs
+	antiAffinity map[string][]int
+	vmAffinity   map[int][]string // VMID -> rule names
+	vmAnti       map[int][]string
+}
+
+// affinityViolation is a VM placed against one of its rules
+type affinityViolation struct {
+	VMID   int
+	Node   string
+	Reason string
+}
+
+// parseAffinityTags returns the affinity and anti-affinity rule names found in VM tags
+func parseAffinityTags(vmTags string) (affinity, antiAffinity []string) {
+	if vmTags == "" {
+		return nil, nil
+	}
+
+	for _, tag := range strings.Split(vmTags, ";") {
+		tag = strings.TrimSpace(tag)
+
+		switch {
+		case strings.HasPrefix(tag, crsAntiAffinityTagPrefix) && len(tag) > len(crsAntiAffinityTagPrefix):
+			antiAffinity = append(antiAffinity, strings.TrimPrefix(tag, crsAntiAffinityTagPrefix))
+		case strings.HasPrefix(tag, crsAffinityTagPrefix) && len(tag) > len(crsAffinityTagPrefix):
+			affinity = append(affinity, strings.TrimPrefix(tag, crsAffinityTagPrefix))
+		}
+	}
+
+	return affinity, antiAffinity
+}
+
+// buildAffinityRules collects the rules of all managed, non-template VMs and containers.
+// VMIDs are unique across both, so they share the rules.
+func (s *Server) buildAffinityRules(resources []proxmox.ClusterResource) *affinityRules {
+	rules := &affinityRules{
+		placement:    make(map[int]string),
+		affinity:     make(map[string][]int),
+		antiAffinity: make(map[string][]int),
+		vmAffinity:   make(map[int][]string),
+		vmAnti:       make(map[int][]string),
+	}
+
+	for _, resource := range resources {
+		guest := resource.Type == vmResourceType || resource.Type == ctResourceType
+		if !guest || resource.Template == vmTemplateFlag || s.hasVMSkipTag(resource.Tags) {
+			continue
+		}
+
+		affinity, antiAffinity := parseAffinityTags(resource.Tags)
+		if len(affinity) == 0 && len(antiAffinity) == 0 {
+			continue
+		}
+
+		rules.placement[resource.VMID] = resource.Node
+		rules.vmAffinity[resource.VMID] = affinity
+		rules.vmAnti[resource.VMID] = antiAffinity
+
+		for _, name := range affinity {
+			rules.affinity[name] = append(rules.affinity[name], resource.VMID)
+		}
+		for _, name := range antiAffinity {
+			rules.antiAffinity[name] = append(rules.antiAffinity[name], resource.VMID)
+		}
+	}
+
+	for _, members := range rules.affinity {
+		sort.Ints(members)
+	}
+	for _, members := range rules.antiAffinity {
+		sort.Ints(members)
+	}
+
+	return rules
+}
+
+// hasRules reports whether the VM is a member of any rule
+func (r *affinityRules) hasRules(vmid int) bool {
+	if r == nil {
+		return false
+	}
+	return len(r.vmAffinity[vmid]) > 0 || len(r.vmAnti[vmid]) > 0
+}
+
+// place records a (planned) move of a VM
+func (r *affinityRules) place(vmid int, node string) {
+	if r == nil {
+		return
+	}
+	if _, ok := r.placement[vmid]; ok {
+		r.placement[vmid] = node
+	}
+}
+
+// affinityAnchor returns the node hosting most members of an affinity group, ignoring one VM.
+// Members are counted in VMID order and a tie keeps the node that reached the count first,
+// so the group converges on a stable node.
+func (r *affinityRules) affinityAnchor(name string, ignoreVMID int) string {
+	counts := make(map[string]int)
+	var anchor string
+
+	for _, vmid := range r.affinity[name] {
+		if vmid == ignoreVMID {
+			continue
+		}
+
+		node := r.placement[vmid]
+		counts[node]++
+		if anchor == "" || counts[node] > counts[anchor] {
+			anchor = node
+		}
+	}
+
+	return anchor
+}
+
+// conflict returns why placing the VM on node would break one of its rules, or an empty string
+func (r *affinityRules) conflict(vmid int, node string) string {
+	if r == nil {
+		return ""
+	}
+
+	for _, name := range r.vmAnti[vmid] {
+		for _, other := range r.antiAffinity[name] {
+			if other != vmid && r.placement[other] == node {
+				return fmt.Sprintf("anti-affinity group %s: VM %d already runs on %s", name, other, node)
+			}
+		}
+	}
+
+	for _, name := range r.vmAffinity[vmid] {
+		if anchor := r.affinityAnchor(name, vmid); anchor != "" && anchor != node {
+			return fmt.Sprintf("affinity group %s: members run on %s", name, anchor)
+		}
+	}
+
+	return ""
+}
+
+// violations lists VMs that currently break a rule. For anti-affinity the lowest VMID on a node keeps its place.
+func (r *affinityRules) violations() []affinityViolation {
+	var result []affinityViolation
+	reported := make(map[int]bool)
+
+	vmids := make([]int, 0, len(r.placement))
+	for vmid := range r.placement {
+		vmids = append(vmids, vmid)
+	}
+	sort.Ints(vmids)
+
+	for _, vmid := range vmids {
+		node := r.placement[vmid]
+
+		for _, name := range r.vmAnti[vmid] {
+			for _, other := range r.antiAffinity[name] {
+				if other < vmid && r.placement[other] == node && !reported[vmid] {
+					reported[vmid] = true
+					result = append(result, affinityViolation{
+						VMID:   vmid,
+						Node:   node,
+						Reason: fmt.Sprintf("anti-affinity group %s: shares node %s with VM %d", name, node, other),
+					})
+				}
+			}
+		}
+
+		for _, name := range r.vmAffinity[vmid] {
+			if anchor := r.affinityAnchor(name, vmid); anchor != "" && anchor != node && !reported[vmid] {
+				// A member only violates the rule if the rest of the group is together elsewhere
+				if r.affinityAnchor(name, 0) != node {
+					reported[vmid] = true
+					result = append(result, affinityViolation{
+						VMID:   vmid,
+						Node:   node,
+						Reason: fmt.Sprintf("affinity group %s: runs on %s, group runs on %s", name, node, anchor),
+					})
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// EnforceAffinityRules reports guests that break their affinity rules and live-migrates those that can be moved safely.
+// Only running VMs in prefer groups are moved, pinned VMs and containers are reported only.
+func (s *Server) EnforceAffinityRules() error {
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	rules := s.buildAffinityRules(resources)
+	violations := rules.violations()
+	if len(violations) == 0 {
+		logging.Debug("No affinity rule violations found")
+		return nil
+	}
+
+	nodes := make(map[string]proxmox.Node)
+	vms := make(map[int]proxmox.ClusterResource)
+	for _, resource := range resources {
+		switch {
+		case resource.Type == "node" && resource.HAState == "maintenance":
+			// Evacuation is in progress and decides placement on its own
+			for _, violation := range violations {
+				logging.Warnf("Affinity violation for VM %d on %s: %s (not corrected while node %s is in maintenance)", violation.VMID, violation.Node, violation.Reason, resource.Node)
+			}
+			return nil
+		case resource.Type == "node" && resource.Status == "online" && !s.isDrainingNode(resource.Node):
+			nodes[resource.Node] = proxmox.Node{
+				Node:   resource.Node,
+				CPU:    resource.CPU,
+				MaxCPU: resource.MaxCPU,
+				Mem:    resource.Mem,
+				MaxMem: resource.MaxMem,
+			}
+		case resource.Type == vmResourceType || resource.Type == ctResourceType:
+			vms[resource.VMID] = resource
+		}
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	groupExists := make(map[string]bool, len(haGroups))
+	groupNodes := make(map[string]map[string]int, len(haGroups))
+	for _, group := range haGroups {
+		groupExists[group.Group] = true
+		groupNodes[group.Group] = parseHAGroupNodes(group.Nodes)
+	}
+
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return fmt.Errorf("failed to get storage info: %w", err)
+	}
+
+	busy := s.runningGuestTasks()
+
+	var corrected int
+	for _, violation := range violations {
+		logging.Warnf("Affinity violation for VM %d on %s: %s", violation.VMID, violation.Node, violation.Reason)
+
+		if corrected >= affinityMaxCorrectionsPerCycle {
+			continue
+		}
+
+		vm := vms[violation.VMID]
+		if vm.Type == ctResourceType {
+			logging.Warnf("Container %d (%s) cannot be live-migrated, affinity violation needs manual correction", vm.VMID, vm.Name)
+			continue
+		}
+
+		if task, ok := busy[vm.VMID]; ok {
+			logging.Infof("Not correcting affinity violation of VM %d (%s) while task %s is running", vm.VMID, vm.Name, task.Type)
+			continue
+		}
+
+		haResource := s.findHAResource(fmt.Sprintf("%s:%d", haResourceType, vm.VMID), haResources)
+		if vm.Status != vmStatusRunning || haResource == nil || haResource.State != haStateStarted || !strings.HasPrefix(haResource.Group, crsPreferGroupPrefix) {
+			logging.Warnf("VM %d (%s) is not a running prefer-group VM, affinity violation needs manual correction", vm.VMID, vm.Name)
+			continue
+		}
+
+		target, err := s.selectAffinityTarget(vm, rules, nodes, groupNodes[haResource.Group], storages)
+		if err != nil {
+			logging.Warnf("Cannot correct affinity violation for VM %d (%s): %v", vm.VMID, vm.Name, err)
+			continue
+		}
+
+		if err := s.liveMigrateAndRehome(vm.VMID, vm.Name, haResource.Group, vm.Node, target, "affinity: "+violation.Reason, groupExists); err != nil {
+			logging.Errorf("Failed to correct affinity violation for VM %d (%s): %v", vm.VMID, vm.Name, err)
+			continue
+		}
+
+		rules.place(vm.VMID, target)
+		corrected++
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// selectAffinityTarget picks the best node of the VM's HA group that satisfies its rules and has capacity
+func (s *Server) selectAffinityTarget(vm proxmox.ClusterResource, rules *affinityRules, nodes map[string]proxmox.Node, allowed map[string]int, storages []proxmox.Storage) (string, error) {
+	var candidates []proxmox.Node
+	for name, priority := range allowed {
+		node, online := nodes[name]
+		if !online || priority <= 0 || name == vm.Node || rules.conflict(vm.VMID, name) != "" {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no node in the VM's HA group satisfies its affinity rules")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Node < candidates[j].Node
+	})
+
+	// Candidates already satisfy the rules, so no rules are passed on
+	return s.selectMigrationTarget(proxmox.VM{VMID: vm.VMID, Name: vm.Name, Node: vm.Node}, candidates, storages, nil)
+}
+
+// affinityPreferGroup returns the prefer group for a new HA resource. If the VM's current node breaks
+// its rules, the prefer group of the first node that satisfies them is returned, and the affinity
+// enforcement moves the VM there.
+func (s *Server) affinityPreferGroup(vmid int, currentNode string, nodes []proxmox.Node, rules *affinityRules) string {
+	preferGroup := tools.GetHAVMPreferGroupName(currentNode)
+	if !rules.hasRules(vmid) || rules.conflict(vmid, currentNode) == "" {
+		return preferGroup
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Status == "online" {
+			names = append(names, node.Node)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if rules.conflict(vmid, name) == "" {
+			logging.Infof("VM %d breaks its affinity rules on %s, assigning prefer group of %s", vmid, currentNode, name)
+			return tools.GetHAVMPreferGroupName(name)
+		}
+	}
+
+	return preferGroup
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestParseAffinityTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         string
+		affinity     []string
+		antiAffinity []string
+	}{
+		{
+			name: "no tags",
+			tags: "",
+		},
+		{
+			name: "unrelated tags",
+			tags: "production;crs-critical",
+		},
+		{
+			name:         "mixed rules",
+			tags:         "crs-affinity-app; crs-anti-affinity-db;production",
+			affinity:     []string{"app"},
+			antiAffinity: []string{"db"},
+		},
+		{
+			name: "prefix without rule name",
+			tags: "crs-affinity-;crs-anti-affinity-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			affinity, antiAffinity := parseAffinityTags(tt.tags)
+			assert.Equal(t, tt.affinity, affinity)
+			assert.Equal(t, tt.antiAffinity, antiAffinity)
+		})
+	}
+}
+
+func testAffinityRules(t *testing.T, resources []proxmox.ClusterResource) *affinityRules {
+	t.Helper()
+
+	testServer, mockServer := createTestServer()
+	t.Cleanup(mockServer.Close)
+
+	return testServer.buildAffinityRules(resources)
+}
+
+func TestAffinityRulesConflict(t *testing.T) {
+	rules := testAffinityRules(t, []proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 101, Node: "pve2", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 200, Node: "pve1", Tags: "crs-affinity-app"},
+		{Type: "qemu", VMID: 201, Node: "pve1", Tags: "crs-affinity-app"},
+		{Type: "qemu", VMID: 300, Node: "pve3", Tags: "crs-skip;crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 301, Node: "pve3", Tags: "crs-anti-affinity-db", Template: 1},
+		{Type: "lxc", VMID: 400, Node: "pve3", Tags: "crs-anti-affinity-cache"},
+		{Type: "lxc", VMID: 401, Node: "pve2", Tags: "crs-anti-affinity-cache"},
+	})
+
+	assert.Contains(t, rules.conflict(100, "pve2"), "anti-affinity group db")
+	assert.Empty(t, rules.conflict(100, "pve3"), "skipped VMs and templates are not members")
+	assert.Contains(t, rules.conflict(200, "pve2"), "affinity group app")
+	assert.Empty(t, rules.conflict(200, "pve1"))
+	assert.Empty(t, rules.conflict(999, "pve1"), "VMs without rules never conflict")
+	assert.Contains(t, rules.conflict(400, "pve2"), "anti-affinity group cache", "containers are members")
+
+	var nilRules *affinityRules
+	assert.Empty(t, nilRules.conflict(100, "pve1"))
+	assert.False(t, nilRules.hasRules(100))
+
+	rules.place(101, "pve3")
+	assert.Empty(t, rules.conflict(100, "pve2"))
+}
+
+func TestAffinityRulesViolations(t *testing.T) {
+	rules := testAffinityRules(t, []proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 101, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 102, Node: "pve2", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 200, Node: "pve1", Tags: "crs-affinity-app"},
+		{Type: "qemu", VMID: 201, Node: "pve1", Tags: "crs-affinity-app"},
+		{Type: "qemu", VMID: 202, Node: "pve3", Tags: "crs-affinity-app"},
+	})
+
+	violations := rules.violations()
+	require.Len(t, violations, 2)
+
+	assert.Equal(t, 101, violations[0].VMID, "the lowest VMID keeps its node")
+	assert.Equal(t, "pve1", violations[0].Node)
+	assert.Contains(t, violations[0].Reason, "shares node pve1 with VM 100")
+
+	assert.Equal(t, 202, violations[1].VMID, "the member away from the group is moved")
+	assert.Contains(t, violations[1].Reason, "group runs on pve1")
+}
+
+func TestAffinityPreferGroup(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	rules := testServer.buildAffinityRules([]proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 101, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 102, Node: "pve2", Tags: "crs-anti-affinity-db"},
+	})
+
+	nodes := []proxmox.Node{
+		{Node: "pve1", Status: "online"},
+		{Node: "pve2", Status: "online"},
+		{Node: "pve3", Status: "offline"},
+		{Node: "pve4", Status: "online"},
+	}
+
+	assert.Equal(t, "crs-vm-prefer-pve2", testServer.affinityPreferGroup(102, "pve2", nodes, rules))
+	assert.Equal(t, "crs-vm-prefer-pve4", testServer.affinityPreferGroup(101, "pve1", nodes, rules))
+	assert.Equal(t, "crs-vm-prefer-pve1", testServer.affinityPreferGroup(999, "pve1", nodes, rules))
+}
+
+func TestComputeBalancingMovesWithAffinity(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	nodes := map[string]*drsNode{
+		"pve1": {Name: "pve1", UsedCPU: 6, MaxCPU: 8, Mem: 48 * gib, MaxMem: 64 * gib},
+		"pve2": {Name: "pve2", UsedCPU: 1, MaxCPU: 8, Mem: 8 * gib, MaxMem: 64 * gib},
+	}
+	allNodes := map[string]bool{"pve1": true, "pve2": true}
+	vms := []drsVM{
+		{VMID: 100, Node: "pve1", UsedCPU: 2, Mem: 16 * gib, AllowedNodes: allNodes},
+	}
+
+	rules := testServer.buildAffinityRules([]proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Node: "pve1", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 101, Node: "pve2", Tags: "crs-anti-affinity-db"},
+	})
+
+	assert.Empty(t, computeBalancingMoves(nodes, vms, 0.10, 2, rules))
+	assert.Len(t, computeBalancingMoves(nodes, vms, 0.10, 2, nil), 1)
+}
+
+func TestSelectMigrationTargetWithAffinity(t *testing.T) {
+	const mib = int64(1024 * 1024)
+
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeVMConfig: true})
+	defer mockServer.Close()
+
+	storages := []proxmox.Storage{{Storage: "local"}}
+	rules := testServer.buildAffinityRules([]proxmox.ClusterResource{
+		{Type: "qemu", VMID: 100, Node: "pve2", Tags: "crs-anti-affinity-db"},
+		{Type: "qemu", VMID: 101, Node: "pve3", Tags: "crs-anti-affinity-db"},
+	})
+	vm := proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"}
+
+	nodes := []proxmox.Node{
+		{Node: "pve1", MaxCPU: 8, Mem: 6000 * mib, MaxMem: 8192 * mib},
+		{Node: "pve3", MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
+	}
+	target, err := testServer.selectMigrationTarget(vm, nodes, storages, rules)
+	require.NoError(t, err)
+	assert.Equal(t, "pve1", target, "a node without conflict wins over a node with more free memory")
+
+	nodes = []proxmox.Node{
+		{Node: "pve3", MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
+	}
+	target, err = testServer.selectMigrationTarget(vm, nodes, storages, rules)
+	require.NoError(t, err)
+	assert.Equal(t, "pve3", target, "a conflicting node is used when nothing else fits")
+}
+
+func TestEnforceAffinityRules(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeAffinityVMs: true,
+		includeVMConfig:    true,
+		includeStorage:     true,
+		recorder:           recorder,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.EnforceAffinityRules())
+	assert.Equal(t, []string{"POST /api2/json/nodes/pve1/qemu/501/migrate"}, recorder.mutations(), "the VM is re-homed once it arrived")
+
+	require.NoError(t, testServer.CheckMigrations())
+	assert.Equal(t, []string{
+		"POST /api2/json/nodes/pve1/qemu/501/migrate",
+		"PUT /api2/json/cluster/ha/resources/vm:501",
+	}, recorder.mutations())
+}
+
+func TestEnforceAffinityRulesPlan(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeAffinityVMs: true,
+		includeVMConfig:    true,
+		includeStorage:     true,
+		recorder:           recorder,
+	})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+	require.NoError(t, testServer.EnforceAffinityRules())
+
+	assert.Empty(t, recorder.mutations())
+	require.Len(t, testServer.plan.Actions, 2)
+	assert.Equal(t, "pve2", testServer.plan.Actions[0].After)
+	assert.Contains(t, testServer.plan.Actions[0].Reason, "anti-affinity group db")
+	assert.Equal(t, "crs-vm-prefer-pve2", testServer.plan.Actions[1].After)
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// ScaleInstanceGroups reconciles the number of instances of every auto scaler group
+func (s *Server) ScaleInstanceGroups() error {
+	groups := s.currentConfig().AutoScaler
+	if len(groups) == 0 {
+		logging.Debug("No auto scaler groups configured")
+		return nil
+	}
+
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	for _, group := range groups {
+		// One broken group must not block the others
+		if err := s.scaleInstanceGroup(group, resources); err != nil {
+			logging.Errorf("Failed to scale instance group %s: %v", group.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// scaleInstanceGroup clones or removes instances until the group matches its desired count
+func (s *Server) scaleInstanceGroup(group consul.AutoScalerGroup, resources []proxmox.ClusterResource) error {
+	var template *proxmox.ClusterResource
+	for i := range resources {
+		if resources[i].Type == vmResourceType && resources[i].VMID == group.TemplateVMID {
+			template = &resources[i]
+			break
+		}
+	}
+
+	if template == nil {
+		return fmt.Errorf("template VM %d not found", group.TemplateVMID)
+	}
+
+	if template.Template != vmTemplateFlag {
+		return fmt.Errorf("VM %d is not a template", group.TemplateVMID)
+	}
+
+	members := s.autoScalerMembers(group, resources)
+	desired := s.autoScalerDesired(group, members)
+
+	switch {
+	case len(members) < desired:
+		logging.Infof("Scaling out instance group %s from %d to %d instances", group.Name, len(members), desired)
+		return s.scaleOutInstanceGroup(group, template, members, resources, desired)
+	case len(members) > desired:
+		logging.Infof("Scaling in instance group %s from %d to %d instances", group.Name, len(members), desired)
+		return s.scaleInInstanceGroup(group, members, desired)
+	}
+
+	logging.Debugf("Instance group %s has the desired %d instances", group.Name, desired)
+	return s.startStoppedMembers(group, members)
+}
+
+// autoScalerMembers returns the group members ordered by VMID
+func (s *Server) autoScalerMembers(group consul.AutoScalerGroup, resources []proxmox.ClusterResource) []proxmox.ClusterResource {
+	tag := autoScalerTagPrefix + group.Name
+
+	var members []proxmox.ClusterResource
+	for _, resource := range resources {
+		if resource.Type != vmResourceType || resource.Template == vmTemplateFlag {
+			continue
+		}
+
+		if s.hasVMTag(resource.Tags, tag) {
+			members = append(members, resource)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].VMID < members[j].VMID
+	})
+
+	return members
+}
+
+// sortMembersByAge orders members from oldest to newest by the creation time in their config.
+// Proxmox hands out the lowest free VMID, so a new instance can have a lower VMID than an older one.
+func (s *Server) sortMembersByAge(members []proxmox.ClusterResource) error {
+	created := make(map[int]time.Time, len(members))
+	for _, member := range members {
+		config, err := s.proxmox.GetVMConfig(member.Node, member.VMID)
+		if err != nil {
+			return fmt.Errorf("failed to get config of VM %d: %w", member.VMID, err)
+		}
+		created[member.VMID] = vmCreationTime(config.Meta)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return created[members[i].VMID].Before(created[members[j].VMID])
+	})
+
+	return nil
+}
+
+// vmCreationTime returns the ctime of a VM meta setting such as "creation-qemu=8.1.2,ctime=1700000000".
+// VMs created before Proxmox recorded it get the zero time and count as the oldest.
+func vmCreationTime(meta string) time.Time {
+	for _, option := range strings.Split(meta, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(option), "ctime=")
+		if !ok {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		return time.Unix(seconds, 0)
+	}
+
+	return time.Time{}
+}
+
+// autoScalerDesired returns the instance count the group should have, applying the CPU scaling rules.
+// The decision is stored in Consul so that a new leader continues where the previous one stopped.
+func (s *Server) autoScalerDesired(group consul.AutoScalerGroup, members []proxmox.ClusterResource) int {
+	desired := group.Desired
+	var lastScale time.Time
+
+	state := s.getAutoScalerState(group.Name)
+	if state != nil && state.ConfigDesired == group.Desired {
+		desired = state.Desired
+		lastScale = state.UpdatedAt
+	} else {
+		// The group is new or an operator changed its desired count, which overrides earlier decisions
+		s.putAutoScalerState(group, group.Desired)
+		lastScale = time.Now()
+	}
+
+	desired = min(max(desired, group.Min), group.Max)
+
+	// Scaling rules only apply to a settled group, otherwise booting instances skew the average
+	if len(members) == 0 || len(members) != desired || time.Since(lastScale) < time.Duration(group.Cooldown)*time.Second {
+		return desired
+	}
+
+	var cpuSum float64
+	for _, member := range members {
+		if member.Status != vmStatusRunning {
+			return desired
+		}
+		cpuSum += member.CPU
+	}
+	avgCPU := cpuSum / float64(len(members))
+
+	next := desired
+	switch {
+	case group.ScaleOutCPU > 0 && avgCPU >= group.ScaleOutCPU && desired < group.Max:
+		next = desired + 1
+	case group.ScaleInCPU > 0 && avgCPU <= group.ScaleInCPU && desired > group.Min:
+		next = desired - 1
+	}
+
+	if next != desired {
+		logging.Infof("Instance group %s average CPU %.2f, changing desired instances from %d to %d", group.Name, avgCPU, desired, next)
+		s.putAutoScalerState(group, next)
+	}
+
+	return next
+}
+
+// getAutoScalerState loads the stored scaling decision of a group, nil means none is stored
+func (s *Server) getAutoScalerState(name string) *consul.AutoScalerState {
+	state, err := s.consul.GetAutoScalerState(name)
+	if err != nil {
+		logging.Errorf("Failed to load state of instance group %s: %v", name, err)
+		return nil
+	}
+
+	return state
+}
+
+// putAutoScalerState stores the scaling decision of a group
+func (s *Server) putAutoScalerState(group consul.AutoScalerGroup, desired int) {
+	if s.isPlanning() {
+		return
+	}
+
+	state := consul.AutoScalerState{
+		Desired:       desired,
+		ConfigDesired: group.Desired,
+		UpdatedAt:     time.Now().UTC(),
+	}
+
+	if err := s.consul.PutAutoScalerState(group.Name, state); err != nil {
+		logging.Errorf("Failed to store state of instance group %s: %v", group.Name, err)
+	}
+}
+
+// scaleOutInstanceGroup clones the template, tags the clone as a group member and starts it
+func (s *Server) scaleOutInstanceGroup(group consul.AutoScalerGroup, template *proxmox.ClusterResource, members []proxmox.ClusterResource, resources []proxmox.ClusterResource, desired int) error {
+	tags := autoScalerMemberTags(template.Tags, autoScalerTagPrefix+group.Name)
+
+	instancesPerNode := make(map[string]int)
+	for _, member := range members {
+		instancesPerNode[member.Node]++
+	}
+
+	var lastID int
+	for count := len(members); count < desired; count++ {
+		// Clones made in this loop are not in resources yet
+		if err := s.checkPoolMemoryShare(group.Pool, resources, int64(count-len(members)+1)*template.MaxMem); err != nil {
+			return fmt.Errorf("not scaling out: %w", err)
+		}
+
+		target, err := s.selectAutoScalerNode(group, resources, instancesPerNode)
+		if err != nil {
+			return err
+		}
+
+		newID, err := s.proxmox.GetClusterNextID()
+		if err != nil {
+			return err
+		}
+
+		// The next ID does not advance until the clone exists, which never happens in plan mode
+		if newID <= lastID {
+			newID = lastID + 1
+		}
+		lastID = newID
+
+		clone := proxmox.CloneOptions{
+			NewID:   newID,
+			Name:    fmt.Sprintf("%s-%d", group.Name, newID),
+			Full:    group.FullClone,
+			Storage: group.Storage,
+			Pool:    group.Pool,
+		}
+		if target != template.Node {
+			clone.Target = target
+		}
+
+		taskID, err := s.applyAction(Action{
+			Kind:     ActionCreate,
+			Resource: ResourceVM,
+			ID:       strconv.Itoa(newID),
+			Node:     template.Node,
+			VMID:     template.VMID,
+			After:    target,
+			Reason:   fmt.Sprintf("instance group %s: %d of %d instances", group.Name, count, desired),
+			Clone:    &clone,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clone template %d: %w", template.VMID, err)
+		}
+
+		if err := s.waitForTask(template.Node, taskID, autoScalerCloneTimeout); err != nil {
+			return fmt.Errorf("failed to clone template %d to VM %d: %w", template.VMID, newID, err)
+		}
+
+		// The tag makes the clone a member, so it is set before anything else can fail
+		if _, err := s.applyAction(Action{
+			Kind:     ActionUpdate,
+			Resource: ResourceVMConfig,
+			ID:       strconv.Itoa(newID),
+			Node:     target,
+			VMID:     newID,
+			Before:   template.Tags,
+			After:    tags,
+			Reason:   fmt.Sprintf("tag instance of group %s", group.Name),
+			VMConfig: &proxmox.VMConfig{Tags: tags},
+		}); err != nil {
+			return fmt.Errorf("failed to tag VM %d: %w", newID, err)
+		}
+
+		if _, err := s.applyAction(Action{
+			Kind:     ActionStart,
+			Resource: ResourceVM,
+			ID:       strconv.Itoa(newID),
+			Node:     target,
+			VMID:     newID,
+			Reason:   fmt.Sprintf("start instance of group %s", group.Name),
+		}); err != nil {
+			return fmt.Errorf("failed to start VM %d: %w", newID, err)
+		}
+
+		logging.Infof("Created instance %s (VM %d) of group %s on node %s", clone.Name, newID, group.Name, target)
+		instancesPerNode[target]++
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// scaleInInstanceGroup shuts down and deletes the newest members
+func (s *Server) scaleInInstanceGroup(group consul.AutoScalerGroup, members []proxmox.ClusterResource, desired int) error {
+	members = slices.Clone(members)
+	if err := s.sortMembersByAge(members); err != nil {
+		return err
+	}
+
+	for i := len(members) - 1; i >= desired; i-- {
+		member := members[i]
+		id := strconv.Itoa(member.VMID)
+		reason := fmt.Sprintf("instance group %s: %d of %d instances", group.Name, i+1, desired)
+
+		// Proxmox refuses to delete HA-managed VMs, and HA would restart the VM after the shutdown
+		if member.HAState != "" {
+			sid := fmt.Sprintf("%s:%d", haResourceType, member.VMID)
+			if _, err := s.applyAction(Action{
+				Kind:     ActionDelete,
+				Resource: ResourceHAResource,
+				ID:       sid,
+				Node:     member.Node,
+				VMID:     member.VMID,
+				Before:   member.HAState,
+				Reason:   reason,
+			}); err != nil {
+				return fmt.Errorf("failed to remove HA resource %s: %w", sid, err)
+			}
+		}
+
+		if member.Status == vmStatusRunning {
+			taskID, err := s.applyAction(Action{
+				Kind:     ActionShutdown,
+				Resource: ResourceVM,
+				ID:       id,
+				Node:     member.Node,
+				VMID:     member.VMID,
+				Reason:   reason,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to shut down VM %d: %w", member.VMID, err)
+			}
+
+			if err := s.waitForTask(member.Node, taskID, autoScalerShutdownTimeout); err != nil {
+				return fmt.Errorf("failed to shut down VM %d: %w", member.VMID, err)
+			}
+		}
+
+		taskID, err := s.applyAction(Action{
+			Kind:     ActionDelete,
+			Resource: ResourceVM,
+			ID:       id,
+			Node:     member.Node,
+			VMID:     member.VMID,
+			Before:   member.Name,
+			Reason:   reason,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete VM %d: %w", member.VMID, err)
+		}
+
+		if err := s.waitForTask(member.Node, taskID, autoScalerDeleteTimeout); err != nil {
+			return fmt.Errorf("failed to delete VM %d: %w", member.VMID, err)
+		}
+
+		logging.Infof("Removed instance %s (VM %d) of group %s from node %s", member.Name, member.VMID, group.Name, member.Node)
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// startStoppedMembers starts members that are stopped and not managed by HA, such as a clone whose start failed
+func (s *Server) startStoppedMembers(group consul.AutoScalerGroup, members []proxmox.ClusterResource) error {
+	for _, member := range members {
+		if member.Status != vmStatusStopped || member.HAState != "" {
+			continue
+		}
+
+		if _, err := s.applyAction(Action{
+			Kind:     ActionStart,
+			Resource: ResourceVM,
+			ID:       strconv.Itoa(member.VMID),
+			Node:     member.Node,
+			VMID:     member.VMID,
+			Reason:   fmt.Sprintf("instance of group %s is stopped", group.Name),
+		}); err != nil {
+			return fmt.Errorf("failed to start VM %d: %w", member.VMID, err)
+		}
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// selectAutoScalerNode spreads instances over the group's nodes that take new guests, preferring the node
+// with the fewest instances and then the most free memory
+func (s *Server) selectAutoScalerNode(group consul.AutoScalerGroup, resources []proxmox.ClusterResource, instancesPerNode map[string]int) (string, error) {
+	allowed := make(map[string]bool, len(group.Nodes))
+	for _, node := range group.Nodes {
+		allowed[node] = true
+	}
+
+	var best *proxmox.ClusterResource
+	for i := range resources {
+		node := &resources[i]
+		if node.Type != "node" || node.Status != "online" || node.HAState == "maintenance" {
+			continue
+		}
+
+		// A drain would move the new instance off again, and excluded nodes get no new guests
+		if s.isDrainingNode(node.Node) || s.isNodeExcluded(node.Node) {
+			continue
+		}
+
+		if len(allowed) > 0 && !allowed[node.Node] {
+			continue
+		}
+
+		if best == nil {
+			best = node
+			continue
+		}
+
+		nodeCount, bestCount := instancesPerNode[node.Node], instancesPerNode[best.Node]
+		if nodeCount < bestCount || (nodeCount == bestCount && node.MaxMem-node.Mem > best.MaxMem-best.Mem) {
+			best = node
+		}
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("no online node available for instance group %s", group.Name)
+	}
+
+	return best.Node, nil
+}
+
+// autoScalerMemberTags adds the group tag to the tags inherited from the template
+func autoScalerMemberTags(templateTags, groupTag string) string {
+	tags := []string{groupTag}
+	for _, tag := range strings.Split(templateTags, ";") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && tag != groupTag {
+			tags = append(tags, tag)
+		}
+	}
+
+	return strings.Join(tags, ";")
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func testAutoScalerResources(members ...proxmox.ClusterResource) []proxmox.ClusterResource {
+	resources := []proxmox.ClusterResource{
+		{Type: "node", Node: "pve1", Status: "online", MaxMem: 64 * gib, Mem: 32 * gib},
+		{Type: "node", Node: "pve2", Status: "online", MaxMem: 64 * gib, Mem: 8 * gib},
+		{Type: "node", Node: "pve3", Status: "online", HAState: "maintenance", MaxMem: 64 * gib},
+		{Type: "qemu", VMID: 900, Name: "ci-runner-template", Node: "pve1", Status: "stopped", Template: 1, Tags: "ci"},
+	}
+	return append(resources, members...)
+}
+
+func testAutoScalerGroup() consul.AutoScalerGroup {
+	return consul.AutoScalerGroup{
+		Name:         "ci",
+		TemplateVMID: 900,
+		Min:          1,
+		Max:          3,
+		Desired:      2,
+		ScaleOutCPU:  0.8,
+		ScaleInCPU:   0.2,
+	}
+}
+
+func TestScaleInstanceGroup(t *testing.T) {
+	tests := []struct {
+		name      string
+		group     func() consul.AutoScalerGroup
+		members   []proxmox.ClusterResource
+		mutations []string
+	}{
+		{
+			name:  "scale out clones, tags and starts",
+			group: testAutoScalerGroup,
+			members: []proxmox.ClusterResource{
+				{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+			},
+			mutations: []string{
+				"POST /api2/json/nodes/pve1/qemu/900/clone",
+				"PUT /api2/json/nodes/pve2/qemu/903/config",
+				"POST /api2/json/nodes/pve2/qemu/903/status/start",
+			},
+		},
+		{
+			name: "scale in removes newest instance",
+			group: func() consul.AutoScalerGroup {
+				group := testAutoScalerGroup()
+				group.Desired = 1
+				return group
+			},
+			members: []proxmox.ClusterResource{
+				{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+				{Type: "qemu", VMID: 902, Name: "ci-902", Node: "pve2", Status: "running", HAState: "started", Tags: "ci;crs-asg-ci", CPU: 0.5},
+			},
+			mutations: []string{
+				"DELETE /api2/json/cluster/ha/resources/vm:902",
+				"POST /api2/json/nodes/pve2/qemu/902/status/shutdown",
+				"DELETE /api2/json/nodes/pve2/qemu/902",
+			},
+		},
+		{
+			name:  "high CPU adds an instance",
+			group: testAutoScalerGroup,
+			members: []proxmox.ClusterResource{
+				{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.9},
+				{Type: "qemu", VMID: 902, Name: "ci-902", Node: "pve2", Status: "running", Tags: "crs-asg-ci", CPU: 0.9},
+			},
+			mutations: []string{
+				"POST /api2/json/nodes/pve1/qemu/900/clone",
+				"PUT /api2/json/nodes/pve2/qemu/903/config",
+				"POST /api2/json/nodes/pve2/qemu/903/status/start",
+			},
+		},
+		{
+			name:  "stopped instance without HA is started",
+			group: testAutoScalerGroup,
+			members: []proxmox.ClusterResource{
+				{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+				{Type: "qemu", VMID: 902, Name: "ci-902", Node: "pve2", Status: "stopped", Tags: "crs-asg-ci"},
+			},
+			mutations: []string{
+				"POST /api2/json/nodes/pve2/qemu/902/status/start",
+			},
+		},
+		{
+			name:  "settled group within thresholds",
+			group: testAutoScalerGroup,
+			members: []proxmox.ClusterResource{
+				{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+				{Type: "qemu", VMID: 902, Name: "ci-902", Node: "pve2", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+			},
+			mutations: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &requestRecorder{}
+			testServer, mockServer := createTestServerWithConfig(testHandlerConfig{recorder: recorder})
+			defer mockServer.Close()
+
+			err := testServer.scaleInstanceGroup(tt.group(), testAutoScalerResources(tt.members...))
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.mutations, recorder.mutations())
+		})
+	}
+}
+
+func TestScaleInstanceGroupRemovesNewestInstance(t *testing.T) {
+	// VM 901 reused a VMID freed before VM 902 was created
+	created := map[string]string{
+		"/api2/json/nodes/pve1/qemu/901/config": "creation-qemu=8.1.2,ctime=1700000200",
+		"/api2/json/nodes/pve2/qemu/902/config": "creation-qemu=8.1.2,ctime=1700000100",
+	}
+
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		cluster: func(w http.ResponseWriter, r *http.Request) bool {
+			meta, ok := created[r.URL.Path]
+			if !ok || r.Method != http.MethodGet {
+				return false
+			}
+			fmt.Fprintf(w, `{"data": {"name": "ci", "meta": %q}}`, meta)
+			return true
+		},
+		recorder: recorder,
+	})
+	defer mockServer.Close()
+
+	group := testAutoScalerGroup()
+	group.Desired = 1
+	members := []proxmox.ClusterResource{
+		{Type: "qemu", VMID: 901, Name: "ci-901", Node: "pve1", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+		{Type: "qemu", VMID: 902, Name: "ci-902", Node: "pve2", Status: "running", Tags: "crs-asg-ci", CPU: 0.5},
+	}
+
+	require.NoError(t, testServer.scaleInstanceGroup(group, testAutoScalerResources(members...)))
+	assert.Equal(t, []string{
+		"POST /api2/json/nodes/pve1/qemu/901/status/shutdown",
+		"DELETE /api2/json/nodes/pve1/qemu/901",
+	}, recorder.mutations())
+}
+
+func TestVMCreationTime(t *testing.T) {
+	assert.Equal(t, time.Unix(1700000000, 0), vmCreationTime("creation-qemu=8.1.2,ctime=1700000000"))
+	assert.True(t, vmCreationTime("").IsZero(), "VMs without a ctime count as the oldest")
+	assert.True(t, vmCreationTime("ctime=soon").IsZero())
+}
+
+func TestScaleInstanceGroupTemplateErrors(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{})
+	defer mockServer.Close()
+
+	group := testAutoScalerGroup()
+	group.TemplateVMID = 999
+	assert.ErrorContains(t, testServer.scaleInstanceGroup(group, testAutoScalerResources()), "not found")
+
+	resources := testAutoScalerResources()
+	resources[3].Template = 0
+	assert.ErrorContains(t, testServer.scaleInstanceGroup(testAutoScalerGroup(), resources), "not a template")
+}
+
+func TestScaleInstanceGroupPlan(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{recorder: recorder})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+
+	group := testAutoScalerGroup()
+	group.Desired = 3
+	require.NoError(t, testServer.scaleInstanceGroup(group, testAutoScalerResources()))
+
+	assert.Empty(t, recorder.mutations())
+	require.Len(t, testServer.plan.Actions, 9)
+
+	// Every planned clone gets its own VMID even though the next ID does not advance
+	assert.Equal(t, 903, testServer.plan.Actions[0].Clone.NewID)
+	assert.Equal(t, 904, testServer.plan.Actions[3].Clone.NewID)
+	assert.Equal(t, 905, testServer.plan.Actions[6].Clone.NewID)
+	assert.Equal(t, "ci-903", testServer.plan.Actions[0].Clone.Name)
+	assert.Equal(t, "crs-asg-ci;ci", testServer.plan.Actions[1].VMConfig.Tags)
+
+	for _, action := range testServer.plan.Actions {
+		assert.NoError(t, action.validate())
+	}
+}
+
+func TestSelectAutoScalerNode(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{})
+	defer mockServer.Close()
+
+	resources := testAutoScalerResources()
+
+	node, err := testServer.selectAutoScalerNode(testAutoScalerGroup(), resources, map[string]int{})
+	require.NoError(t, err)
+	assert.Equal(t, "pve2", node, "most free memory wins")
+
+	node, err = testServer.selectAutoScalerNode(testAutoScalerGroup(), resources, map[string]int{"pve2": 1})
+	require.NoError(t, err)
+	assert.Equal(t, "pve1", node, "fewest instances wins")
+
+	group := testAutoScalerGroup()
+	group.Nodes = []string{"pve3"}
+	_, err = testServer.selectAutoScalerNode(group, resources, map[string]int{})
+	assert.Error(t, err, "nodes in maintenance are not used")
+}
+
+func TestSelectAutoScalerNodeSkipsDrainingAndExcluded(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{})
+	defer mockServer.Close()
+
+	resources := testAutoScalerResources()
+
+	testServer.drains = map[string]*consul.DrainState{
+		"pve2": {Node: "pve2", Status: consul.DrainStatusDraining},
+	}
+
+	node, err := testServer.selectAutoScalerNode(testAutoScalerGroup(), resources, map[string]int{"pve1": 2})
+	require.NoError(t, err)
+	assert.Equal(t, "pve1", node, "the only other node is draining")
+
+	group := testAutoScalerGroup()
+	group.Nodes = []string{"pve2"}
+	_, err = testServer.selectAutoScalerNode(group, resources, map[string]int{})
+	assert.Error(t, err, "draining nodes are not used")
+
+	testServer.drains = nil
+	testServer.config.Nodes = map[string]consul.NodeAttributes{"pve2": {Excluded: true}}
+
+	node, err = testServer.selectAutoScalerNode(testAutoScalerGroup(), resources, map[string]int{"pve1": 2})
+	require.NoError(t, err)
+	assert.Equal(t, "pve1", node, "excluded nodes are not used")
+}
+
+func TestValidateAutoScalerGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*consul.AutoScalerGroup)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(*consul.AutoScalerGroup) {}},
+		{name: "invalid name", modify: func(g *consul.AutoScalerGroup) { g.Name = "CI_Runners" }, wantErr: true},
+		{name: "missing template", modify: func(g *consul.AutoScalerGroup) { g.TemplateVMID = 0 }, wantErr: true},
+		{name: "max below min", modify: func(g *consul.AutoScalerGroup) { g.Max = 0 }, wantErr: true},
+		{name: "desired above max", modify: func(g *consul.AutoScalerGroup) { g.Desired = 4 }, wantErr: true},
+		{name: "cpu above 1", modify: func(g *consul.AutoScalerGroup) { g.ScaleOutCPU = 80 }, wantErr: true},
+		{name: "scale in above scale out", modify: func(g *consul.AutoScalerGroup) { g.ScaleInCPU = 0.9 }, wantErr: true},
+		{name: "negative cooldown", modify: func(g *consul.AutoScalerGroup) { g.Cooldown = -1 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := testAutoScalerGroup()
+			tt.modify(&group)
+
+			err := validateAutoScalerGroup(&group)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, autoScalerDefaultCooldown, group.Cooldown)
+		})
+	}
+
+	group := consul.AutoScalerGroup{Name: "ci", TemplateVMID: 900, Min: 2, Max: 4}
+	require.NoError(t, validateAutoScalerGroup(&group))
+	assert.Equal(t, 2, group.Desired, "desired defaults to min")
+}
+
+func TestAutoScalerMemberTags(t *testing.T) {
+	assert.Equal(t, "crs-asg-ci", autoScalerMemberTags("", "crs-asg-ci"))
+	assert.Equal(t, "crs-asg-ci;ci;linux", autoScalerMemberTags("ci; linux", "crs-asg-ci"))
+	assert.Equal(t, "crs-asg-ci;ci", autoScalerMemberTags("crs-asg-ci;ci", "crs-asg-ci"))
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+)
+
+// errBreakerTripped is returned for every action while the circuit breaker halts automation
+var errBreakerTripped = errors.New("circuit breaker tripped")
+
+// isBudgetedAction reports whether an action counts against the change budget.
+// These are the changes a wrong view of the cluster repeats for every guest, migrations and node actions have their own limits.
+func isBudgetedAction(action Action) bool {
+	switch action.Resource {
+	case ResourceHAGroup, ResourceHAResource, ResourceVMConfig, ResourceCTConfig:
+		return true
+	default:
+		return false
+	}
+}
+
+// spendBudget counts an action against the change budget before it is executed.
+// An action that goes over a limit trips the circuit breaker, and no action runs while the breaker is tripped.
+func (s *Server) spendBudget(action Action, now time.Time) error {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if s.breaker != nil {
+		return fmt.Errorf("%w: %s", errBreakerTripped, s.breaker.Reason)
+	}
+
+	if !isBudgetedAction(action) {
+		return nil
+	}
+
+	budget := s.currentConfig().Budget
+	window := time.Duration(budget.Window) * time.Second
+
+	recent := s.windowChanges[:0]
+	for _, changedAt := range s.windowChanges {
+		if now.Sub(changedAt) < window {
+			recent = append(recent, changedAt)
+		}
+	}
+	s.windowChanges = recent
+
+	var reason string
+	switch {
+	case budget.MaxPerCycle > 0 && s.cycleChanges >= budget.MaxPerCycle:
+		reason = fmt.Sprintf("more than %d changes in one cycle", budget.MaxPerCycle)
+	case budget.MaxPerWindow > 0 && len(s.windowChanges) >= budget.MaxPerWindow:
+		reason = fmt.Sprintf("more than %d changes within %s", budget.MaxPerWindow, window)
+	default:
+		s.cycleChanges++
+		s.windowChanges = append(s.windowChanges, now)
+		return nil
+	}
+
+	s.tripBreaker(fmt.Sprintf("%s, stopped before %s", reason, action), now)
+
+	return fmt.Errorf("%w: %s", errBreakerTripped, s.breaker.Reason)
+}
+
+// tripBreaker halts automation until an operator acknowledges it. The caller holds budgetMu.
+func (s *Server) tripBreaker(reason string, now time.Time) {
+	state := &consul.BreakerState{TrippedAt: now.UTC(), Reason: reason}
+
+	if err := s.consul.PutBreakerState(*state); err != nil {
+		logging.Errorf("Failed to store circuit breaker state, it only holds on this instance: %v", err)
+	}
+
+	s.breaker = state
+	metrics.BreakerTripped.Set(1)
+
+	logging.Errorf("Circuit breaker tripped, CRS makes no changes until it is acknowledged: %s", reason)
+	s.putEvent(consul.Event{
+		Time:     state.TrippedAt,
+		Kind:     eventKindBreaker,
+		Resource: eventResourceCluster,
+		ID:       eventResourceCluster,
+		Reason:   reason,
+		Result:   breakerTripped,
+	})
+}
+
+// acknowledgeBreaker resets a tripped circuit breaker together with the change budget.
+// It returns false when the breaker was not tripped.
+func (s *Server) acknowledgeBreaker() (bool, error) {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if s.breaker == nil {
+		return false, nil
+	}
+
+	if err := s.consul.DeleteBreakerState(); err != nil {
+		return false, err
+	}
+
+	logging.Infof("Circuit breaker acknowledged, CRS resumes changes (%s)", s.breaker.Reason)
+	s.putEvent(consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     eventKindBreaker,
+		Resource: eventResourceCluster,
+		ID:       eventResourceCluster,
+		Reason:   "acknowledged: " + s.breaker.Reason,
+		Result:   breakerAcknowledged,
+	})
+
+	s.resetBreaker()
+
+	return true, nil
+}
+
+// resetBreaker clears the circuit breaker and the changes it counted. The caller holds budgetMu.
+func (s *Server) resetBreaker() {
+	s.breaker = nil
+	s.cycleChanges = 0
+	s.windowChanges = nil
+	metrics.BreakerTripped.Set(0)
+}
+
+// loadBreakerState refreshes the circuit breaker from Consul, keeping the current state when it fails to load.
+// A breaker acknowledged on another instance also resets the change budget here.
+func (s *Server) loadBreakerState() {
+	state, err := s.consul.GetBreakerState()
+	if err != nil {
+		logging.Errorf("Failed to load circuit breaker state, keeping current state: %v", err)
+		return
+	}
+
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if state == nil {
+		if s.breaker != nil {
+			s.resetBreaker()
+		}
+		return
+	}
+
+	s.breaker = state
+	metrics.BreakerTripped.Set(1)
+}
+
+// getBreakerState returns a copy of the tripped circuit breaker, or nil when it is not tripped
+func (s *Server) getBreakerState() *consul.BreakerState {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	if s.breaker == nil {
+		return nil
+	}
+
+	state := *s.breaker
+	return &state
+}
+
+// breakerHolds reports whether the circuit breaker keeps the cycle from making changes, plan mode only warns
+func (s *Server) breakerHolds() bool {
+	return !s.isPlanning() && s.getBreakerState() != nil
+}
+
+// startCycleBudget starts counting the changes of a new cycle
+func (s *Server) startCycleBudget() {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+
+	s.cycleChanges = 0
+}
+
+// checkPlanBudget warns when applying a plan would trip the circuit breaker
+func (s *Server) checkPlanBudget(plan *Plan) {
+	if breaker := s.getBreakerState(); breaker != nil {
+		logging.Warnf("Circuit breaker is tripped (%s), a running CRS makes no changes until it is acknowledged", breaker.Reason)
+	}
+
+	changes := 0
+	for _, action := range plan.Actions {
+		if isBudgetedAction(action) {
+			changes++
+		}
+	}
+
+	if limit := s.currentConfig().Budget.MaxPerCycle; limit > 0 && changes > limit {
+		logging.Warnf("The plan makes %d changes, more than the %d allowed per cycle, the circuit breaker would trip", changes, limit)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func budgetTestAction(group string) Action {
+	return Action{
+		Kind:     ActionUpdate,
+		Resource: ResourceHAGroup,
+		ID:       group,
+		HAGroup:  &proxmox.ClusterHAGroup{Group: group, Nodes: "pve1"},
+	}
+}
+
+func TestApplyActionBudget(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{recorder: recorder})
+	defer mockServer.Close()
+	testServer.config.Budget = consul.BudgetConfig{MaxPerCycle: 2, Window: budgetDefaultWindow}
+
+	for _, group := range []string{"crs-vm-pin-pve1", "crs-vm-pin-pve2"} {
+		_, err := testServer.applyAction(budgetTestAction(group))
+		require.NoError(t, err)
+	}
+
+	_, err := testServer.applyAction(budgetTestAction("crs-vm-pin-pve3"))
+	require.ErrorIs(t, err, errBreakerTripped)
+	assert.Contains(t, err.Error(), "more than 2 changes in one cycle, stopped before update ha-group crs-vm-pin-pve3")
+
+	_, err = testServer.applyAction(Action{
+		Kind:      ActionMigrate,
+		Resource:  ResourceVM,
+		ID:        "100",
+		Node:      "pve1",
+		VMID:      100,
+		Migration: &proxmox.MigrationOptions{Target: "pve2", Online: true},
+	})
+	assert.ErrorIs(t, err, errBreakerTripped, "nothing runs while the breaker is tripped")
+	assert.Len(t, recorder.mutations(), 2)
+
+	testServer.startCycleBudget()
+	_, err = testServer.applyAction(budgetTestAction("crs-vm-pin-pve3"))
+	assert.ErrorIs(t, err, errBreakerTripped, "a new cycle does not reset the breaker")
+
+	acknowledged, err := testServer.acknowledgeBreaker()
+	require.NoError(t, err)
+	assert.True(t, acknowledged)
+	assert.Nil(t, testServer.getBreakerState())
+
+	_, err = testServer.applyAction(budgetTestAction("crs-vm-pin-pve3"))
+	require.NoError(t, err)
+	assert.Len(t, recorder.mutations(), 3)
+
+	acknowledged, err = testServer.acknowledgeBreaker()
+	require.NoError(t, err)
+	assert.False(t, acknowledged)
+}
+
+func TestSpendBudgetWindow(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+	testServer.config.Budget.MaxPerWindow = 2
+
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, testServer.spendBudget(budgetTestAction("a"), now))
+	testServer.startCycleBudget()
+	require.NoError(t, testServer.spendBudget(budgetTestAction("b"), now.Add(30*time.Minute)))
+	testServer.startCycleBudget()
+	require.NoError(t, testServer.spendBudget(budgetTestAction("c"), now.Add(time.Hour)), "the first change left the window")
+	require.NoError(t, testServer.spendBudget(Action{Kind: ActionWake, Resource: ResourceNode, ID: "pve1", Node: "pve1"}, now.Add(time.Hour)),
+		"node actions are not budgeted")
+
+	err := testServer.spendBudget(budgetTestAction("d"), now.Add(time.Hour))
+	require.ErrorIs(t, err, errBreakerTripped)
+	assert.Contains(t, err.Error(), "more than 2 changes within 1h0m0s")
+	assert.Equal(t, now.Add(time.Hour), testServer.getBreakerState().TrippedAt)
+}
+
+func TestSetupCRSBreaker(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{health: &healthFixture{quorate: 1}, recorder: recorder})
+	defer mockServer.Close()
+
+	testServer.budgetMu.Lock()
+	testServer.tripBreaker("more than 50 changes in one cycle", time.Now())
+	testServer.budgetMu.Unlock()
+
+	require.NoError(t, testServer.SetupCRS(context.Background()))
+
+	cycle := testServer.getLastCycle()
+	assert.Equal(t, "more than 50 changes in one cycle", cycle.Breaker)
+	require.Len(t, cycle.Steps, 1, "only read-only steps run")
+	assert.Equal(t, "collect_metrics", cycle.Steps[0].Name)
+	assert.Empty(t, recorder.mutations())
+
+	assert.ErrorContains(t, testServer.ApplyPlan(&Plan{Actions: []Action{budgetTestAction("a")}}), "circuit breaker is tripped")
+}
+
+func TestValidateBudgetConfig(t *testing.T) {
+	config := consul.BudgetConfig{MaxPerCycle: 50}
+	require.NoError(t, validateBudgetConfig(&config))
+	assert.Equal(t, consul.BudgetConfig{MaxPerCycle: 50, Window: budgetDefaultWindow}, config)
+
+	assert.Error(t, validateBudgetConfig(&consul.BudgetConfig{MaxPerCycle: -1}))
+	assert.Error(t, validateBudgetConfig(&consul.BudgetConfig{Window: -60}))
+}
@@ -3,12 +3,55 @@ package server
 import "time"
 
 const (
-	crsMaxNodePriority = 1000
+	crsMaxNodePriority = 1000 // Default, see crs/config/scheduler
 	crsMinNodePriority = 1
+	nodeMaxWeight      = 100 // Upper limit of node and node class weights, see crs_nodes.go
 	crsSkipTag         = "crs-skip"
 	crsCriticalTag     = "crs-critical"
 	crsGroupPrefix     = "crs-"
 
+	// Affinity rule tags, the rule name follows the prefix
+	crsAffinityTagPrefix           = "crs-affinity-"
+	crsAntiAffinityTagPrefix       = "crs-anti-affinity-"
+	affinityMaxCorrectionsPerCycle = 2
+
+	// HA group name prefixes
+	crsPinGroupPrefix    = "crs-vm-pin-"
+	crsPreferGroupPrefix = "crs-vm-prefer-"
+	crsPolicyGroupPrefix = "crs-vm-policy-" // Followed by the VMID, see crs_vm_policy.go
+	crsPoolGroupPrefix   = "crs-pool-"      // Followed by the pool name, see crs_pools.go
+	crsPCIGroupPrefix    = "crs-pci-"       // Followed by the PCI resource mappings, see crs_pci_mappings.go
+
+	// HA class tag, the class name follows the prefix, see crs_ha_classes.go
+	crsHAClassTagPrefix = "crs-ha-"
+
+	// Upper limit of max_restart and max_relocate in VM policies and HA classes
+	vmPolicyMaxHAAttempts = 10
+
+	// Event kind of configuration that failed validation
+	eventKindValidate = "validate"
+
+	// Event kind and resource of node drains
+	eventKindDrain    = "drain"
+	eventResourceNode = "node"
+
+	// Event kind of power management
+	eventKindPower = "power"
+
+	// Event kind, resource and results of the cluster health gate, see crs_health.go
+	eventKindHealth      = "health"
+	eventResourceCluster = "cluster"
+	healthGateHeld       = "held"
+	healthGateReleased   = "released"
+
+	// Event kind and results of the circuit breaker, see crs_budget.go
+	eventKindBreaker    = "breaker"
+	breakerTripped      = "tripped"
+	breakerAcknowledged = "acknowledged"
+
+	// CRM state of a node, and HA state of a service, waiting for a fence
+	haNodeStateFence = "fence"
+
 	// HA states
 	haStateError    = "error"
 	haStateDisabled = "disabled"
@@ -16,6 +59,7 @@ const (
 	haStateStopped  = "stopped"
 	haStateIgnored  = "ignored"
 	haStateMigrate  = "migrate"
+	haStateRelocate = "relocate"
 
 	// VM statuses
 	vmStatusRunning = "running"
@@ -25,15 +69,90 @@ const (
 	vmTemplateFlag = 1
 
 	// HA resource configuration
-	haResourceType    = "vm"
-	haResourceComment = "crs-managed"
+	haResourceType          = "vm"
+	haContainerResourceType = "ct"
+	haResourceComment       = "crs-managed"
 
-	// API rate limiting
+	// API rate limiting, default of crs/config/scheduler
 	apiRateLimit = 500 * time.Millisecond
 
+	// Waiting for HA state changes in UpdateHAStatus, defaults of crs/config/scheduler
+	haStateMaxAttempts  = 30
+	haStateWaitInterval = 10 * time.Second
+
+	// Minimum uptime before CD-ROMs of running VMs are detached, default of crs/config/scheduler
+	cdromDetachUptimeHours = 24
+
 	// VM resource types
 	vmResourceType = "qemu"
+	ctResourceType = "lxc"
+
+	// Proxmox default memory of a VM without a memory setting, in MiB
+	vmDefaultMemoryMiB = 512
 
 	// VM startup configuration
-	vmStartupCriticalOrder = "order=1"
+	vmStartupCriticalOrder  = "order=1"
+	crsStartupTierTagPrefix = "crs-tier-"
+	startupBuiltinTiers     = 5 // Tiers 1 to 5 start in their order without configuration
+
+	// Proxmox task tracking
+	taskPollInterval = 2 * time.Second
+
+	// Migration task timeouts and attempts, a timed out task keeps running and blocks the guest
+	migrationOfflineTimeout   = 15 * time.Minute
+	migrationLiveTimeout      = 30 * time.Minute
+	migrationContainerTimeout = 15 * time.Minute
+	migrationMaxAttempts      = 2
+
+	// A migration still unresolved this long after its start, like a hung task, a task whose status cannot be read
+	// or an HA migration that never settles, is no longer tracked so it does not block the guest forever
+	migrationExpiry = 2 * time.Hour
+
+	// Auto scaler
+	autoScalerTagPrefix       = "crs-asg-"
+	autoScalerDefaultCooldown = 300 // Seconds
+	autoScalerCloneTimeout    = 10 * time.Minute
+	autoScalerShutdownTimeout = 5 * time.Minute
+	autoScalerDeleteTimeout   = 5 * time.Minute
+
+	// Node drain defaults, see crs/config/drain
+	drainDefaultMaxPerSource = 2
+	drainDefaultMaxPerTarget = 1
+	drainMaxMigrations       = 10
+
+	// Maintenance windows, see crs/config/maintenance/
+	maintenanceDefaultPreDrainMinutes = 30
+	maintenanceMaxPreDrainMinutes     = 24 * 60
+
+	// Rolling reboot, see RollingReboot
+	rebootOrigin       = "rolling reboot"
+	rebootPollInterval = 10 * time.Second
+	rebootDrainTimeout = 2 * time.Hour
+	rebootDownTimeout  = 10 * time.Minute
+	rebootUpTimeout    = 30 * time.Minute
+
+	// Power management defaults, see crs/config/power
+	powerOrigin                = "power management"
+	powerDefaultMinOnlineNodes = 2
+	powerDefaultLowLoad        = 0.4
+	powerDefaultHighLoad       = 0.7
+	powerDefaultCooldown       = 1800 // Seconds
+	powerShutdownTimeout       = 10 * time.Minute
+	powerWakeTimeout           = 15 * time.Minute
+
+	// Sliding window of the change budget in seconds, see crs/config/budget
+	budgetDefaultWindow = 3600
+
+	// Event journal retention defaults
+	eventsDefaultRetentionHours = 7 * 24
+	eventsDefaultMaxEvents      = 10000
+	eventsDefaultListLimit      = 100
+
+	// DRS defaults and tuning
+	drsDefaultAggressiveness = 3
+	drsDefaultMaxMigrations  = 2
+	drsCPUWeight             = 0.5
+	drsMemoryWeight          = 0.5
+	drsMaxMemoryUsage        = 0.9  // Target node memory usage limit after a migration
+	drsMinImprovementRatio   = 0.25 // Minimum imbalance reduction per move, relative to the threshold
 )
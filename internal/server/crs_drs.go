@@ -0,0 +1,415 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// drsImbalanceThresholds maps the aggressiveness level to the imbalance score that triggers rebalancing.
+// Level 1 only reacts to severe imbalance, level 5 keeps nodes closely balanced.
+var drsImbalanceThresholds = map[int]float64{
+	1: 0.20,
+	2: 0.15,
+	3: 0.10,
+	4: 0.07,
+	5: 0.05,
+}
+
+// drsNode is the load view of a node used by the scheduler
+type drsNode struct {
+	Name    string
+	UsedCPU float64 // CPU cores in use
+	MaxCPU  int
+	Mem     int64
+	MaxMem  int64
+	Weight  float64 // Node weight relative to the mean of the balanced nodes, 0 counts as 1
+}
+
+// load returns the weighted CPU and memory utilisation of the node in the range 0..1, divided by its relative weight
+// so that nodes with a higher weight are balanced to a higher utilisation
+func (n *drsNode) load() float64 {
+	var cpuLoad, memLoad float64
+	if n.MaxCPU > 0 {
+		cpuLoad = n.UsedCPU / float64(n.MaxCPU)
+	}
+	if n.MaxMem > 0 {
+		memLoad = float64(n.Mem) / float64(n.MaxMem)
+	}
+
+	load := drsCPUWeight*cpuLoad + drsMemoryWeight*memLoad
+	if n.Weight > 0 {
+		load /= n.Weight
+	}
+
+	return load
+}
+
+// drsVM is a running VM that the scheduler is allowed to move
+type drsVM struct {
+	VMID         int
+	Name         string
+	Node         string
+	UsedCPU      float64 // CPU cores in use
+	Mem          int64
+	Group        string          // The VM's HA group
+	AllowedNodes map[string]bool // Nodes of the VM's HA group
+}
+
+// drsMove is a single planned live migration
+type drsMove struct {
+	VM              drsVM
+	Source          string
+	Target          string
+	ImbalanceBefore float64
+	ImbalanceAfter  float64
+}
+
+// BalanceResources live-migrates running prefer-group VMs between nodes to even out CPU and memory load
+func (s *Server) BalanceResources() error {
+	config := s.currentConfig().DRS
+	if !config.Enabled {
+		logging.Debug("DRS is disabled, skipping resource balancing")
+		return nil
+	}
+
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	nodes := make(map[string]*drsNode)
+	for _, resource := range resources {
+		if resource.Type != "node" {
+			continue
+		}
+
+		if resource.HAState == "maintenance" {
+			// Evacuation is in progress, moving VMs around now would only fight it
+			logging.Debugf("Node %s is in maintenance mode, skipping resource balancing", resource.Node)
+			return nil
+		}
+
+		if resource.Status != "online" || resource.MaxCPU == 0 || resource.MaxMem == 0 {
+			continue
+		}
+
+		if s.isDrainingNode(resource.Node) || s.isNodeExcluded(resource.Node) {
+			// The drain moves its VMs, DRS neither places VMs on it nor moves them off it.
+			// Excluded nodes are left alone the same way.
+			continue
+		}
+
+		nodes[resource.Node] = &drsNode{
+			Name:    resource.Node,
+			UsedCPU: resource.CPU * float64(resource.MaxCPU),
+			MaxCPU:  resource.MaxCPU,
+			Mem:     resource.Mem,
+			MaxMem:  resource.MaxMem,
+		}
+	}
+
+	if len(nodes) < 2 {
+		logging.Debug("Less than two online nodes, nothing to balance")
+		return nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	for name, node := range nodes {
+		node.Weight = s.relativeNodeWeight(name, names)
+	}
+
+	vms := s.collectDRSVMs(resources, haResources, haGroups, nodes, s.runningGuestTasks())
+
+	threshold := drsImbalanceThresholds[config.Aggressiveness]
+	moves := computeBalancingMoves(nodes, vms, threshold, config.MaxMigrationsPerCycle, s.buildAffinityRules(resources))
+	if len(moves) == 0 {
+		logging.Debugf("Cluster imbalance %.3f is within threshold %.3f, no migrations needed", drsImbalance(nodes), threshold)
+		return nil
+	}
+
+	groupExists := make(map[string]bool, len(haGroups))
+	for _, group := range haGroups {
+		groupExists[group.Group] = true
+	}
+
+	for _, move := range moves {
+		if err := s.applyBalancingMove(move, groupExists); err != nil {
+			logging.Errorf("Failed to rebalance VM %d (%s) from %s to %s: %v", move.VM.VMID, move.VM.Name, move.Source, move.Target, err)
+		}
+	}
+
+	return nil
+}
+
+// collectDRSVMs returns running VMs in CRS prefer groups without a running task, which are the only VMs DRS moves
+func (s *Server) collectDRSVMs(resources []proxmox.ClusterResource, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup, nodes map[string]*drsNode, busy map[int]proxmox.Task) []drsVM {
+	groupNodes := make(map[string]map[string]bool, len(haGroups))
+	for _, group := range haGroups {
+		allowed := make(map[string]bool)
+		for node, priority := range parseHAGroupNodes(group.Nodes) {
+			if priority > 0 {
+				allowed[node] = true
+			}
+		}
+		groupNodes[group.Group] = allowed
+	}
+
+	var vms []drsVM
+	for _, resource := range resources {
+		if resource.Type != vmResourceType || resource.Status != vmStatusRunning || resource.HAState != haStateStarted {
+			continue
+		}
+
+		if s.hasVMSkipTag(resource.Tags) {
+			continue
+		}
+
+		if _, ok := busy[resource.VMID]; ok {
+			logging.Debugf("VM %d (%s) has a running task, not considered for balancing", resource.VMID, resource.Name)
+			continue
+		}
+
+		if _, ok := nodes[resource.Node]; !ok {
+			continue
+		}
+
+		haResource := s.findHAResource(fmt.Sprintf("%s:%d", haResourceType, resource.VMID), haResources)
+		if haResource == nil || !strings.HasPrefix(haResource.Group, crsPreferGroupPrefix) {
+			// Pinned VMs must stay where they are and unmanaged VMs are not ours to move
+			continue
+		}
+
+		vms = append(vms, drsVM{
+			VMID:         resource.VMID,
+			Name:         resource.Name,
+			Node:         resource.Node,
+			UsedCPU:      resource.CPU * float64(resource.MaxCPU),
+			Mem:          resource.Mem,
+			Group:        haResource.Group,
+			AllowedNodes: groupNodes[haResource.Group],
+		})
+	}
+
+	return vms
+}
+
+// applyBalancingMove starts the live migration of a planned DRS move
+func (s *Server) applyBalancingMove(move drsMove, groupExists map[string]bool) error {
+	logging.Infof("DRS: migrating VM %d (%s) from %s to %s, imbalance %.3f -> %.3f",
+		move.VM.VMID, move.VM.Name, move.Source, move.Target, move.ImbalanceBefore, move.ImbalanceAfter)
+
+	reason := fmt.Sprintf("DRS: cluster imbalance %.3f -> %.3f", move.ImbalanceBefore, move.ImbalanceAfter)
+	return s.liveMigrateAndRehome(move.VM.VMID, move.VM.Name, move.VM.Group, move.Source, move.Target, reason, groupExists)
+}
+
+// liveMigrateAndRehome starts the live migration of a running prefer-group VM. Once the migration has finished,
+// CheckMigrations moves it from group to the prefer group of its new node in the same storage zone.
+func (s *Server) liveMigrateAndRehome(vmid int, name, group, source, target, reason string, groupExists map[string]bool) error {
+	if err := s.startMigration(Action{
+		Kind:     ActionMigrate,
+		Resource: ResourceVM,
+		ID:       strconv.Itoa(vmid),
+		Node:     source,
+		VMID:     vmid,
+		Before:   source,
+		After:    target,
+		Reason:   reason,
+		Migration: &proxmox.MigrationOptions{
+			Target: target,
+			Online: true,
+		},
+	}, true); err != nil {
+		return err
+	}
+
+	logging.Debugf("Started live migration of VM %d (%s) to %s", vmid, name, target)
+
+	// The plan shows the re-homing that follows the migration
+	targetGroup := preferGroupOnNode(group, target)
+	if !s.isPlanning() || !groupExists[targetGroup] {
+		return nil
+	}
+
+	sid := haResourceSID(vmResourceType, vmid)
+	if _, err := s.applyAction(rehomeAction(sid, vmid, target, group, targetGroup, "guest re-homed to its new node")); err != nil {
+		return fmt.Errorf("failed to move HA resource %s to group %s: %w", sid, targetGroup, err)
+	}
+
+	return nil
+}
+
+// computeBalancingMoves greedily picks migrations that reduce the imbalance score the most.
+// The node loads are updated in place as if each move had completed.
+// Moves that would break an affinity rule are never chosen, rules may be nil.
+func computeBalancingMoves(nodes map[string]*drsNode, vms []drsVM, threshold float64, budget int, rules *affinityRules) []drsMove {
+	nodeNames := make([]string, 0, len(nodes))
+	for name := range nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+
+	candidates := make([]drsVM, len(vms))
+	copy(candidates, vms)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].VMID < candidates[j].VMID
+	})
+
+	moved := make(map[int]bool)
+	var moves []drsMove
+
+	for len(moves) < budget {
+		current := drsImbalance(nodes)
+		if current <= threshold {
+			break
+		}
+
+		// Only moves off the most loaded node are considered, so each step is easy to reason about
+		source := nodeNames[0]
+		for _, name := range nodeNames {
+			if nodes[name].load() > nodes[source].load() {
+				source = name
+			}
+		}
+
+		var best *drsMove
+		var bestIndex int
+
+		for i, vm := range candidates {
+			if vm.Node != source || moved[vm.VMID] {
+				continue
+			}
+
+			for _, target := range nodeNames {
+				if target == source || !vm.AllowedNodes[target] || !drsFits(nodes[target], vm) || rules.conflict(vm.VMID, target) != "" {
+					continue
+				}
+
+				after := drsImbalanceAfterMove(nodes, vm, source, target)
+				if best == nil || after < best.ImbalanceAfter {
+					best = &drsMove{
+						VM:              vm,
+						Source:          source,
+						Target:          target,
+						ImbalanceBefore: current,
+						ImbalanceAfter:  after,
+					}
+					bestIndex = i
+				}
+			}
+		}
+
+		// Require a real improvement so VMs don't ping-pong between similarly loaded nodes
+		if best == nil || current-best.ImbalanceAfter < threshold*drsMinImprovementRatio {
+			break
+		}
+
+		drsMoveLoad(nodes[best.Source], nodes[best.Target], best.VM)
+		rules.place(best.VM.VMID, best.Target)
+		candidates[bestIndex].Node = best.Target
+		moved[best.VM.VMID] = true
+		moves = append(moves, *best)
+	}
+
+	return moves
+}
+
+// drsFits checks that the target keeps memory headroom after receiving the VM
+func drsFits(target *drsNode, vm drsVM) bool {
+	return float64(target.Mem+vm.Mem) <= float64(target.MaxMem)*drsMaxMemoryUsage
+}
+
+// drsImbalanceAfterMove returns the imbalance score as if vm had been moved from source to target
+func drsImbalanceAfterMove(nodes map[string]*drsNode, vm drsVM, source, target string) float64 {
+	sourceCopy := *nodes[source]
+	targetCopy := *nodes[target]
+	drsMoveLoad(&sourceCopy, &targetCopy, vm)
+
+	simulated := make(map[string]*drsNode, len(nodes))
+	for name, node := range nodes {
+		simulated[name] = node
+	}
+	simulated[source] = &sourceCopy
+	simulated[target] = &targetCopy
+
+	return drsImbalance(simulated)
+}
+
+// drsMoveLoad transfers the load of a VM from one node to another
+func drsMoveLoad(source, target *drsNode, vm drsVM) {
+	source.UsedCPU -= vm.UsedCPU
+	source.Mem -= vm.Mem
+	target.UsedCPU += vm.UsedCPU
+	target.Mem += vm.Mem
+}
+
+// drsImbalance returns the standard deviation of node loads, 0 means perfectly balanced
+func drsImbalance(nodes map[string]*drsNode) float64 {
+	if len(nodes) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, node := range nodes {
+		sum += node.load()
+	}
+	mean := sum / float64(len(nodes))
+
+	var variance float64
+	for _, node := range nodes {
+		diff := node.load() - mean
+		variance += diff * diff
+	}
+
+	return math.Sqrt(variance / float64(len(nodes)))
+}
+
+// parseHAGroupNodes parses an HA group node list like "pve1:1000,pve2:995" into node priorities
+func parseHAGroupNodes(nodes string) map[string]int {
+	result := make(map[string]int)
+	if nodes == "" {
+		return result
+	}
+
+	for _, entry := range strings.Split(nodes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, priorityStr, found := strings.Cut(entry, ":")
+		priority := 0
+		if found {
+			if value, err := strconv.Atoi(priorityStr); err == nil {
+				priority = value
+			}
+		}
+
+		// Nodes without a priority are still members of the group
+		if priority == 0 {
+			priority = crsMinNodePriority
+		}
+
+		result[name] = priority
+	}
+
+	return result
+}
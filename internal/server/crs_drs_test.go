@@ -0,0 +1,235 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+const gib = int64(1024 * 1024 * 1024)
+
+func TestDRSNodeLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     drsNode
+		expected float64
+	}{
+		{
+			name:     "idle node",
+			node:     drsNode{MaxCPU: 8, MaxMem: 64 * gib},
+			expected: 0,
+		},
+		{
+			name:     "half CPU and half memory",
+			node:     drsNode{UsedCPU: 4, MaxCPU: 8, Mem: 32 * gib, MaxMem: 64 * gib},
+			expected: 0.5,
+		},
+		{
+			name:     "full CPU and no memory",
+			node:     drsNode{UsedCPU: 8, MaxCPU: 8, MaxMem: 64 * gib},
+			expected: 0.5,
+		},
+		{
+			name:     "no capacity reported",
+			node:     drsNode{UsedCPU: 4, Mem: 32 * gib},
+			expected: 0,
+		},
+		{
+			name:     "half loaded node of double weight",
+			node:     drsNode{UsedCPU: 4, MaxCPU: 8, Mem: 32 * gib, MaxMem: 64 * gib, Weight: 2},
+			expected: 0.25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, tt.node.load(), 0.0001)
+		})
+	}
+}
+
+func TestDRSImbalance(t *testing.T) {
+	balanced := map[string]*drsNode{
+		"pve1": {Name: "pve1", UsedCPU: 4, MaxCPU: 8, Mem: 32 * gib, MaxMem: 64 * gib},
+		"pve2": {Name: "pve2", UsedCPU: 4, MaxCPU: 8, Mem: 32 * gib, MaxMem: 64 * gib},
+	}
+	assert.InDelta(t, 0, drsImbalance(balanced), 0.0001)
+
+	skewed := map[string]*drsNode{
+		"pve1": {Name: "pve1", UsedCPU: 8, MaxCPU: 8, Mem: 64 * gib, MaxMem: 64 * gib},
+		"pve2": {Name: "pve2", MaxCPU: 8, MaxMem: 64 * gib},
+	}
+	assert.InDelta(t, 0.5, drsImbalance(skewed), 0.0001)
+
+	assert.Equal(t, float64(0), drsImbalance(nil))
+}
+
+func TestComputeBalancingMoves(t *testing.T) {
+	allNodes := map[string]bool{"pve1": true, "pve2": true, "pve3": true}
+
+	newNodes := func() map[string]*drsNode {
+		return map[string]*drsNode{
+			"pve1": {Name: "pve1", UsedCPU: 6, MaxCPU: 8, Mem: 48 * gib, MaxMem: 64 * gib},
+			"pve2": {Name: "pve2", UsedCPU: 1, MaxCPU: 8, Mem: 8 * gib, MaxMem: 64 * gib},
+			"pve3": {Name: "pve3", UsedCPU: 1, MaxCPU: 8, Mem: 8 * gib, MaxMem: 64 * gib},
+		}
+	}
+
+	vms := []drsVM{
+		{VMID: 100, Node: "pve1", UsedCPU: 2, Mem: 16 * gib, AllowedNodes: allNodes},
+		{VMID: 101, Node: "pve1", UsedCPU: 2, Mem: 16 * gib, AllowedNodes: allNodes},
+		{VMID: 102, Node: "pve1", UsedCPU: 1, Mem: 8 * gib, AllowedNodes: allNodes},
+	}
+
+	t.Run("moves VMs off the most loaded node", func(t *testing.T) {
+		nodes := newNodes()
+		moves := computeBalancingMoves(nodes, vms, 0.10, 2, nil)
+
+		require.Len(t, moves, 2)
+		assert.Equal(t, "pve1", moves[0].Source)
+		assert.Equal(t, "pve1", moves[1].Source)
+		assert.NotEqual(t, moves[0].Target, moves[1].Target, "load should be spread over both idle nodes")
+		assert.Less(t, moves[0].ImbalanceAfter, moves[0].ImbalanceBefore)
+		assert.Less(t, moves[1].ImbalanceAfter, moves[1].ImbalanceBefore)
+	})
+
+	t.Run("respects migration budget", func(t *testing.T) {
+		moves := computeBalancingMoves(newNodes(), vms, 0.10, 1, nil)
+		assert.Len(t, moves, 1)
+	})
+
+	t.Run("no moves when below threshold", func(t *testing.T) {
+		moves := computeBalancingMoves(newNodes(), vms, 0.50, 2, nil)
+		assert.Empty(t, moves)
+	})
+
+	t.Run("respects HA group membership", func(t *testing.T) {
+		restricted := []drsVM{
+			{VMID: 100, Node: "pve1", UsedCPU: 2, Mem: 16 * gib, AllowedNodes: map[string]bool{"pve1": true, "pve3": true}},
+		}
+
+		moves := computeBalancingMoves(newNodes(), restricted, 0.10, 2, nil)
+		require.Len(t, moves, 1)
+		assert.Equal(t, "pve3", moves[0].Target)
+	})
+
+	t.Run("no moves when target lacks memory headroom", func(t *testing.T) {
+		nodes := newNodes()
+		nodes["pve2"].Mem = 60 * gib
+		nodes["pve3"].Mem = 60 * gib
+
+		moves := computeBalancingMoves(nodes, vms, 0.10, 2, nil)
+		assert.Empty(t, moves)
+	})
+
+	t.Run("no moves without candidates", func(t *testing.T) {
+		moves := computeBalancingMoves(newNodes(), nil, 0.10, 2, nil)
+		assert.Empty(t, moves)
+	})
+}
+
+func TestParseHAGroupNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    string
+		expected map[string]int
+	}{
+		{
+			name:     "empty",
+			nodes:    "",
+			expected: map[string]int{},
+		},
+		{
+			name:     "with priorities",
+			nodes:    "pve1:1000,pve2:995",
+			expected: map[string]int{"pve1": 1000, "pve2": 995},
+		},
+		{
+			name:     "without priority",
+			nodes:    "pve1, pve2:5",
+			expected: map[string]int{"pve1": 1, "pve2": 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseHAGroupNodes(tt.nodes))
+		})
+	}
+}
+
+func TestBalanceResources(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		recorder := &requestRecorder{}
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			includeClusterResources: true,
+			includeHAResources:      true,
+			recorder:                recorder,
+		})
+		defer mockServer.Close()
+
+		require.NoError(t, testServer.BalanceResources())
+		assert.Empty(t, recorder.requests)
+	})
+
+	t.Run("enabled without load data", func(t *testing.T) {
+		recorder := &requestRecorder{}
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			includeClusterResources: true,
+			includeHAResources:      true,
+			recorder:                recorder,
+		})
+		defer mockServer.Close()
+
+		testServer.config.DRS.Enabled = true
+
+		require.NoError(t, testServer.BalanceResources())
+		assert.Empty(t, recorder.mutations())
+	})
+}
+
+func TestValidateDRSConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   consul.DRSConfig
+		expected consul.DRSConfig
+		wantErr  bool
+	}{
+		{
+			name:     "defaults for omitted values",
+			config:   consul.DRSConfig{Enabled: true},
+			expected: consul.DRSConfig{Enabled: true, Aggressiveness: drsDefaultAggressiveness, MaxMigrationsPerCycle: drsDefaultMaxMigrations},
+		},
+		{
+			name:     "explicit values",
+			config:   consul.DRSConfig{Enabled: true, Aggressiveness: 5, MaxMigrationsPerCycle: 4},
+			expected: consul.DRSConfig{Enabled: true, Aggressiveness: 5, MaxMigrationsPerCycle: 4},
+		},
+		{
+			name:    "aggressiveness out of range",
+			config:  consul.DRSConfig{Aggressiveness: 6},
+			wantErr: true,
+		},
+		{
+			name:    "negative budget",
+			config:  consul.DRSConfig{MaxMigrationsPerCycle: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			err := validateDRSConfig(&config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, config)
+		})
+	}
+}
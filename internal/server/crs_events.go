@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// eventJournal stores the history of CRS changes, implemented by the Consul client
+type eventJournal interface {
+	PutEvent(event consul.Event) error
+	ListEvents(filter consul.EventFilter) ([]consul.Event, error)
+	PruneEvents(maxAge time.Duration, maxEvents int) (int, error)
+}
+
+// newEvent returns a journal entry for an action
+func newEvent(action Action, upid, result string, err error) consul.Event {
+	event := consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     string(action.Kind),
+		Resource: string(action.Resource),
+		ID:       action.ID,
+		Node:     action.Node,
+		VMID:     action.VMID,
+		Before:   action.Before,
+		After:    action.After,
+		Reason:   action.Reason,
+		Result:   result,
+		UPID:     upid,
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	return event
+}
+
+// putEvent writes an entry to the journal. A journal failure is logged and never fails the change itself.
+func (s *Server) putEvent(event consul.Event) {
+	if s.journal == nil {
+		return
+	}
+
+	if err := s.journal.PutEvent(event); err != nil {
+		logging.Warnf("Failed to record event %s %s %s: %v", event.Kind, event.Resource, event.ID, err)
+	}
+}
+
+// recordActionEvent journals an executed action and whether the API accepted it
+func (s *Server) recordActionEvent(action Action, upid string, err error) {
+	result := consul.EventResultApplied
+	if err != nil {
+		result = consul.EventResultFailed
+	}
+
+	s.putEvent(newEvent(action, upid, result, err))
+}
+
+// recordTaskEvent journals the outcome of the task started by an action
+func (s *Server) recordTaskEvent(action Action, upid string, result *proxmox.TaskResult, err error) {
+	switch {
+	case err != nil:
+		s.putEvent(newEvent(action, upid, consul.EventResultTaskFailed, err))
+	case result == nil:
+		// No task to track
+	case result.Success():
+		s.putEvent(newEvent(action, upid, consul.EventResultTaskSucceeded, nil))
+	case result.TimedOut:
+		s.putEvent(newEvent(action, upid, consul.EventResultTaskTimedOut, result.Err()))
+	default:
+		s.putEvent(newEvent(action, upid, consul.EventResultTaskFailed, result.Err()))
+	}
+}
+
+// PruneEvents removes journal entries beyond the configured retention
+func (s *Server) PruneEvents() error {
+	if s.journal == nil || s.isPlanning() {
+		return nil
+	}
+
+	config := s.currentConfig().Events
+	retention := time.Duration(config.RetentionHours) * time.Hour
+
+	pruned, err := s.journal.PruneEvents(retention, config.MaxEvents)
+	if err != nil {
+		return fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	if pruned > 0 {
+		logging.Debugf("Pruned %d events from the journal", pruned)
+	}
+
+	return nil
+}
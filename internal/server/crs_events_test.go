@@ -0,0 +1,203 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// memoryJournal keeps journal entries in memory
+type memoryJournal struct {
+	events    []consul.Event
+	filter    consul.EventFilter
+	pruneAge  time.Duration
+	pruneMax  int
+	failWrite bool
+}
+
+func (j *memoryJournal) PutEvent(event consul.Event) error {
+	if j.failWrite {
+		return errors.New("consul unavailable")
+	}
+	j.events = append(j.events, event)
+	return nil
+}
+
+func (j *memoryJournal) ListEvents(filter consul.EventFilter) ([]consul.Event, error) {
+	j.filter = filter
+
+	var events []consul.Event
+	for _, event := range j.events {
+		if filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (j *memoryJournal) PruneEvents(maxAge time.Duration, maxEvents int) (int, error) {
+	j.pruneAge = maxAge
+	j.pruneMax = maxEvents
+	return 0, nil
+}
+
+func TestApplyActionRecordsEvents(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{})
+	defer mockServer.Close()
+
+	journal := &memoryJournal{}
+	testServer.journal = journal
+
+	action := Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         "vm:100",
+		VMID:       100,
+		Before:     "error",
+		After:      "disabled",
+		Reason:     "HA resource is in error state",
+		HAResource: &proxmox.ClusterHAResource{SID: "vm:100", State: "disabled"},
+	}
+
+	_, err := testServer.applyAction(action)
+	require.NoError(t, err)
+
+	_, err = testServer.applyAction(Action{Kind: ActionUpdate, Resource: ResourceHAResource, ID: "vm:101"})
+	require.Error(t, err, "an action without payload fails")
+
+	require.Len(t, journal.events, 2)
+	assert.Equal(t, "vm:100", journal.events[0].ID)
+	assert.Equal(t, 100, journal.events[0].VMID)
+	assert.Equal(t, "error", journal.events[0].Before)
+	assert.Equal(t, "disabled", journal.events[0].After)
+	assert.Equal(t, "HA resource is in error state", journal.events[0].Reason)
+	assert.Equal(t, consul.EventResultApplied, journal.events[0].Result)
+	assert.False(t, journal.events[0].Time.IsZero())
+
+	assert.Equal(t, consul.EventResultFailed, journal.events[1].Result)
+	assert.Contains(t, journal.events[1].Error, "missing ha_resource payload")
+
+	testServer.plan = &Plan{}
+	_, err = testServer.applyAction(action)
+	require.NoError(t, err)
+	assert.Len(t, journal.events, 2, "planned actions are not journaled")
+
+	testServer.plan = nil
+	journal.failWrite = true
+	_, err = testServer.applyAction(action)
+	assert.NoError(t, err, "a journal failure does not fail the action")
+}
+
+func TestCheckMigrationsRecordsTaskEvents(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: migrationTaskHandler("migration aborted", "OK")})
+	defer mockServer.Close()
+
+	journal := &memoryJournal{}
+	testServer.journal = journal
+
+	require.NoError(t, testServer.startMigration(testMigrationAction(), false))
+	require.NoError(t, testServer.CheckMigrations())
+	require.NoError(t, testServer.CheckMigrations())
+
+	results := make([]string, 0, len(journal.events))
+	for _, event := range journal.events {
+		results = append(results, event.Result)
+	}
+
+	assert.Equal(t, []string{
+		consul.EventResultApplied,
+		consul.EventResultTaskFailed,
+		consul.EventResultApplied,
+		consul.EventResultTaskSucceeded,
+	}, results)
+	assert.Equal(t, "UPID:pve1:00002:qmigrate:100:root@pam:", journal.events[3].UPID)
+}
+
+func TestPruneEvents(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.PruneEvents(), "no journal configured")
+
+	journal := &memoryJournal{}
+	testServer.journal = journal
+	testServer.config.Events = consul.EventsConfig{RetentionHours: 24, MaxEvents: 500}
+
+	require.NoError(t, testServer.PruneEvents())
+	assert.Equal(t, 24*time.Hour, journal.pruneAge)
+	assert.Equal(t, 500, journal.pruneMax)
+}
+
+func TestAdminAPIEvents(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+
+	code, _ := serveAdmin(t, testServer, http.MethodGet, "/events", "secret")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	journal := &memoryJournal{events: []consul.Event{
+		{Kind: "migrate", Resource: "vm", ID: "100", VMID: 100},
+		{Kind: "update", Resource: "ha-resource", ID: "vm:101", VMID: 101},
+	}}
+	testServer.journal = journal
+
+	code, body := serveAdmin(t, testServer, http.MethodGet, "/events?vmid=100&resource=vm&kind=migrate&limit=5", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, consul.EventFilter{VMID: 100, Resource: "vm", Kind: "migrate", Limit: 5}, journal.filter)
+	assert.Len(t, body["events"], 1)
+
+	code, _ = serveAdmin(t, testServer, http.MethodGet, "/events", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, eventsDefaultListLimit, journal.filter.Limit)
+
+	code, _ = serveAdmin(t, testServer, http.MethodGet, "/events?vmid=abc", "secret")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestValidateEventsConfig(t *testing.T) {
+	config := consul.EventsConfig{}
+	require.NoError(t, validateEventsConfig(&config))
+	assert.Equal(t, consul.EventsConfig{RetentionHours: eventsDefaultRetentionHours, MaxEvents: eventsDefaultMaxEvents}, config)
+
+	config = consul.EventsConfig{RetentionHours: 48, MaxEvents: 100}
+	require.NoError(t, validateEventsConfig(&config))
+	assert.Equal(t, consul.EventsConfig{RetentionHours: 48, MaxEvents: 100}, config)
+
+	assert.Error(t, validateEventsConfig(&consul.EventsConfig{MaxEvents: -1}))
+}
+
+func TestHAResourceEventsFilterByVMID(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	journal := &memoryJournal{}
+	testServer.journal = journal
+
+	require.NoError(t, testServer.RemoveSkippedVMsFromCRSGroups())
+	testServer.ensureCriticalVMStarted("vm:105", &proxmox.ClusterHAResource{SID: "vm:105", State: "stopped"}, 1, 0)
+
+	events, err := journal.ListEvents(consul.EventFilter{VMID: 602})
+	require.NoError(t, err)
+	require.Len(t, events, 1, "crs-skip removals are found by VMID")
+	assert.Equal(t, "ct:602", events[0].ID)
+
+	events, err = journal.ListEvents(consul.EventFilter{VMID: 105})
+	require.NoError(t, err)
+	require.NotEmpty(t, events, "HA state recoveries are found by VMID")
+	assert.Equal(t, "vm:105", events[0].ID)
+
+	require.NoError(t, testServer.removeVMsFromHAGroup("crs-vm-prefer-pve2"))
+
+	events, err = journal.ListEvents(consul.EventFilter{VMID: 601})
+	require.NoError(t, err)
+	require.Len(t, events, 1, "removals from orphaned groups are found by VMID")
+	assert.Equal(t, "ct:601", events[0].ID)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// builtinHAClasses returns the HA classes available without configuration.
+// Aggressive guests recover fast by restarting and relocating, conservative guests get one restart and stay on their node.
+func builtinHAClasses() map[string]consul.HAClass {
+	return map[string]consul.HAClass{
+		"aggressive":   {MaxRestart: intValue(3), MaxRelocate: intValue(5)},
+		"conservative": {MaxRestart: intValue(1), MaxRelocate: intValue(0)},
+	}
+}
+
+// intValue returns a pointer to value, for optional config fields
+func intValue(value int) *int {
+	return &value
+}
+
+// validateHAClass checks the name and limits of an HA class
+func validateHAClass(name string, class consul.HAClass) error {
+	if !configNameRe.MatchString(name) {
+		return fmt.Errorf("name %q must consist of lowercase letters, digits and dashes", name)
+	}
+
+	for field, value := range map[string]*int{"max_restart": class.MaxRestart, "max_relocate": class.MaxRelocate} {
+		if value != nil && (*value < 0 || *value > vmPolicyMaxHAAttempts) {
+			return fmt.Errorf("%s must be between 0 and %d, got %d", field, vmPolicyMaxHAAttempts, *value)
+		}
+	}
+
+	return nil
+}
+
+// loadHAClassesConfig merges the classes from Consul over the built-in ones.
+// An invalid class keeps its previous definition.
+func (s *Server) loadHAClassesConfig(config *crsConfig) {
+	classes, err := s.consul.GetHAClasses()
+	if err != nil {
+		logging.Errorf("Failed to load HA classes, keeping previous settings: %v", err)
+		return
+	}
+
+	loaded := builtinHAClasses()
+	for name, class := range classes {
+		if err := validateHAClass(name, class); err != nil {
+			logging.Errorf("Invalid HA class %s, keeping previous settings: %v", name, err)
+			if previous, ok := config.HAClasses[name]; ok {
+				loaded[name] = previous
+			}
+			continue
+		}
+
+		loaded[name] = class
+	}
+
+	config.HAClasses = loaded
+}
+
+// parseHAClassTag returns the class of the first crs-ha-<class> tag, or an empty string
+func parseHAClassTag(tags string) string {
+	for _, tag := range strings.Split(tags, ";") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, crsHAClassTagPrefix) && len(tag) > len(crsHAClassTagPrefix) {
+			return strings.TrimPrefix(tag, crsHAClassTagPrefix)
+		}
+	}
+
+	return ""
+}
+
+// haLimits returns the max_restart and max_relocate of a guest's HA resource and where they come from.
+// A VM policy wins over the HA class tag, which wins over the HA class of the guest's pool policy
+// and the Proxmox defaults with an empty source.
+func (s *Server) haLimits(guest haGuest) (maxRestart, maxRelocate int, source string) {
+	maxRestart, maxRelocate = proxmox.HAMaxRestart, proxmox.HAMaxRelocate
+
+	config := s.currentConfig()
+	name, pool := parseHAClassTag(guest.Tags), ""
+	if policy, ok := config.Pools[guest.Pool]; name == "" && ok && policy.HAClass != "" {
+		name, pool = policy.HAClass, guest.Pool
+	}
+
+	if name != "" {
+		class, ok := config.HAClasses[name]
+		switch {
+		case !ok && pool != "":
+			logging.Warnf("%s %d (%s) is in pool %s with HA class %s but the HA class is not defined, using defaults", guest.Kind, guest.VMID, guest.Name, pool, name)
+		case !ok:
+			logging.Warnf("%s %d (%s) has tag %s%s but the HA class is not defined, using defaults", guest.Kind, guest.VMID, guest.Name, crsHAClassTagPrefix, name)
+		default:
+			source = "HA class " + name
+			if pool != "" {
+				source += " of pool " + pool
+			}
+			if class.MaxRestart != nil {
+				maxRestart = *class.MaxRestart
+			}
+			if class.MaxRelocate != nil {
+				maxRelocate = *class.MaxRelocate
+			}
+		}
+	}
+
+	if policy := guest.Policy; policy != nil && (policy.MaxRestart != nil || policy.MaxRelocate != nil) {
+		source = "policy in VM description"
+		if policy.MaxRestart != nil {
+			maxRestart = *policy.MaxRestart
+		}
+		if policy.MaxRelocate != nil {
+			maxRelocate = *policy.MaxRelocate
+		}
+	}
+
+	return maxRestart, maxRelocate, source
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestParseHAClassTag(t *testing.T) {
+	assert.Empty(t, parseHAClassTag(""))
+	assert.Empty(t, parseHAClassTag("crs-critical;crs-ha-"))
+	assert.Equal(t, "aggressive", parseHAClassTag("production; crs-ha-aggressive"))
+	assert.Equal(t, "db", parseHAClassTag("crs-ha-db;crs-ha-aggressive"), "the first class tag wins")
+}
+
+func TestValidateHAClass(t *testing.T) {
+	assert.NoError(t, validateHAClass("db", consul.HAClass{MaxRestart: intValue(0)}))
+	assert.NoError(t, validateHAClass("db", consul.HAClass{}))
+	assert.Error(t, validateHAClass("DB", consul.HAClass{}))
+	assert.Error(t, validateHAClass("db", consul.HAClass{MaxRelocate: intValue(11)}))
+	assert.Error(t, validateHAClass("db", consul.HAClass{MaxRestart: intValue(-1)}))
+}
+
+func TestHALimits(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+	testServer.config.HAClasses["db"] = consul.HAClass{MaxRelocate: intValue(2)}
+	testServer.config.Pools = map[string]consul.PoolPolicy{"tenant-a": {HAClass: "conservative"}}
+
+	tests := []struct {
+		name        string
+		guest       haGuest
+		maxRestart  int
+		maxRelocate int
+		source      string
+	}{
+		{
+			name:        "no tag",
+			guest:       haGuest{Tags: "production"},
+			maxRestart:  proxmox.HAMaxRestart,
+			maxRelocate: proxmox.HAMaxRelocate,
+		},
+		{
+			name:        "built-in class",
+			guest:       haGuest{Tags: "crs-ha-conservative"},
+			maxRestart:  1,
+			maxRelocate: 0,
+			source:      "HA class conservative",
+		},
+		{
+			name:        "configured class keeps omitted defaults",
+			guest:       haGuest{Tags: "crs-ha-db"},
+			maxRestart:  proxmox.HAMaxRestart,
+			maxRelocate: 2,
+			source:      "HA class db",
+		},
+		{
+			name:        "unknown class",
+			guest:       haGuest{Tags: "crs-ha-missing"},
+			maxRestart:  proxmox.HAMaxRestart,
+			maxRelocate: proxmox.HAMaxRelocate,
+		},
+		{
+			name:        "class of the pool",
+			guest:       haGuest{Pool: "tenant-a"},
+			maxRestart:  1,
+			maxRelocate: 0,
+			source:      "HA class conservative of pool tenant-a",
+		},
+		{
+			name:        "tag wins over the class of the pool",
+			guest:       haGuest{Tags: "crs-ha-aggressive", Pool: "tenant-a"},
+			maxRestart:  3,
+			maxRelocate: 5,
+			source:      "HA class aggressive",
+		},
+		{
+			name:        "VM policy wins over the class",
+			guest:       haGuest{Tags: "crs-ha-aggressive", Policy: &vmPolicy{MaxRestart: intValue(7)}},
+			maxRestart:  7,
+			maxRelocate: 5,
+			source:      "policy in VM description",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxRestart, maxRelocate, source := testServer.haLimits(tt.guest)
+			assert.Equal(t, tt.maxRestart, maxRestart)
+			assert.Equal(t, tt.maxRelocate, maxRelocate)
+			assert.Equal(t, tt.source, source)
+		})
+	}
+}
+
+func TestEnforceHAClass(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: failingScenario})
+	defer mockServer.Close()
+
+	resources := []proxmox.ClusterHAResource{
+		{SID: "vm:100", Group: "crs-vm-prefer-pve1", Comment: haResourceComment, MaxRestart: 10, MaxRelocate: 10},
+		{SID: "vm:101", Group: "crs-vm-prefer-pve1", Comment: haResourceComment, MaxRestart: 1, MaxRelocate: 0},
+		{SID: "vm:102", Group: "crs-vm-prefer-pve1", Comment: "managed by hand", MaxRestart: 1, MaxRelocate: 1},
+		{SID: "ct:103", Group: "crs-vm-pin-pve1", Comment: haResourceComment, MaxRestart: 10, MaxRelocate: 10},
+	}
+
+	guests := []haGuest{
+		{Type: vmResourceType, Kind: "VM", VMID: 100, Node: "pve1", Tags: "crs-ha-aggressive"},
+		{Type: vmResourceType, Kind: "VM", VMID: 101, Node: "pve1"},
+		{Type: vmResourceType, Kind: "VM", VMID: 102, Node: "pve1"},
+		{Type: ctResourceType, Kind: "container", VMID: 103, Node: "pve1", Tags: "crs-ha-conservative"},
+	}
+
+	testServer.plan = &Plan{}
+	for _, guest := range guests {
+		require.NoError(t, testServer.enforceHAResourcePolicy(guest, resources, nil))
+	}
+
+	require.Len(t, testServer.plan.Actions, 3)
+
+	assert.Equal(t, "vm:100", testServer.plan.Actions[0].ID)
+	assert.Equal(t, "group=crs-vm-prefer-pve1 max_restart=3 max_relocate=5", testServer.plan.Actions[0].After)
+	assert.Equal(t, "HA class aggressive", testServer.plan.Actions[0].Reason)
+
+	assert.Equal(t, "vm:101", testServer.plan.Actions[1].ID, "a removed tag restores the defaults")
+	assert.Equal(t, "group=crs-vm-prefer-pve1 max_restart=10 max_relocate=10", testServer.plan.Actions[1].After)
+	assert.Equal(t, "default HA limits", testServer.plan.Actions[1].Reason)
+
+	assert.Equal(t, "ct:103", testServer.plan.Actions[2].ID)
+	assert.Equal(t, "group=crs-vm-pin-pve1 max_restart=1 max_relocate=0", testServer.plan.Actions[2].After)
+}
+
+func TestConservativeHAClassSendsZeroRelocate(t *testing.T) {
+	var forms []url.Values
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: func(_ http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut {
+			require.NoError(t, r.ParseForm())
+			forms = append(forms, r.PostForm)
+		}
+		return false
+	}})
+	defer mockServer.Close()
+
+	guest := haGuest{Type: vmResourceType, Kind: "VM", VMID: 100, Node: "pve1", Tags: "crs-ha-conservative"}
+	resources := []proxmox.ClusterHAResource{
+		{SID: "vm:100", Group: "crs-vm-pin-pve1", Comment: haResourceComment, MaxRestart: 10, MaxRelocate: 10},
+	}
+
+	require.NoError(t, testServer.enforceHAResourcePolicy(guest, resources, nil))
+	require.Len(t, forms, 1)
+	assert.Equal(t, "1", forms[0].Get("max_restart"))
+	assert.Equal(t, []string{"0"}, forms[0]["max_relocate"], "a limit of 0 is sent to Proxmox")
+
+	// Once applied, the resource is left alone in the next cycle
+	resources[0].MaxRestart, resources[0].MaxRelocate = 1, 0
+	require.NoError(t, testServer.enforceHAResourcePolicy(guest, resources, nil))
+	assert.Len(t, forms, 1)
+}
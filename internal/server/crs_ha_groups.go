@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -24,7 +25,7 @@ func (s *Server) SetupVMPin() error {
 
 	for _, node := range nodes {
 		haGroupPin := tools.GetHAVMPinGroupName(node.Node)
-		expectedNodes := fmt.Sprintf("%s:%d", node.Node, crsMaxNodePriority)
+		expectedNodes := fmt.Sprintf("%s:%d", node.Node, s.currentConfig().Scheduler.MaxNodePriority)
 
 		var existingGroup *proxmox.ClusterHAGroup
 		for _, group := range haGroups {
@@ -37,11 +38,19 @@ func (s *Server) SetupVMPin() error {
 		if existingGroup == nil {
 			logging.Infof("creating ha group %s", haGroupPin)
 
-			_, err := s.proxmox.CreateClusterHAGroup(proxmox.ClusterHAGroup{
-				Group:      haGroupPin,
-				Nodes:      expectedNodes,
-				NoFailback: 1,
-				Restricted: 1,
+			_, err := s.applyAction(Action{
+				Kind:     ActionCreate,
+				Resource: ResourceHAGroup,
+				ID:       haGroupPin,
+				Node:     node.Node,
+				After:    expectedNodes,
+				Reason:   "pin group missing for node",
+				HAGroup: &proxmox.ClusterHAGroup{
+					Group:      haGroupPin,
+					Nodes:      expectedNodes,
+					NoFailback: 1,
+					Restricted: 1,
+				},
 			})
 			if err != nil {
 				return fmt.Errorf("failed to create ha group %s: %s", haGroupPin, err)
@@ -50,11 +59,20 @@ func (s *Server) SetupVMPin() error {
 			// Check if existing group has correct configuration
 			logging.Infof("updating ha group %s: before=%q, after=%q", haGroupPin, existingGroup.Nodes, expectedNodes)
 
-			err := s.proxmox.UpdateClusterHAGroup(proxmox.ClusterHAGroup{
-				Group:      haGroupPin,
-				Nodes:      expectedNodes,
-				NoFailback: 1,
-				Restricted: 1,
+			_, err := s.applyAction(Action{
+				Kind:     ActionUpdate,
+				Resource: ResourceHAGroup,
+				ID:       haGroupPin,
+				Node:     node.Node,
+				Before:   existingGroup.Nodes,
+				After:    expectedNodes,
+				Reason:   "pin group nodes differ from expected",
+				HAGroup: &proxmox.ClusterHAGroup{
+					Group:      haGroupPin,
+					Nodes:      expectedNodes,
+					NoFailback: 1,
+					Restricted: 1,
+				},
 			})
 			if err != nil {
 				return fmt.Errorf("failed to update ha group %s: %s", haGroupPin, err)
@@ -87,92 +105,124 @@ func (s *Server) SetupVMPrefer() error {
 	}
 
 	for _, node := range nodes {
-		haGroupPrefer := tools.GetHAVMPreferGroupName(node.Node)
-
 		// Generate expected node configuration
 		expectedNodes := s.generateExpectedPreferNodes(nodes, node.Node)
 
-		var existingGroup *proxmox.ClusterHAGroup
-		for _, group := range haGroups {
-			if haGroupPrefer == group.Group {
-				existingGroup = &group
-				break
-			}
+		if err := s.ensurePreferGroup(tools.GetHAVMPreferGroupName(node.Node), node.Node, expectedNodes, haGroups); err != nil {
+			return err
 		}
+	}
 
-		if existingGroup == nil {
-			logging.Infof("creating ha group %s", haGroupPrefer)
+	// Storages that only some nodes reach get prefer groups restricted to these nodes
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return err
+	}
 
-			if _, err := s.proxmox.CreateClusterHAGroup(proxmox.ClusterHAGroup{
+	for _, reach := range storageZones(restrictedStorages(storages, nodes)) {
+		if err := s.ensureZonePreferGroups(reach, haGroups); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePreferGroup creates or updates a prefer group of a node with the expected node configuration
+func (s *Server) ensurePreferGroup(haGroupPrefer, node, expectedNodes string, haGroups []proxmox.ClusterHAGroup) error {
+	var existingGroup *proxmox.ClusterHAGroup
+	for _, group := range haGroups {
+		if haGroupPrefer == group.Group {
+			existingGroup = &group
+			break
+		}
+	}
+
+	if existingGroup == nil {
+		logging.Infof("creating ha group %s", haGroupPrefer)
+
+		if _, err := s.applyAction(Action{
+			Kind:     ActionCreate,
+			Resource: ResourceHAGroup,
+			ID:       haGroupPrefer,
+			Node:     node,
+			After:    expectedNodes,
+			Reason:   "prefer group missing for node",
+			HAGroup: &proxmox.ClusterHAGroup{
 				Group:      haGroupPrefer,
 				Nodes:      expectedNodes,
 				NoFailback: 1,
 				Restricted: 1,
-			}); err != nil {
-				return fmt.Errorf("failed to create ha group %s: %s", haGroupPrefer, err)
-			}
-		} else if !s.compareNodeConfiguration(existingGroup.Nodes, expectedNodes) {
-			// Check if existing group has correct configuration
-			logging.Infof("updating ha group %s: before=%q, after=%q", haGroupPrefer, existingGroup.Nodes, expectedNodes)
-
-			err := s.proxmox.UpdateClusterHAGroup(proxmox.ClusterHAGroup{
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to create ha group %s: %s", haGroupPrefer, err)
+		}
+	} else if !s.compareNodeConfiguration(existingGroup.Nodes, expectedNodes) {
+		// Check if existing group has correct configuration
+		logging.Infof("updating ha group %s: before=%q, after=%q", haGroupPrefer, existingGroup.Nodes, expectedNodes)
+
+		_, err := s.applyAction(Action{
+			Kind:     ActionUpdate,
+			Resource: ResourceHAGroup,
+			ID:       haGroupPrefer,
+			Node:     node,
+			Before:   existingGroup.Nodes,
+			After:    expectedNodes,
+			Reason:   "prefer group nodes differ from expected",
+			HAGroup: &proxmox.ClusterHAGroup{
 				Group:      haGroupPrefer,
 				Nodes:      expectedNodes,
 				NoFailback: 1,
 				Restricted: 1,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to update ha group %s: %s", haGroupPrefer, err)
-			}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update ha group %s: %s", haGroupPrefer, err)
 		}
 	}
 
 	return nil
 }
 
-// generateExpectedPreferNodes generates the expected node configuration string for a prefer group
+// generateExpectedPreferNodes generates the expected node configuration string for a prefer group.
+// The preferred node gets the highest priority, the other nodes follow in the order of the weighted node ring,
+// so nodes with a higher weight take over the guests of more nodes. Excluded nodes only appear in their own group.
 func (s *Server) generateExpectedPreferNodes(nodes []proxmox.Node, preferredNodeName string) string {
-	groupNodes := make([]string, 0, len(nodes))
-
-	// Create a copy of nodes for sorting to ensure consistent ordering
-	sortedNodes := make([]proxmox.Node, len(nodes))
-	copy(sortedNodes, nodes)
+	// placementNodes returns a copy, sorting it keeps the ordering consistent
+	sortedNodes := s.placementNodes(nodes, preferredNodeName)
 	sort.Slice(sortedNodes, func(i, j int) bool {
 		return sortedNodes[i].Node < sortedNodes[j].Node
 	})
 
-	// Find the index of the preferred node
-	preferredIndex := -1
-	for i, n := range sortedNodes {
-		if n.Node == preferredNodeName {
-			preferredIndex = i
-			break
+	ring := s.weightedNodeRing(sortedNodes)
+
+	// Find the position of the preferred node, the other nodes follow it round-robin
+	preferredIndex := slices.Index(ring, preferredNodeName)
+
+	groupNodes := make([]string, 0, len(sortedNodes))
+	seen := make(map[string]bool, len(sortedNodes))
+	position := 0
+	maxPriority := s.currentConfig().Scheduler.MaxNodePriority
+	for i := 1; i <= len(ring); i++ {
+		name := ring[(preferredIndex+i)%len(ring)]
+		if seen[name] {
+			continue
 		}
-	}
+		seen[name] = true
 
-	// Assign priorities using round-robin starting from preferred node
-	for i, n := range sortedNodes {
-		var priority int
-		if n.Node == preferredNodeName {
-			// Preferred node gets maximum priority
-			priority = crsMaxNodePriority
-		} else {
-			// Calculate round-robin position relative to preferred node
-			relativePosition := (i - preferredIndex + len(sortedNodes)) % len(sortedNodes)
-			if relativePosition == 0 {
-				relativePosition = len(sortedNodes) // Move preferred node to end for calculation
-			}
+		// Preferred node gets maximum priority
+		priority := maxPriority
+		if name != preferredNodeName {
 			// Start from max priority and decrement by 5 for each position
-			priority = crsMaxNodePriority - (relativePosition * 5)
+			position++
+			priority = maxPriority - position*5
 			// Ensure priority doesn't go below minimum
 			if priority < crsMinNodePriority {
 				priority = crsMinNodePriority
 			}
 		}
 
-		groupNodes = append(groupNodes,
-			fmt.Sprintf("%s:%d", n.Node, priority),
-		)
+		groupNodes = append(groupNodes, fmt.Sprintf("%s:%d", name, priority))
 	}
 
 	sort.Strings(groupNodes)
@@ -239,6 +289,40 @@ func (s *Server) generateActualHAGroupNames() (map[string]bool, error) {
 		}
 	}
 
+	for pool := range s.currentConfig().Pools {
+		actualGroups[poolGroupName(pool)] = true
+	}
+
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reach := range storageZones(restrictedStorages(storages, nodes)) {
+		for _, node := range reach {
+			actualGroups[zonePreferGroupName(node, zoneName(reach))] = true
+		}
+	}
+
+	// Policy, pool and PCI groups are kept while an HA resource uses them, prefer groups of a zone while a guest is in the zone
+	resources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range resources {
+		if strings.HasPrefix(resource.Group, crsPolicyGroupPrefix) || strings.HasPrefix(resource.Group, crsPoolGroupPrefix) ||
+			strings.HasPrefix(resource.Group, crsPCIGroupPrefix) {
+			actualGroups[resource.Group] = true
+		}
+
+		if zone := preferGroupZone(resource.Group); zone != "" {
+			for _, node := range nodes {
+				actualGroups[zonePreferGroupName(node.Node, zone)] = true
+			}
+		}
+	}
+
 	return actualGroups, nil
 }
 
@@ -252,7 +336,14 @@ func (s *Server) removeVMsFromHAGroup(groupName string) error {
 	for _, resource := range resources {
 		if resource.Group == groupName {
 			logging.Infof("removing HA resource %s from group %s", resource.SID, groupName)
-			if err := s.proxmox.DeleteClusterHAResource(resource.SID); err != nil {
+			if _, err := s.applyAction(Action{
+				Kind:     ActionDelete,
+				Resource: ResourceHAResource,
+				ID:       resource.SID,
+				VMID:     haResourceVMID(resource.SID),
+				Before:   groupName,
+				Reason:   "HA group is orphaned",
+			}); err != nil {
 				return fmt.Errorf("failed to remove HA resource %s from group %s: %w", resource.SID, groupName, err)
 			}
 
@@ -288,7 +379,13 @@ func (s *Server) CleanupOrphanedHAGroups() error {
 
 			// Then delete the group
 			logging.Infof("deleting orphaned HA group: %s", group.Group)
-			if err := s.proxmox.DeleteClusterHAGroup(group.Group); err != nil {
+			if _, err := s.applyAction(Action{
+				Kind:     ActionDelete,
+				Resource: ResourceHAGroup,
+				ID:       group.Group,
+				Before:   group.Nodes,
+				Reason:   "HA group is orphaned",
+			}); err != nil {
 				return fmt.Errorf("failed to delete orphaned HA group %s: %w", group.Group, err)
 			}
 		}
@@ -9,9 +9,10 @@ import (
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
 
-// UpdateHAStatus updates HA status for VMs with issues using default options
+// UpdateHAStatus updates HA status for VMs with issues using the configured retry options
 func (s *Server) UpdateHAStatus() error {
-	return s.UpdateHAStatusWithOptions(30, 10*time.Second)
+	scheduler := s.currentConfig().Scheduler
+	return s.UpdateHAStatusWithOptions(scheduler.HAStateMaxAttempts, time.Duration(scheduler.HAStateWaitSeconds)*time.Second)
 }
 
 // UpdateHAStatusWithOptions updates HA status for VMs with configurable retry options
@@ -29,15 +30,15 @@ func (s *Server) UpdateHAStatusWithOptions(maxAttempts int, waitInterval time.Du
 	var criticalNotStartedVMs []string
 
 	for _, resource := range resources {
-		// Only check VMs (type=qemu)
-		if resource.Type == vmResourceType {
+		// Only check VMs (type=qemu) and containers (type=lxc)
+		if isGuestResource(resource) {
 			// Skip VMs with crs-skip tag
 			if s.hasVMSkipTag(resource.Tags) {
 				logging.Debugf("Skipping VM %d (%s) with crs-skip tag for HA status operations", resource.VMID, resource.Name)
 				continue
 			}
 
-			vmSID := fmt.Sprintf("vm:%d", resource.VMID)
+			vmSID := haResourceSID(resource.Type, resource.VMID)
 
 			// Check if VM has critical tag and is not in started state
 			// Skip VMs that are currently migrating as they shouldn't be forced to started state
@@ -176,11 +177,41 @@ func (s *Server) fixErrorStateVM(vmSID string, haResource *proxmox.ClusterHAReso
 	// Set state to disabled
 	disabledResource := *haResource
 	disabledResource.State = haStateDisabled
-	if err := s.proxmox.UpdateClusterHAResource(disabledResource); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         vmSID,
+		VMID:       haResourceVMID(vmSID),
+		Before:     haResource.State,
+		After:      haStateDisabled,
+		Reason:     "clear HA error state",
+		HAResource: &disabledResource,
+	}); err != nil {
 		logging.Errorf("Failed to set HA resource %s to disabled: %v", vmSID, err)
 		return false
 	}
 
+	// Return to original state with retry logic
+	originalResource := *haResource
+	originalResource.State = originalState
+
+	restoreAction := Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         vmSID,
+		VMID:       haResourceVMID(vmSID),
+		Before:     haStateDisabled,
+		After:      originalState,
+		Reason:     "restore state after clearing HA error",
+		HAResource: &originalResource,
+	}
+
+	if s.isPlanning() {
+		// Nothing changes while planning, ApplyPlan waits for the disabled state before the restore, see awaitsHAState
+		_, _ = s.applyAction(restoreAction)
+		return true
+	}
+
 	logging.Infof("Set HA resource %s to disabled, waiting for state to stabilize", vmSID)
 
 	// Wait for the disabled state to be applied and error to clear
@@ -189,12 +220,8 @@ func (s *Server) fixErrorStateVM(vmSID string, haResource *proxmox.ClusterHAReso
 		return false
 	}
 
-	// Return to original state with retry logic
-	originalResource := *haResource
-	originalResource.State = originalState
-
 	// Try to restore the original state
-	if err := s.proxmox.UpdateClusterHAResource(originalResource); err != nil {
+	if _, err := s.applyAction(restoreAction); err != nil {
 		logging.Errorf("Failed to restore HA resource %s to %s state: %v", vmSID, originalState, err)
 		return false
 	}
@@ -217,11 +244,24 @@ func (s *Server) startDisabledVM(vmSID string, haResource *proxmox.ClusterHAReso
 	// Set state to started
 	startedResource := *haResource
 	startedResource.State = haStateStarted
-	if err := s.proxmox.UpdateClusterHAResource(startedResource); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         vmSID,
+		VMID:       haResourceVMID(vmSID),
+		Before:     currentState,
+		After:      haStateStarted,
+		Reason:     "HA resource is disabled",
+		HAResource: &startedResource,
+	}); err != nil {
 		logging.Errorf("Failed to set HA resource %s to started: %v", vmSID, err)
 		return false
 	}
 
+	if s.isPlanning() {
+		return true
+	}
+
 	logging.Infof("Set HA resource %s to started, waiting for state to change", vmSID)
 
 	// Wait for the started state to be applied (from current state to started)
@@ -238,11 +278,24 @@ func (s *Server) ensureCriticalVMStarted(vmSID string, haResource *proxmox.Clust
 	// Critical VMs must always be in started state
 	startedResource := *haResource
 	startedResource.State = haStateStarted
-	if err := s.proxmox.UpdateClusterHAResource(startedResource); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         vmSID,
+		VMID:       haResourceVMID(vmSID),
+		Before:     haResource.State,
+		After:      haStateStarted,
+		Reason:     "critical VM is not started",
+		HAResource: &startedResource,
+	}); err != nil {
 		logging.Errorf("Failed to set critical HA resource %s to started: %v", vmSID, err)
 		return false
 	}
 
+	if s.isPlanning() {
+		return true
+	}
+
 	logging.Infof("Set critical HA resource %s to started, waiting for state to change", vmSID)
 
 	// Wait for the started state to be applied
@@ -268,8 +321,8 @@ func (s *Server) waitForHAStateChangeWithInterval(vmSID, fromState, toState stri
 		}
 
 		for _, resource := range resources {
-			if resource.Type == vmResourceType {
-				if vmidStr := fmt.Sprintf("vm:%d", resource.VMID); vmidStr == vmSID {
+			if isGuestResource(resource) {
+				if haResourceSID(resource.Type, resource.VMID) == vmSID {
 					currentState := resource.HAState
 					logging.Debugf("Attempt %d/%d: HA resource %s current state: %s (target: %s)",
 						attempt, maxAttempts, vmSID, currentState, toState)
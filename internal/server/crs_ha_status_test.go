@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpdateHAStatus(t *testing.T) {
@@ -145,3 +146,15 @@ func TestUpdateHAStatusSkipsMigratingVMs(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestUpdateHAStatusContainers(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+	require.NoError(t, testServer.UpdateHAStatusWithOptions(1, time.Millisecond))
+
+	require.Len(t, testServer.plan.Actions, 1)
+	assert.Equal(t, "ct:603", testServer.plan.Actions[0].ID, "critical containers are started")
+	assert.Equal(t, "started", testServer.plan.Actions[0].After)
+}
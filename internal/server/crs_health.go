@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+)
+
+// clusterHealthProblem returns why the cluster is not safe to change, or an empty string when it is:
+// the cluster lost quorum, HA services run without an active CRM master, or a node is being fenced
+func (s *Server) clusterHealthProblem() (string, error) {
+	status, err := s.proxmox.GetClusterStatus()
+	if err != nil {
+		return "", err
+	}
+
+	if status.Quorate != 1 {
+		return "cluster is not quorate", nil
+	}
+
+	haStatus, err := s.proxmox.GetClusterHAStatus()
+	if err != nil {
+		return "", err
+	}
+
+	master, services := false, false
+	for _, entry := range haStatus {
+		switch entry.Type {
+		case "master":
+			master = strings.Contains(entry.Status, "(active")
+		case "lrm":
+			if entry.CRMState == haNodeStateFence {
+				return fmt.Sprintf("node %s is being fenced", entry.Node), nil
+			}
+		case "service":
+			services = true
+			if entry.State == haNodeStateFence {
+				return fmt.Sprintf("HA service %s waits for node %s to be fenced", entry.ID, entry.Node), nil
+			}
+		}
+	}
+
+	if services && !master {
+		// Without HA services the CRM master may go idle
+		return "HA manager has no active CRM master", nil
+	}
+
+	return "", nil
+}
+
+// checkHealthGate reports whether the cluster is healthy enough for a cycle to change it.
+// Holding and releasing the gate is logged and journaled once per change.
+func (s *Server) checkHealthGate() (bool, error) {
+	problem, err := s.clusterHealthProblem()
+	if err != nil {
+		return false, fmt.Errorf("failed to check cluster health: %w", err)
+	}
+
+	if s.isPlanning() {
+		if problem != "" {
+			logging.Warnf("Cluster is not healthy (%s), a running CRS would make no changes", problem)
+		}
+		return true, nil
+	}
+
+	s.statusMu.Lock()
+	previous := s.healthProblem
+	s.healthProblem = problem
+	s.statusMu.Unlock()
+
+	if problem != "" {
+		metrics.HealthGateHeld.Set(1)
+	} else {
+		metrics.HealthGateHeld.Set(0)
+	}
+
+	switch {
+	case problem != "" && problem != previous:
+		logging.Warnf("Cluster is not healthy, CRS makes no changes until it recovers: %s", problem)
+		s.putEvent(consul.Event{
+			Time:     time.Now().UTC(),
+			Kind:     eventKindHealth,
+			Resource: eventResourceCluster,
+			ID:       eventResourceCluster,
+			Reason:   problem,
+			Result:   healthGateHeld,
+		})
+	case problem == "" && previous != "":
+		logging.Infof("Cluster is healthy again (%s), CRS resumes changes", previous)
+		s.putEvent(consul.Event{
+			Time:     time.Now().UTC(),
+			Kind:     eventKindHealth,
+			Resource: eventResourceCluster,
+			ID:       eventResourceCluster,
+			Reason:   "recovered from: " + previous,
+			Result:   healthGateReleased,
+		})
+	}
+
+	return problem == "", nil
+}
+
+// currentHealthProblem returns why the health gate holds, empty while the cluster is healthy
+func (s *Server) currentHealthProblem() string {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	return s.healthProblem
+}
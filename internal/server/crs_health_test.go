@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterHealthProblem(t *testing.T) {
+	const (
+		master  = `{"id": "master", "type": "master", "node": "pve1", "status": "pve1 (active, Fri Oct 16 10:00:00 2026)"}`
+		service = `{"id": "service:vm:100", "type": "service", "node": "pve2", "state": "started"}`
+	)
+
+	tests := []struct {
+		name     string
+		quorate  int
+		haStatus string
+		problem  string
+	}{
+		{name: "healthy", quorate: 1, haStatus: master + "," + service},
+		{name: "no HA services", quorate: 1, haStatus: `{"id": "master", "type": "master", "status": "pve1 (idle, Fri Oct 16 10:00:00 2026)"}`},
+		{name: "no quorum", quorate: 0, haStatus: master, problem: "cluster is not quorate"},
+		{
+			name:     "no master",
+			quorate:  1,
+			haStatus: `{"id": "master", "type": "master", "status": "pve1 (old timestamp - dead?, Fri Oct 16 09:00:00 2026)"},` + service,
+			problem:  "HA manager has no active CRM master",
+		},
+		{
+			name:     "fenced node",
+			quorate:  1,
+			haStatus: master + `,{"id": "lrm:pve2", "type": "lrm", "node": "pve2", "status": "pve2 (old timestamp - dead?)", "crm_state": "fence"}`,
+			problem:  "node pve2 is being fenced",
+		},
+		{
+			name:     "service waiting for a fence",
+			quorate:  1,
+			haStatus: master + `,{"id": "service:vm:100", "type": "service", "node": "pve2", "state": "fence"}`,
+			problem:  "HA service service:vm:100 waits for node pve2 to be fenced",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+				health: &healthFixture{quorate: tt.quorate, haStatus: tt.haStatus},
+			})
+			defer mockServer.Close()
+
+			problem, err := testServer.clusterHealthProblem()
+			require.NoError(t, err)
+			assert.Equal(t, tt.problem, problem)
+		})
+	}
+}
+
+func TestSetupCRSHealthGate(t *testing.T) {
+	fixture := &healthFixture{}
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{health: fixture, recorder: recorder})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.SetupCRS(context.Background()))
+
+	cycle := testServer.getLastCycle()
+	assert.Equal(t, "cluster is not quorate", cycle.HealthGate)
+	require.Len(t, cycle.Steps, 1, "only read-only steps run")
+	assert.Equal(t, "collect_metrics", cycle.Steps[0].Name)
+	assert.Empty(t, recorder.mutations())
+
+	fixture.quorate = 1
+	healthy, err := testServer.checkHealthGate()
+	require.NoError(t, err)
+	assert.True(t, healthy, "the gate opens once the cluster recovers")
+	assert.Empty(t, testServer.healthProblem)
+}
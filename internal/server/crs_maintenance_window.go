@@ -0,0 +1,437 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// Phases of a maintenance window, see windowPhase
+const (
+	windowPhaseScheduled = "scheduled"
+	windowPhasePreDrain  = "pre-drain"
+	windowPhaseActive    = "active"
+	windowPhaseOver      = "over"
+)
+
+// windowStatus is the state of a maintenance window at the last cycle, part of GET /status
+type windowStatus struct {
+	Name  string    `json:"name"`
+	Node  string    `json:"node"`
+	Phase string    `json:"phase"`
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+	Drain string    `json:"drain,omitempty"` // Status of the node drain started by the window
+}
+
+var windowWeekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// validateMaintenanceWindow checks a maintenance window and fills in defaults for omitted values
+func validateMaintenanceWindow(window *consul.MaintenanceWindow) error {
+	if !configNameRe.MatchString(window.Name) {
+		return fmt.Errorf("name %q must consist of lowercase letters, digits and dashes", window.Name)
+	}
+
+	if window.Node == "" {
+		return fmt.Errorf("node is required")
+	}
+
+	switch {
+	case (window.Weekday == "") == (window.Date == ""):
+		return fmt.Errorf("exactly one of weekday and date is required")
+	case window.Weekday != "":
+		window.Weekday = strings.ToLower(window.Weekday)
+		if _, ok := windowWeekdays[window.Weekday]; !ok {
+			return fmt.Errorf("unknown weekday %q", window.Weekday)
+		}
+	default:
+		if _, err := time.Parse(time.DateOnly, window.Date); err != nil {
+			return fmt.Errorf("date %q must be YYYY-MM-DD", window.Date)
+		}
+	}
+
+	for field, value := range map[string]string{"start": window.Start, "end": window.End} {
+		if _, err := time.Parse("15:04", value); err != nil {
+			return fmt.Errorf("%s %q must be HH:MM", field, value)
+		}
+	}
+
+	if window.Start == window.End {
+		return fmt.Errorf("start and end must differ")
+	}
+
+	if window.Timezone == "" {
+		window.Timezone = "UTC"
+	}
+
+	if _, err := time.LoadLocation(window.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", window.Timezone)
+	}
+
+	if window.PreDrainMinutes == 0 {
+		window.PreDrainMinutes = maintenanceDefaultPreDrainMinutes
+	}
+
+	if window.PreDrainMinutes < 1 || window.PreDrainMinutes > maintenanceMaxPreDrainMinutes {
+		return fmt.Errorf("pre_drain_minutes must be between 1 and %d, got %d", maintenanceMaxPreDrainMinutes, window.PreDrainMinutes)
+	}
+
+	return nil
+}
+
+// loadMaintenanceWindowsConfig refreshes the maintenance windows from Consul.
+// An invalid window keeps its previous definition.
+func (s *Server) loadMaintenanceWindowsConfig(config *crsConfig) {
+	windows, err := s.consul.GetMaintenanceWindows()
+	if err != nil {
+		logging.Errorf("Failed to load maintenance windows, keeping previous settings: %v", err)
+		return
+	}
+
+	previous := make(map[string]consul.MaintenanceWindow, len(config.MaintenanceWindows))
+	for _, window := range config.MaintenanceWindows {
+		previous[window.Name] = window
+	}
+
+	loaded := make([]consul.MaintenanceWindow, 0, len(windows))
+	for _, window := range windows {
+		if err := validateMaintenanceWindow(&window); err != nil {
+			logging.Errorf("Invalid maintenance window %s, keeping previous settings: %v", window.Name, err)
+			if prev, ok := previous[window.Name]; ok {
+				loaded = append(loaded, prev)
+			}
+			continue
+		}
+
+		loaded = append(loaded, window)
+	}
+
+	config.MaintenanceWindows = loaded
+}
+
+// windowOccurrence returns the first occurrence of a validated window that ends after now.
+// It returns false when a one-time window is over.
+func windowOccurrence(window consul.MaintenanceWindow, now time.Time) (start, end time.Time, ok bool) {
+	location, err := time.LoadLocation(window.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	startTime, _ := time.Parse("15:04", window.Start)
+	endTime, _ := time.Parse("15:04", window.End)
+
+	occurrence := func(year int, month time.Month, day int) (time.Time, time.Time) {
+		start := time.Date(year, month, day, startTime.Hour(), startTime.Minute(), 0, 0, location)
+		end := time.Date(year, month, day, endTime.Hour(), endTime.Minute(), 0, 0, location)
+		if !end.After(start) {
+			// The window ends on the next day
+			end = time.Date(year, month, day+1, endTime.Hour(), endTime.Minute(), 0, 0, location)
+		}
+		return start, end
+	}
+
+	if window.Date != "" {
+		date, _ := time.Parse(time.DateOnly, window.Date)
+		start, end = occurrence(date.Year(), date.Month(), date.Day())
+		return start, end, end.After(now)
+	}
+
+	// Start a day early for a weekly window that is still running past midnight
+	local := now.In(location)
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+		if day.Weekday() != windowWeekdays[window.Weekday] {
+			continue
+		}
+
+		start, end = occurrence(day.Year(), day.Month(), day.Day())
+		if end.After(now) {
+			return start, end, true
+		}
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// windowPhase returns the phase of a window at now and its current or next occurrence
+func windowPhase(window consul.MaintenanceWindow, now time.Time) (phase string, start, end time.Time) {
+	start, end, ok := windowOccurrence(window, now)
+	switch {
+	case !ok:
+		return windowPhaseOver, time.Time{}, time.Time{}
+	case !now.Before(start):
+		return windowPhaseActive, start, end
+	case !now.Before(start.Add(-time.Duration(window.PreDrainMinutes) * time.Minute)):
+		return windowPhasePreDrain, start, end
+	default:
+		return windowPhaseScheduled, start, end
+	}
+}
+
+// ApplyMaintenanceWindows drains nodes ahead of their maintenance windows and ends the drains once the windows are over.
+// The node is in HA maintenance while its window is active, and no guests are placed on it until the window ends.
+func (s *Server) ApplyMaintenanceWindows() error {
+	return s.applyMaintenanceWindows(time.Now())
+}
+
+func (s *Server) applyMaintenanceWindows(now time.Time) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	windows := s.currentConfig().MaintenanceWindows
+	if len(windows) == 0 && !s.hasWindowDrains() {
+		s.setWindowStatuses(nil)
+		return nil
+	}
+
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		known[node.Node] = true
+	}
+
+	open := make(map[string]bool)
+	statuses := make([]windowStatus, 0, len(windows))
+
+	for _, window := range windows {
+		phase, start, end := windowPhase(window, now)
+		status := windowStatus{Name: window.Name, Node: window.Node, Phase: phase, Start: start, End: end}
+
+		if phase == windowPhasePreDrain || phase == windowPhaseActive {
+			open[window.Name] = true
+
+			if !known[window.Node] {
+				logging.Warnf("Maintenance window %s is open but node %s is not part of the cluster", window.Name, window.Node)
+			} else if err := s.openMaintenanceWindow(window, phase); err != nil {
+				logging.Errorf("Failed to drain node %s for maintenance window %s: %v", window.Node, window.Name, err)
+			} else if phase == windowPhaseActive {
+				s.enterWindowMaintenance(window)
+			}
+		}
+
+		if state, ok := s.drains[window.Node]; ok && state.Window == window.Name {
+			status.Drain = state.Status
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for _, node := range sortedDrainNodes(s.drains) {
+		state := s.drains[node]
+		if state.Window == "" || state.Status == consul.DrainStatusRestoring || open[state.Window] {
+			continue
+		}
+
+		logging.Infof("Maintenance window %s of node %s is over, returning its displaced VMs", state.Window, node)
+		if s.isPlanning() {
+			continue
+		}
+
+		restoring := cloneDrainState(state)
+		if restoring.Maintenance {
+			if err := s.setNodeMaintenance(node, ActionDisable, "maintenance window "+state.Window+" ended"); err != nil {
+				continue
+			}
+			restoring.Maintenance = false
+		}
+		restoring.Status = consul.DrainStatusRestoring
+		if err := s.putDrainState(restoring); err != nil {
+			logging.Errorf("Failed to store drain state of node %s: %v", node, err)
+			continue
+		}
+
+		s.putEvent(consul.Event{
+			Time:     now.UTC(),
+			Kind:     eventKindDrain,
+			Resource: eventResourceNode,
+			ID:       node,
+			Node:     node,
+			Reason:   "maintenance window " + state.Window + " ended",
+			Result:   consul.DrainStatusRestoring,
+		})
+	}
+
+	if !s.isPlanning() {
+		s.setWindowStatuses(statuses)
+	}
+
+	return nil
+}
+
+// openMaintenanceWindow drains the node of an open window. A drain started by hand is left as it is.
+// The caller holds drainMu.
+func (s *Server) openMaintenanceWindow(window consul.MaintenanceWindow, phase string) error {
+	state, ok := s.drains[window.Node]
+	switch {
+	case ok && state.Window != window.Name:
+		logging.Debugf("Node %s of maintenance window %s is already drained", window.Node, window.Name)
+		return nil
+	case ok && state.Status == consul.DrainStatusRestoring:
+		// The next occurrence started before the previous one was restored
+		if s.isPlanning() {
+			return nil
+		}
+		draining := cloneDrainState(state)
+		draining.Status = consul.DrainStatusDraining
+		return s.putDrainState(draining)
+	case ok:
+		return nil
+	}
+
+	reason := fmt.Sprintf("maintenance window %s is %s", window.Name, phase)
+	if s.isPlanning() {
+		logging.Infof("Would drain node %s: %s", window.Node, reason)
+		return nil
+	}
+
+	_, err := s.addDrain(&consul.DrainState{
+		Node:    window.Node,
+		Origin:  "maintenance window " + window.Name,
+		Window:  window.Name,
+		Restore: true,
+	}, reason)
+	return err
+}
+
+// enterWindowMaintenance puts the node of an active window into HA maintenance, once the window's drain has emptied it
+// as far as it could before the window. The caller holds drainMu.
+func (s *Server) enterWindowMaintenance(window consul.MaintenanceWindow) {
+	state, ok := s.drains[window.Node]
+	if !ok || state.Window != window.Name || state.Maintenance {
+		return
+	}
+
+	if err := s.setNodeMaintenance(window.Node, ActionEnable, "maintenance window "+window.Name+" is active"); err != nil || s.isPlanning() {
+		return
+	}
+
+	inMaintenance := cloneDrainState(state)
+	inMaintenance.Maintenance = true
+	if err := s.putDrainState(inMaintenance); err != nil {
+		logging.Errorf("Failed to store drain state of node %s: %v", window.Node, err)
+	}
+}
+
+// hasWindowDrains reports whether a maintenance window started one of the drains. The caller holds drainMu.
+func (s *Server) hasWindowDrains() bool {
+	for _, state := range s.drains {
+		if state.Window != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedDrainNodes returns the drained nodes in name order
+func sortedDrainNodes(drains map[string]*consul.DrainState) []string {
+	nodes := make([]string, 0, len(drains))
+	for node := range drains {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// setWindowStatuses stores the maintenance window states of the last cycle
+func (s *Server) setWindowStatuses(statuses []windowStatus) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	s.windowStatuses = statuses
+}
+
+// getWindowStatuses returns a copy of the maintenance window states of the last cycle
+func (s *Server) getWindowStatuses() []windowStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	return append([]windowStatus(nil), s.windowStatuses...)
+}
+
+// restoreDisplacedVMs live-migrates the VMs a drain moved off a node back to it and returns them
+// to the node's prefer group, a few per cycle. VMs that were moved or re-grouped since are left where they are.
+// It reports whether every displaced VM is handled, which includes the end of its return migration.
+func (s *Server) restoreDisplacedVMs(state *consul.DrainState, resources []proxmox.ClusterResource, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup, busy map[int]proxmox.Task) bool {
+	groupExists := make(map[string]bool, len(haGroups))
+	for _, group := range haGroups {
+		groupExists[group.Group] = true
+	}
+
+	vms := make(map[int]proxmox.ClusterResource)
+	for _, resource := range resources {
+		if resource.Type == vmResourceType {
+			vms[resource.VMID] = resource
+		}
+	}
+
+	var pending []consul.DrainMigration
+	restored := 0
+
+	for _, displaced := range state.Displaced {
+		vm, exists := vms[displaced.VMID]
+		haResource := s.findHAResource(haResourceSID(vmResourceType, displaced.VMID), haResources)
+
+		switch {
+		case exists && vm.Node == state.Node:
+			// Returned, CheckMigrations re-homed it once its migration finished
+			continue
+		case !exists || vm.Node != displaced.Target:
+			logging.Debugf("VM %d (%s) left %s since the drain, not returning it", displaced.VMID, displaced.Name, displaced.Target)
+			continue
+		case haResource == nil || haResource.Group != preferGroupOnNode(haResource.Group, displaced.Target):
+			logging.Debugf("VM %d (%s) changed its HA group since the drain, not returning it", displaced.VMID, displaced.Name)
+			continue
+		case s.hasVMSkipTag(vm.Tags):
+			continue
+		}
+
+		if s.isMigrationInFlight(displaced.VMID) {
+			// The return is in flight and counts against the limit
+			restored++
+			pending = append(pending, displaced)
+			continue
+		}
+
+		if _, ok := busy[displaced.VMID]; ok || restored >= s.currentConfig().Drain.MaxPerSource {
+			pending = append(pending, displaced)
+			continue
+		}
+		restored++
+
+		logging.Infof("Returning VM %d (%s) from %s to %s after %s", displaced.VMID, displaced.Name, displaced.Target, state.Node, state.Origin)
+
+		// Proxmox ignores online for stopped VMs and migrates them offline
+		reason := state.Origin + " ended"
+		if err := s.liveMigrateAndRehome(displaced.VMID, displaced.Name, haResource.Group, displaced.Target, state.Node, reason, groupExists); err != nil {
+			logging.Errorf("Failed to return VM %d (%s) to %s, leaving it on %s: %v", displaced.VMID, displaced.Name, state.Node, displaced.Target, err)
+			continue
+		}
+
+		// The drain waits for the migration, which CheckMigrations retries when it fails
+		if s.isMigrationInFlight(displaced.VMID) {
+			pending = append(pending, displaced)
+		}
+	}
+
+	state.Displaced = pending
+
+	return len(pending) == 0 && len(state.InFlight) == 0
+}
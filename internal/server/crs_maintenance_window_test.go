@@ -0,0 +1,130 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	window := consul.MaintenanceWindow{Name: "patching", Node: "pve1", Weekday: "Sunday", Start: "02:00", End: "04:00"}
+	require.NoError(t, validateMaintenanceWindow(&window))
+	assert.Equal(t, "sunday", window.Weekday)
+	assert.Equal(t, "UTC", window.Timezone)
+	assert.Equal(t, maintenanceDefaultPreDrainMinutes, window.PreDrainMinutes)
+
+	tests := []struct {
+		name   string
+		window consul.MaintenanceWindow
+	}{
+		{"no node", consul.MaintenanceWindow{Name: "w", Weekday: "sunday", Start: "02:00", End: "04:00"}},
+		{"weekday and date", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "sunday", Date: "2026-11-01", Start: "02:00", End: "04:00"}},
+		{"unknown weekday", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "someday", Start: "02:00", End: "04:00"}},
+		{"invalid date", consul.MaintenanceWindow{Name: "w", Node: "pve1", Date: "01.11.2026", Start: "02:00", End: "04:00"}},
+		{"invalid time", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "sunday", Start: "2am", End: "04:00"}},
+		{"empty window", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "sunday", Start: "02:00", End: "02:00"}},
+		{"unknown timezone", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "sunday", Start: "02:00", End: "04:00", Timezone: "Mars/Olympus"}},
+		{"pre-drain too long", consul.MaintenanceWindow{Name: "w", Node: "pve1", Weekday: "sunday", Start: "02:00", End: "04:00", PreDrainMinutes: 2000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, validateMaintenanceWindow(&tt.window))
+		})
+	}
+}
+
+func TestWindowPhase(t *testing.T) {
+	weekly := consul.MaintenanceWindow{Name: "patching", Node: "pve1", Weekday: "sunday", Start: "02:00", End: "04:00"}
+	overnight := consul.MaintenanceWindow{Name: "overnight", Node: "pve1", Weekday: "saturday", Start: "23:00", End: "01:00"}
+	once := consul.MaintenanceWindow{Name: "firmware", Node: "pve1", Date: "2026-11-01", Start: "02:00", End: "04:00", Timezone: "Europe/Kyiv"}
+	for _, window := range []*consul.MaintenanceWindow{&weekly, &overnight, &once} {
+		require.NoError(t, validateMaintenanceWindow(window))
+	}
+
+	sunday := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		window consul.MaintenanceWindow
+		now    time.Time
+		phase  string
+		start  time.Time
+	}{
+		{"before the window", weekly, sunday.Add(-12 * time.Hour), windowPhaseScheduled, sunday.Add(2 * time.Hour)},
+		{"pre-drain", weekly, sunday.Add(105 * time.Minute), windowPhasePreDrain, sunday.Add(2 * time.Hour)},
+		{"active", weekly, sunday.Add(3 * time.Hour), windowPhaseActive, sunday.Add(2 * time.Hour)},
+		{"next week", weekly, sunday.Add(5 * time.Hour), windowPhaseScheduled, sunday.Add(7*24*time.Hour + 2*time.Hour)},
+		{"past midnight", overnight, sunday.Add(30 * time.Minute), windowPhaseActive, sunday.Add(-time.Hour)},
+		{"time zone", once, sunday.Add(30 * time.Minute), windowPhaseActive, sunday},
+		{"one-time window over", once, sunday.Add(3 * time.Hour), windowPhaseOver, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phase, start, _ := windowPhase(tt.window, tt.now)
+			assert.Equal(t, tt.phase, phase)
+			assert.True(t, tt.start.Equal(start), "start %s, expected %s", start, tt.start)
+		})
+	}
+}
+
+func TestApplyMaintenanceWindows(t *testing.T) {
+	cluster := &drainTestCluster{done: make(map[string]string), moved: map[int]string{101: "pve2"}, finish: true}
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+
+	window := consul.MaintenanceWindow{Name: "patching", Node: "pve1", Date: "2026-11-01", Start: "02:00", End: "04:00"}
+	require.NoError(t, validateMaintenanceWindow(&window))
+	testServer.config.MaintenanceWindows = []consul.MaintenanceWindow{window}
+
+	start := time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC)
+
+	require.NoError(t, testServer.applyMaintenanceWindows(start.Add(-time.Hour)))
+	assert.Empty(t, testServer.drainStates(), "the node is drained shortly before the window")
+	assert.Equal(t, windowPhaseScheduled, testServer.getWindowStatuses()[0].Phase)
+
+	require.NoError(t, testServer.applyMaintenanceWindows(start.Add(-10*time.Minute)))
+	require.Len(t, testServer.drainStates(), 1)
+	assert.Equal(t, "patching", testServer.drainStates()[0].Window)
+	assert.True(t, testServer.isDrainingNode("pve1"))
+	assert.Equal(t, windowStatus{
+		Name: "patching", Node: "pve1", Phase: windowPhasePreDrain,
+		Start: start, End: start.Add(2 * time.Hour), Drain: consul.DrainStatusDraining,
+	}, testServer.getWindowStatuses()[0])
+	assert.Empty(t, cluster.recorder.mutations(), "HA maintenance waits for the window")
+
+	// VM 101 was moved to pve2 by the drain and re-homed
+	testServer.drains["pve1"].Displaced = []consul.DrainMigration{
+		{VMID: 101, Type: vmResourceType, Name: "web", Target: "pve2"},
+		{VMID: 102, Type: vmResourceType, Name: "batch", Target: "pve3"},
+	}
+
+	require.NoError(t, testServer.applyMaintenanceWindows(start.Add(time.Hour)))
+	assert.True(t, testServer.drainStates()[0].Maintenance)
+	assert.Equal(t, "POST /api2/json/cluster/ha/crm-command/node-maintenance/enable", cluster.recorder.mutations()[len(cluster.recorder.mutations())-1])
+
+	require.NoError(t, testServer.applyMaintenanceWindows(start.Add(3*time.Hour)))
+	assert.Equal(t, consul.DrainStatusRestoring, testServer.drainStates()[0].Status)
+	assert.False(t, testServer.drainStates()[0].Maintenance)
+	assert.Equal(t, "POST /api2/json/cluster/ha/crm-command/node-maintenance/disable", cluster.recorder.mutations()[len(cluster.recorder.mutations())-1])
+	assert.False(t, testServer.isDrainingNode("pve1"), "guests may return once the window is over")
+	assert.Equal(t, windowPhaseOver, testServer.getWindowStatuses()[0].Phase)
+
+	require.NoError(t, testServer.DrainNodes())
+	assert.Len(t, testServer.drainStates(), 1, "the drain waits for the return migration")
+
+	require.NoError(t, testServer.CheckMigrations())
+	cluster.moved[101] = "pve1"
+	require.NoError(t, testServer.DrainNodes())
+	assert.Empty(t, testServer.drainStates(), "the drain ends once the displaced VMs are back")
+	assert.Equal(t, []string{
+		"POST /api2/json/cluster/ha/crm-command/node-maintenance/enable",
+		"POST /api2/json/cluster/ha/crm-command/node-maintenance/disable",
+		"POST /api2/json/nodes/pve2/qemu/101/migrate",
+		"PUT /api2/json/cluster/ha/resources/vm:101",
+	}, cluster.recorder.mutations(), "VM 102 is no longer on the node it was moved to and stays")
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// collectGuestMetrics updates the guest count per CRS group, the memory share of the pools with a policy,
+// the number of critical guests that are not started and the weight of every node
+func (s *Server) collectGuestMetrics() error {
+	if s.isPlanning() {
+		return nil
+	}
+
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	// Groups removed since the last cycle must not keep reporting their old count
+	metrics.GroupGuests.Reset()
+	for group, count := range countManagedGuests(haResources).Groups {
+		metrics.GroupGuests.WithLabelValues(group).Set(float64(count))
+	}
+
+	metrics.PoolMemoryShare.Reset()
+	for pool := range s.currentConfig().Pools {
+		metrics.PoolMemoryShare.WithLabelValues(pool).Set(poolMemoryShare(pool, resources, 0))
+	}
+
+	metrics.NodeWeight.Reset()
+	metrics.NodeExcluded.Reset()
+	for _, resource := range resources {
+		if resource.Type != "node" {
+			continue
+		}
+
+		attributes := s.currentConfig().Nodes[resource.Node]
+		metrics.NodeWeight.WithLabelValues(resource.Node, attributes.Class, attributes.Rack).Set(float64(s.nodeWeight(resource.Node)))
+
+		var excluded float64
+		if attributes.Excluded {
+			excluded = 1
+		}
+		metrics.NodeExcluded.WithLabelValues(resource.Node).Set(excluded)
+	}
+
+	var criticalNotStarted int
+	for _, resource := range resources {
+		if !isGuestResource(resource) || s.hasVMSkipTag(resource.Tags) || !s.hasVMCriticalTag(resource.Tags) {
+			continue
+		}
+
+		if resource.HAState != haStateStarted {
+			criticalNotStarted++
+		}
+	}
+	metrics.CriticalNotStarted.Set(float64(criticalNotStarted))
+
+	return nil
+}
+
+// managedGuests counts the guests whose HA resource is in a CRS group
+type managedGuests struct {
+	VMs        int            `json:"vms"`
+	Containers int            `json:"containers"`
+	Groups     map[string]int `json:"groups"`
+}
+
+// countManagedGuests counts the HA resources in CRS groups per guest type and group
+func countManagedGuests(haResources []proxmox.ClusterHAResource) managedGuests {
+	counts := managedGuests{Groups: make(map[string]int)}
+
+	for _, haResource := range haResources {
+		if !strings.HasPrefix(haResource.Group, crsGroupPrefix) {
+			continue
+		}
+
+		counts.Groups[haResource.Group]++
+
+		switch {
+		case strings.HasPrefix(haResource.SID, haContainerResourceType+":"):
+			counts.Containers++
+		case strings.HasPrefix(haResource.SID, haResourceType+":"):
+			counts.VMs++
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,775 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// errNodeNotFound is returned for a drain of a node that is not part of the cluster
+var errNodeNotFound = errors.New("node not found")
+
+// drainCounts tracks the migrations in flight per source and target node across all drains
+type drainCounts struct {
+	source map[string]int
+	target map[string]int
+}
+
+// loadDrainStates refreshes the node drains from Consul, keeping the current ones when they fail to load
+func (s *Server) loadDrainStates() {
+	states, err := s.consul.GetDrainStates()
+	if err != nil {
+		logging.Errorf("Failed to load node drains, keeping current state: %v", err)
+		return
+	}
+
+	drains := make(map[string]*consul.DrainState, len(states))
+	for _, state := range states {
+		drains[state.Node] = &state
+	}
+
+	s.drainMu.Lock()
+	s.drains = drains
+	s.drainMu.Unlock()
+}
+
+// putDrainState stores a drain in Consul and in memory
+func (s *Server) putDrainState(state *consul.DrainState) error {
+	if err := s.consul.PutDrainState(*state); err != nil {
+		return err
+	}
+
+	if s.drains == nil {
+		s.drains = make(map[string]*consul.DrainState)
+	}
+	s.drains[state.Node] = state
+
+	return nil
+}
+
+// startDrain requests a drain of node and returns its state and whether the drain is new.
+// Draining a node twice returns the running drain.
+func (s *Server) startDrain(node string) (*consul.DrainState, bool, error) {
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	if !slices.ContainsFunc(nodes, func(n proxmox.Node) bool { return n.Node == node }) {
+		return nil, false, errNodeNotFound
+	}
+
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if state, ok := s.drains[node]; ok {
+		return cloneDrainState(state), false, nil
+	}
+
+	state, err := s.addDrain(&consul.DrainState{Node: node, Origin: "admin API"}, "drain requested")
+	if err != nil {
+		return nil, false, err
+	}
+
+	return cloneDrainState(state), true, nil
+}
+
+// addDrain starts the drain described by state, which names the node and what requested the drain.
+// The caller holds drainMu.
+func (s *Server) addDrain(state *consul.DrainState, reason string) (*consul.DrainState, error) {
+	node := state.Node
+	state.Status = consul.DrainStatusDraining
+	state.RequestedAt = time.Now().UTC()
+
+	if err := s.putDrainState(state); err != nil {
+		return nil, err
+	}
+
+	logging.Warnf("Drain of node %s started (%s), CRS no longer places guests on it", node, reason)
+	s.putEvent(consul.Event{
+		Time:     state.RequestedAt,
+		Kind:     eventKindDrain,
+		Resource: eventResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   reason,
+		Result:   consul.DrainStatusDraining,
+	})
+
+	return state, nil
+}
+
+// removeDrain deletes the drain of node. The caller holds drainMu.
+func (s *Server) removeDrain(node string) error {
+	if err := s.consul.DeleteDrainState(node); err != nil {
+		return err
+	}
+	delete(s.drains, node)
+
+	return nil
+}
+
+// cancelDrain ends the drain of node. Migrations in flight keep running but are no longer tracked.
+// It returns false when the node is not drained.
+func (s *Server) cancelDrain(node string) (bool, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	state, ok := s.drains[node]
+	if !ok {
+		return false, nil
+	}
+
+	if state.Maintenance {
+		if err := s.setNodeMaintenance(node, ActionDisable, "drain cancelled"); err != nil {
+			return false, err
+		}
+	}
+
+	if err := s.removeDrain(node); err != nil {
+		return false, err
+	}
+
+	logging.Infof("Drain of node %s ended, CRS places guests on it again", node)
+	s.putEvent(consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     eventKindDrain,
+		Resource: eventResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   "drain cancelled",
+		Result:   "cancelled",
+	})
+
+	return true, nil
+}
+
+// drainStates returns a copy of all drains ordered by node
+func (s *Server) drainStates() []consul.DrainState {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	states := make([]consul.DrainState, 0, len(s.drains))
+	for _, state := range s.drains {
+		states = append(states, *cloneDrainState(state))
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Node < states[j].Node
+	})
+
+	return states
+}
+
+// isDrainingNode reports whether node is drained or draining, CRS places no guests on such nodes
+func (s *Server) isDrainingNode(node string) bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	return s.drainExcludes(node)
+}
+
+// drainExcludes reports whether a drain keeps guests off node. The caller holds drainMu.
+func (s *Server) drainExcludes(node string) bool {
+	state, ok := s.drains[node]
+	return ok && state.Status != consul.DrainStatusRestoring
+}
+
+// cloneDrainState returns a deep copy of a drain, so it can be changed without touching the original
+func cloneDrainState(state *consul.DrainState) *consul.DrainState {
+	clone := *state
+	clone.InFlight = slices.Clone(state.InFlight)
+	clone.Blockers = slices.Clone(state.Blockers)
+	clone.Displaced = slices.Clone(state.Displaced)
+
+	if state.Failures != nil {
+		clone.Failures = make(map[int]int, len(state.Failures))
+		for vmid, count := range state.Failures {
+			clone.Failures[vmid] = count
+		}
+	}
+
+	if state.CompletedAt != nil {
+		completedAt := *state.CompletedAt
+		clone.CompletedAt = &completedAt
+	}
+
+	return &clone
+}
+
+// DrainNodes migrates all guests off nodes drained through the admin API, by a maintenance window or a rolling reboot.
+// Migrations are started without waiting for them, their tasks are checked on the next cycles,
+// and the number of migrations per source and target node is limited by crs/config/drain.
+// Once a drain with Restore set has ended, the VMs it displaced are returned to their node.
+func (s *Server) DrainNodes() error {
+	s.drainStepMu.Lock()
+	defer s.drainStepMu.Unlock()
+
+	// The step works on a snapshot, so the API calls do not block the admin API and the other drain users
+	drains := s.drainSnapshot()
+	if len(drains) == 0 {
+		return nil
+	}
+
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return fmt.Errorf("failed to get storage info: %w", err)
+	}
+
+	var targets []proxmox.Node
+	maintenance := make(map[string]bool)
+	for _, resource := range resources {
+		if resource.Type == "node" && resource.HAState == "maintenance" {
+			maintenance[resource.Node] = true
+		}
+
+		if resource.Type != "node" || resource.Status != "online" || resource.HAState == "maintenance" {
+			continue
+		}
+
+		if state, ok := drains[resource.Node]; ok && state.Status != consul.DrainStatusRestoring {
+			continue
+		}
+
+		targets = append(targets, proxmox.Node{
+			Node:   resource.Node,
+			Status: resource.Status,
+			CPU:    resource.CPU,
+			MaxCPU: resource.MaxCPU,
+			Mem:    resource.Mem,
+			MaxMem: resource.MaxMem,
+		})
+	}
+
+	counts := drainCounts{source: make(map[string]int), target: make(map[string]int)}
+	for _, state := range drains {
+		for _, migration := range state.InFlight {
+			counts.source[state.Node]++
+			counts.target[migration.Target]++
+		}
+	}
+
+	rules := s.buildAffinityRules(resources)
+	busy := s.runningGuestTasks()
+
+	for _, node := range sortedDrainNodes(drains) {
+		// Plan mode drops the changed copy, so the stored drain is left as it is
+		before := drains[node]
+		state := cloneDrainState(before)
+
+		s.checkDrainMigrations(state, haResources, haGroups, &counts)
+
+		if state.Status != consul.DrainStatusRestoring {
+			s.startDrainMigrations(state, resources, haResources, haGroups, storages, targets, rules, busy, &counts)
+			s.syncDrainMaintenance(state, maintenance[node])
+		} else if !s.syncDrainMaintenance(state, maintenance[node]) {
+			logging.Infof("Node %s is still in HA maintenance, its displaced VMs return once it left", node)
+		} else {
+			done := s.restoreDisplacedVMs(state, resources, haResources, haGroups, busy)
+			if s.isPlanning() {
+				continue
+			}
+
+			if done {
+				removed, err := s.removeRestoredDrain(before)
+				if err != nil {
+					logging.Errorf("Failed to remove drain state of node %s: %v", node, err)
+					continue
+				}
+
+				if removed {
+					logging.Infof("Drain of node %s by %s is over, all displaced VMs are back", node, state.Origin)
+					s.putEvent(consul.Event{
+						Time:     time.Now().UTC(),
+						Kind:     eventKindDrain,
+						Resource: eventResourceNode,
+						ID:       node,
+						Node:     node,
+						Reason:   state.Origin + " ended, displaced VMs restored",
+						Result:   "restored",
+					})
+					continue
+				}
+			}
+		}
+
+		if s.isPlanning() {
+			continue
+		}
+
+		if err := s.mergeDrainState(before, state); err != nil {
+			logging.Errorf("Failed to store drain state of node %s: %v", node, err)
+		}
+	}
+
+	return nil
+}
+
+// drainSnapshot returns a copy of all drains by node
+func (s *Server) drainSnapshot() map[string]*consul.DrainState {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	drains := make(map[string]*consul.DrainState, len(s.drains))
+	for node, state := range s.drains {
+		drains[node] = cloneDrainState(state)
+	}
+
+	return drains
+}
+
+// currentDrain returns the drain before was copied from, or nil when it was cancelled or started again since.
+// The caller holds drainMu.
+func (s *Server) currentDrain(before *consul.DrainState) *consul.DrainState {
+	current, ok := s.drains[before.Node]
+	if !ok || !current.RequestedAt.Equal(before.RequestedAt) {
+		return nil
+	}
+
+	return current
+}
+
+// mergeDrainState stores the state a DrainNodes step derived from before. The result of a drain that was
+// cancelled or started again during the step is dropped, and a status or HA maintenance set during the step,
+// like at the end of a maintenance window, wins over the one of the step.
+func (s *Server) mergeDrainState(before, after *consul.DrainState) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	current := s.currentDrain(before)
+	if current == nil {
+		logging.Infof("Drain of node %s was cancelled or restarted during the drain step, dropping its result", before.Node)
+		return nil
+	}
+
+	if current.Status != before.Status {
+		after.Status, after.CompletedAt = current.Status, current.CompletedAt
+	}
+	if current.Maintenance != before.Maintenance {
+		after.Maintenance = current.Maintenance
+	}
+
+	return s.putDrainState(after)
+}
+
+// removeRestoredDrain removes a drain whose displaced VMs are back. It returns false when the drain
+// was cancelled, started again or set back to draining during the step.
+func (s *Server) removeRestoredDrain(before *consul.DrainState) (bool, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	current := s.currentDrain(before)
+	if current == nil || current.Status != consul.DrainStatusRestoring {
+		return false, nil
+	}
+
+	if err := s.removeDrain(before.Node); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// syncDrainMaintenance puts a drained node into HA maintenance and takes it out once the drain is restoring.
+// A node still draining stays out of maintenance, HA would evacuate it by itself, ignoring the per-node limits
+// and the critical-first order of the drain. Maintenance windows do both themselves around the window.
+// It reports whether a restoring drain can return its VMs, which it cannot while the node is still in maintenance.
+func (s *Server) syncDrainMaintenance(state *consul.DrainState, inMaintenance bool) bool {
+	if state.Status != consul.DrainStatusRestoring {
+		if state.Status == consul.DrainStatusDrained && !state.Maintenance && state.Window == "" &&
+			s.setNodeMaintenance(state.Node, ActionEnable, "node is drained by "+state.Origin) == nil {
+			state.Maintenance = true
+		}
+		return false
+	}
+
+	if state.Maintenance {
+		// HA leaves maintenance after the next CRM round, the cluster resources of this cycle still show it
+		if s.setNodeMaintenance(state.Node, ActionDisable, state.Origin+" ended") == nil {
+			state.Maintenance = false
+		}
+		return false
+	}
+
+	return !inMaintenance
+}
+
+// setNodeMaintenance enables or disables HA maintenance of node
+func (s *Server) setNodeMaintenance(node string, kind ActionKind, reason string) error {
+	_, err := s.applyAction(Action{
+		Kind:     kind,
+		Resource: ResourceHAMaintenance,
+		ID:       node,
+		Node:     node,
+		Reason:   reason,
+	})
+	if err != nil {
+		logging.Errorf("Failed to %s HA maintenance of node %s: %v", kind, node, err)
+		return err
+	}
+
+	logging.Infof("HA maintenance of node %s: %s (%s)", node, kind, reason)
+	return nil
+}
+
+// checkDrainMigrations checks the tasks of migrations in flight. Finished migrations are journaled,
+// successful ones re-home the guest to the prefer group of its new node, failed ones are retried.
+func (s *Server) checkDrainMigrations(state *consul.DrainState, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup, counts *drainCounts) {
+	if s.isPlanning() {
+		return
+	}
+
+	var pending []consul.DrainMigration
+
+	for _, migration := range state.InFlight {
+		action := drainMigrationAction(state.Node, migration)
+
+		result, err := s.migrationTaskResult(action, migration.UPID, migration.StartedAt)
+		if err != nil {
+			logging.Warnf("Failed to check drain migration task %s of guest %d: %v", migration.UPID, migration.VMID, err)
+			pending = append(pending, migration)
+			continue
+		}
+
+		if result == nil {
+			if timeout := migrationTimeout(migration.Type, migration.Online); time.Since(migration.StartedAt) > timeout {
+				logging.Warnf("Drain migration of guest %d (%s) from %s to %s is still running after %s", migration.VMID, migration.Name, state.Node, migration.Target, timeout)
+			}
+			pending = append(pending, migration)
+			continue
+		}
+
+		counts.source[state.Node]--
+		counts.target[migration.Target]--
+
+		s.recordTaskEvent(action, migration.UPID, result, nil)
+
+		if !result.Success() {
+			metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationFailed).Inc()
+
+			if state.Failures == nil {
+				state.Failures = make(map[int]int)
+			}
+			state.Failures[migration.VMID]++
+
+			logging.Warnf("Drain migration of guest %d (%s) from %s to %s failed (attempt %d/%d): %v",
+				migration.VMID, migration.Name, state.Node, migration.Target, state.Failures[migration.VMID], migrationMaxAttempts, result.Err())
+			continue
+		}
+
+		metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationSucceeded).Inc()
+		state.Migrated++
+		delete(state.Failures, migration.VMID)
+
+		logging.Infof("Drain migration of guest %d (%s) from %s to %s finished", migration.VMID, migration.Name, state.Node, migration.Target)
+
+		rehomed, err := s.rehomeDrainedGuest(migration, state.Node, haResources, haGroups)
+		if err != nil {
+			logging.Errorf("Failed to re-home guest %d (%s) after its drain migration: %v", migration.VMID, migration.Name, err)
+		}
+
+		// Maintenance windows and rolling reboots return the VMs they moved out of the node's prefer group once they end
+		if rehomed && state.Restore && migration.Type == vmResourceType {
+			state.Displaced = append(state.Displaced, migration)
+		}
+	}
+
+	state.InFlight = pending
+}
+
+// startDrainMigrations starts migrations for the guests left on a drained node, critical and running guests first,
+// and marks the node drained once no guest is left
+func (s *Server) startDrainMigrations(state *consul.DrainState, resources []proxmox.ClusterResource, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup, storages []proxmox.Storage, targets []proxmox.Node, rules *affinityRules, busy map[int]proxmox.Task, counts *drainCounts) {
+	var guests []proxmox.ClusterResource
+	for _, resource := range resources {
+		if isGuestResource(resource) && resource.Node == state.Node {
+			guests = append(guests, resource)
+		}
+	}
+
+	sort.SliceStable(guests, func(i, j int) bool {
+		a, b := guests[i], guests[j]
+		if critical := s.hasVMCriticalTag(a.Tags); critical != s.hasVMCriticalTag(b.Tags) {
+			return critical
+		}
+		if running := a.Status == vmStatusRunning; running != (b.Status == vmStatusRunning) {
+			return running
+		}
+		return a.VMID < b.VMID
+	})
+
+	inFlight := make(map[int]bool, len(state.InFlight))
+	for _, migration := range state.InFlight {
+		inFlight[migration.VMID] = true
+	}
+
+	groups := make(map[string]proxmox.ClusterHAGroup, len(haGroups))
+	for _, group := range haGroups {
+		groups[group.Group] = group
+	}
+
+	state.Blockers = nil
+
+	for _, guest := range guests {
+		if inFlight[guest.VMID] {
+			continue
+		}
+
+		if reason := s.drainBlocker(guest, state, busy); reason != "" {
+			state.Blockers = append(state.Blockers, consul.DrainBlocker{VMID: guest.VMID, Name: guest.Name, Reason: reason})
+			continue
+		}
+
+		candidates, blocker := drainTargets(targets, s.findHAResource(haResourceSID(guest.Type, guest.VMID), haResources), groups, counts, s.currentConfig().Drain.MaxPerTarget)
+		if blocker != "" {
+			state.Blockers = append(state.Blockers, consul.DrainBlocker{VMID: guest.VMID, Name: guest.Name, Reason: blocker})
+			continue
+		}
+
+		if counts.source[state.Node] >= s.currentConfig().Drain.MaxPerSource {
+			// The guest is started in a later cycle
+			continue
+		}
+
+		if len(candidates) == 0 {
+			logging.Debugf("No drain target for guest %d (%s) below the migration limits, waiting", guest.VMID, guest.Name)
+			continue
+		}
+
+		target, err := s.selectDrainTarget(guest, candidates, storages, rules)
+		if err != nil {
+			state.Blockers = append(state.Blockers, consul.DrainBlocker{VMID: guest.VMID, Name: guest.Name, Reason: err.Error()})
+			continue
+		}
+		reserveDrainTarget(targets, candidates, target)
+
+		migration := consul.DrainMigration{
+			VMID:      guest.VMID,
+			Type:      guest.Type,
+			Name:      guest.Name,
+			Target:    target,
+			Online:    guest.Type == vmResourceType && guest.Status == vmStatusRunning,
+			StartedAt: time.Now().UTC(),
+		}
+
+		logging.Infof("Drain: migrating %s %d (%s) from %s to %s", guest.Type, guest.VMID, guest.Name, state.Node, target)
+
+		upid, err := s.applyAction(drainMigrationAction(state.Node, migration))
+		if err != nil {
+			logging.Errorf("Failed to start drain migration of guest %d (%s) from %s to %s: %v", guest.VMID, guest.Name, state.Node, target, err)
+			state.Blockers = append(state.Blockers, consul.DrainBlocker{VMID: guest.VMID, Name: guest.Name, Reason: err.Error()})
+			continue
+		}
+
+		if !s.isPlanning() {
+			metrics.Migrations.WithLabelValues(string(drainMigrationAction(state.Node, migration).Resource), metrics.MigrationStarted).Inc()
+		}
+
+		migration.UPID = upid
+		state.InFlight = append(state.InFlight, migration)
+		counts.source[state.Node]++
+		counts.target[target]++
+
+		s.rateLimitSleep()
+	}
+
+	remaining := len(guests)
+	switch {
+	case remaining == 0 && len(state.InFlight) == 0 && state.Status != consul.DrainStatusDrained:
+		completedAt := time.Now().UTC()
+		state.Status = consul.DrainStatusDrained
+		state.CompletedAt = &completedAt
+
+		logging.Infof("Node %s is drained: no guests are left, it is safe to reboot", state.Node)
+		if !s.isPlanning() {
+			s.putEvent(consul.Event{
+				Time:     completedAt,
+				Kind:     eventKindDrain,
+				Resource: eventResourceNode,
+				ID:       state.Node,
+				Node:     state.Node,
+				Reason:   "no guests left, safe to reboot",
+				Result:   consul.DrainStatusDrained,
+			})
+		}
+	case remaining > 0 && state.Status == consul.DrainStatusDrained:
+		// A guest came back, for example through an HA recovery
+		logging.Warnf("Node %s was drained but runs %d guests again, draining it again", state.Node, remaining)
+		state.Status = consul.DrainStatusDraining
+		state.CompletedAt = nil
+	case remaining > 0:
+		logging.Infof("Draining node %s: %d guests left, %d migrations in flight, %d blocked", state.Node, remaining, len(state.InFlight), len(state.Blockers))
+	}
+}
+
+// drainBlocker returns why a guest cannot be migrated off a drained node now, or an empty string
+func (s *Server) drainBlocker(guest proxmox.ClusterResource, state *consul.DrainState, busy map[int]proxmox.Task) string {
+	if s.hasVMSkipTag(guest.Tags) {
+		return "tagged " + crsSkipTag + ", move it manually"
+	}
+
+	if task, ok := busy[guest.VMID]; ok {
+		return fmt.Sprintf("task %s is running", task.Type)
+	}
+
+	if failures := state.Failures[guest.VMID]; failures >= migrationMaxAttempts {
+		return fmt.Sprintf("migration failed %d times", failures)
+	}
+
+	return ""
+}
+
+// drainTargets returns the nodes a guest may be migrated to now: nodes below the target limit
+// that the restricted HA group of the guest allows. A restricted group without another node blocks the guest.
+func drainTargets(targets []proxmox.Node, haResource *proxmox.ClusterHAResource, groups map[string]proxmox.ClusterHAGroup, counts *drainCounts, maxPerTarget int) ([]proxmox.Node, string) {
+	var allowed map[string]int
+	if haResource != nil {
+		if group, ok := groups[haResource.Group]; ok && group.Restricted == 1 {
+			allowed = parseHAGroupNodes(group.Nodes)
+		}
+	}
+
+	var candidates []proxmox.Node
+	permitted := 0
+	for _, node := range targets {
+		if allowed != nil && allowed[node.Node] <= 0 {
+			continue
+		}
+		permitted++
+
+		if counts.target[node.Node] >= maxPerTarget {
+			continue
+		}
+
+		candidates = append(candidates, node)
+	}
+
+	if permitted == 0 && allowed != nil {
+		return nil, fmt.Sprintf("HA group %s allows no other node, move it manually", haResource.Group)
+	}
+
+	return candidates, ""
+}
+
+// reserveDrainTarget copies the memory reserved on the chosen candidate back to targets,
+// so the next guests of the cycle see the reduced capacity
+func reserveDrainTarget(targets, candidates []proxmox.Node, target string) {
+	for _, candidate := range candidates {
+		if candidate.Node != target {
+			continue
+		}
+
+		for i := range targets {
+			if targets[i].Node == target {
+				targets[i].Mem = candidate.Mem
+			}
+		}
+	}
+}
+
+// selectDrainTarget picks the node for a guest, restricted HA groups without another node are reported
+func (s *Server) selectDrainTarget(guest proxmox.ClusterResource, candidates []proxmox.Node, storages []proxmox.Storage, rules *affinityRules) (string, error) {
+	if guest.Type == ctResourceType {
+		return s.selectContainerMigrationTarget(proxmox.Container{VMID: guest.VMID, Name: guest.Name, Node: guest.Node, Template: guest.Template}, candidates, storages, rules)
+	}
+
+	return s.selectMigrationTarget(proxmox.VM{VMID: guest.VMID, Name: guest.Name, Node: guest.Node, Template: guest.Template}, candidates, storages, rules)
+}
+
+// drainMigrationAction returns the action of a drain migration. Running VMs are live-migrated,
+// running containers are restarted on the target, stopped guests are migrated offline.
+func drainMigrationAction(source string, migration consul.DrainMigration) Action {
+	action := Action{
+		Kind:   ActionMigrate,
+		ID:     strconv.Itoa(migration.VMID),
+		Node:   source,
+		VMID:   migration.VMID,
+		Before: source,
+		After:  migration.Target,
+		Reason: "node is drained",
+	}
+
+	if migration.Type == ctResourceType {
+		action.Resource = ResourceContainer
+		action.Migration = &proxmox.MigrationOptions{Target: migration.Target, Restart: true}
+		return action
+	}
+
+	action.Resource = ResourceVM
+	action.Migration = &proxmox.MigrationOptions{
+		Target:    migration.Target,
+		Online:    migration.Online,
+		WithDisks: true,
+	}
+
+	return action
+}
+
+// rehomeDrainedGuest moves a migrated guest from the prefer group of the drained node to the prefer group of its new node.
+// It reports whether the guest was moved.
+func (s *Server) rehomeDrainedGuest(migration consul.DrainMigration, source string, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup) (bool, error) {
+	return s.rehomeGuest(migration.Type, migration.VMID, source, migration.Target, "guest re-homed after draining "+source, haResources, haGroups)
+}
+
+// rehomeGuest moves a migrated guest from the prefer group of source to the prefer group of target in the same storage zone.
+// Guests in another group, or without such a group on target, are left alone. It reports whether the guest was moved.
+func (s *Server) rehomeGuest(guestType string, vmid int, source, target, reason string, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup) (bool, error) {
+	sid := haResourceSID(guestType, vmid)
+
+	haResource := s.findHAResource(sid, haResources)
+	if haResource == nil || haResource.Group != preferGroupOnNode(haResource.Group, source) {
+		return false, nil
+	}
+
+	targetGroup := preferGroupOnNode(haResource.Group, target)
+	if !slices.ContainsFunc(haGroups, func(g proxmox.ClusterHAGroup) bool { return g.Group == targetGroup }) {
+		return false, nil
+	}
+
+	if _, err := s.applyAction(rehomeAction(sid, vmid, target, haResource.Group, targetGroup, reason)); err != nil {
+		return false, fmt.Errorf("failed to move HA resource %s to group %s: %w", sid, targetGroup, err)
+	}
+
+	return true, nil
+}
+
+// rehomeAction returns the action that moves the HA resource of a guest on node from group before to group after
+func rehomeAction(sid string, vmid int, node, before, after, reason string) Action {
+	return Action{
+		Kind:     ActionUpdate,
+		Resource: ResourceHAResource,
+		ID:       sid,
+		Node:     node,
+		VMID:     vmid,
+		Before:   before,
+		After:    after,
+		Reason:   reason,
+		HAResource: &proxmox.ClusterHAResource{
+			SID:   sid,
+			Group: after,
+		},
+	}
+}
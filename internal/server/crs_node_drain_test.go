@@ -0,0 +1,341 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+// drainTestCluster serves three nodes with guests on pve1: two running VMs (100 is critical), a stopped VM,
+// a skipped VM, a pinned VM and a running container. Finished tasks are set in done, moved guests in moved.
+type drainTestCluster struct {
+	mu       sync.Mutex
+	recorder requestRecorder
+	started  int
+	finish   bool              // New migrations finish at once
+	ha       bool              // Migrations queue an hamigrate task, HA reports moved guests on their new node
+	done     map[string]string // Exit status by UPID, tasks not listed are running
+	moved    map[int]string    // Current node by VMID of migrated guests
+}
+
+func (c *drainTestCluster) node(vmid int) string {
+	if node, ok := c.moved[vmid]; ok {
+		return node
+	}
+	return "pve1"
+}
+
+func (c *drainTestCluster) serve(w http.ResponseWriter, r *http.Request) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api2/json/cluster/resources":
+		fmt.Fprintf(w, `{"data": [
+			{"id": "node/pve1", "type": "node", "node": "pve1", "status": "online", "maxcpu": 8, "maxmem": 68719476736, "mem": 8589934592},
+			{"id": "node/pve2", "type": "node", "node": "pve2", "status": "online", "maxcpu": 8, "maxmem": 68719476736, "mem": 8589934592},
+			{"id": "node/pve3", "type": "node", "node": "pve3", "status": "online", "maxcpu": 8, "maxmem": 68719476736, "mem": 8589934592},
+			{"id": "qemu/100", "type": "qemu", "vmid": 100, "name": "db", "node": %q, "status": "running", "tags": "crs-critical"},
+			{"id": "qemu/101", "type": "qemu", "vmid": 101, "name": "web", "node": %q, "status": "running"},
+			{"id": "qemu/102", "type": "qemu", "vmid": 102, "name": "batch", "node": %q, "status": "stopped"},
+			{"id": "qemu/103", "type": "qemu", "vmid": 103, "name": "manual", "node": "pve1", "status": "running", "tags": "crs-skip"},
+			{"id": "qemu/104", "type": "qemu", "vmid": 104, "name": "pinned", "node": "pve1", "status": "running"},
+			{"id": "lxc/105", "type": "lxc", "vmid": 105, "name": "dns", "node": %q, "status": "running"}
+		]}`, c.node(100), c.node(101), c.node(102), c.node(105))
+	case r.URL.Path == "/api2/json/nodes":
+		w.Write([]byte(`{"data": [{"node": "pve1", "status": "online"}, {"node": "pve2", "status": "online"}, {"node": "pve3", "status": "online"}]}`))
+	case r.URL.Path == "/api2/json/cluster/ha/resources":
+		w.Write([]byte(`{"data": [
+			{"sid": "vm:100", "group": "crs-vm-prefer-pve1", "state": "started"},
+			{"sid": "vm:101", "group": "crs-vm-prefer-pve2", "state": "started"},
+			{"sid": "vm:104", "group": "crs-vm-pin-pve1", "state": "started"}
+		]}`))
+	case r.URL.Path == "/api2/json/cluster/ha/groups":
+		w.Write([]byte(`{"data": [
+			{"group": "crs-vm-pin-pve1", "nodes": "pve1:1000", "restricted": 1, "nofailback": 1},
+			{"group": "crs-vm-prefer-pve1", "nodes": "pve1:1000,pve2:995,pve3:990", "restricted": 1, "nofailback": 1},
+			{"group": "crs-vm-prefer-pve2", "nodes": "pve1:990,pve2:1000,pve3:995", "restricted": 1, "nofailback": 1},
+			{"group": "crs-vm-prefer-pve3", "nodes": "pve1:995,pve2:990,pve3:1000", "restricted": 1, "nofailback": 1}
+		]}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/migrate"):
+		c.started++
+		upid := fmt.Sprintf("UPID:pve1:0000%d:migrate::root@pam:", c.started)
+		if c.ha {
+			upid = fmt.Sprintf("UPID:pve1:0000%d:00000000:6A000000:hamigrate:%d:root@pam:", c.started, c.started)
+		}
+		if c.finish {
+			c.done[upid] = "OK"
+		}
+		fmt.Fprintf(w, `{"data": %q}`, upid)
+	case strings.Contains(r.URL.Path, "/tasks/"):
+		_, upid, _ := strings.Cut(strings.TrimSuffix(r.URL.Path, "/status"), "/tasks/")
+		taskType := "qmigrate"
+		if c.ha {
+			taskType = "hamigrate"
+		}
+
+		status, ok := c.done[upid]
+		if !ok {
+			fmt.Fprintf(w, `{"data": {"type": %q, "status": "running"}}`, taskType)
+			return true
+		}
+		fmt.Fprintf(w, `{"data": {"type": %q, "status": "stopped", "exitstatus": %q}}`, taskType, status)
+	case c.ha && r.URL.Path == "/api2/json/cluster/ha/status/current":
+		var services []string
+		for _, sid := range []string{"vm:100", "vm:101", "vm:102", "ct:105"} {
+			vmid, _ := strconv.Atoi(sid[3:])
+			services = append(services, fmt.Sprintf(`{"id": "service:%s", "type": "service", "node": %q, "state": "started"}`, sid, c.node(vmid)))
+		}
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(services, ","))
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/config"):
+		w.Write([]byte(`{"data": {"cores": 2, "memory": "2048"}}`))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// config serves the cluster through the mock API, its recorder collects the requests
+func (c *drainTestCluster) config() testHandlerConfig {
+	return testHandlerConfig{cluster: c.serve, recorder: &c.recorder}
+}
+
+func TestDrainNodes(t *testing.T) {
+	cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string)}
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve1": {Node: "pve1", Status: consul.DrainStatusDraining},
+	}
+
+	require.NoError(t, testServer.DrainNodes())
+
+	state := testServer.drainStates()[0]
+	require.Len(t, state.InFlight, 2, "max_per_source limits the migrations of the node")
+	assert.Equal(t, 100, state.InFlight[0].VMID, "critical guests are drained first")
+	assert.True(t, state.InFlight[0].Online)
+	assert.Equal(t, 101, state.InFlight[1].VMID)
+	assert.NotEqual(t, state.InFlight[0].Target, state.InFlight[1].Target, "max_per_target spreads the migrations")
+	assert.Equal(t, []consul.DrainBlocker{
+		{VMID: 103, Name: "manual", Reason: "tagged crs-skip, move it manually"},
+		{VMID: 104, Name: "pinned", Reason: "HA group crs-vm-pin-pve1 allows no other node, move it manually"},
+	}, state.Blockers)
+	assert.Equal(t, consul.DrainStatusDraining, state.Status)
+
+	// The first migration finishes, the second one fails
+	cluster.mu.Lock()
+	cluster.done[state.InFlight[0].UPID] = "OK"
+	cluster.done[state.InFlight[1].UPID] = "migration aborted"
+	cluster.moved[100] = state.InFlight[0].Target
+	cluster.mu.Unlock()
+
+	require.NoError(t, testServer.DrainNodes())
+
+	state = testServer.drainStates()[0]
+	assert.Equal(t, 1, state.Migrated)
+	assert.Equal(t, map[int]int{101: 1}, state.Failures)
+	require.Len(t, state.InFlight, 2)
+	assert.Equal(t, 101, state.InFlight[0].VMID, "failed migrations are retried")
+	assert.Equal(t, 105, state.InFlight[1].VMID, "running guests are drained before stopped ones")
+	assert.Equal(t, "lxc", state.InFlight[1].Type)
+	assert.Contains(t, cluster.recorder.mutations(), "PUT /api2/json/cluster/ha/resources/vm:100", "the migrated VM is re-homed")
+
+	// Everything but the blockers left the node
+	cluster.mu.Lock()
+	for _, migration := range state.InFlight {
+		cluster.done[migration.UPID] = "OK"
+		cluster.moved[migration.VMID] = migration.Target
+	}
+	cluster.mu.Unlock()
+
+	require.NoError(t, testServer.DrainNodes())
+
+	state = testServer.drainStates()[0]
+	require.Len(t, state.InFlight, 1)
+	assert.Equal(t, 102, state.InFlight[0].VMID)
+	assert.False(t, state.InFlight[0].Online, "stopped VMs are migrated offline")
+	assert.Equal(t, 3, state.Migrated)
+	assert.Equal(t, consul.DrainStatusDraining, state.Status, "blocked guests keep the node draining")
+}
+
+func TestDrainNodesHAMigration(t *testing.T) {
+	cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string), finish: true, ha: true}
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve1": {Node: "pve1", Status: consul.DrainStatusDraining},
+	}
+
+	require.NoError(t, testServer.DrainNodes())
+	require.NoError(t, testServer.DrainNodes())
+
+	state := testServer.drainStates()[0]
+	require.Len(t, state.InFlight, 2, "the hamigrate tasks finished but HA did not move the guests yet")
+	assert.Zero(t, state.Migrated)
+	assert.NotContains(t, cluster.recorder.mutations(), "PUT /api2/json/cluster/ha/resources/vm:100")
+
+	cluster.mu.Lock()
+	cluster.moved[100] = state.InFlight[0].Target
+	cluster.mu.Unlock()
+
+	require.NoError(t, testServer.DrainNodes())
+
+	state = testServer.drainStates()[0]
+	assert.Equal(t, 1, state.Migrated)
+	assert.Equal(t, 101, state.InFlight[0].VMID)
+	assert.Contains(t, cluster.recorder.mutations(), "PUT /api2/json/cluster/ha/resources/vm:100", "the guest is re-homed once HA moved it")
+}
+
+func TestDrainNodesMaintenance(t *testing.T) {
+	const enable = "POST /api2/json/cluster/ha/crm-command/node-maintenance/enable"
+
+	cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string)}
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve1": {Node: "pve1", Status: consul.DrainStatusDraining, Origin: "admin API"},
+	}
+
+	require.NoError(t, testServer.DrainNodes())
+	require.NoError(t, testServer.DrainNodes())
+
+	assert.NotContains(t, cluster.recorder.mutations(), enable, "HA would evacuate the queued guests by itself")
+	assert.False(t, testServer.drainStates()[0].Maintenance)
+
+	t.Run("drained node goes into maintenance", func(t *testing.T) {
+		cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string)}
+		config := cluster.config()
+		config.cluster = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/api2/json/cluster/resources" {
+				w.Write([]byte(`{"data": [
+					{"id": "node/pve1", "type": "node", "node": "pve1", "status": "online"},
+					{"id": "node/pve2", "type": "node", "node": "pve2", "status": "online"}
+				]}`))
+				return true
+			}
+			return cluster.serve(w, r)
+		}
+
+		testServer, mockServer := createTestServerWithConfig(config)
+		defer mockServer.Close()
+		testServer.drains = map[string]*consul.DrainState{
+			"pve1": {Node: "pve1", Status: consul.DrainStatusDraining, Origin: "admin API"},
+		}
+
+		require.NoError(t, testServer.DrainNodes())
+		require.NoError(t, testServer.DrainNodes())
+
+		assert.Equal(t, []string{enable}, cluster.recorder.mutations(), "maintenance is enabled once, in the step the node is drained")
+		assert.True(t, testServer.drainStates()[0].Maintenance)
+
+		found, err := testServer.cancelDrain("pve1")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Contains(t, cluster.recorder.mutations(), "POST /api2/json/cluster/ha/crm-command/node-maintenance/disable")
+	})
+
+	t.Run("restoring drain waits for the node to leave maintenance", func(t *testing.T) {
+		cluster := &drainTestCluster{done: make(map[string]string), moved: map[int]string{101: "pve2"}, finish: true}
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		testServer.drains = map[string]*consul.DrainState{
+			"pve1": {
+				Node: "pve1", Status: consul.DrainStatusRestoring, Restore: true, Maintenance: true,
+				Displaced: []consul.DrainMigration{{VMID: 101, Type: vmResourceType, Name: "web", Target: "pve2"}},
+			},
+		}
+
+		require.NoError(t, testServer.DrainNodes())
+		assert.Equal(t, []string{"POST /api2/json/cluster/ha/crm-command/node-maintenance/disable"}, cluster.recorder.mutations())
+
+		require.NoError(t, testServer.DrainNodes())
+		assert.Contains(t, cluster.recorder.mutations(), "POST /api2/json/nodes/pve2/qemu/101/migrate", "the displaced VM returns in the next cycle")
+	})
+}
+
+func TestDrainNodesDoesNotBlockDrains(t *testing.T) {
+	cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string)}
+	entered, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+
+	config := cluster.config()
+	config.cluster = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/api2/json/cluster/resources" {
+			once.Do(func() {
+				close(entered)
+				<-release
+			})
+		}
+		return cluster.serve(w, r)
+	}
+
+	testServer, mockServer := createTestServerWithConfig(config)
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve1": {Node: "pve1", Status: consul.DrainStatusDraining},
+	}
+
+	stepped := make(chan error)
+	go func() { stepped <- testServer.DrainNodes() }()
+	<-entered
+
+	// The drain is cancelled while the step waits for the Proxmox API
+	found, err := testServer.cancelDrain("pve1")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	close(release)
+	require.NoError(t, <-stepped)
+	assert.Empty(t, testServer.drainStates(), "the result of the cancelled drain is dropped")
+}
+
+func TestDrainNodesCompletes(t *testing.T) {
+	cluster := &drainTestCluster{done: make(map[string]string), moved: make(map[int]string)}
+	config := cluster.config()
+	config.cluster = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/api2/json/cluster/resources" {
+			w.Write([]byte(`{"data": [
+				{"id": "node/pve1", "type": "node", "node": "pve1", "status": "online"},
+				{"id": "node/pve2", "type": "node", "node": "pve2", "status": "online"}
+			]}`))
+			return true
+		}
+		return cluster.serve(w, r)
+	}
+
+	testServer, mockServer := createTestServerWithConfig(config)
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve1": {Node: "pve1", Status: consul.DrainStatusDraining},
+	}
+
+	testServer.plan = &Plan{}
+	require.NoError(t, testServer.DrainNodes())
+	assert.Equal(t, consul.DrainStatusDraining, testServer.drainStates()[0].Status, "plan mode keeps the drain state")
+
+	testServer.plan = nil
+	require.NoError(t, testServer.DrainNodes())
+
+	state := testServer.drainStates()[0]
+	assert.Equal(t, consul.DrainStatusDrained, state.Status)
+	assert.NotNil(t, state.CompletedAt)
+	assert.True(t, testServer.isDrainingNode("pve1"), "a drained node stays excluded until the drain is cancelled")
+	assert.False(t, testServer.isDrainingNode("pve2"))
+}
+
+func TestValidateDrainConfig(t *testing.T) {
+	config := consul.DrainConfig{}
+	require.NoError(t, validateDrainConfig(&config))
+	assert.Equal(t, consul.DrainConfig{MaxPerSource: drainDefaultMaxPerSource, MaxPerTarget: drainDefaultMaxPerTarget}, config)
+
+	assert.Error(t, validateDrainConfig(&consul.DrainConfig{MaxPerSource: 11}))
+	assert.Error(t, validateDrainConfig(&consul.DrainConfig{MaxPerTarget: -1}))
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -9,7 +10,8 @@ import (
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
 
-// HandleNodeMaintenance migrates stopped VMs and templates from maintenance nodes
+// HandleNodeMaintenance starts migrations of stopped VMs, containers and templates off maintenance nodes.
+// CheckMigrations follows their tasks on the next cycles.
 func (s *Server) HandleNodeMaintenance() error {
 	logging.Debug("Checking for nodes in maintenance mode")
 
@@ -35,16 +37,24 @@ func (s *Server) HandleNodeMaintenance() error {
 
 	// Check each node's hastate for maintenance mode
 	for _, nodeResource := range nodeResources {
-		// Convert ClusterResource to Node for compatibility
+		// Convert ClusterResource to Node for compatibility, capacity is needed to select migration targets
 		node := proxmox.Node{
 			Node:   nodeResource.Node,
 			Status: nodeResource.Status,
+			CPU:    nodeResource.CPU,
+			MaxCPU: nodeResource.MaxCPU,
+			Mem:    nodeResource.Mem,
+			MaxMem: nodeResource.MaxMem,
 		}
 
 		// Check both status and hastate for maintenance
 		switch {
+		case nodeResource.Status == "online" && nodeResource.HAState != "maintenance" && s.isDrainingNode(nodeResource.Node):
+			logging.Debugf("Node %s is drained, not used as a migration target", nodeResource.Node)
 		case nodeResource.Status == "online" && nodeResource.HAState != "maintenance":
 			onlineNodes = append(onlineNodes, node)
+		case nodeResource.HAState == "maintenance" && s.isDrainingNode(nodeResource.Node):
+			logging.Debugf("Node %s is in maintenance for its drain, the drain moves its guests", nodeResource.Node)
 		case nodeResource.HAState == "maintenance":
 			maintenanceNodes = append(maintenanceNodes, node)
 			logging.Debugf("Node %s is in maintenance mode (hastate: %s, status: %s)", nodeResource.Node, nodeResource.HAState, nodeResource.Status)
@@ -66,10 +76,14 @@ func (s *Server) HandleNodeMaintenance() error {
 
 	logging.Debugf("Found %d nodes in maintenance mode and %d online nodes available for migration", len(maintenanceNodes), len(onlineNodes))
 
+	// Affinity rules steer evacuated VMs away from nodes that would break them
+	rules := s.buildAffinityRules(resources)
+	busy := s.runningGuestTasks()
+
 	var totalMigrated int
 
 	for _, maintenanceNode := range maintenanceNodes {
-		migrated, err := s.migrateVMsFromMaintenanceNode(maintenanceNode.Node, onlineNodes)
+		migrated, err := s.migrateVMsFromMaintenanceNode(maintenanceNode.Node, onlineNodes, rules, busy)
 		if err != nil {
 			logging.Errorf("Failed to migrate VMs from maintenance node %s: %v", maintenanceNode.Node, err)
 			// Continue with other nodes
@@ -79,7 +93,7 @@ func (s *Server) HandleNodeMaintenance() error {
 	}
 
 	if totalMigrated > 0 {
-		logging.Infof("Successfully migrated %d VMs/templates from maintenance nodes", totalMigrated)
+		logging.Infof("Started migrations of %d VMs/templates from maintenance nodes", totalMigrated)
 	} else {
 		logging.Debug("No VMs/templates needed migration from maintenance nodes")
 	}
@@ -87,8 +101,9 @@ func (s *Server) HandleNodeMaintenance() error {
 	return nil
 }
 
-// migrateVMsFromMaintenanceNode migrates eligible VMs and templates from a maintenance node
-func (s *Server) migrateVMsFromMaintenanceNode(maintenanceNode string, onlineNodes []proxmox.Node) (int, error) {
+// migrateVMsFromMaintenanceNode starts migrations of eligible VMs and templates from a maintenance node and returns their number.
+// Guests with a running task in busy are left alone until the task ends.
+func (s *Server) migrateVMsFromMaintenanceNode(maintenanceNode string, onlineNodes []proxmox.Node, rules *affinityRules, busy map[int]proxmox.Task) (int, error) {
 	logging.Debugf("Checking VMs on maintenance node %s for migration", maintenanceNode)
 
 	// Get VMs on the maintenance node
@@ -103,18 +118,16 @@ func (s *Server) migrateVMsFromMaintenanceNode(maintenanceNode string, onlineNod
 		return 0, fmt.Errorf("failed to get HA resources: %w", err)
 	}
 
-	// Create a map of VM ID to HA resource for quick lookup
-	haResourceMap := make(map[int]*proxmox.ClusterHAResource)
+	// Storage node restrictions decide which nodes can reach a VM's disks
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get storage info: %w", err)
+	}
+
+	// Create a map of HA resource ID to HA resource for quick lookup
+	haResourceMap := make(map[string]*proxmox.ClusterHAResource)
 	for _, resource := range haResources {
-		if resource.Type == haResourceType {
-			// Extract VM ID from resource SID (format: "vm:123")
-			parts := strings.Split(resource.SID, ":")
-			if len(parts) == 2 {
-				if vmid := parseVMID(parts[1]); vmid > 0 {
-					haResourceMap[vmid] = &resource
-				}
-			}
-		}
+		haResourceMap[resource.SID] = &resource
 	}
 
 	var migratedCount int
@@ -126,10 +139,15 @@ func (s *Server) migrateVMsFromMaintenanceNode(maintenanceNode string, onlineNod
 			continue
 		}
 
-		if s.shouldMigrateVMFromMaintenance(vm, haResourceMap[vm.VMID]) {
-			targetNode, err := s.selectMigrationTarget(vm, onlineNodes)
+		if task, ok := busy[vm.VMID]; ok {
+			logging.Infof("Not migrating VM %d (%s) from maintenance node %s while task %s is running", vm.VMID, vm.Name, maintenanceNode, task.Type)
+			continue
+		}
+
+		if s.shouldMigrateVMFromMaintenance(vm, haResourceMap[haResourceSID(vmResourceType, vm.VMID)]) {
+			targetNode, err := s.selectMigrationTarget(vm, onlineNodes, storages, rules)
 			if err != nil {
-				logging.Errorf("Failed to select migration target for VM %d (%s): %v", vm.VMID, vm.Name, err)
+				logging.Warnf("Not migrating VM %d (%s) from maintenance node %s: %v", vm.VMID, vm.Name, maintenanceNode, err)
 				continue
 			}
 
@@ -138,14 +156,55 @@ func (s *Server) migrateVMsFromMaintenanceNode(maintenanceNode string, onlineNod
 				continue
 			}
 
+			rules.place(vm.VMID, targetNode)
 			migratedCount++
-			logging.Infof("Successfully migrated VM %d (%s) from maintenance node %s to %s", vm.VMID, vm.Name, maintenanceNode, targetNode)
+			logging.Infof("Started migration of VM %d (%s) from maintenance node %s to %s", vm.VMID, vm.Name, maintenanceNode, targetNode)
 
 			// Sleep to avoid overwhelming the Proxmox API
 			s.rateLimitSleep()
 		}
 	}
 
+	containers, err := s.proxmox.GetNodeContainers(maintenanceNode)
+	if err != nil {
+		return migratedCount, fmt.Errorf("failed to get containers for maintenance node %s: %w", maintenanceNode, err)
+	}
+
+	for _, ct := range containers {
+		ct.Node = maintenanceNode
+
+		if s.hasVMSkipTag(ct.Tags) {
+			logging.Debugf("Skipping container %d (%s) with crs-skip tag on maintenance node %s", ct.VMID, ct.Name, maintenanceNode)
+			continue
+		}
+
+		if task, ok := busy[ct.VMID]; ok {
+			logging.Infof("Not migrating container %d (%s) from maintenance node %s while task %s is running", ct.VMID, ct.Name, maintenanceNode, task.Type)
+			continue
+		}
+
+		if !s.shouldMigrateContainerFromMaintenance(ct, haResourceMap[haResourceSID(ctResourceType, ct.VMID)]) {
+			continue
+		}
+
+		targetNode, err := s.selectContainerMigrationTarget(ct, onlineNodes, storages, rules)
+		if err != nil {
+			logging.Warnf("Not migrating container %d (%s) from maintenance node %s: %v", ct.VMID, ct.Name, maintenanceNode, err)
+			continue
+		}
+
+		if err := s.migrateContainer(maintenanceNode, ct, targetNode); err != nil {
+			logging.Errorf("Failed to migrate container %d (%s) from %s to %s: %v", ct.VMID, ct.Name, maintenanceNode, targetNode, err)
+			continue
+		}
+
+		rules.place(ct.VMID, targetNode)
+		migratedCount++
+		logging.Infof("Started migration of container %d (%s) from maintenance node %s to %s", ct.VMID, ct.Name, maintenanceNode, targetNode)
+
+		s.rateLimitSleep()
+	}
+
 	return migratedCount, nil
 }
 
@@ -180,6 +239,39 @@ func (s *Server) shouldMigrateVMFromMaintenance(vm proxmox.VM, haResource *proxm
 	return false
 }
 
+// shouldMigrateContainerFromMaintenance determines if a container should be migrated from maintenance node.
+// Containers follow the VM rules: templates on shared storage and stopped prefer-group containers move,
+// running containers are relocated by HA.
+func (s *Server) shouldMigrateContainerFromMaintenance(ct proxmox.Container, haResource *proxmox.ClusterHAResource) bool {
+	if ct.Template == vmTemplateFlag {
+		return s.isContainerOnSharedStorage(ct)
+	}
+
+	if ct.Status == vmStatusRunning {
+		logging.Debugf("Container %d (%s) is running, letting HA handle migration", ct.VMID, ct.Name)
+		return false
+	}
+
+	if haResource != nil && strings.HasPrefix(haResource.Group, crsPreferGroupPrefix) {
+		logging.Debugf("Container %d (%s) is stopped and in prefer group (%s), should migrate", ct.VMID, ct.Name, haResource.Group)
+		return true
+	}
+
+	logging.Debugf("Container %d (%s) is pinned or not in a CRS group, not migrating", ct.VMID, ct.Name)
+	return false
+}
+
+// isContainerOnSharedStorage checks if a container (template) has rootfs and all mount points on shared storage
+func (s *Server) isContainerOnSharedStorage(ct proxmox.Container) bool {
+	group, err := s.determineContainerHAGroup(ct.Node, ct.VMID)
+	if err != nil {
+		logging.Errorf("Failed to analyze container %d storage: %v", ct.VMID, err)
+		return false
+	}
+
+	return strings.HasPrefix(group, crsPreferGroupPrefix)
+}
+
 // isVMOnSharedStorage checks if a VM (template) is stored on shared storage
 func (s *Server) isVMOnSharedStorage(vm proxmox.VM) bool {
 	// Get VM configuration to check storage
@@ -199,22 +291,229 @@ func (s *Server) isVMOnSharedStorage(vm proxmox.VM) bool {
 	return allShared
 }
 
-// selectMigrationTarget selects the best target node for migration
-func (s *Server) selectMigrationTarget(vm proxmox.VM, onlineNodes []proxmox.Node) (string, error) {
+// vmRequirements describes what a node must provide to run a VM
+type vmRequirements struct {
+	Cores    int
+	Memory   int64 // Bytes
+	Storages []string
+}
+
+// getVMRequirements reads the configured cores, memory and storages of a VM
+func (s *Server) getVMRequirements(node string, vmid int) (*vmRequirements, error) {
+	config, err := s.proxmox.GetVMConfig(node, vmid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM %d config: %w", vmid, err)
+	}
+
+	requirements := &vmRequirements{
+		Cores:  parseVMConfigInt(config.Cores, 1) * parseVMConfigInt(config.Sockets, 1),
+		Memory: int64(parseVMConfigInt(config.Memory, vmDefaultMemoryMiB)) * 1024 * 1024,
+	}
+
+	seen := make(map[string]bool)
+	for diskKey, diskValue := range config.Disks {
+		if !s.isDiskEntry(diskKey) {
+			continue
+		}
+
+		storage := s.extractStorageFromDiskConfig(diskValue)
+		if storage == "" || seen[storage] {
+			continue
+		}
+
+		seen[storage] = true
+		requirements.Storages = append(requirements.Storages, storage)
+	}
+	sort.Strings(requirements.Storages)
+
+	return requirements, nil
+}
+
+// getContainerRequirements reads the configured cores, memory and storages of a container
+func (s *Server) getContainerRequirements(node string, vmid int) (*vmRequirements, error) {
+	config, err := s.proxmox.GetContainerConfig(node, vmid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container %d config: %w", vmid, err)
+	}
+
+	requirements := &vmRequirements{
+		Cores:  parseVMConfigInt(config.Cores, 1),
+		Memory: int64(parseVMConfigInt(config.Memory, vmDefaultMemoryMiB)) * 1024 * 1024,
+	}
+
+	seen := make(map[string]bool)
+	for _, value := range config.Disks {
+		if isContainerBindMount(value) {
+			continue
+		}
+
+		storage := s.extractStorageFromDiskConfig(value)
+		if storage == "" || seen[storage] {
+			continue
+		}
+
+		seen[storage] = true
+		requirements.Storages = append(requirements.Storages, storage)
+	}
+	sort.Strings(requirements.Storages)
+
+	return requirements, nil
+}
+
+// parseVMConfigInt converts a numeric VM config value, which the API returns as number or string, to int
+func parseVMConfigInt(value interface{}, fallback int) int {
+	var result int
+
+	switch v := value.(type) {
+	case float64:
+		result = int(v)
+	case int:
+		result = v
+	case string:
+		// Memory may be given as "current=<size>" with further options
+		v = strings.TrimPrefix(strings.Split(v, ",")[0], "current=")
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return fallback
+		}
+		result = parsed
+	}
+
+	if result <= 0 {
+		return fallback
+	}
+
+	return result
+}
+
+// selectMigrationTarget selects the online node with the most free memory and CPU, scaled by its weight, that can run the VM.
+// The chosen node's memory is reserved in onlineNodes so later VMs of the same cycle see the reduced capacity.
+// Nodes that break the VM's affinity rules are only used when no other node fits, rules may be nil.
+func (s *Server) selectMigrationTarget(vm proxmox.VM, onlineNodes []proxmox.Node, storages []proxmox.Storage, rules *affinityRules) (string, error) {
 	if len(onlineNodes) == 0 {
 		return "", fmt.Errorf("no online nodes available")
 	}
 
-	// For now, use round-robin selection based on VM ID
-	// In the future, this could be enhanced with load balancing
-	targetIndex := vm.VMID % len(onlineNodes)
-	targetNode := onlineNodes[targetIndex].Node
+	requirements, err := s.getVMRequirements(vm.Node, vm.VMID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.pickMigrationTarget(vm, requirements, onlineNodes, storages, rules)
+}
+
+// selectContainerMigrationTarget is selectMigrationTarget for containers
+func (s *Server) selectContainerMigrationTarget(ct proxmox.Container, onlineNodes []proxmox.Node, storages []proxmox.Storage, rules *affinityRules) (string, error) {
+	if len(onlineNodes) == 0 {
+		return "", fmt.Errorf("no online nodes available")
+	}
+
+	requirements, err := s.getContainerRequirements(ct.Node, ct.VMID)
+	if err != nil {
+		return "", err
+	}
+
+	guest := proxmox.VM{VMID: ct.VMID, Name: ct.Name, Node: ct.Node, Template: ct.Template}
+	return s.pickMigrationTarget(guest, requirements, onlineNodes, storages, rules)
+}
+
+// pickMigrationTarget scores the online nodes that satisfy the requirements and reserves memory on the chosen one.
+// Excluded nodes are never chosen.
+func (s *Server) pickMigrationTarget(vm proxmox.VM, requirements *vmRequirements, onlineNodes []proxmox.Node, storages []proxmox.Storage, rules *affinityRules) (string, error) {
+	storageMap := make(map[string]proxmox.Storage, len(storages))
+	for _, storage := range storages {
+		storageMap[storage.Storage] = storage
+	}
+
+	// Templates never start, so they do not consume the target's memory
+	template := vm.Template == vmTemplateFlag
+
+	bestIndex := -1
+	bestConflict := ""
+	var bestScore float64
+	var refusals []string
+
+	for i, node := range onlineNodes {
+		reason := migrationTargetRefusal(node, requirements, storageMap, template)
+		if s.isNodeExcluded(node.Node) {
+			reason = "excluded from CRS"
+		}
+
+		if reason != "" {
+			refusals = append(refusals, fmt.Sprintf("%s: %s", node.Node, reason))
+			continue
+		}
+
+		conflict := rules.conflict(vm.VMID, node.Node)
+		// Nodes with a higher weight take a larger share of the guests
+		score := migrationTargetScore(node, requirements) * float64(s.nodeWeight(node.Node))
+
+		// A node without affinity conflict always wins over one with a conflict
+		better := bestIndex == -1 ||
+			(conflict == "" && bestConflict != "") ||
+			((conflict == "") == (bestConflict == "") && score > bestScore)
+		if better {
+			bestIndex = i
+			bestScore = score
+			bestConflict = conflict
+		}
+	}
+
+	if bestIndex == -1 {
+		return "", fmt.Errorf("no node can host VM %d (%d cores, %d MiB memory, storages [%s]): %s",
+			vm.VMID, requirements.Cores, requirements.Memory/1024/1024, strings.Join(requirements.Storages, ","), strings.Join(refusals, "; "))
+	}
+
+	if !template {
+		onlineNodes[bestIndex].Mem += requirements.Memory
+	}
+
+	targetNode := onlineNodes[bestIndex].Node
+	if bestConflict != "" {
+		logging.Warnf("No node satisfies the affinity rules of VM %d (%s), using %s anyway: %s", vm.VMID, vm.Name, targetNode, bestConflict)
+	}
 
-	logging.Debugf("Selected target node %s for VM %d (%s) migration", targetNode, vm.VMID, vm.Name)
+	logging.Debugf("Selected target node %s (score %.3f) for VM %d (%s) migration", targetNode, bestScore, vm.VMID, vm.Name)
 	return targetNode, nil
 }
 
-// migrateVM performs the actual VM migration
+// migrationTargetRefusal returns why a node cannot run the VM, or an empty string if it can.
+// The free memory of the node is not checked for templates.
+func migrationTargetRefusal(node proxmox.Node, requirements *vmRequirements, storageMap map[string]proxmox.Storage, template bool) string {
+	for _, storageName := range requirements.Storages {
+		storage, exists := storageMap[storageName]
+		if !exists {
+			return fmt.Sprintf("storage %s not found", storageName)
+		}
+
+		if !isStorageAvailableOnNode(storage, node.Node) {
+			return fmt.Sprintf("storage %s not available", storageName)
+		}
+	}
+
+	// Nodes without reported capacity are not checked, Proxmox reports it for every online node
+	if node.MaxCPU > 0 && requirements.Cores > node.MaxCPU {
+		return fmt.Sprintf("needs %d cores, node has %d", requirements.Cores, node.MaxCPU)
+	}
+
+	if !template && node.MaxMem > 0 && requirements.Memory > node.MaxMem-node.Mem {
+		return fmt.Sprintf("needs %d MiB memory, %d MiB free", requirements.Memory/1024/1024, (node.MaxMem-node.Mem)/1024/1024)
+	}
+
+	return ""
+}
+
+// migrationTargetScore rates a node by the memory left after placing the VM and its idle CPU, higher is better
+func migrationTargetScore(node proxmox.Node, requirements *vmRequirements) float64 {
+	var memScore float64
+	if node.MaxMem > 0 {
+		memScore = float64(node.MaxMem-node.Mem-requirements.Memory) / float64(node.MaxMem)
+	}
+
+	return drsMemoryWeight*memScore + drsCPUWeight*(1-node.CPU)
+}
+
+// migrateVM starts an offline VM migration
 func (s *Server) migrateVM(sourceNode string, vm proxmox.VM, targetNode string) error {
 	logging.Infof("Migrating VM %d (%s) from %s to %s", vm.VMID, vm.Name, sourceNode, targetNode)
 
@@ -224,13 +523,38 @@ func (s *Server) migrateVM(sourceNode string, vm proxmox.VM, targetNode string)
 		WithDisks: true,  // Move disks if they're on shared storage
 	}
 
-	taskID, err := s.proxmox.MigrateVM(sourceNode, vm.VMID, migrationOptions)
-	if err != nil {
-		return fmt.Errorf("failed to start migration: %w", err)
+	return s.startMigration(Action{
+		Kind:      ActionMigrate,
+		Resource:  ResourceVM,
+		ID:        fmt.Sprintf("%d", vm.VMID),
+		Node:      sourceNode,
+		VMID:      vm.VMID,
+		Before:    sourceNode,
+		After:     targetNode,
+		Reason:    "node is in maintenance mode",
+		Migration: &migrationOptions,
+	}, false)
+}
+
+// migrateContainer starts an offline container migration
+func (s *Server) migrateContainer(sourceNode string, ct proxmox.Container, targetNode string) error {
+	logging.Infof("Migrating container %d (%s) from %s to %s", ct.VMID, ct.Name, sourceNode, targetNode)
+
+	migrationOptions := proxmox.MigrationOptions{
+		Target: targetNode,
 	}
 
-	logging.Debugf("Started migration task %s for VM %d", taskID, vm.VMID)
-	return nil
+	return s.startMigration(Action{
+		Kind:      ActionMigrate,
+		Resource:  ResourceContainer,
+		ID:        fmt.Sprintf("%d", ct.VMID),
+		Node:      sourceNode,
+		VMID:      ct.VMID,
+		Before:    sourceNode,
+		After:     targetNode,
+		Reason:    "node is in maintenance mode",
+		Migration: &migrationOptions,
+	}, false)
 }
 
 // parseVMID safely parses a VM ID string to integer
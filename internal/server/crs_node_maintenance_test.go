@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
 
@@ -187,53 +188,141 @@ func TestShouldMigrateVMFromMaintenance(t *testing.T) {
 }
 
 func TestSelectMigrationTarget(t *testing.T) {
-	testServer, mockServer := createTestServer()
+	const mib = int64(1024 * 1024)
+
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeVMConfig: true})
 	defer mockServer.Close()
 
-	onlineNodes := []proxmox.Node{
-		{Node: "pve1", Status: "online"},
-		{Node: "pve3", Status: "online"},
+	storages := []proxmox.Storage{
+		{Storage: "local", Shared: 0},
+		{Storage: "shared-storage", Shared: 1, Nodes: "pve1,pve3"},
 	}
 
 	tests := []struct {
-		name     string
-		vm       proxmox.VM
-		expected string
+		name        string
+		vm          proxmox.VM
+		nodes       []proxmox.Node
+		expected    string
+		errContains string
 	}{
 		{
-			name: "should select target based on VM ID",
-			vm: proxmox.VM{
-				VMID: 100,
-				Name: "test-vm",
+			name: "should select node with most free memory",
+			vm:   proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"},
+			nodes: []proxmox.Node{
+				{Node: "pve1", MaxCPU: 8, Mem: 6000 * mib, MaxMem: 8192 * mib},
+				{Node: "pve3", MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
 			},
-			expected: "pve1", // 100 % 2 = 0, so index 0 (pve1)
+			expected: "pve3",
 		},
 		{
-			name: "should select different target for different VM ID",
-			vm: proxmox.VM{
-				VMID: 101,
-				Name: "test-vm-2",
+			name: "should prefer idle CPU when memory is equal",
+			vm:   proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"},
+			nodes: []proxmox.Node{
+				{Node: "pve1", CPU: 0.1, MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
+				{Node: "pve3", CPU: 0.9, MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
+			},
+			expected: "pve1",
+		},
+		{
+			name: "should skip nodes without enough memory",
+			vm:   proxmox.VM{VMID: 400, Node: "pve2", Name: "hostpci-vm"}, // 4096 MiB
+			nodes: []proxmox.Node{
+				{Node: "pve1", MaxCPU: 8, Mem: 6000 * mib, MaxMem: 8192 * mib},
+				{Node: "pve3", MaxCPU: 8, Mem: 4000 * mib, MaxMem: 8192 * mib},
+			},
+			expected: "pve3",
+		},
+		{
+			name: "should skip nodes without the VM storage",
+			vm:   proxmox.VM{VMID: 200, Node: "pve2", Name: "shared-storage-vm"},
+			nodes: []proxmox.Node{
+				{Node: "pve4", MaxCPU: 8, MaxMem: 8192 * mib},
+				{Node: "pve3", MaxCPU: 8, Mem: 4000 * mib, MaxMem: 8192 * mib},
+			},
+			expected: "pve3",
+		},
+		{
+			name: "should refuse when the VM fits nowhere",
+			vm:   proxmox.VM{VMID: 106, Node: "pve2", Name: "critical-vm"}, // 2 cores, 2048 MiB
+			nodes: []proxmox.Node{
+				{Node: "pve1", MaxCPU: 1, MaxMem: 8192 * mib},
+				{Node: "pve3", MaxCPU: 8, Mem: 7000 * mib, MaxMem: 8192 * mib},
 			},
-			expected: "pve3", // 101 % 2 = 1, so index 1 (pve3)
+			errContains: "no node can host VM 106 (2 cores, 2048 MiB memory, storages []): pve1: needs 2 cores, node has 1; pve3: needs 2048 MiB memory, 1192 MiB free",
+		},
+		{
+			name: "should place templates regardless of free memory",
+			vm:   proxmox.VM{VMID: 106, Node: "pve2", Name: "critical-vm", Template: 1}, // 2 cores, 2048 MiB
+			nodes: []proxmox.Node{
+				{Node: "pve3", MaxCPU: 8, Mem: 7000 * mib, MaxMem: 8192 * mib},
+			},
+			expected: "pve3",
+		},
+		{
+			name:        "should error when no online nodes available",
+			vm:          proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"},
+			nodes:       []proxmox.Node{},
+			errContains: "no online nodes available",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			target, err := testServer.selectMigrationTarget(tt.vm, onlineNodes)
+			target, err := testServer.selectMigrationTarget(tt.vm, tt.nodes, storages, nil)
+			if tt.errContains != "" {
+				assert.ErrorContains(t, err, tt.errContains)
+				return
+			}
+
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expected, target)
 		})
 	}
 
-	t.Run("should error when no online nodes available", func(t *testing.T) {
-		vm := proxmox.VM{VMID: 100, Name: "test-vm"}
-		_, err := testServer.selectMigrationTarget(vm, []proxmox.Node{})
-		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "no online nodes available")
+	t.Run("should reserve memory for following VMs", func(t *testing.T) {
+		nodes := []proxmox.Node{
+			{Node: "pve1", MaxCPU: 8, Mem: 1000 * mib, MaxMem: 8192 * mib},
+			{Node: "pve3", MaxCPU: 8, Mem: 1500 * mib, MaxMem: 8192 * mib},
+		}
+		vm := proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"} // 1024 MiB
+
+		first, err := testServer.selectMigrationTarget(vm, nodes, storages, nil)
+		assert.NoError(t, err)
+		second, err := testServer.selectMigrationTarget(vm, nodes, storages, nil)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "pve1", first)
+		assert.Equal(t, "pve3", second)
 	})
 }
 
+func TestParseVMConfigInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected int
+	}{
+		{"number", float64(4096), 4096},
+		{"string", "2048", 2048},
+		{"current syntax", "current=8192,balloon=0", 8192},
+		{"missing", nil, 7},
+		{"invalid string", "abc", 7},
+		{"zero", float64(0), 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseVMConfigInt(tt.value, 7))
+		})
+	}
+}
+
+func TestIsStorageAvailableOnNode(t *testing.T) {
+	assert.True(t, isStorageAvailableOnNode(proxmox.Storage{Storage: "ceph"}, "pve1"))
+	assert.True(t, isStorageAvailableOnNode(proxmox.Storage{Storage: "nfs", Nodes: "pve1, pve2"}, "pve2"))
+	assert.False(t, isStorageAvailableOnNode(proxmox.Storage{Storage: "nfs", Nodes: "pve1,pve2"}, "pve3"))
+}
+
 func TestParseVMID(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -273,3 +362,34 @@ func TestMigrateVM(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestHandleNodeMaintenanceContainers(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeContainers: true,
+		recorder:          recorder,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.HandleNodeMaintenance())
+
+	assert.Equal(t, []string{"POST /api2/json/nodes/pve2/lxc/601/migrate"}, recorder.mutations())
+}
+
+func TestMigrateVMsFromMaintenanceNodeSkipsBusyGuests(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeContainers: true,
+		recorder:          recorder,
+	})
+	defer mockServer.Close()
+
+	onlineNodes := []proxmox.Node{{Node: "pve1", MaxCPU: 8, MaxMem: 16 * gib}}
+	busy := map[int]proxmox.Task{601: {Type: "vzmigrate", ID: "601"}}
+
+	migrated, err := testServer.migrateVMsFromMaintenanceNode("pve2", onlineNodes, nil, busy)
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+	assert.Empty(t, recorder.mutations())
+}
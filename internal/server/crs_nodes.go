@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// validateNodeClass checks the name and weight of a node capacity class
+func validateNodeClass(name string, class consul.NodeClass) error {
+	if !configNameRe.MatchString(name) {
+		return fmt.Errorf("name %q must consist of lowercase letters, digits and dashes", name)
+	}
+
+	if class.Weight < 1 || class.Weight > nodeMaxWeight {
+		return fmt.Errorf("weight must be between 1 and %d, got %d", nodeMaxWeight, class.Weight)
+	}
+
+	return nil
+}
+
+// validateNodeAttributes checks the attributes of a node against the capacity classes
+func validateNodeAttributes(attributes consul.NodeAttributes, classes map[string]consul.NodeClass) error {
+	if attributes.Weight < 0 || attributes.Weight > nodeMaxWeight {
+		return fmt.Errorf("weight must be between 1 and %d or 0 for the weight of the class, got %d", nodeMaxWeight, attributes.Weight)
+	}
+
+	if _, ok := classes[attributes.Class]; attributes.Class != "" && !ok {
+		return fmt.Errorf("node class %s is not defined", attributes.Class)
+	}
+
+	return nil
+}
+
+// loadNodesConfig loads the node capacity classes and then the node attributes that refer to them.
+// An invalid class or node keeps its previous definition.
+func (s *Server) loadNodesConfig(config *crsConfig) {
+	classes, err := s.consul.GetNodeClasses()
+	if err != nil {
+		logging.Errorf("Failed to load node classes, keeping previous settings: %v", err)
+	} else {
+		loaded := make(map[string]consul.NodeClass, len(classes))
+		for name, class := range classes {
+			if err := validateNodeClass(name, class); err != nil {
+				logging.Errorf("Invalid node class %s, keeping previous settings: %v", name, err)
+				if previous, ok := config.NodeClasses[name]; ok {
+					loaded[name] = previous
+				}
+				continue
+			}
+
+			loaded[name] = class
+		}
+
+		config.NodeClasses = loaded
+	}
+
+	nodes, err := s.consul.GetNodeAttributes()
+	if err != nil {
+		logging.Errorf("Failed to load node attributes, keeping previous settings: %v", err)
+		return
+	}
+
+	loaded := make(map[string]consul.NodeAttributes, len(nodes))
+	for name, attributes := range nodes {
+		if err := validateNodeAttributes(attributes, config.NodeClasses); err != nil {
+			logging.Errorf("Invalid attributes of node %s, keeping previous settings: %v", name, err)
+			if previous, ok := config.Nodes[name]; ok {
+				loaded[name] = previous
+			}
+			continue
+		}
+
+		loaded[name] = attributes
+	}
+
+	config.Nodes = loaded
+}
+
+// nodeWeight returns the relative capacity of a node: its own weight, the weight of its class, or 1
+func (s *Server) nodeWeight(node string) int {
+	config := s.currentConfig()
+	attributes := config.Nodes[node]
+	if attributes.Weight > 0 {
+		return attributes.Weight
+	}
+
+	if class, ok := config.NodeClasses[attributes.Class]; ok {
+		return class.Weight
+	}
+
+	return 1
+}
+
+// isNodeExcluded reports whether CRS must not place guests on a node
+func (s *Server) isNodeExcluded(node string) bool {
+	return s.currentConfig().Nodes[node].Excluded
+}
+
+// placementNodes returns the nodes CRS may place guests on, keeping the given node even when it is excluded
+func (s *Server) placementNodes(nodes []proxmox.Node, keep string) []proxmox.Node {
+	return slices.DeleteFunc(slices.Clone(nodes), func(node proxmox.Node) bool {
+		return node.Node != keep && s.isNodeExcluded(node.Node)
+	})
+}
+
+// weightedNodeRing returns the sorted nodes interleaved by smooth weighted round-robin, every node appears as often as its weight.
+// With equal weights it is the sorted list of nodes.
+func (s *Server) weightedNodeRing(sorted []proxmox.Node) []string {
+	weights := make([]int, len(sorted))
+	total := 0
+	for i, node := range sorted {
+		weights[i] = s.nodeWeight(node.Node)
+		total += weights[i]
+	}
+
+	current := make([]int, len(sorted))
+	ring := make([]string, 0, total)
+	for len(ring) < total {
+		best := 0
+		for i := range sorted {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+
+		current[best] -= total
+		ring = append(ring, sorted[best].Node)
+	}
+
+	return ring
+}
+
+// relativeNodeWeight returns the weight of a node divided by the mean weight of the given nodes
+func (s *Server) relativeNodeWeight(node string, nodes []string) float64 {
+	if len(nodes) == 0 {
+		return 1
+	}
+
+	total := 0
+	for _, name := range nodes {
+		total += s.nodeWeight(name)
+	}
+
+	return float64(s.nodeWeight(node)) * float64(len(nodes)) / float64(total)
+}
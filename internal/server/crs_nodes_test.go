@@ -0,0 +1,87 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// weightedTestServer has pve1 in the large class of weight 4 and pve2 and pve3 without a class
+func weightedTestServer() *Server {
+	config := defaultCRSConfig()
+	config.NodeClasses = map[string]consul.NodeClass{"large": {Weight: 4}}
+	config.Nodes = map[string]consul.NodeAttributes{"pve1": {Class: "large", Rack: "r1"}}
+
+	return &Server{config: config}
+}
+
+func TestValidateNodeClass(t *testing.T) {
+	assert.NoError(t, validateNodeClass("large", consul.NodeClass{Weight: 4}))
+	assert.ErrorContains(t, validateNodeClass("Large", consul.NodeClass{Weight: 4}), "lowercase letters")
+	assert.ErrorContains(t, validateNodeClass("large", consul.NodeClass{}), "weight must be between 1 and 100, got 0")
+	assert.ErrorContains(t, validateNodeClass("large", consul.NodeClass{Weight: 101}), "got 101")
+}
+
+func TestValidateNodeAttributes(t *testing.T) {
+	classes := map[string]consul.NodeClass{"large": {Weight: 4}}
+
+	assert.NoError(t, validateNodeAttributes(consul.NodeAttributes{}, classes))
+	assert.NoError(t, validateNodeAttributes(consul.NodeAttributes{Class: "large", Rack: "r1"}, classes))
+	assert.NoError(t, validateNodeAttributes(consul.NodeAttributes{Weight: 2, Excluded: true}, classes))
+	assert.ErrorContains(t, validateNodeAttributes(consul.NodeAttributes{Class: "small"}, classes), "node class small is not defined")
+	assert.ErrorContains(t, validateNodeAttributes(consul.NodeAttributes{Weight: -1}, classes), "got -1")
+}
+
+func TestNodeWeight(t *testing.T) {
+	testServer := weightedTestServer()
+	testServer.config.Nodes["pve2"] = consul.NodeAttributes{Class: "large", Weight: 2}
+
+	assert.Equal(t, 4, testServer.nodeWeight("pve1"), "weight of the class")
+	assert.Equal(t, 2, testServer.nodeWeight("pve2"), "own weight wins over the class")
+	assert.Equal(t, 1, testServer.nodeWeight("pve3"), "nodes without attributes")
+
+	assert.InDelta(t, 2, testServer.relativeNodeWeight("pve1", []string{"pve1", "pve2", "pve3", "pve4"}), 0.0001)
+	assert.InDelta(t, 0.5, testServer.relativeNodeWeight("pve3", []string{"pve1", "pve2", "pve3", "pve4"}), 0.0001)
+}
+
+func TestWeightedNodeRing(t *testing.T) {
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+
+	assert.Equal(t, []string{"pve1", "pve2", "pve3"}, (&Server{config: defaultCRSConfig()}).weightedNodeRing(nodes))
+	assert.Equal(t, []string{"pve1", "pve1", "pve2", "pve1", "pve3", "pve1"}, weightedTestServer().weightedNodeRing(nodes))
+}
+
+func TestGenerateExpectedPreferNodesWeighted(t *testing.T) {
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+
+	testServer := weightedTestServer()
+	assert.Equal(t, "pve1:995,pve2:1000,pve3:990", testServer.generateExpectedPreferNodes(nodes, "pve2"), "the heavier node follows every other node")
+	assert.Equal(t, "pve1:995,pve2:990,pve3:1000", testServer.generateExpectedPreferNodes(nodes, "pve3"))
+
+	testServer.config.Nodes["pve3"] = consul.NodeAttributes{Excluded: true}
+	assert.Equal(t, "pve1:995,pve2:1000", testServer.generateExpectedPreferNodes(nodes, "pve2"), "excluded nodes are left out")
+	assert.Equal(t, "pve1:995,pve2:990,pve3:1000", testServer.generateExpectedPreferNodes(nodes, "pve3"), "excluded nodes keep their own group")
+}
+
+func TestPickMigrationTargetExcludedNode(t *testing.T) {
+	const mib = int64(1024 * 1024)
+
+	testServer := weightedTestServer()
+	testServer.config.Nodes["pve3"] = consul.NodeAttributes{Excluded: true}
+
+	vm := proxmox.VM{VMID: 100, Node: "pve2", Name: "test-vm"}
+	requirements := &vmRequirements{Cores: 1, Memory: 1024 * mib}
+
+	target, err := testServer.pickMigrationTarget(vm, requirements, []proxmox.Node{
+		{Node: "pve1", MaxCPU: 8, Mem: 6000 * mib, MaxMem: 8192 * mib},
+		{Node: "pve3", MaxCPU: 8, MaxMem: 8192 * mib},
+	}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "pve1", target)
+
+	_, err = testServer.pickMigrationTarget(vm, requirements, []proxmox.Node{{Node: "pve3", MaxCPU: 8, MaxMem: 8192 * mib}}, nil, nil)
+	assert.ErrorContains(t, err, "pve3: excluded from CRS")
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// pciMappingIDs returns the resource mappings used by the hostpci devices of a VM, sorted and without duplicates.
+// It reports false when a device is passed through by its host PCI address, which only exists on one node.
+func pciMappingIDs(hostpci map[string]string) ([]string, bool) {
+	if len(hostpci) == 0 {
+		return nil, false
+	}
+
+	var ids []string
+	for _, value := range hostpci {
+		var id string
+		for _, option := range strings.Split(value, ",") {
+			if mapping, ok := strings.CutPrefix(strings.TrimSpace(option), "mapping="); ok {
+				id = mapping
+			}
+		}
+
+		if id == "" {
+			return nil, false
+		}
+
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+	return ids, true
+}
+
+// pciGroupName returns the HA group of the VMs using the given resource mappings
+func pciGroupName(ids []string) string {
+	return crsPCIGroupPrefix + strings.Join(ids, "-")
+}
+
+// expectedPCIGroup returns the restricted HA group over the nodes of the cluster that provide every one of the mappings.
+// All nodes get the same priority, the VM stays where HA last started it.
+func (s *Server) expectedPCIGroup(ids []string, mappings []proxmox.ClusterPCIMapping, nodes []proxmox.Node) (proxmox.ClusterHAGroup, error) {
+	group := proxmox.ClusterHAGroup{
+		Group:      pciGroupName(ids),
+		Restricted: 1,
+		NoFailback: 1,
+	}
+
+	members := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		members = append(members, node.Node)
+	}
+
+	for _, id := range ids {
+		index := slices.IndexFunc(mappings, func(mapping proxmox.ClusterPCIMapping) bool {
+			return mapping.ID == id
+		})
+		if index < 0 {
+			return group, fmt.Errorf("PCI resource mapping %s does not exist", id)
+		}
+
+		provided := mappings[index].Nodes()
+		members = slices.DeleteFunc(members, func(node string) bool {
+			return !slices.Contains(provided, node)
+		})
+	}
+
+	if len(members) == 0 {
+		return group, fmt.Errorf("no node provides all of the PCI resource mappings %s", strings.Join(ids, ", "))
+	}
+
+	slices.Sort(members)
+	entries := make([]string, 0, len(members))
+	for _, node := range members {
+		entries = append(entries, fmt.Sprintf("%s:%d", node, s.currentConfig().Scheduler.MaxNodePriority))
+	}
+	group.Nodes = strings.Join(entries, ",")
+
+	return group, nil
+}
+
+// pciMappingGroup returns the expected HA group of a VM whose hostpci devices all use resource mappings.
+// It returns nil when the VM has to stay pinned to its node and logs why.
+func (s *Server) pciMappingGroup(nodeName string, vmid int, vmConfig *proxmox.VMConfigRead) (*proxmox.ClusterHAGroup, error) {
+	ids, ok := pciMappingIDs(vmConfig.HostPCI)
+	if !ok {
+		return nil, nil
+	}
+
+	allDisksShared, err := s.areAllVMDisksShared(vmConfig.Disks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze VM storage: %w", err)
+	}
+
+	if !allDisksShared {
+		logging.Debugf("VM %d uses PCI resource mappings but has local storage, keeping it pinned", vmid)
+		return nil, nil
+	}
+
+	mappings, err := s.proxmox.GetClusterPCIMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	group, err := s.expectedPCIGroup(ids, mappings, nodes)
+	if err != nil {
+		logging.Warnf("VM %d stays pinned to node %s: %v", vmid, nodeName, err)
+		return nil, nil
+	}
+
+	if !slices.Contains(strings.Split(group.Nodes, ","), fmt.Sprintf("%s:%d", nodeName, s.currentConfig().Scheduler.MaxNodePriority)) {
+		logging.Warnf("VM %d stays pinned to node %s, which does not provide all of its PCI resource mappings", vmid, nodeName)
+		return nil, nil
+	}
+
+	return &group, nil
+}
+
+// ensurePCIGroup creates or updates the HA group over the nodes providing the PCI resource mappings of a VM
+func (s *Server) ensurePCIGroup(guest haGuest) error {
+	vmConfig, err := s.proxmox.GetVMConfig(guest.Node, guest.VMID)
+	if err != nil {
+		return fmt.Errorf("failed to get VM config: %w", err)
+	}
+
+	expected, err := s.pciMappingGroup(guest.Node, guest.VMID, vmConfig)
+	if err != nil || expected == nil {
+		return err
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	var existing *proxmox.ClusterHAGroup
+	for _, group := range haGroups {
+		if group.Group == expected.Group {
+			existing = &group
+			break
+		}
+	}
+
+	action := Action{
+		Resource: ResourceHAGroup,
+		ID:       expected.Group,
+		VMID:     guest.VMID,
+		After:    expected.Nodes,
+		HAGroup:  expected,
+	}
+
+	switch {
+	case existing == nil:
+		action.Kind = ActionCreate
+		action.Reason = "VM uses PCI resource mappings"
+	case !s.compareNodeConfiguration(existing.Nodes, expected.Nodes) || existing.Restricted != expected.Restricted || existing.NoFailback != expected.NoFailback:
+		action.Kind = ActionUpdate
+		action.Before = existing.Nodes
+		action.Reason = "nodes providing the PCI resource mappings changed"
+	default:
+		return nil
+	}
+
+	logging.Infof("%s HA group %s for the PCI resource mappings of VM %d: nodes=%s", action.Kind, expected.Group, guest.VMID, expected.Nodes)
+
+	if _, err := s.applyAction(action); err != nil {
+		return fmt.Errorf("failed to %s HA group %s: %w", action.Kind, expected.Group, err)
+	}
+
+	s.rateLimitSleep()
+
+	return nil
+}
+
+// pciPlacementGroup returns the HA group of a VM in a pin or PCI group once its hostpci devices were checked again.
+// A pinned VM moves into a PCI group once all its devices use resource mappings, a VM leaves its PCI group when
+// that no longer holds. The current group is kept when the VM config cannot be read.
+func (s *Server) pciPlacementGroup(guest haGuest, current string, nodes []proxmox.Node) (string, error) {
+	group, _, err := s.vmPlacement(guest.Node, guest.VMID)
+	if err != nil {
+		logging.Warnf("Failed to determine HA group of VM %d, keeping %s: %v", guest.VMID, current, err)
+		return current, nil
+	}
+
+	switch {
+	case strings.HasPrefix(group, crsPCIGroupPrefix):
+		if err := s.ensurePCIGroup(guest); err != nil {
+			return "", err
+		}
+		return group, nil
+	case !strings.HasPrefix(current, crsPCIGroupPrefix):
+		// Pinned for its devices or local storage, pin groups are not re-evaluated
+		return current, nil
+	case strings.HasPrefix(group, crsPolicyGroupPrefix):
+		if err := s.ensurePolicyGroup(guest, nodes); err != nil {
+			return "", err
+		}
+		return group, nil
+	default:
+		return s.poolPlacementGroup(guest, group, nodes), nil
+	}
+}
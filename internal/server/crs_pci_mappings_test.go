@@ -0,0 +1,148 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestPCIMappingIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		hostpci map[string]string
+		ids     []string
+		mapped  bool
+	}{
+		{name: "no devices"},
+		{name: "host address", hostpci: map[string]string{"hostpci0": "0000:01:00.0,pcie=1"}},
+		{name: "mapping", hostpci: map[string]string{"hostpci0": "mapping=gpu,pcie=1"}, ids: []string{"gpu"}, mapped: true},
+		{
+			name:    "several mappings",
+			hostpci: map[string]string{"hostpci0": "mapping=nic", "hostpci1": "pcie=1,mapping=gpu", "hostpci2": "mapping=gpu"},
+			ids:     []string{"gpu", "nic"},
+			mapped:  true,
+		},
+		{name: "mapping and host address", hostpci: map[string]string{"hostpci0": "mapping=gpu", "hostpci1": "0000:02:00.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, mapped := pciMappingIDs(tt.hostpci)
+			assert.Equal(t, tt.ids, ids)
+			assert.Equal(t, tt.mapped, mapped)
+		})
+	}
+}
+
+func TestExpectedPCIGroup(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+	mappings := []proxmox.ClusterPCIMapping{
+		{ID: "gpu", Map: []string{"node=pve1,path=0000:01:00.0", "node=pve2,path=0000:01:00.0", "node=pve9,path=0000:01:00.0"}},
+		{ID: "nic", Map: []string{"node=pve2,path=0000:02:00.0", "node=pve3,path=0000:02:00.0"}},
+		{ID: "fpga", Map: []string{"node=pve3,path=0000:03:00.0"}},
+	}
+
+	group, err := testServer.expectedPCIGroup([]string{"gpu"}, mappings, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, "crs-pci-gpu", group.Group)
+	assert.Equal(t, "pve1:1000,pve2:1000", group.Nodes, "nodes outside the cluster are left out")
+	assert.Equal(t, 1, group.Restricted)
+	assert.Equal(t, 1, group.NoFailback)
+
+	group, err = testServer.expectedPCIGroup([]string{"gpu", "nic"}, mappings, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, "crs-pci-gpu-nic", group.Group)
+	assert.Equal(t, "pve2:1000", group.Nodes, "only nodes providing every mapping")
+
+	_, err = testServer.expectedPCIGroup([]string{"gpu", "fpga"}, mappings, nodes)
+	assert.ErrorContains(t, err, "no node provides all of the PCI resource mappings gpu, fpga")
+
+	_, err = testServer.expectedPCIGroup([]string{"tpu"}, mappings, nodes)
+	assert.ErrorContains(t, err, "PCI resource mapping tpu does not exist")
+}
+
+// pciTestGuest is VM 500 with the given hostpci0 device, in the given HA group or without an HA resource
+func pciTestGuest(hostpci, group string) *guestFixture {
+	return &guestFixture{
+		vmid:   500,
+		name:   "gpu",
+		config: map[string]any{"scsi0": "ceph:vm-500-disk-0", "hostpci0": hostpci},
+		group:  group,
+	}
+}
+
+func TestSetupVMHAResourcesWithPCIMapping(t *testing.T) {
+	t.Run("new resource uses the PCI group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: pciTestGuest("mapping=gpu,pcie=1", "")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 2)
+		group := testServer.plan.Actions[0]
+		assert.Equal(t, ActionCreate, group.Kind)
+		assert.Equal(t, ResourceHAGroup, group.Resource)
+		assert.Equal(t, "crs-pci-gpu", group.ID)
+		assert.Equal(t, "pve1:1000,pve2:1000", group.After)
+		assert.Equal(t, 1, group.HAGroup.Restricted)
+
+		resource := testServer.plan.Actions[1]
+		assert.Equal(t, ResourceHAResource, resource.Resource)
+		assert.Equal(t, "crs-pci-gpu", resource.HAResource.Group)
+	})
+
+	t.Run("pinned resource moves into the PCI group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: pciTestGuest("mapping=gpu", "crs-vm-pin-pve1")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 2)
+		assert.Equal(t, "crs-pci-gpu", testServer.plan.Actions[0].ID)
+		update := testServer.plan.Actions[1]
+		assert.Equal(t, "group=crs-pci-gpu max_restart=10 max_relocate=10", update.After)
+		assert.Equal(t, "hostpci devices from PCI resource mappings", update.Reason)
+	})
+
+	t.Run("host address keeps the pin group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: pciTestGuest("0000:01:00.0", "crs-vm-pin-pve1")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+		assert.Empty(t, testServer.plan.Actions)
+	})
+
+	t.Run("node without the mapping keeps the pin group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: pciTestGuest("mapping=nic", "")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-pin-pve1", testServer.plan.Actions[0].HAResource.Group)
+	})
+
+	t.Run("resource leaves the PCI group once a device uses a host address", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: pciTestGuest("0000:01:00.0", "crs-pci-gpu")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-pin-pve1", testServer.plan.Actions[0].HAResource.Group)
+		assert.Equal(t, "hostpci devices no longer from PCI resource mappings", testServer.plan.Actions[0].Reason)
+	})
+}
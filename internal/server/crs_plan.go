@@ -0,0 +1,400 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// ActionKind describes what an action does to a resource
+type ActionKind string
+
+const (
+	ActionCreate   ActionKind = "create"
+	ActionUpdate   ActionKind = "update"
+	ActionDelete   ActionKind = "delete"
+	ActionMigrate  ActionKind = "migrate"
+	ActionStart    ActionKind = "start"
+	ActionShutdown ActionKind = "shutdown"
+	ActionReboot   ActionKind = "reboot"
+	ActionWake     ActionKind = "wake"
+	ActionEnable   ActionKind = "enable"
+	ActionDisable  ActionKind = "disable"
+)
+
+// ActionResource describes which kind of Proxmox object an action changes
+type ActionResource string
+
+const (
+	ResourceHAGroup        ActionResource = "ha-group"
+	ResourceHAResource     ActionResource = "ha-resource"
+	ResourceVMConfig       ActionResource = "vm-config"
+	ResourceVM             ActionResource = "vm"
+	ResourceContainer      ActionResource = "ct"
+	ResourceCTConfig       ActionResource = "ct-config"
+	ResourceClusterOptions ActionResource = "cluster-options"
+	ResourceNode           ActionResource = "node"
+	ResourceHAMaintenance  ActionResource = "ha-maintenance"
+)
+
+// Action is a single change CRS makes to the Proxmox cluster.
+// It carries the full API payload so that a stored plan can be applied later without recomputing it.
+type Action struct {
+	Kind     ActionKind     `json:"kind"`
+	Resource ActionResource `json:"resource"`
+	ID       string         `json:"id"`
+	Node     string         `json:"node,omitempty"`
+	VMID     int            `json:"vmid,omitempty"`
+	Before   string         `json:"before,omitempty"`
+	After    string         `json:"after,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+
+	HAGroup        *proxmox.ClusterHAGroup    `json:"ha_group,omitempty"`
+	HAResource     *proxmox.ClusterHAResource `json:"ha_resource,omitempty"`
+	VMConfig       *proxmox.VMConfig          `json:"vm_config,omitempty"`
+	CTConfig       *proxmox.ContainerConfig   `json:"ct_config,omitempty"`
+	Migration      *proxmox.MigrationOptions  `json:"migration,omitempty"`
+	Clone          *proxmox.CloneOptions      `json:"clone,omitempty"`
+	ClusterOptions *proxmox.ClusterOptions    `json:"cluster_options,omitempty"`
+}
+
+// String returns a short human-readable description of the action
+func (a Action) String() string {
+	return fmt.Sprintf("%s %s %s", a.Kind, a.Resource, a.ID)
+}
+
+// Plan is an ordered list of actions collected from a SetupCRS run in plan mode
+type Plan struct {
+	CreatedAt time.Time `json:"created_at"`
+	Actions   []Action  `json:"actions"`
+}
+
+// Table renders the plan as a human-readable table
+func (p *Plan) Table() string {
+	if len(p.Actions) == 0 {
+		return "No changes. The cluster matches the CRS configuration.\n"
+	}
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "#\tKIND\tRESOURCE\tID\tNODE\tBEFORE\tAFTER\tREASON")
+	for i, action := range p.Actions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			i+1, action.Kind, action.Resource, action.ID,
+			planCell(action.Node), planCell(action.Before), planCell(action.After), planCell(action.Reason))
+	}
+
+	w.Flush()
+	fmt.Fprintf(&sb, "\n%d action(s) planned\n", len(p.Actions))
+
+	return sb.String()
+}
+
+// JSON renders the plan as indented JSON that can be fed back to ApplyPlan
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// ParsePlan decodes a plan previously produced by Plan.JSON
+func ParsePlan(data []byte) (*Plan, error) {
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	for i, action := range plan.Actions {
+		if err := action.validate(); err != nil {
+			return nil, fmt.Errorf("invalid action #%d (%s): %w", i+1, action, err)
+		}
+	}
+
+	return &plan, nil
+}
+
+// planCell replaces empty values so that table columns stay aligned
+func planCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// validate checks that the action carries the payload required to execute it
+func (a Action) validate() error {
+	switch a.Resource {
+	case ResourceHAGroup:
+		if a.Kind != ActionDelete && a.HAGroup == nil {
+			return fmt.Errorf("missing ha_group payload")
+		}
+	case ResourceHAResource:
+		if a.Kind != ActionDelete && a.HAResource == nil {
+			return fmt.Errorf("missing ha_resource payload")
+		}
+	case ResourceVMConfig:
+		if a.VMConfig == nil || a.Node == "" || a.VMID == 0 {
+			return fmt.Errorf("missing vm_config payload, node or vmid")
+		}
+	case ResourceVM:
+		if a.Node == "" || a.VMID == 0 {
+			return fmt.Errorf("missing node or vmid")
+		}
+
+		switch a.Kind {
+		case ActionMigrate:
+			if a.Migration == nil {
+				return fmt.Errorf("missing migration payload")
+			}
+		case ActionCreate:
+			if a.Clone == nil || a.Clone.NewID == 0 {
+				return fmt.Errorf("missing clone payload")
+			}
+		case ActionStart, ActionShutdown, ActionDelete:
+		default:
+			return fmt.Errorf("unsupported kind %q for vm", a.Kind)
+		}
+	case ResourceCTConfig:
+		if a.CTConfig == nil || a.Node == "" || a.VMID == 0 {
+			return fmt.Errorf("missing ct_config payload, node or vmid")
+		}
+	case ResourceContainer:
+		if a.Node == "" || a.VMID == 0 {
+			return fmt.Errorf("missing node or vmid")
+		}
+
+		if a.Kind != ActionMigrate {
+			return fmt.Errorf("unsupported kind %q for ct", a.Kind)
+		}
+		if a.Migration == nil {
+			return fmt.Errorf("missing migration payload")
+		}
+	case ResourceClusterOptions:
+		if a.ClusterOptions == nil {
+			return fmt.Errorf("missing cluster_options payload")
+		}
+	case ResourceNode:
+		if a.Node == "" {
+			return fmt.Errorf("missing node")
+		}
+
+		if a.Kind != ActionReboot && a.Kind != ActionShutdown && a.Kind != ActionWake {
+			return fmt.Errorf("unsupported kind %q for node", a.Kind)
+		}
+	case ResourceHAMaintenance:
+		if a.Node == "" {
+			return fmt.Errorf("missing node")
+		}
+
+		if a.Kind != ActionEnable && a.Kind != ActionDisable {
+			return fmt.Errorf("unsupported kind %q for HA maintenance", a.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown resource %q", a.Resource)
+	}
+
+	if a.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+
+	return nil
+}
+
+// isPlanning reports whether mutations are currently collected into a plan instead of being executed
+func (s *Server) isPlanning() bool {
+	return s.plan != nil
+}
+
+// PlanCRS runs SetupCRS in plan mode and returns every change it would make without touching the cluster
+func (s *Server) PlanCRS() (*Plan, error) {
+	s.plan = &Plan{
+		CreatedAt: time.Now().UTC(),
+		Actions:   []Action{},
+	}
+	defer func() {
+		s.plan = nil
+	}()
+
+	s.loadConfig()
+
+	// Plan mode makes no changes, so it needs no leadership
+	if err := s.SetupCRS(context.Background()); err != nil {
+		return nil, err
+	}
+
+	s.checkPlanBudget(s.plan)
+
+	return s.plan, nil
+}
+
+// ApplyPlan executes exactly the actions of a plan, in order, stopping at the first failure.
+// The whole plan counts as one cycle against the change budget.
+func (s *Server) ApplyPlan(plan *Plan) error {
+	s.loadConfig()
+
+	if breaker := s.getBreakerState(); breaker != nil {
+		return fmt.Errorf("circuit breaker is tripped, not applying the plan: %s", breaker.Reason)
+	}
+
+	problem, err := s.clusterHealthProblem()
+	if err != nil {
+		return fmt.Errorf("failed to check cluster health: %w", err)
+	}
+
+	if problem != "" {
+		return fmt.Errorf("cluster is not healthy, not applying the plan: %s", problem)
+	}
+
+	s.startCycleBudget()
+
+	for i, action := range plan.Actions {
+		if err := action.validate(); err != nil {
+			return fmt.Errorf("invalid action #%d (%s): %w", i+1, action, err)
+		}
+
+		logging.Infof("Applying action %d/%d: %s", i+1, len(plan.Actions), action)
+
+		if _, err := s.applyAction(action); err != nil {
+			return fmt.Errorf("action #%d (%s): %w", i+1, action, err)
+		}
+
+		if awaitsHAState(action, plan.Actions[i+1:]) {
+			scheduler := s.currentConfig().Scheduler
+			if !s.waitForHAStateChangeWithInterval(action.ID, action.Before, action.HAResource.State,
+				scheduler.HAStateMaxAttempts, time.Duration(scheduler.HAStateWaitSeconds)*time.Second) {
+				return fmt.Errorf("action #%d (%s): HA resource did not reach %s state", i+1, action, action.HAResource.State)
+			}
+		}
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// awaitsHAState reports whether the next planned action changes the same HA resource again, like restoring its state
+// after clearing an HA error. HA must reach the state set by the action first, as it does when CRS makes the change itself.
+func awaitsHAState(action Action, next []Action) bool {
+	if action.Kind != ActionUpdate || action.Resource != ResourceHAResource || action.HAResource == nil || len(next) == 0 {
+		return false
+	}
+
+	return next[0].Kind == ActionUpdate && next[0].Resource == ResourceHAResource && next[0].ID == action.ID
+}
+
+// applyAction executes a mutating action against the Proxmox API, or records it when planning.
+// All CRS changes go through here and are refused while the circuit breaker is tripped, see spendBudget,
+// and once the leadership they are made under is lost, see leadershipErr.
+// The returned string is the task UPID for asynchronous operations.
+func (s *Server) applyAction(action Action) (string, error) {
+	if s.isPlanning() {
+		logging.Debugf("Planned action: %s (before=%q, after=%q, reason=%q)", action, action.Before, action.After, action.Reason)
+		s.plan.Actions = append(s.plan.Actions, action)
+		return "", nil
+	}
+
+	// A cycle that outlived its leadership must not change the cluster next to the new leader
+	if err := s.leadershipErr(); err != nil {
+		return "", fmt.Errorf("refusing %s: %w", action, err)
+	}
+
+	if err := s.spendBudget(action, time.Now()); err != nil {
+		return "", err
+	}
+
+	upid, err := s.executeAction(action)
+	s.recordActionEvent(action, upid, err)
+
+	return upid, err
+}
+
+// executeAction dispatches an action to the matching Proxmox API call
+func (s *Server) executeAction(action Action) (string, error) {
+	if err := action.validate(); err != nil {
+		return "", fmt.Errorf("invalid action %s: %w", action, err)
+	}
+
+	switch action.Resource {
+	case ResourceHAGroup:
+		switch action.Kind {
+		case ActionCreate:
+			_, err := s.proxmox.CreateClusterHAGroup(*action.HAGroup)
+			return "", err
+		case ActionUpdate:
+			return "", s.proxmox.UpdateClusterHAGroup(*action.HAGroup)
+		case ActionDelete:
+			return "", s.proxmox.DeleteClusterHAGroup(action.ID)
+		}
+	case ResourceHAResource:
+		var err error
+		switch action.Kind {
+		case ActionCreate:
+			_, err = s.proxmox.CreateClusterHAResource(*action.HAResource)
+		case ActionUpdate:
+			err = s.proxmox.UpdateClusterHAResource(*action.HAResource)
+		case ActionDelete:
+			err = s.proxmox.DeleteClusterHAResource(action.ID)
+		default:
+			return "", fmt.Errorf("unsupported action %s", action)
+		}
+
+		if err == nil {
+			metrics.HAResourceChanges.WithLabelValues(string(action.Kind)).Inc()
+		}
+		return "", err
+	case ResourceVMConfig:
+		if action.Kind == ActionUpdate {
+			return "", s.proxmox.UpdateVMConfig(action.Node, action.VMID, *action.VMConfig)
+		}
+	case ResourceVM:
+		switch action.Kind {
+		case ActionMigrate:
+			return s.proxmox.MigrateVM(action.Node, action.VMID, *action.Migration)
+		case ActionCreate:
+			// VMID and Node refer to the source template, the new VM is described by the clone payload
+			return s.proxmox.CloneVMWithOptions(action.Node, action.VMID, *action.Clone)
+		case ActionStart:
+			return s.proxmox.StartVM(action.Node, action.VMID)
+		case ActionShutdown:
+			return s.proxmox.ShutdownVM(action.Node, action.VMID)
+		case ActionDelete:
+			return s.proxmox.DeleteVM(action.Node, action.VMID)
+		}
+	case ResourceCTConfig:
+		if action.Kind == ActionUpdate {
+			return "", s.proxmox.UpdateContainerConfig(action.Node, action.VMID, *action.CTConfig)
+		}
+	case ResourceContainer:
+		if action.Kind == ActionMigrate {
+			return s.proxmox.MigrateContainer(action.Node, action.VMID, *action.Migration)
+		}
+	case ResourceClusterOptions:
+		if action.Kind == ActionUpdate {
+			return "", s.proxmox.UpdateClusterOptions(*action.ClusterOptions)
+		}
+	case ResourceNode:
+		switch action.Kind {
+		case ActionReboot:
+			return s.proxmox.RebootNode(action.Node)
+		case ActionShutdown:
+			return s.proxmox.ShutdownNode(action.Node)
+		case ActionWake:
+			return "", s.proxmox.WakeNode(action.Node)
+		}
+	case ResourceHAMaintenance:
+		switch action.Kind {
+		case ActionEnable:
+			return "", s.proxmox.EnableNodeMaintenance(action.Node)
+		case ActionDisable:
+			return "", s.proxmox.DisableNodeMaintenance(action.Node)
+		}
+	}
+
+	return "", fmt.Errorf("unsupported action %s", action)
+}
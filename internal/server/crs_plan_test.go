@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestPlanCRS(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeNodes:          true,
+		includeNodeVMs:        true,
+		includeVMConfig:       true,
+		includeStorage:        true,
+		includeClusterOptions: true,
+		recorder:              recorder,
+	})
+	defer mockServer.Close()
+
+	plan, err := testServer.PlanCRS()
+	require.NoError(t, err)
+	require.NotNil(t, plan)
+
+	assert.Empty(t, recorder.mutations(), "plan mode must not send mutating requests")
+	assert.False(t, testServer.isPlanning(), "plan mode must be switched off after planning")
+
+	var planned []string
+	for _, action := range plan.Actions {
+		planned = append(planned, action.String())
+	}
+
+	assert.Contains(t, planned, "update cluster-options registered-tags")
+	assert.Contains(t, planned, "create ha-group crs-vm-pin-pve1")
+	assert.Contains(t, planned, "create ha-resource vm:100")
+	assert.NotContains(t, planned, "create ha-resource vm:101", "templates must not get HA resources")
+	assert.NotContains(t, planned, "create ha-resource vm:102", "crs-skip VMs must not get HA resources")
+}
+
+func TestApplyPlan(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{recorder: recorder})
+	defer mockServer.Close()
+
+	plan := &Plan{
+		Actions: []Action{
+			{
+				Kind:     ActionCreate,
+				Resource: ResourceHAGroup,
+				ID:       "crs-vm-pin-pve1",
+				HAGroup:  &proxmox.ClusterHAGroup{Group: "crs-vm-pin-pve1", Nodes: "pve1:1000", Restricted: 1, NoFailback: 1},
+			},
+			{
+				Kind:     ActionDelete,
+				Resource: ResourceHAResource,
+				ID:       "vm:100",
+			},
+		},
+	}
+
+	require.NoError(t, testServer.ApplyPlan(plan))
+	assert.Equal(t, []string{
+		"POST /api2/json/cluster/ha/groups",
+		"DELETE /api2/json/cluster/ha/resources/vm:100",
+	}, recorder.mutations())
+}
+
+func TestApplyPlanRejectsInvalidAction(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{recorder: recorder})
+	defer mockServer.Close()
+
+	plan := &Plan{
+		Actions: []Action{
+			{Kind: ActionCreate, Resource: ResourceHAGroup, ID: "crs-vm-pin-pve1"},
+		},
+	}
+
+	err := testServer.ApplyPlan(plan)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing ha_group payload")
+	assert.Empty(t, recorder.mutations())
+}
+
+func TestParsePlan(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		original := &Plan{
+			Actions: []Action{
+				{
+					Kind:      ActionMigrate,
+					Resource:  ResourceVM,
+					ID:        "300",
+					Node:      "pve2",
+					VMID:      300,
+					Before:    "pve2",
+					After:     "pve1",
+					Migration: &proxmox.MigrationOptions{Target: "pve1"},
+				},
+			},
+		}
+
+		data, err := original.JSON()
+		require.NoError(t, err)
+
+		parsed, err := ParsePlan(data)
+		require.NoError(t, err)
+		assert.Equal(t, original.Actions, parsed.Actions)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, err := ParsePlan([]byte("{"))
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown resource", func(t *testing.T) {
+		_, err := ParsePlan([]byte(`{"actions":[{"kind":"create","resource":"pool","id":"x"}]}`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown resource")
+	})
+}
+
+func TestPlanTable(t *testing.T) {
+	empty := &Plan{}
+	assert.Contains(t, empty.Table(), "No changes")
+
+	plan := &Plan{
+		Actions: []Action{
+			{Kind: ActionDelete, Resource: ResourceHAGroup, ID: "crs-vm-pin-old", Before: "old:1000", Reason: "HA group is orphaned"},
+		},
+	}
+
+	table := plan.Table()
+	assert.Contains(t, table, "KIND")
+	assert.Contains(t, table, "crs-vm-pin-old")
+	assert.Contains(t, table, "HA group is orphaned")
+	assert.Contains(t, table, "1 action(s) planned")
+}
+
+func TestApplyPlanWaitsForHAStateBetweenUpdates(t *testing.T) {
+	var requests []string
+	state := haStateError
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{consulKV: map[string]string{
+		consul.SchedulerConfigKey: `{"ha_state_wait_seconds": 1}`,
+	}, cluster: func(w http.ResponseWriter, r *http.Request) bool {
+		switch {
+		case r.Method == http.MethodPut:
+			requests = append(requests, "PUT")
+			state = haStateDisabled
+			return false
+		case r.URL.Path == "/api2/json/cluster/resources":
+			requests = append(requests, "GET "+state)
+			fmt.Fprintf(w, `{"data": [{"type": "qemu", "vmid": 100, "node": "pve1", "hastate": %q}]}`, state)
+			return true
+		default:
+			return false
+		}
+	}})
+	defer mockServer.Close()
+
+	plan := &Plan{}
+	testServer.plan = plan
+	require.True(t, testServer.fixErrorStateVM("vm:100", &proxmox.ClusterHAResource{SID: "vm:100", State: haStateError}, 1, 0))
+	testServer.plan = nil
+	require.Len(t, plan.Actions, 2)
+
+	require.NoError(t, testServer.ApplyPlan(plan))
+	assert.Equal(t, []string{"PUT", "GET disabled", "PUT"}, requests, "the state is restored once HA disabled the resource")
+}
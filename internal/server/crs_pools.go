@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/tools"
+)
+
+// poolNameRe matches the Proxmox pool names that form a valid HA group name after the crs-pool- prefix
+var poolNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// poolGroupName returns the HA group of the guests in a pool with a policy
+func poolGroupName(pool string) string {
+	return crsPoolGroupPrefix + pool
+}
+
+// validatePoolPolicy checks a pool policy against the HA classes, the nodes are checked when the group is built
+func validatePoolPolicy(name string, policy consul.PoolPolicy, classes map[string]consul.HAClass) error {
+	if !poolNameRe.MatchString(name) {
+		return fmt.Errorf("name %q must consist of letters, digits, dashes and underscores", name)
+	}
+
+	seen := make(map[string]bool, len(policy.AllowedNodes))
+	for _, node := range policy.AllowedNodes {
+		if seen[node] {
+			return fmt.Errorf("allowed_nodes lists %s twice", node)
+		}
+		seen[node] = true
+	}
+
+	for _, node := range policy.PreferredNodes {
+		if len(policy.AllowedNodes) > 0 && !seen[node] {
+			return fmt.Errorf("preferred node %s is not in allowed_nodes", node)
+		}
+	}
+
+	if _, ok := classes[policy.HAClass]; policy.HAClass != "" && !ok {
+		return fmt.Errorf("HA class %s is not defined", policy.HAClass)
+	}
+
+	if policy.MaxMemoryShare < 0 || policy.MaxMemoryShare > 1 {
+		return fmt.Errorf("max_memory_share must be between 0 and 1, got %.2f", policy.MaxMemoryShare)
+	}
+
+	return nil
+}
+
+// loadPoolsConfig loads the pool policies, an invalid policy keeps its previous definition.
+// The HA classes are loaded first, so that the policies can refer to them.
+func (s *Server) loadPoolsConfig(config *crsConfig) {
+	policies, err := s.consul.GetPoolPolicies()
+	if err != nil {
+		logging.Errorf("Failed to load pool policies, keeping previous settings: %v", err)
+		return
+	}
+
+	loaded := make(map[string]consul.PoolPolicy, len(policies))
+	for name, policy := range policies {
+		if err := validatePoolPolicy(name, policy, config.HAClasses); err != nil {
+			logging.Errorf("Invalid policy of pool %s, keeping previous settings: %v", name, err)
+			if previous, ok := config.Pools[name]; ok {
+				loaded[name] = previous
+			}
+			continue
+		}
+
+		loaded[name] = policy
+	}
+
+	config.Pools = loaded
+}
+
+// expectedPoolGroup returns the HA group of a pool policy. Preferred nodes get the highest priority,
+// the group is restricted to the allowed nodes that exist in the cluster.
+func (s *Server) expectedPoolGroup(name string, policy consul.PoolPolicy, nodes []proxmox.Node) (proxmox.ClusterHAGroup, error) {
+	group := proxmox.ClusterHAGroup{
+		Group:      poolGroupName(name),
+		NoFailback: 1,
+	}
+
+	entries := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if len(policy.AllowedNodes) > 0 && !slices.Contains(policy.AllowedNodes, node.Node) {
+			continue
+		}
+
+		// Excluded nodes only take the guests of pools that list them
+		if len(policy.AllowedNodes) == 0 && !slices.Contains(policy.PreferredNodes, node.Node) && s.isNodeExcluded(node.Node) {
+			continue
+		}
+
+		priority := s.currentConfig().Scheduler.MaxNodePriority
+		if len(policy.PreferredNodes) > 0 && !slices.Contains(policy.PreferredNodes, node.Node) {
+			priority = crsMinNodePriority
+		}
+
+		entries = append(entries, fmt.Sprintf("%s:%d", node.Node, priority))
+	}
+
+	if len(entries) == 0 {
+		return group, fmt.Errorf("none of the allowed nodes of pool %s exist", name)
+	}
+
+	sort.Strings(entries)
+	group.Nodes = strings.Join(entries, ",")
+
+	if len(policy.AllowedNodes) > 0 {
+		group.Restricted = 1
+	}
+
+	if len(policy.PreferredNodes) > 0 {
+		// HA moves the guests back to a preferred node once one is available again
+		group.NoFailback = 0
+	}
+
+	return group, nil
+}
+
+// SetupPoolGroups creates and updates the HA group of every pool policy
+func (s *Server) SetupPoolGroups() error {
+	pools := s.currentConfig().Pools
+	if len(pools) == 0 {
+		return nil
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range sortedPoolNames(pools) {
+		expected, err := s.expectedPoolGroup(name, pools[name], nodes)
+		if err != nil {
+			// The guests of the pool stay in the prefer groups
+			logging.Warnf("Skipping HA group of pool %s: %v", name, err)
+			continue
+		}
+
+		var existing *proxmox.ClusterHAGroup
+		for _, group := range haGroups {
+			if group.Group == expected.Group {
+				existing = &group
+				break
+			}
+		}
+
+		action := Action{
+			Resource: ResourceHAGroup,
+			ID:       expected.Group,
+			After:    expected.Nodes,
+			HAGroup:  &expected,
+		}
+
+		switch {
+		case existing == nil:
+			action.Kind = ActionCreate
+			action.Reason = "pool " + name + " has a policy"
+		case !s.compareNodeConfiguration(existing.Nodes, expected.Nodes) || existing.Restricted != expected.Restricted || existing.NoFailback != expected.NoFailback:
+			action.Kind = ActionUpdate
+			action.Before = existing.Nodes
+			action.Reason = "policy of pool " + name + " changed"
+		default:
+			continue
+		}
+
+		logging.Infof("%s HA group %s for the policy of pool %s: nodes=%s", action.Kind, expected.Group, name, expected.Nodes)
+
+		if _, err := s.applyAction(action); err != nil {
+			return fmt.Errorf("failed to %s HA group %s: %w", action.Kind, expected.Group, err)
+		}
+
+		s.rateLimitSleep()
+	}
+
+	return nil
+}
+
+// poolGroup returns the HA group of a pool whose policy has a group, or false for pools without one
+func (s *Server) poolGroup(pool string, nodes []proxmox.Node) (string, bool) {
+	policy, ok := s.currentConfig().Pools[pool]
+	if pool == "" || !ok {
+		return "", false
+	}
+
+	if _, err := s.expectedPoolGroup(pool, policy, nodes); err != nil {
+		return "", false
+	}
+
+	return poolGroupName(pool), true
+}
+
+// poolPlacementGroup returns the HA group of a guest given the pin, prefer or pool group it is or would be in.
+// The prefer group of a guest in a pool with a policy is replaced by the pool group,
+// a guest in the group of a pool it left or whose policy was removed returns to the prefer group of its node.
+// Prefer groups of a storage zone are kept, the pool group may hold nodes that do not reach the guest's storage.
+func (s *Server) poolPlacementGroup(guest haGuest, group string, nodes []proxmox.Node) string {
+	if (!strings.HasPrefix(group, crsPreferGroupPrefix) && !strings.HasPrefix(group, crsPoolGroupPrefix)) || preferGroupZone(group) != "" {
+		return group
+	}
+
+	if poolGroup, ok := s.poolGroup(guest.Pool, nodes); ok {
+		return poolGroup
+	}
+
+	if strings.HasPrefix(group, crsPoolGroupPrefix) {
+		return tools.GetHAVMPreferGroupName(guest.Node)
+	}
+
+	return group
+}
+
+// guestPools returns the pool of every guest in the cluster by VMID, guests outside a pool are left out
+func guestPools(resources []proxmox.ClusterResource) map[int]string {
+	pools := make(map[int]string)
+	for _, resource := range resources {
+		if isGuestResource(resource) && resource.Pool != "" {
+			pools[resource.VMID] = resource.Pool
+		}
+	}
+
+	return pools
+}
+
+// poolMemoryShare returns the share of the online nodes' memory assigned to the running guests of a pool,
+// counting extra bytes of guests that are about to be started
+func poolMemoryShare(pool string, resources []proxmox.ClusterResource, extra int64) float64 {
+	used, total := extra, int64(0)
+	for _, resource := range resources {
+		switch {
+		case resource.Type == "node" && resource.Status == "online":
+			total += resource.MaxMem
+		case isGuestResource(resource) && resource.Pool == pool && resource.Status == vmStatusRunning:
+			used += resource.MaxMem
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(used) / float64(total)
+}
+
+// checkPoolMemoryShare returns an error when starting guests with extra bytes of memory
+// takes a pool over the max_memory_share of its policy
+func (s *Server) checkPoolMemoryShare(pool string, resources []proxmox.ClusterResource, extra int64) error {
+	policy, ok := s.currentConfig().Pools[pool]
+	if pool == "" || !ok || policy.MaxMemoryShare == 0 {
+		return nil
+	}
+
+	if share := poolMemoryShare(pool, resources, extra); share > policy.MaxMemoryShare {
+		return fmt.Errorf("pool %s would use %.0f%% of the cluster memory, its max_memory_share is %.0f%%", pool, share*100, policy.MaxMemoryShare*100)
+	}
+
+	return nil
+}
+
+// sortedPoolNames returns the names of the pool policies in order
+func sortedPoolNames(pools map[string]consul.PoolPolicy) []string {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
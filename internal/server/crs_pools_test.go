@@ -0,0 +1,183 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+var testPoolNodes = []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+
+func TestValidatePoolPolicy(t *testing.T) {
+	classes := builtinHAClasses()
+
+	assert.NoError(t, validatePoolPolicy("tenant_A", consul.PoolPolicy{AllowedNodes: []string{"pve1", "pve2"}, PreferredNodes: []string{"pve1"}}, classes))
+	assert.NoError(t, validatePoolPolicy("tenant-a", consul.PoolPolicy{PreferredNodes: []string{"pve1"}, HAClass: "aggressive", MaxMemoryShare: 0.25}, classes))
+
+	assert.Error(t, validatePoolPolicy("tenant a", consul.PoolPolicy{}, classes))
+	assert.Error(t, validatePoolPolicy("tenant-a", consul.PoolPolicy{AllowedNodes: []string{"pve1", "pve1"}}, classes))
+	assert.Error(t, validatePoolPolicy("tenant-a", consul.PoolPolicy{AllowedNodes: []string{"pve1"}, PreferredNodes: []string{"pve2"}}, classes))
+	assert.Error(t, validatePoolPolicy("tenant-a", consul.PoolPolicy{HAClass: "missing"}, classes))
+	assert.Error(t, validatePoolPolicy("tenant-a", consul.PoolPolicy{MaxMemoryShare: 1.5}, classes))
+}
+
+func TestExpectedPoolGroup(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+
+	tests := []struct {
+		name       string
+		policy     consul.PoolPolicy
+		nodes      string
+		restricted int
+		noFailback int
+	}{
+		{name: "all nodes", nodes: "pve1:1000,pve2:1000,pve3:1000", noFailback: 1},
+		{
+			name:       "allowed nodes",
+			policy:     consul.PoolPolicy{AllowedNodes: []string{"pve2", "pve3", "pve9"}},
+			nodes:      "pve2:1000,pve3:1000",
+			restricted: 1,
+			noFailback: 1,
+		},
+		{
+			name:   "preferred nodes",
+			policy: consul.PoolPolicy{PreferredNodes: []string{"pve1"}},
+			nodes:  "pve1:1000,pve2:1,pve3:1",
+		},
+		{
+			name:       "preferred within allowed nodes",
+			policy:     consul.PoolPolicy{AllowedNodes: []string{"pve1", "pve2"}, PreferredNodes: []string{"pve2"}},
+			nodes:      "pve1:1,pve2:1000",
+			restricted: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group, err := testServer.expectedPoolGroup("tenant-a", tt.policy, testPoolNodes)
+			require.NoError(t, err)
+			assert.Equal(t, "crs-pool-tenant-a", group.Group)
+			assert.Equal(t, tt.nodes, group.Nodes)
+			assert.Equal(t, tt.restricted, group.Restricted)
+			assert.Equal(t, tt.noFailback, group.NoFailback)
+		})
+	}
+
+	_, err := testServer.expectedPoolGroup("tenant-a", consul.PoolPolicy{AllowedNodes: []string{"pve9"}}, testPoolNodes)
+	assert.Error(t, err)
+}
+
+// poolTestGuest is VM 300 of pool tenant-a, in the given HA group or without an HA resource
+func poolTestGuest(group string) *guestFixture {
+	return &guestFixture{
+		vmid:   300,
+		name:   "web",
+		pool:   "tenant-a",
+		config: map[string]any{"scsi0": "ceph:vm-300-disk-0"},
+		group:  group,
+	}
+}
+
+func TestSetupPoolGroups(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: poolTestGuest(""), recorder: recorder})
+	defer mockServer.Close()
+
+	testServer.config.Pools = map[string]consul.PoolPolicy{
+		"tenant-a": {AllowedNodes: []string{"pve1", "pve2"}},
+		"tenant-b": {AllowedNodes: []string{"pve9"}},
+	}
+	testServer.plan = &Plan{}
+
+	require.NoError(t, testServer.SetupPoolGroups())
+
+	require.Len(t, testServer.plan.Actions, 1, "a pool without existing nodes gets no group")
+	action := testServer.plan.Actions[0]
+	assert.Equal(t, ActionCreate, action.Kind)
+	assert.Equal(t, "crs-pool-tenant-a", action.ID)
+	assert.Equal(t, "pve1:1000,pve2:1000", action.After)
+	assert.Equal(t, 1, action.HAGroup.Restricted)
+}
+
+func TestSetupVMHAResourcesWithPool(t *testing.T) {
+	pools := map[string]consul.PoolPolicy{
+		"tenant-a": {AllowedNodes: []string{"pve1", "pve2"}, HAClass: "aggressive"},
+	}
+
+	t.Run("new resource uses the pool group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: poolTestGuest("")})
+		defer mockServer.Close()
+
+		testServer.config.Pools = pools
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		create := testServer.plan.Actions[0]
+		assert.Equal(t, ActionCreate, create.Kind)
+		assert.Equal(t, "crs-pool-tenant-a", create.HAResource.Group)
+		assert.Equal(t, 3, create.HAResource.MaxRestart, "the HA class of the pool applies")
+	})
+
+	t.Run("existing resource moves into the pool group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: poolTestGuest("crs-vm-prefer-pve1")})
+		defer mockServer.Close()
+
+		testServer.config.Pools = pools
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		update := testServer.plan.Actions[0]
+		assert.Equal(t, "group=crs-pool-tenant-a max_restart=3 max_relocate=5", update.After)
+		assert.Equal(t, "policy of pool tenant-a", update.Reason)
+	})
+
+	t.Run("removed pool policy returns the resource to its prefer group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: poolTestGuest("crs-pool-tenant-a")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-prefer-pve1", testServer.plan.Actions[0].HAResource.Group)
+		assert.Equal(t, "pool policy no longer applies", testServer.plan.Actions[0].Reason)
+	})
+
+	t.Run("pinned resource keeps its group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: poolTestGuest("crs-vm-pin-pve1")})
+		defer mockServer.Close()
+
+		testServer.config.Pools = map[string]consul.PoolPolicy{"tenant-a": {}}
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+		assert.Empty(t, testServer.plan.Actions)
+	})
+}
+
+func TestCheckPoolMemoryShare(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+	testServer.config.Pools = map[string]consul.PoolPolicy{"tenant-a": {MaxMemoryShare: 0.25}}
+
+	resources := []proxmox.ClusterResource{
+		{Type: "node", Node: "pve1", Status: "online", MaxMem: 64 * gib},
+		{Type: "node", Node: "pve2", Status: "online", MaxMem: 64 * gib},
+		{Type: "node", Node: "pve3", Status: "offline", MaxMem: 64 * gib},
+		{Type: "qemu", VMID: 100, Status: "running", Pool: "tenant-a", MaxMem: 16 * gib},
+		{Type: "qemu", VMID: 101, Status: "stopped", Pool: "tenant-a", MaxMem: 16 * gib},
+		{Type: "qemu", VMID: 102, Status: "running", Pool: "tenant-b", MaxMem: 64 * gib},
+	}
+
+	assert.InDelta(t, 0.125, poolMemoryShare("tenant-a", resources, 0), 0.001, "only running guests and online nodes count")
+	assert.NoError(t, testServer.checkPoolMemoryShare("tenant-a", resources, 16*gib))
+	assert.ErrorContains(t, testServer.checkPoolMemoryShare("tenant-a", resources, 17*gib), "pool tenant-a would use 26% of the cluster memory")
+	assert.NoError(t, testServer.checkPoolMemoryShare("tenant-b", resources, 64*gib), "pools without a policy have no limit")
+}
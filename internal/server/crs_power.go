@@ -0,0 +1,542 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// loadPowerStates refreshes the nodes shut down by power management from Consul, keeping the current ones when they fail to load
+func (s *Server) loadPowerStates() {
+	states, err := s.consul.GetPowerStates()
+	if err != nil {
+		logging.Errorf("Failed to load power states, keeping current state: %v", err)
+		return
+	}
+
+	powerStates := make(map[string]*consul.PowerState, len(states))
+	for _, state := range states {
+		powerStates[state.Node] = &state
+	}
+
+	s.drainMu.Lock()
+	s.powerStates = powerStates
+	s.drainMu.Unlock()
+}
+
+// putPowerState stores a power state in Consul and in memory. The caller holds drainMu.
+func (s *Server) putPowerState(state *consul.PowerState) error {
+	if err := s.consul.PutPowerState(*state); err != nil {
+		return err
+	}
+
+	if s.powerStates == nil {
+		s.powerStates = make(map[string]*consul.PowerState)
+	}
+	s.powerStates[state.Node] = state
+
+	return nil
+}
+
+// removePowerState deletes the power state of node. The caller holds drainMu.
+func (s *Server) removePowerState(node string) error {
+	if err := s.consul.DeletePowerState(node); err != nil {
+		return err
+	}
+	delete(s.powerStates, node)
+
+	return nil
+}
+
+// getPowerStates returns a copy of the power states ordered by node
+func (s *Server) getPowerStates() []consul.PowerState {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	states := make([]consul.PowerState, 0, len(s.powerStates))
+	for _, state := range s.powerStates {
+		states = append(states, *state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Node < states[j].Node
+	})
+
+	return states
+}
+
+// powerStep is the copy of the drains and power states a power management step works on.
+// Its changes are stored under drainMu, the changes of others during the step win.
+type powerStep struct {
+	drains map[string]*consul.DrainState
+	states map[string]*consul.PowerState
+}
+
+// powerSnapshot returns a copy of the drains and power states
+func (s *Server) powerSnapshot() *powerStep {
+	drains := s.drainSnapshot()
+
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	states := make(map[string]*consul.PowerState, len(s.powerStates))
+	for node, state := range s.powerStates {
+		power := *state
+		states[node] = &power
+	}
+
+	return &powerStep{drains: drains, states: states}
+}
+
+// ManagePower shuts down a spare node while the cluster is idle and wakes it again when capacity runs short.
+// A node is drained before it is shut down, so its guests are packed onto the remaining nodes.
+// The online nodes must stay above min_online_nodes, keep quorum and be able to lose their largest node (N+1).
+func (s *Server) ManagePower() error {
+	return s.managePower(time.Now())
+}
+
+func (s *Server) managePower(now time.Time) error {
+	// The step works on a snapshot, so the API calls do not block the admin API and the other drain users
+	step := s.powerSnapshot()
+
+	config := s.currentConfig().Power
+	if !config.Enabled && len(step.states) == 0 && !step.hasPowerDrains() {
+		return nil
+	}
+
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	nodes := make(map[string]proxmox.ClusterResource)
+	for _, resource := range resources {
+		if resource.Type == "node" {
+			nodes[resource.Node] = resource
+		}
+	}
+
+	pending := s.progressPowerChanges(step, nodes, now)
+
+	// Guests of draining nodes end up on the active nodes, so the usage of all online nodes counts
+	var active []proxmox.ClusterResource
+	var usedCPU float64
+	var usedMem int64
+	online := 0
+
+	for _, name := range sortedNodeNames(nodes) {
+		node := nodes[name]
+		if node.Status != "online" {
+			continue
+		}
+
+		online++
+		usedCPU += node.CPU * float64(node.MaxCPU)
+		usedMem += node.Mem
+
+		if node.HAState != "maintenance" && !s.isDrainingNode(node.Node) {
+			active = append(active, node)
+		}
+	}
+
+	load := powerHeadroomLoad(active, usedCPU, usedMem)
+
+	switch {
+	case !config.Enabled:
+		s.wakeAllNodes(step, now)
+		return nil
+	case len(active) < config.MinOnlineNodes:
+		s.addPowerCapacity(step, fmt.Sprintf("%d active nodes, min_online_nodes is %d", len(active), config.MinOnlineNodes), now)
+		return nil
+	case load > config.HighLoad:
+		s.addPowerCapacity(step, fmt.Sprintf("load %.2f with one node failed exceeds high_load %.2f", load, config.HighLoad), now)
+		return nil
+	case pending:
+		return nil
+	case now.Sub(s.powerChangedAt) < time.Duration(config.Cooldown)*time.Second:
+		logging.Debugf("Power management cooldown until %s", s.powerChangedAt.Add(time.Duration(config.Cooldown)*time.Second).Format(time.RFC3339))
+		return nil
+	case len(active) <= config.MinOnlineNodes:
+		return nil
+	case online-1 <= len(nodes)/2:
+		logging.Debugf("Shutting down another node would lose quorum with %d of %d nodes online", online-1, len(nodes))
+		return nil
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	candidate, remainingLoad := s.selectPowerCandidate(active, resources, haResources, usedCPU, usedMem)
+	if candidate == "" {
+		logging.Debugf("No node can be shut down, load with one node failed is %.2f", load)
+		return nil
+	}
+
+	reason := fmt.Sprintf("load %.2f with one node failed stays below low_load %.2f without it", remainingLoad, config.LowLoad)
+	if s.isPlanning() {
+		logging.Infof("Would drain node %s to shut it down: %s", candidate, reason)
+		return nil
+	}
+
+	return s.startPowerDrain(candidate, "power management: "+reason)
+}
+
+// startPowerDrain drains node to shut it down, unless a drain of the node was started during the step
+func (s *Server) startPowerDrain(node, reason string) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if _, ok := s.drains[node]; ok {
+		logging.Infof("Node %s was drained during the power management step, not shutting it down", node)
+		return nil
+	}
+
+	_, err := s.addDrain(&consul.DrainState{Node: node, Origin: powerOrigin}, reason)
+	return err
+}
+
+// progressPowerChanges shuts down drained nodes and tracks nodes going down and coming back.
+// It returns true while a power change is in progress.
+func (s *Server) progressPowerChanges(step *powerStep, nodes map[string]proxmox.ClusterResource, now time.Time) bool {
+	pending := false
+
+	for _, node := range sortedDrainNodes(step.drains) {
+		state := step.drains[node]
+		if state.Origin != powerOrigin || step.states[node] != nil {
+			continue
+		}
+
+		pending = true
+		if state.Status == consul.DrainStatusDrained {
+			s.powerNode(step, node, ActionShutdown, consul.PowerStatusShuttingDown, "node is drained", now)
+		}
+	}
+
+	for _, node := range sortedPowerNodes(step.states) {
+		state := step.states[node]
+		resource, exists := nodes[node]
+		online := resource.Status == "online"
+
+		switch {
+		case !exists:
+			logging.Warnf("Node %s left the cluster while shut down by power management", node)
+			s.endStandby(step, node, "node left the cluster")
+		case state.Status == consul.PowerStatusShuttingDown && !online:
+			logging.Infof("Node %s is shut down by power management", node)
+			s.powerChangedAt = now
+			if s.isPlanning() {
+				continue
+			}
+
+			standby := *state
+			standby.Status = consul.PowerStatusStandby
+			standby.ChangedAt = now.UTC()
+			if err := s.storePowerState(step, &standby); err != nil {
+				logging.Errorf("Failed to store power state of node %s: %v", node, err)
+				continue
+			}
+
+			s.putEvent(consul.Event{
+				Time:     now.UTC(),
+				Kind:     eventKindPower,
+				Resource: eventResourceNode,
+				ID:       node,
+				Node:     node,
+				Reason:   "node shut down",
+				Result:   consul.PowerStatusStandby,
+			})
+		case state.Status == consul.PowerStatusShuttingDown:
+			pending = true
+			if now.Sub(state.ChangedAt) > powerShutdownTimeout {
+				logging.Errorf("Node %s is still online %s after the shutdown request, retrying", node, powerShutdownTimeout)
+				s.powerNode(step, node, ActionShutdown, consul.PowerStatusShuttingDown, "shutdown timed out", now)
+			}
+		case state.Status == consul.PowerStatusWaking && online:
+			s.powerChangedAt = now
+			s.endStandby(step, node, "node woken")
+		case state.Status == consul.PowerStatusWaking:
+			pending = true
+			if now.Sub(state.ChangedAt) > powerWakeTimeout {
+				logging.Errorf("Node %s is still offline %s after the wake-on-LAN request, retrying", node, powerWakeTimeout)
+				s.powerNode(step, node, ActionWake, consul.PowerStatusWaking, "wake timed out", now)
+			}
+		case online:
+			logging.Warnf("Node %s in standby was started outside of CRS", node)
+			s.endStandby(step, node, "node started outside of CRS")
+		}
+	}
+
+	return pending
+}
+
+// addPowerCapacity brings one node back: a node still draining for its shutdown keeps running,
+// otherwise a node in standby is woken.
+func (s *Server) addPowerCapacity(step *powerStep, reason string, now time.Time) {
+	for _, node := range sortedDrainNodes(step.drains) {
+		if step.drains[node].Origin == powerOrigin && step.states[node] == nil {
+			logging.Infof("Power management: keeping node %s online, %s", node, reason)
+			s.endPowerDrain(step, node, reason)
+			return
+		}
+	}
+
+	var standby []string
+	for _, node := range sortedPowerNodes(step.states) {
+		switch step.states[node].Status {
+		case consul.PowerStatusWaking:
+			logging.Debugf("Power management: %s, waiting for node %s to come back", reason, node)
+			return
+		case consul.PowerStatusStandby:
+			standby = append(standby, node)
+		}
+	}
+
+	if len(standby) == 0 {
+		logging.Debugf("Power management: %s, but no node is in standby", reason)
+		return
+	}
+
+	logging.Warnf("Power management: waking node %s, %s", standby[0], reason)
+	s.powerNode(step, standby[0], ActionWake, consul.PowerStatusWaking, reason, now)
+}
+
+// wakeAllNodes brings back every node power management shut down or is draining, after it was disabled
+func (s *Server) wakeAllNodes(step *powerStep, now time.Time) {
+	const reason = "power management is disabled"
+
+	for _, node := range sortedDrainNodes(step.drains) {
+		if step.drains[node].Origin == powerOrigin && step.states[node] == nil {
+			s.endPowerDrain(step, node, reason)
+		}
+	}
+
+	for _, node := range sortedPowerNodes(step.states) {
+		if step.states[node].Status == consul.PowerStatusStandby {
+			logging.Infof("Power management: waking node %s, %s", node, reason)
+			s.powerNode(step, node, ActionWake, consul.PowerStatusWaking, reason, now)
+		}
+	}
+}
+
+// powerNode shuts down or wakes node and records its new power status
+func (s *Server) powerNode(step *powerStep, node string, kind ActionKind, status, reason string, now time.Time) {
+	if _, err := s.applyAction(Action{
+		Kind:     kind,
+		Resource: ResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   powerOrigin + ": " + reason,
+	}); err != nil {
+		logging.Errorf("Failed to %s node %s: %v", kind, node, err)
+		return
+	}
+
+	if s.isPlanning() {
+		return
+	}
+
+	if err := s.storePowerState(step, &consul.PowerState{Node: node, Status: status, ChangedAt: now.UTC()}); err != nil {
+		logging.Errorf("Failed to store power state of node %s: %v", node, err)
+	}
+}
+
+// storePowerState stores the new power state of a node and records it in the step
+func (s *Server) storePowerState(step *powerStep, state *consul.PowerState) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	stored := *state
+	if err := s.putPowerState(&stored); err != nil {
+		return err
+	}
+	step.states[state.Node] = state
+
+	return nil
+}
+
+// endStandby ends the drain of a node that is back online and forgets its power state
+func (s *Server) endStandby(step *powerStep, node, reason string) {
+	if s.isPlanning() {
+		return
+	}
+
+	s.drainMu.Lock()
+	err := s.removePowerState(node)
+	s.drainMu.Unlock()
+
+	if err != nil {
+		logging.Errorf("Failed to remove power state of node %s: %v", node, err)
+		return
+	}
+	delete(step.states, node)
+
+	s.putEvent(consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     eventKindPower,
+		Resource: eventResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   reason,
+		Result:   "online",
+	})
+
+	if state, ok := step.drains[node]; ok && state.Origin == powerOrigin {
+		s.endPowerDrain(step, node, reason)
+	}
+}
+
+// endPowerDrain removes a drain started by power management, CRS places guests on the node again.
+// A drain that was cancelled or started again during the step is left alone.
+func (s *Server) endPowerDrain(step *powerStep, node, reason string) {
+	if s.isPlanning() {
+		return
+	}
+
+	removed, err := s.removePowerDrain(step.drains[node])
+	if err != nil {
+		logging.Errorf("Failed to remove drain state of node %s: %v", node, err)
+		return
+	}
+	delete(step.drains, node)
+
+	if !removed {
+		return
+	}
+
+	logging.Infof("Drain of node %s by power management ended, CRS places guests on it again", node)
+	s.putEvent(consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     eventKindDrain,
+		Resource: eventResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   reason,
+		Result:   "ended",
+	})
+}
+
+// removePowerDrain removes the drain before was copied from. It returns false when the drain
+// was cancelled or started again since.
+func (s *Server) removePowerDrain(before *consul.DrainState) (bool, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if s.currentDrain(before) == nil {
+		return false, nil
+	}
+
+	if err := s.removeDrain(before.Node); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// selectPowerCandidate returns the least loaded active node that may be shut down and the load the other
+// nodes would have with one of them failed. Nodes with guests a drain cannot move and excluded nodes are never chosen.
+func (s *Server) selectPowerCandidate(active, resources []proxmox.ClusterResource, haResources []proxmox.ClusterHAResource, usedCPU float64, usedMem int64) (string, float64) {
+	config := s.currentConfig().Power
+
+	blocked := make(map[string]bool)
+	for _, resource := range resources {
+		if !isGuestResource(resource) {
+			continue
+		}
+
+		haResource := s.findHAResource(haResourceSID(resource.Type, resource.VMID), haResources)
+		if s.hasVMSkipTag(resource.Tags) || (haResource != nil && (strings.HasPrefix(haResource.Group, crsPinGroupPrefix) || strings.HasPrefix(haResource.Group, crsPCIGroupPrefix))) {
+			blocked[resource.Node] = true
+		}
+	}
+
+	candidates := slices.Clone(active)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return nodeLoad(candidates[i]) < nodeLoad(candidates[j])
+	})
+
+	for _, candidate := range candidates {
+		if blocked[candidate.Node] || s.isNodeExcluded(candidate.Node) || (len(config.Nodes) > 0 && !slices.Contains(config.Nodes, candidate.Node)) {
+			continue
+		}
+
+		remaining := slices.DeleteFunc(slices.Clone(active), func(node proxmox.ClusterResource) bool {
+			return node.Node == candidate.Node
+		})
+
+		if load := powerHeadroomLoad(remaining, usedCPU, usedMem); load <= config.LowLoad {
+			return candidate.Node, load
+		}
+	}
+
+	return "", 0
+}
+
+// powerHeadroomLoad returns the CPU or memory usage, whichever is higher, of nodes running the given usage
+// after their largest node failed. Less than two nodes have no headroom.
+func powerHeadroomLoad(nodes []proxmox.ClusterResource, usedCPU float64, usedMem int64) float64 {
+	if len(nodes) < 2 {
+		return math.Inf(1)
+	}
+
+	var totalCPU, maxCPU int
+	var totalMem, maxMem int64
+	for _, node := range nodes {
+		totalCPU += node.MaxCPU
+		totalMem += node.MaxMem
+		maxCPU = max(maxCPU, node.MaxCPU)
+		maxMem = max(maxMem, node.MaxMem)
+	}
+
+	if totalCPU == maxCPU || totalMem == maxMem {
+		return math.Inf(1)
+	}
+
+	return math.Max(usedCPU/float64(totalCPU-maxCPU), float64(usedMem)/float64(totalMem-maxMem))
+}
+
+// nodeLoad returns the weighted CPU and memory usage of a node resource, like DRS
+func nodeLoad(node proxmox.ClusterResource) float64 {
+	load := drsNode{UsedCPU: node.CPU * float64(node.MaxCPU), MaxCPU: node.MaxCPU, Mem: node.Mem, MaxMem: node.MaxMem}
+	return load.load()
+}
+
+// hasPowerDrains reports whether power management started one of the drains
+func (step *powerStep) hasPowerDrains() bool {
+	for _, state := range step.drains {
+		if state.Origin == powerOrigin {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedPowerNodes returns the nodes with a power state in name order
+func sortedPowerNodes(states map[string]*consul.PowerState) []string {
+	nodes := make([]string, 0, len(states))
+	for node := range states {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// sortedNodeNames returns the names of node resources in name order
+func sortedNodeNames(nodes map[string]proxmox.ClusterResource) []string {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
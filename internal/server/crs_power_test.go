@@ -0,0 +1,237 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// powerTestCluster serves four nodes with 8 cores and 64 GiB each. A shutdown request keeps the node online
+// until the test takes it offline, a wake-on-LAN request brings it back at once.
+type powerTestCluster struct {
+	mu       sync.Mutex
+	recorder requestRecorder
+	offline  map[string]bool
+	memGiB   int64 // Memory used on every online node
+	guests   string
+}
+
+func (c *powerTestCluster) serve(w http.ResponseWriter, r *http.Request) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api2/json/cluster/resources":
+		entries := []string{}
+		for _, node := range []string{"pve1", "pve2", "pve3", "pve4"} {
+			status, mem := "online", c.memGiB*gib
+			if c.offline[node] {
+				status, mem = "offline", 0
+			}
+			entries = append(entries, fmt.Sprintf(`{"id": "node/%s", "type": "node", "node": %q, "status": %q, "cpu": 0.05, "maxcpu": 8, "mem": %d, "maxmem": %d}`,
+				node, node, status, mem, 64*gib))
+		}
+		if c.guests != "" {
+			entries = append(entries, c.guests)
+		}
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(entries, ","))
+	case r.URL.Path == "/api2/json/cluster/ha/resources":
+		w.Write([]byte(`{"data": [{"sid": "vm:104", "group": "crs-vm-pin-pve1", "state": "started"}]}`))
+	case strings.HasSuffix(r.URL.Path, "/wakeonlan"):
+		c.offline[strings.Split(r.URL.Path, "/")[4]] = false
+		return false
+	default:
+		return false
+	}
+
+	return true
+}
+
+// config serves the cluster through the mock API, its recorder collects the requests
+func (c *powerTestCluster) config() testHandlerConfig {
+	return testHandlerConfig{cluster: c.serve, recorder: &c.recorder}
+}
+
+func (c *powerTestCluster) set(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn()
+}
+
+func TestManagePower(t *testing.T) {
+	cluster := &powerTestCluster{offline: make(map[string]bool), memGiB: 8}
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+	testServer.config.Power.Enabled = true
+
+	now := time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)
+
+	// 32 GiB used fit into two of the three other nodes with room to spare
+	require.NoError(t, testServer.managePower(now))
+	require.Len(t, testServer.drainStates(), 1)
+	assert.Equal(t, "pve1", testServer.drainStates()[0].Node)
+	assert.Equal(t, powerOrigin, testServer.drainStates()[0].Origin)
+	assert.False(t, testServer.drainStates()[0].Restore, "VMs do not return when the node comes back")
+
+	require.NoError(t, testServer.managePower(now.Add(time.Minute)))
+	assert.Empty(t, cluster.recorder.mutations(), "the node is shut down once drained")
+	assert.Len(t, testServer.drainStates(), 1, "one power change at a time")
+
+	testServer.drains["pve1"].Status = consul.DrainStatusDrained
+	require.NoError(t, testServer.managePower(now.Add(2*time.Minute)))
+	assert.Equal(t, []string{"POST /api2/json/nodes/pve1/status"}, cluster.recorder.mutations())
+	assert.Equal(t, consul.PowerStatusShuttingDown, testServer.getPowerStates()[0].Status)
+
+	cluster.set(func() { cluster.offline["pve1"] = true })
+	require.NoError(t, testServer.managePower(now.Add(3*time.Minute)))
+	assert.Equal(t, consul.PowerStatusStandby, testServer.getPowerStates()[0].Status)
+	assert.True(t, testServer.isDrainingNode("pve1"), "the drain keeps guests off the node in standby")
+
+	require.NoError(t, testServer.managePower(now.Add(2*time.Hour)))
+	assert.Len(t, testServer.drainStates(), 1, "three of four nodes online is the quorum limit")
+
+	// 3 x 48 GiB on the two nodes left after a failure
+	cluster.set(func() { cluster.memGiB = 48 })
+	require.NoError(t, testServer.managePower(now.Add(3*time.Hour)))
+	assert.Equal(t, "POST /api2/json/nodes/pve1/wakeonlan", cluster.recorder.mutations()[1])
+	assert.Equal(t, consul.PowerStatusWaking, testServer.getPowerStates()[0].Status)
+
+	require.NoError(t, testServer.managePower(now.Add(3*time.Hour+time.Minute)))
+	assert.Empty(t, testServer.getPowerStates())
+	assert.Empty(t, testServer.drainStates(), "the woken node takes guests again")
+	assert.Len(t, cluster.recorder.mutations(), 2)
+}
+
+func TestManagePowerLimits(t *testing.T) {
+	t.Run("cooldown", func(t *testing.T) {
+		cluster := &powerTestCluster{offline: make(map[string]bool), memGiB: 8}
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		testServer.config.Power.Enabled = true
+
+		now := time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)
+		testServer.powerChangedAt = now.Add(-time.Minute)
+
+		require.NoError(t, testServer.managePower(now))
+		assert.Empty(t, testServer.drainStates())
+	})
+
+	t.Run("min online nodes", func(t *testing.T) {
+		cluster := &powerTestCluster{offline: make(map[string]bool), memGiB: 8}
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		testServer.config.Power.Enabled = true
+		testServer.config.Power.MinOnlineNodes = 4
+
+		require.NoError(t, testServer.managePower(time.Now()))
+		assert.Empty(t, testServer.drainStates())
+	})
+
+	t.Run("blocked nodes", func(t *testing.T) {
+		cluster := &powerTestCluster{offline: make(map[string]bool), memGiB: 8, guests: `
+			{"id": "qemu/103", "type": "qemu", "vmid": 103, "node": "pve2", "status": "running", "tags": "crs-skip"},
+			{"id": "qemu/104", "type": "qemu", "vmid": 104, "node": "pve1", "status": "running"}`}
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		testServer.config.Power.Enabled = true
+		testServer.config.Power.Nodes = []string{"pve1", "pve2", "pve3"}
+
+		require.NoError(t, testServer.managePower(time.Now()))
+		require.Len(t, testServer.drainStates(), 1)
+		assert.Equal(t, "pve3", testServer.drainStates()[0].Node, "pinned and skipped guests are never drained for power, pve4 is not listed")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cluster := &powerTestCluster{offline: map[string]bool{"pve4": true}, memGiB: 8}
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		testServer.drains = map[string]*consul.DrainState{
+			"pve3": {Node: "pve3", Status: consul.DrainStatusDraining, Origin: powerOrigin},
+			"pve4": {Node: "pve4", Status: consul.DrainStatusDrained, Origin: powerOrigin},
+		}
+		testServer.powerStates = map[string]*consul.PowerState{
+			"pve4": {Node: "pve4", Status: consul.PowerStatusStandby},
+		}
+
+		require.NoError(t, testServer.managePower(time.Now()))
+		assert.Equal(t, []string{"POST /api2/json/nodes/pve4/wakeonlan"}, cluster.recorder.mutations())
+		assert.Equal(t, []string{"pve4"}, sortedDrainNodes(testServer.drains), "the pending shutdown is given up")
+	})
+}
+
+func TestPowerHeadroomLoad(t *testing.T) {
+	node := proxmox.ClusterResource{MaxCPU: 8, MaxMem: 64 * gib}
+	small := proxmox.ClusterResource{MaxCPU: 4, MaxMem: 32 * gib}
+
+	assert.True(t, math.IsInf(powerHeadroomLoad([]proxmox.ClusterResource{node}, 1, gib), 1))
+	assert.InDelta(t, 0.5, powerHeadroomLoad([]proxmox.ClusterResource{node, node, node}, 4, 64*gib), 0.001, "memory is the tighter resource")
+	assert.InDelta(t, 0.75, powerHeadroomLoad([]proxmox.ClusterResource{node, small}, 3, 8*gib), 0.001, "the largest node fails")
+}
+
+func TestValidatePowerConfig(t *testing.T) {
+	config := consul.PowerConfig{Enabled: true}
+	require.NoError(t, validatePowerConfig(&config))
+	assert.Equal(t, consul.PowerConfig{
+		Enabled:        true,
+		MinOnlineNodes: powerDefaultMinOnlineNodes,
+		LowLoad:        powerDefaultLowLoad,
+		HighLoad:       powerDefaultHighLoad,
+		Cooldown:       powerDefaultCooldown,
+	}, config)
+
+	assert.Error(t, validatePowerConfig(&consul.PowerConfig{MinOnlineNodes: 1}))
+	assert.Error(t, validatePowerConfig(&consul.PowerConfig{LowLoad: 0.8, HighLoad: 0.6}))
+	assert.Error(t, validatePowerConfig(&consul.PowerConfig{HighLoad: 1.2}))
+	assert.Error(t, validatePowerConfig(&consul.PowerConfig{Cooldown: -1}))
+}
+
+func TestManagePowerDoesNotBlockDrains(t *testing.T) {
+	cluster := &powerTestCluster{offline: make(map[string]bool), memGiB: 8}
+	entered, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+
+	config := cluster.config()
+	config.cluster = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/api2/json/cluster/resources" {
+			once.Do(func() {
+				close(entered)
+				<-release
+			})
+		}
+		return cluster.serve(w, r)
+	}
+
+	testServer, mockServer := createTestServerWithConfig(config)
+	defer mockServer.Close()
+	testServer.drains = map[string]*consul.DrainState{
+		"pve3": {Node: "pve3", Status: consul.DrainStatusDraining, Origin: powerOrigin},
+	}
+
+	stepped := make(chan error)
+	go func() { stepped <- testServer.managePower(time.Now()) }()
+	<-entered
+
+	// The power drain is replaced by an admin drain while the step waits for the Proxmox API
+	found, err := testServer.cancelDrain("pve3")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	testServer.drainMu.Lock()
+	_, err = testServer.addDrain(&consul.DrainState{Node: "pve3", Origin: "admin API"}, "drain requested")
+	testServer.drainMu.Unlock()
+	require.NoError(t, err)
+
+	close(release)
+	require.NoError(t, <-stepped)
+	require.Len(t, testServer.drainStates(), 1, "disabled power management leaves the new drain alone")
+	assert.Equal(t, "admin API", testServer.drainStates()[0].Origin)
+}
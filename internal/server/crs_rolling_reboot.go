@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+)
+
+// rebootTimings are the poll interval and the time limits of the steps of a rolling reboot
+type rebootTimings struct {
+	poll  time.Duration
+	drain time.Duration // Draining the node, and returning its VMs after the reboot
+	down  time.Duration // The node going offline or its uptime going back after the reboot request
+	up    time.Duration // The node coming back online with quorum and an active HA LRM
+}
+
+// RollingReboot reboots nodes one at a time, all nodes in name order when nodes is empty.
+// Each node is drained, rebooted, waited for until it is back online with quorum and HA active,
+// and gets its displaced VMs back before the next node starts. Any failure or a degraded cluster
+// stops the reboot and leaves the drain of the current node in place.
+func (s *Server) RollingReboot(ctx context.Context, nodes []string) error {
+	return s.rollingReboot(ctx, nodes, rebootTimings{
+		poll:  rebootPollInterval,
+		drain: rebootDrainTimeout,
+		down:  rebootDownTimeout,
+		up:    rebootUpTimeout,
+	})
+}
+
+func (s *Server) rollingReboot(ctx context.Context, nodes []string, timings rebootTimings) error {
+	s.loadConfig()
+
+	order, err := s.rebootOrder(nodes)
+	if err != nil {
+		return err
+	}
+
+	// The node of a stopped rolling reboot may still be drained in HA maintenance
+	resumed := ""
+	for _, state := range s.drainStates() {
+		if state.Origin == rebootOrigin {
+			resumed = state.Node
+		}
+	}
+
+	if err := s.checkRebootHealth("", resumed); err != nil {
+		return fmt.Errorf("cluster is not healthy, not starting the rolling reboot: %w", err)
+	}
+
+	for i, node := range order {
+		logging.Infof("Rolling reboot: node %s (%d of %d)", node, i+1, len(order))
+
+		if err := s.rebootNode(ctx, node, timings); err != nil {
+			return fmt.Errorf("rolling reboot stopped at node %s: %w", node, err)
+		}
+	}
+
+	logging.Infof("Rolling reboot of %d node(s) finished", len(order))
+	return nil
+}
+
+// rebootOrder returns the nodes to reboot, checking that each is part of the cluster
+func (s *Server) rebootOrder(nodes []string) ([]string, error) {
+	clusterNodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	known := make([]string, 0, len(clusterNodes))
+	for _, node := range clusterNodes {
+		known = append(known, node.Node)
+	}
+	slices.Sort(known)
+
+	if len(nodes) == 0 {
+		return known, nil
+	}
+
+	order := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if !slices.Contains(known, node) {
+			return nil, fmt.Errorf("%w: %s", errNodeNotFound, node)
+		}
+
+		if !slices.Contains(order, node) {
+			order = append(order, node)
+		}
+	}
+
+	return order, nil
+}
+
+// rebootNode drains, reboots and restores one node
+func (s *Server) rebootNode(ctx context.Context, node string, timings rebootTimings) error {
+	if err := s.startRebootDrain(node); err != nil {
+		return err
+	}
+
+	err := waitRebootStep(ctx, timings.poll, timings.drain, "the drain", func() (bool, error) {
+		// Each poll is a drain step of its own, like a reconcile cycle
+		s.startCycleBudget()
+
+		// The node goes into HA maintenance once it is drained
+		if err := s.checkRebootHealth("", node); err != nil {
+			return false, fmt.Errorf("cluster health degraded while draining: %w", err)
+		}
+
+		if err := s.DrainNodes(); err != nil {
+			return false, err
+		}
+
+		state, err := s.rebootDrainState(node)
+		switch {
+		case err != nil:
+			return false, err
+		case state.Status == consul.DrainStatusDrained:
+			return true, nil
+		case len(state.InFlight) == 0 && len(state.Blockers) > 0:
+			reasons := make([]string, 0, len(state.Blockers))
+			for _, blocker := range state.Blockers {
+				reasons = append(reasons, fmt.Sprintf("%d (%s): %s", blocker.VMID, blocker.Name, blocker.Reason))
+			}
+			return false, fmt.Errorf("guests block the drain: %s", strings.Join(reasons, "; "))
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The uptime going back shows the reboot of a node that is never seen offline
+	status, err := s.proxmox.GetNode(node)
+	if err != nil {
+		return err
+	}
+	uptime := status.Uptime
+
+	_, err = s.applyAction(Action{
+		Kind:     ActionReboot,
+		Resource: ResourceNode,
+		ID:       node,
+		Node:     node,
+		Reason:   rebootOrigin,
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Warnf("Node %s is rebooting", node)
+
+	err = waitRebootStep(ctx, timings.poll, timings.down, "the node to restart", func() (bool, error) {
+		online, err := s.isClusterNodeOnline(node)
+		if err != nil {
+			// The API may be served by the rebooting node
+			logging.Warnf("Waiting for node %s to restart: %v", node, err)
+			return false, nil
+		}
+
+		if !online {
+			return true, nil
+		}
+
+		if status, err := s.proxmox.GetNode(node); err == nil && status.Uptime < uptime {
+			logging.Infof("Node %s restarted, uptime went back from %ds to %ds", node, uptime, status.Uptime)
+			return true, nil
+		}
+
+		if err := s.checkRebootHealth(node, ""); err != nil {
+			return false, fmt.Errorf("cluster health degraded during the reboot: %w", err)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = waitRebootStep(ctx, timings.poll, timings.up, "the node to come back", func() (bool, error) {
+		online, err := s.isClusterNodeOnline(node)
+		switch {
+		case err != nil:
+			logging.Warnf("Waiting for node %s to come back: %v", node, err)
+			return false, nil
+		case !online:
+			return false, nil
+		}
+
+		// Quorum and the HA LRM of the node follow shortly after the node is online, it stays in HA maintenance until its drain ends
+		if err := s.checkRebootHealth("", node); err != nil {
+			logging.Infof("Node %s is online, waiting for the cluster: %v", node, err)
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Infof("Node %s is back online with quorum and HA running, returning its VMs", node)
+
+	if err := s.endRebootDrain(node); err != nil {
+		return err
+	}
+
+	return waitRebootStep(ctx, timings.poll, timings.drain, "the VMs to return", func() (bool, error) {
+		s.startCycleBudget()
+
+		// The node leaves HA maintenance before its VMs return
+		if err := s.checkRebootHealth("", node); err != nil {
+			return false, fmt.Errorf("cluster health degraded while returning VMs: %w", err)
+		}
+
+		// The returning VMs are re-homed once their migration finished
+		if err := s.CheckMigrations(); err != nil {
+			return false, err
+		}
+
+		if err := s.DrainNodes(); err != nil {
+			return false, err
+		}
+
+		s.drainMu.Lock()
+		_, ok := s.drains[node]
+		s.drainMu.Unlock()
+
+		if ok {
+			return false, nil
+		}
+
+		// The node is done once it left HA maintenance and its LRM is active again
+		if err := s.checkRebootHealth("", ""); err != nil {
+			logging.Infof("Displaced VMs of node %s are back, waiting for its HA LRM: %v", node, err)
+			return false, nil
+		}
+
+		return true, nil
+	})
+}
+
+// startRebootDrain drains node for the reboot. A drain left by a stopped rolling reboot is resumed,
+// any other drain stops the reboot, so only one node is ever out of service.
+func (s *Server) startRebootDrain(node string) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	for _, other := range sortedDrainNodes(s.drains) {
+		state := s.drains[other]
+		if other != node || state.Origin != rebootOrigin {
+			return fmt.Errorf("node %s is drained by %s, end that drain first", other, state.Origin)
+		}
+	}
+
+	if state, ok := s.drains[node]; ok {
+		logging.Infof("Resuming the drain of node %s left by a previous rolling reboot", node)
+		if state.Status != consul.DrainStatusRestoring {
+			return nil
+		}
+
+		// The node is drained and rebooted again, its displaced VMs return afterwards
+		state.Status = consul.DrainStatusDraining
+		return s.putDrainState(state)
+	}
+
+	_, err := s.addDrain(&consul.DrainState{Node: node, Origin: rebootOrigin, Restore: true}, "rolling reboot")
+	return err
+}
+
+// endRebootDrain ends the drain of a rebooted node, DrainNodes then returns its displaced VMs
+func (s *Server) endRebootDrain(node string) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	state, ok := s.drains[node]
+	if !ok {
+		return fmt.Errorf("drain of node %s was cancelled", node)
+	}
+
+	state.Status = consul.DrainStatusRestoring
+	return s.putDrainState(state)
+}
+
+// rebootDrainState returns a copy of the drain of node, or an error when it was cancelled
+func (s *Server) rebootDrainState(node string) (*consul.DrainState, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	state, ok := s.drains[node]
+	if !ok {
+		return nil, fmt.Errorf("drain of node %s was cancelled", node)
+	}
+
+	return cloneDrainState(state), nil
+}
+
+// isClusterNodeOnline reports whether corosync sees node as online
+func (s *Server) isClusterNodeOnline(node string) (bool, error) {
+	nodes, err := s.proxmox.GetClusterNodes()
+	if err != nil {
+		return false, err
+	}
+
+	for _, clusterNode := range nodes {
+		if clusterNode.Name == node {
+			return clusterNode.Online == 1, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkRebootHealth returns why the cluster is not healthy enough for a rolling reboot to go on:
+// the health gate of the reconcile cycle holds, an HA service is in error,
+// or a node other than skip is offline or has no active HA LRM. Only the LRM of drained,
+// the node the rolling reboot drains, may be in maintenance.
+func (s *Server) checkRebootHealth(skip, drained string) error {
+	problem, err := s.clusterHealthProblem()
+	if err != nil {
+		return err
+	}
+
+	if problem != "" {
+		return errors.New(problem)
+	}
+
+	nodes, err := s.proxmox.GetClusterNodes()
+	if err != nil {
+		return err
+	}
+
+	status, err := s.proxmox.GetClusterHAStatus()
+	if err != nil {
+		return err
+	}
+
+	lrms := make(map[string]string)
+	services := make(map[string]int)
+	for _, entry := range status {
+		switch entry.Type {
+		case "lrm":
+			lrms[entry.Node] = entry.Status
+		case "service":
+			services[entry.Node]++
+			if entry.State == haStateError {
+				return fmt.Errorf("HA service %s on node %s is in state %s", entry.ID, entry.Node, entry.State)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if node.Name == skip {
+			continue
+		}
+
+		if node.Online != 1 {
+			return fmt.Errorf("node %s is offline", node.Name)
+		}
+
+		lrm := lrms[node.Name]
+		if !isLRMActive(lrm, services[node.Name]) && (node.Name != drained || !strings.Contains(lrm, "(maintenance")) {
+			return fmt.Errorf("HA LRM of node %s is not active: %q", node.Name, lrm)
+		}
+	}
+
+	return nil
+}
+
+// isLRMActive reports whether an LRM status like "pve1 (active, Mon Oct 12 10:00:00 2026)" is active.
+// The LRM of a node without HA services stays idle.
+func isLRMActive(status string, services int) bool {
+	return strings.Contains(status, "(active") || (services == 0 && strings.Contains(status, "(idle"))
+}
+
+// waitRebootStep calls check every poll until it reports done, fails or timeout passes
+func waitRebootStep(ctx context.Context, poll, timeout time.Duration, what string, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		done, err := check()
+		switch {
+		case err != nil:
+			return err
+		case done:
+			return nil
+		case time.Now().After(deadline):
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, what)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
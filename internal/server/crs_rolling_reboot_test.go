@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+var testRebootTimings = rebootTimings{poll: time.Millisecond, drain: time.Second, down: time.Second, up: time.Second}
+
+// rebootTestCluster extends drainTestCluster with the cluster and HA manager status. Migrations move guests,
+// re-homing changes HA groups, HA maintenance sets the LRM status and a rebooted node is offline for a few status requests.
+type rebootTestCluster struct {
+	*drainTestCluster
+	groups     map[int]string    // HA group by VMID of VMs 100 and 101
+	lrms       map[string]string // LRM status by node, active when not listed
+	quorate    bool
+	rebooting  string
+	downPolls  int
+	rebooted   bool // The rebooting node reports a lower uptime
+	fastReboot bool // The rebooting node is never offline
+}
+
+func newRebootTestCluster() *rebootTestCluster {
+	return &rebootTestCluster{
+		drainTestCluster: &drainTestCluster{done: make(map[string]string), moved: make(map[int]string), finish: true},
+		groups:           map[int]string{100: "crs-vm-prefer-pve1", 101: "crs-vm-prefer-pve2"},
+		lrms:             make(map[string]string),
+		quorate:          true,
+	}
+}
+
+func (c *rebootTestCluster) online(node string) bool {
+	return node != c.rebooting || c.downPolls == 0
+}
+
+func (c *rebootTestCluster) serve(w http.ResponseWriter, r *http.Request) bool {
+	path := r.URL.Path
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/migrate"):
+		r.ParseForm()
+		c.drainTestCluster.serve(w, r)
+
+		parts := strings.Split(path, "/")
+		vmid, _ := strconv.Atoi(parts[len(parts)-2])
+
+		c.mu.Lock()
+		c.moved[vmid] = r.PostForm.Get("target")
+		c.mu.Unlock()
+		return true
+	case r.Method == http.MethodGet && path == "/api2/json/cluster/ha/resources":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		fmt.Fprintf(w, `{"data": [
+			{"sid": "vm:100", "group": %q, "state": "started"},
+			{"sid": "vm:101", "group": %q, "state": "started"},
+			{"sid": "vm:104", "group": "crs-vm-pin-pve1", "state": "started"}
+		]}`, c.groups[100], c.groups[101])
+		return true
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/api2/json/cluster/ha/resources/vm:"):
+		r.ParseForm()
+		vmid, _ := strconv.Atoi(strings.TrimPrefix(path, "/api2/json/cluster/ha/resources/vm:"))
+
+		c.mu.Lock()
+		c.groups[vmid] = r.PostForm.Get("group")
+		c.mu.Unlock()
+		return false
+	case strings.HasPrefix(path, "/api2/json/cluster/ha/crm-command/node-maintenance/"):
+		r.ParseForm()
+
+		c.mu.Lock()
+		if strings.HasSuffix(path, "/enable") {
+			c.lrms[r.PostForm.Get("node")] = "maintenance"
+		} else {
+			delete(c.lrms, r.PostForm.Get("node"))
+		}
+		c.mu.Unlock()
+		return false
+	}
+
+	c.mu.Lock()
+
+	switch {
+	case path == "/api2/json/cluster/status":
+		defer c.mu.Unlock()
+
+		entries := []string{fmt.Sprintf(`{"type": "cluster", "name": "lab", "quorate": %d}`, boolToInt(c.quorate))}
+		for _, node := range []string{"pve1", "pve2", "pve3"} {
+			entries = append(entries, fmt.Sprintf(`{"type": "node", "name": %q, "online": %d}`, node, boolToInt(c.online(node))))
+		}
+
+		if c.downPolls > 0 {
+			c.downPolls--
+		}
+
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(entries, ","))
+	case path == "/api2/json/cluster/ha/status/current":
+		defer c.mu.Unlock()
+
+		entries := []string{
+			fmt.Sprintf(`{"id": "quorum", "type": "quorum", "quorate": %d}`, boolToInt(c.quorate)),
+			`{"id": "master", "type": "master", "node": "pve1", "status": "pve1 (active, Fri Oct 16 10:00:00 2026)"}`,
+			`{"id": "service:vm:101", "type": "service", "node": "pve2", "state": "started"}`,
+		}
+		for _, node := range []string{"pve1", "pve2", "pve3"} {
+			status := node + " (active, Fri Oct 16 10:00:00 2026)"
+			if lrm, ok := c.lrms[node]; ok {
+				status = node + " (" + lrm + ", Fri Oct 16 10:00:00 2026)"
+			}
+			if !c.online(node) {
+				status = node + " (old timestamp - dead?, Fri Oct 16 09:00:00 2026)"
+			}
+			entries = append(entries, fmt.Sprintf(`{"id": "lrm:%s", "type": "lrm", "node": %q, "status": %q}`, node, node, status))
+		}
+
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(entries, ","))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/api2/json/nodes/") && strings.Count(path, "/") == 5 && strings.HasSuffix(path, "/status"):
+		defer c.mu.Unlock()
+
+		uptime := 86400
+		if node := strings.Split(path, "/")[4]; node == c.rebooting && c.rebooted {
+			uptime = 30
+		}
+
+		fmt.Fprintf(w, `{"data": {"uptime": %d}}`, uptime)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/status"):
+		defer c.mu.Unlock()
+
+		c.rebooting = strings.Split(path, "/")[4]
+		c.rebooted = true
+		if !c.fastReboot {
+			c.downPolls = 3
+		}
+		return false
+	default:
+		c.mu.Unlock()
+		return c.drainTestCluster.serve(w, r)
+	}
+
+	return true
+}
+
+// config serves the cluster through the mock API, its recorder collects the requests
+func (c *rebootTestCluster) config() testHandlerConfig {
+	return testHandlerConfig{cluster: c.serve, recorder: &c.recorder}
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func TestRollingReboot(t *testing.T) {
+	cluster := newRebootTestCluster()
+	cluster.moved[101] = "pve2"
+
+	config := cluster.config()
+	config.consulKV = map[string]string{"crs/config/budget": `{"max_per_cycle": 1}`}
+
+	testServer, mockServer := createTestServerWithConfig(config)
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.rollingReboot(context.Background(), []string{"pve2"}, testRebootTimings))
+
+	assert.Nil(t, testServer.getBreakerState(), "every poll has a change budget of its own")
+	assert.Empty(t, testServer.drainStates(), "the drain ends once the displaced VMs are back")
+	assert.Equal(t, "pve2", cluster.moved[101], "the displaced VM returned to the rebooted node")
+	assert.Equal(t, "crs-vm-prefer-pve2", cluster.groups[101])
+
+	assert.Equal(t, []string{
+		"POST /api2/json/nodes/pve2/qemu/101/migrate",
+		"PUT /api2/json/cluster/ha/resources/vm:101",
+		"POST /api2/json/cluster/ha/crm-command/node-maintenance/enable",
+		"POST /api2/json/nodes/pve2/status",
+		"POST /api2/json/cluster/ha/crm-command/node-maintenance/disable",
+		"POST /api2/json/nodes/pve1/qemu/101/migrate",
+		"PUT /api2/json/cluster/ha/resources/vm:101",
+	}, cluster.recorder.mutations(), "the drained node goes into HA maintenance before the reboot and leaves it before its VMs return")
+}
+
+func TestRollingRebootWithoutOfflineSample(t *testing.T) {
+	cluster := newRebootTestCluster()
+	cluster.fastReboot = true
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.rollingReboot(context.Background(), []string{"pve3"}, testRebootTimings))
+	assert.Contains(t, cluster.recorder.mutations(), "POST /api2/json/nodes/pve3/status")
+	assert.Empty(t, testServer.drainStates())
+}
+
+func TestRollingRebootStops(t *testing.T) {
+	t.Run("unhealthy cluster", func(t *testing.T) {
+		cluster := newRebootTestCluster()
+		cluster.quorate = false
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+
+		err := testServer.rollingReboot(context.Background(), nil, testRebootTimings)
+		require.ErrorContains(t, err, "cluster is not quorate")
+		assert.Empty(t, cluster.recorder.mutations())
+	})
+
+	t.Run("unknown node", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(newRebootTestCluster().config())
+		defer mockServer.Close()
+
+		err := testServer.rollingReboot(context.Background(), []string{"pve9"}, testRebootTimings)
+		assert.ErrorIs(t, err, errNodeNotFound)
+	})
+
+	t.Run("another drain", func(t *testing.T) {
+		cluster := newRebootTestCluster()
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+		require.NoError(t, testServer.putDrainState(&consul.DrainState{Node: "pve3", Status: consul.DrainStatusDrained, Origin: "admin API"}))
+
+		err := testServer.rollingReboot(context.Background(), []string{"pve2"}, testRebootTimings)
+		require.ErrorContains(t, err, "node pve3 is drained by admin API")
+		assert.Empty(t, cluster.recorder.mutations())
+	})
+
+	t.Run("blocked drain", func(t *testing.T) {
+		cluster := newRebootTestCluster()
+		testServer, mockServer := createTestServerWithConfig(cluster.config())
+		defer mockServer.Close()
+
+		err := testServer.rollingReboot(context.Background(), nil, testRebootTimings)
+		require.ErrorContains(t, err, "rolling reboot stopped at node pve1: guests block the drain")
+		assert.NotContains(t, cluster.recorder.mutations(), "POST /api2/json/nodes/pve1/status", "the node is not rebooted")
+		require.Len(t, testServer.drainStates(), 1, "the drain stays in place")
+		assert.Equal(t, rebootOrigin, testServer.drainStates()[0].Origin)
+	})
+}
+
+func TestCheckRebootHealth(t *testing.T) {
+	cluster := newRebootTestCluster()
+	testServer, mockServer := createTestServerWithConfig(cluster.config())
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.checkRebootHealth("", ""))
+
+	cluster.lrms["pve2"] = "maintenance"
+	assert.ErrorContains(t, testServer.checkRebootHealth("", ""), "HA LRM of node pve2 is not active")
+	assert.NoError(t, testServer.checkRebootHealth("", "pve2"), "the drained node may be in maintenance")
+	assert.ErrorContains(t, testServer.checkRebootHealth("", "pve3"), "HA LRM of node pve2 is not active")
+
+	cluster.lrms["pve2"], cluster.lrms["pve3"] = "idle", "idle"
+	assert.ErrorContains(t, testServer.checkRebootHealth("", ""), "HA LRM of node pve2 is not active", "an LRM with services must be active")
+	delete(cluster.lrms, "pve2")
+	assert.NoError(t, testServer.checkRebootHealth("", ""), "an LRM without services is idle")
+
+	cluster.rebooting, cluster.downPolls = "pve3", 100
+	assert.ErrorContains(t, testServer.checkRebootHealth("", ""), "node pve3 is offline")
+	assert.NoError(t, testServer.checkRebootHealth("pve3", ""), "the rebooting node is skipped")
+	assert.ErrorContains(t, testServer.checkRebootHealth("pve2", ""), "node pve3 is offline")
+}
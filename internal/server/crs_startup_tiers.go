@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// Causes of startup settings that still diverge from the tier after a cycle, the reason label of crs_startup_diverged_guests
+const (
+	startupDivergedUpdateFailed  = "update_failed"
+	startupDivergedSkipped       = "skipped"
+	startupDivergedUndefinedTier = "undefined_tier"
+)
+
+// builtinStartupTiers returns the startup tiers available without configuration, tier N starts with order N
+func builtinStartupTiers() map[string]consul.StartupTier {
+	tiers := make(map[string]consul.StartupTier, startupBuiltinTiers)
+	for order := 1; order <= startupBuiltinTiers; order++ {
+		tiers[strconv.Itoa(order)] = consul.StartupTier{Order: order}
+	}
+
+	return tiers
+}
+
+// validateStartupTier checks the name and startup setting of a startup tier
+func validateStartupTier(name string, tier consul.StartupTier) error {
+	if !configNameRe.MatchString(name) {
+		return fmt.Errorf("name %q must consist of lowercase letters, digits and dashes", name)
+	}
+
+	if tier.Order < 1 {
+		return fmt.Errorf("order must be at least 1, got %d", tier.Order)
+	}
+
+	for field, value := range map[string]int{"up": tier.Up, "down": tier.Down} {
+		if value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", field, value)
+		}
+	}
+
+	return nil
+}
+
+// loadStartupTiersConfig merges the tiers from Consul over the built-in ones.
+// An invalid tier keeps its previous definition.
+func (s *Server) loadStartupTiersConfig(config *crsConfig) {
+	tiers, err := s.consul.GetStartupTiers()
+	if err != nil {
+		logging.Errorf("Failed to load startup tiers, keeping previous settings: %v", err)
+		return
+	}
+
+	loaded := builtinStartupTiers()
+	for name, tier := range tiers {
+		if err := validateStartupTier(name, tier); err != nil {
+			logging.Errorf("Invalid startup tier %s, keeping previous settings: %v", name, err)
+			if previous, ok := config.StartupTiers[name]; ok {
+				loaded[name] = previous
+			}
+			continue
+		}
+
+		loaded[name] = tier
+	}
+
+	config.StartupTiers = loaded
+}
+
+// startupSetting returns the Proxmox startup setting of a tier, like order=2,up=60,down=120
+func startupSetting(tier consul.StartupTier) string {
+	setting := "order=" + strconv.Itoa(tier.Order)
+	if tier.Up > 0 {
+		setting += ",up=" + strconv.Itoa(tier.Up)
+	}
+	if tier.Down > 0 {
+		setting += ",down=" + strconv.Itoa(tier.Down)
+	}
+
+	return setting
+}
+
+// sameStartup reports whether two Proxmox startup settings are equal regardless of the order of their options
+func sameStartup(a, b string) bool {
+	split := func(setting string) []string {
+		var options []string
+		for _, option := range strings.Split(setting, ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+		slices.Sort(options)
+		return options
+	}
+
+	return slices.Equal(split(a), split(b))
+}
+
+// parseStartupTierTag returns the tier of the first crs-tier-<tier> tag, or an empty string
+func parseStartupTierTag(tags string) string {
+	for _, tag := range strings.Split(tags, ";") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, crsStartupTierTagPrefix) && len(tag) > len(crsStartupTierTagPrefix) {
+			return strings.TrimPrefix(tag, crsStartupTierTagPrefix)
+		}
+	}
+
+	return ""
+}
+
+// guestStartup returns the startup setting CRS keeps on a VM or container and why, empty when CRS leaves it alone.
+// A VM policy wins over the crs-tier-<tier> tag, which wins over the crs-critical tag.
+func (s *Server) guestStartup(resource proxmox.ClusterResource, policy *vmPolicy) (string, string) {
+	kind := "VM"
+	if resource.Type == ctResourceType {
+		kind = "container"
+	}
+
+	switch {
+	case policy.startup() != "":
+		return policy.startup(), "startup_order in VM policy"
+	case policy != nil && policy.StartupTier != "":
+		// Policies naming an undefined tier are rejected by policyFromConfig
+		return startupSetting(s.currentConfig().StartupTiers[policy.StartupTier]), "startup_tier " + policy.StartupTier + " in VM policy"
+	}
+
+	if name := parseStartupTierTag(resource.Tags); name != "" {
+		if tier, ok := s.currentConfig().StartupTiers[name]; ok {
+			return startupSetting(tier), kind + " has " + crsStartupTierTagPrefix + name + " tag"
+		}
+
+		logging.Warnf("%s %d (%s) has tag %s%s but the startup tier is not defined", kind, resource.VMID, resource.Name, crsStartupTierTagPrefix, name)
+	}
+
+	if s.hasVMCriticalTag(resource.Tags) {
+		return vmStartupCriticalOrder, kind + " has " + crsCriticalTag + " tag"
+	}
+
+	return "", ""
+}
+
+// startupTierUndefined reports whether a guest has a crs-tier-<tier> tag of an undefined tier and no VM policy sets its startup
+func (s *Server) startupTierUndefined(resource proxmox.ClusterResource, policy *vmPolicy) bool {
+	if policy.startup() != "" || (policy != nil && policy.StartupTier != "") {
+		return false
+	}
+
+	name := parseStartupTierTag(resource.Tags)
+	_, ok := s.currentConfig().StartupTiers[name]
+	return name != "" && !ok
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestValidateStartupTier(t *testing.T) {
+	assert.NoError(t, validateStartupTier("infra", consul.StartupTier{Order: 1, Up: 60, Down: 120}))
+	assert.NoError(t, validateStartupTier("2", consul.StartupTier{Order: 2}))
+	assert.ErrorContains(t, validateStartupTier("Infra", consul.StartupTier{Order: 1}), "lowercase letters")
+	assert.ErrorContains(t, validateStartupTier("infra", consul.StartupTier{}), "order must be at least 1, got 0")
+	assert.ErrorContains(t, validateStartupTier("infra", consul.StartupTier{Order: 1, Down: -5}), "down must not be negative")
+}
+
+func TestStartupSetting(t *testing.T) {
+	assert.Equal(t, "order=3", startupSetting(consul.StartupTier{Order: 3}))
+	assert.Equal(t, "order=1,up=60,down=120", startupSetting(consul.StartupTier{Order: 1, Up: 60, Down: 120}))
+	assert.Equal(t, "order=1,down=120", startupSetting(consul.StartupTier{Order: 1, Down: 120}))
+
+	assert.True(t, sameStartup("order=1,up=60", "up=60, order=1"))
+	assert.True(t, sameStartup("", ""))
+	assert.False(t, sameStartup("order=1", "order=1,up=60"))
+	assert.False(t, sameStartup("", "order=1"))
+
+	assert.Len(t, builtinStartupTiers(), 5)
+	assert.Equal(t, consul.StartupTier{Order: 4}, builtinStartupTiers()["4"])
+}
+
+func TestParseStartupTierTag(t *testing.T) {
+	assert.Empty(t, parseStartupTierTag(""))
+	assert.Empty(t, parseStartupTierTag("crs-critical;crs-tier-"))
+	assert.Equal(t, "2", parseStartupTierTag("production; crs-tier-2"))
+	assert.Equal(t, "infra", parseStartupTierTag("crs-tier-infra;crs-tier-1"), "the first tier tag wins")
+}
+
+func TestGuestStartup(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+	testServer.config.StartupTiers["infra"] = consul.StartupTier{Order: 1, Up: 60}
+
+	tests := []struct {
+		name     string
+		resource proxmox.ClusterResource
+		policy   *vmPolicy
+		startup  string
+		reason   string
+	}{
+		{
+			name:     "untagged",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100},
+		},
+		{
+			name:     "critical",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100, Tags: "crs-critical"},
+			startup:  "order=1",
+			reason:   "VM has crs-critical tag",
+		},
+		{
+			name:     "tier wins over critical",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100, Tags: "crs-critical;crs-tier-infra"},
+			startup:  "order=1,up=60",
+			reason:   "VM has crs-tier-infra tag",
+		},
+		{
+			name:     "built-in tier of a container",
+			resource: proxmox.ClusterResource{Type: ctResourceType, VMID: 101, Tags: "crs-tier-4"},
+			startup:  "order=4",
+			reason:   "container has crs-tier-4 tag",
+		},
+		{
+			name:     "undefined tier falls back to critical",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100, Tags: "crs-critical;crs-tier-db"},
+			startup:  "order=1",
+			reason:   "VM has crs-critical tag",
+		},
+		{
+			name:     "policy tier wins over the tag",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100, Tags: "crs-tier-5"},
+			policy:   &vmPolicy{StartupTier: "infra"},
+			startup:  "order=1,up=60",
+			reason:   "startup_tier infra in VM policy",
+		},
+		{
+			name:     "policy order",
+			resource: proxmox.ClusterResource{Type: vmResourceType, VMID: 100, Tags: "crs-tier-infra"},
+			policy:   &vmPolicy{StartupOrder: intValue(7)},
+			startup:  "order=7",
+			reason:   "startup_order in VM policy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startup, reason := testServer.guestStartup(tt.resource, tt.policy)
+			assert.Equal(t, tt.startup, startup)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+// startupTestGuest is VM 700 with the given tags, description and startup setting
+func startupTestGuest(tags, description, startup string) *guestFixture {
+	return &guestFixture{
+		vmid:   700,
+		name:   "dns",
+		tags:   tags,
+		config: map[string]any{"description": description, "startup": startup, "scsi0": "ceph:vm-700-disk-0"},
+	}
+}
+
+func TestUpdateVMMetaStartupTiers(t *testing.T) {
+	t.Run("guests get the startup setting of their tier", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("crs-tier-infra", "", "order=9")})
+		defer mockServer.Close()
+
+		testServer.config.StartupTiers["infra"] = consul.StartupTier{Order: 1, Up: 60, Down: 120}
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.UpdateVMMeta())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		vm := testServer.plan.Actions[0]
+		assert.Equal(t, ResourceVMConfig, vm.Resource)
+		assert.Equal(t, "startup=order=9", vm.Before)
+		assert.Equal(t, "startup=order=1,up=60,down=120", vm.After)
+		assert.Equal(t, "VM has crs-tier-infra tag", vm.Reason)
+	})
+
+	t.Run("containers get the startup setting of their tier", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: &guestFixture{
+			vmid:      701,
+			guestType: ctResourceType,
+			name:      "app",
+			tags:      "crs-tier-3",
+			config:    map[string]any{"memory": 512, "rootfs": "ceph:vm-701-disk-0,size=4G"},
+		}})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.UpdateVMMeta())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		container := testServer.plan.Actions[0]
+		assert.Equal(t, ResourceCTConfig, container.Resource)
+		assert.Equal(t, 701, container.VMID)
+		assert.Equal(t, "startup=order=3", container.After)
+		assert.Equal(t, "container has crs-tier-3 tag", container.Reason)
+	})
+
+	t.Run("matching setting in another option order is kept", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("crs-tier-2", "", "up=30,order=2")})
+		defer mockServer.Close()
+
+		testServer.config.StartupTiers["2"] = consul.StartupTier{Order: 2, Up: 30}
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.UpdateVMMeta())
+		assert.Empty(t, testServer.plan.Actions)
+	})
+
+	t.Run("policy tier", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("", "```\ncrs:\n  startup_tier: 2\n```\n", "")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.UpdateVMMeta())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "startup=order=2", testServer.plan.Actions[0].After)
+		assert.Equal(t, "startup_tier 2 in VM policy", testServer.plan.Actions[0].Reason)
+	})
+
+	t.Run("policy with an undefined tier is ignored", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("crs-critical", "```\ncrs:\n  startup_tier: db\n```\n", "order=1")})
+		defer mockServer.Close()
+
+		testServer.journal = &memoryJournal{}
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.UpdateVMMeta())
+		assert.Empty(t, testServer.plan.Actions, "the VM keeps the order of crs-critical")
+	})
+
+	t.Run("skipped guests and undefined tiers are reported", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("crs-skip;crs-tier-2", "", "order=9")})
+		defer mockServer.Close()
+
+		require.NoError(t, testServer.UpdateVMMeta())
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.StartupDiverged.WithLabelValues(startupDivergedSkipped)))
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.StartupDiverged.WithLabelValues(startupDivergedUndefinedTier)))
+
+		testServer, mockServer = createTestServerWithConfig(testHandlerConfig{guest: startupTestGuest("crs-tier-db", "", "")})
+		defer mockServer.Close()
+
+		require.NoError(t, testServer.UpdateVMMeta())
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.StartupDiverged.WithLabelValues(startupDivergedSkipped)))
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.StartupDiverged.WithLabelValues(startupDivergedUndefinedTier)))
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.StartupDiverged.WithLabelValues(startupDivergedUpdateFailed)))
+	})
+}
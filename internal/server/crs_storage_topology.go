@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/tools"
+)
+
+// A storage zone is a set of nodes that reach the same shared storages, like the nodes of a rack with its NFS or iSCSI storage.
+// Guests on storage of a zone get prefer groups restricted to it, named crs-vm-prefer-<node>_<zone>.
+// Node names never contain an underscore, so the zone is everything after the first one.
+
+// zoneName returns the name of the storage zone of a set of nodes, a short hash that keeps the group names of large zones short
+func zoneName(nodes []string) string {
+	sorted := slices.Clone(nodes)
+	slices.Sort(sorted)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join(sorted, ",")))
+
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// zonePreferGroupName returns the prefer group of a node within a storage zone
+func zonePreferGroupName(node, zone string) string {
+	return tools.GetHAVMPreferGroupName(node) + "_" + zone
+}
+
+// preferGroupZone returns the storage zone of a prefer group, empty for prefer groups over all nodes and other groups
+func preferGroupZone(group string) string {
+	if !strings.HasPrefix(group, crsPreferGroupPrefix) {
+		return ""
+	}
+
+	_, zone, _ := strings.Cut(strings.TrimPrefix(group, crsPreferGroupPrefix), "_")
+	return zone
+}
+
+// preferGroupOnNode returns the prefer group of a node in the storage zone of the given prefer group
+func preferGroupOnNode(group, node string) string {
+	if zone := preferGroupZone(group); zone != "" {
+		return zonePreferGroupName(node, zone)
+	}
+
+	return tools.GetHAVMPreferGroupName(node)
+}
+
+// restrictedStorages returns the cluster nodes reaching every shared storage that not all nodes of the cluster reach
+func restrictedStorages(storages []proxmox.Storage, nodes []proxmox.Node) map[string][]string {
+	restricted := make(map[string][]string)
+	for _, storage := range storages {
+		if storage.Shared != 1 || storage.Nodes == "" {
+			continue
+		}
+
+		reach := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			if isStorageAvailableOnNode(storage, node.Node) {
+				reach = append(reach, node.Node)
+			}
+		}
+
+		if len(reach) < len(nodes) {
+			slices.Sort(reach)
+			restricted[storage.Storage] = reach
+		}
+	}
+
+	return restricted
+}
+
+// storageZones returns the node sets of the restricted storages that are large enough for a zone, without duplicates
+func storageZones(restricted map[string][]string) [][]string {
+	var zones [][]string
+	for _, reach := range restricted {
+		if len(reach) < 2 || slices.ContainsFunc(zones, func(zone []string) bool { return slices.Equal(zone, reach) }) {
+			continue
+		}
+		zones = append(zones, reach)
+	}
+
+	slices.SortFunc(zones, func(a, b []string) int {
+		return strings.Compare(strings.Join(a, ","), strings.Join(b, ","))
+	})
+
+	return zones
+}
+
+// diskReach returns the nodes that reach every restricted storage used by the disks.
+// It reports false when the disks only use storages that all nodes reach.
+func (s *Server) diskReach(disks map[string]string, restricted map[string][]string) ([]string, bool) {
+	var reach []string
+	limited := false
+
+	for key, value := range disks {
+		if !s.isDiskEntry(key) {
+			continue
+		}
+
+		nodes, ok := restricted[s.extractStorageFromDiskConfig(value)]
+		if !ok {
+			continue
+		}
+
+		if !limited {
+			reach = slices.Clone(nodes)
+			limited = true
+			continue
+		}
+
+		reach = slices.DeleteFunc(reach, func(node string) bool {
+			return !slices.Contains(nodes, node)
+		})
+	}
+
+	return reach, limited
+}
+
+// storageTopology returns the restricted storages of the cluster and the cluster nodes
+func (s *Server) storageTopology() (map[string][]string, []proxmox.Node, error) {
+	storages, err := s.proxmox.GetStorage()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get storage info: %w", err)
+	}
+
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	return restrictedStorages(storages, nodes), nodes, nil
+}
+
+// sharedStorageGroup returns the prefer group of a guest with all disks on shared storage and why it was chosen.
+// Storage that only some nodes reach restricts the group to the zone of these nodes,
+// a guest whose storage no other node reaches stays in its pin group.
+func (s *Server) sharedStorageGroup(nodeName string, disks map[string]string, reason string) (string, string, error) {
+	restricted, _, err := s.storageTopology()
+	if err != nil {
+		return "", "", err
+	}
+
+	reach, limited := s.diskReach(disks, restricted)
+	if !limited {
+		return tools.GetHAVMPreferGroupName(nodeName), reason, nil
+	}
+
+	if len(reach) < 2 || !slices.Contains(reach, nodeName) {
+		return tools.GetHAVMPinGroupName(nodeName), reason + " that only " + nodeName + " reaches", nil
+	}
+
+	return zonePreferGroupName(nodeName, zoneName(reach)), fmt.Sprintf("%s reachable from %s", reason, strings.Join(reach, ", ")), nil
+}
+
+// guestStorageReach returns the nodes that reach every restricted storage of a guest, false when all nodes reach its storage.
+// The guest config is only read when the cluster has restricted storages.
+func (s *Server) guestStorageReach(guest haGuest) ([]string, bool, error) {
+	restricted, _, err := s.storageTopology()
+	if err != nil || len(restricted) == 0 {
+		return nil, false, err
+	}
+
+	var disks map[string]string
+	if guest.Type == ctResourceType {
+		config, err := s.proxmox.GetContainerConfig(guest.Node, guest.VMID)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get container config: %w", err)
+		}
+		disks = config.Disks
+	} else {
+		config, err := s.proxmox.GetVMConfig(guest.Node, guest.VMID)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get VM config: %w", err)
+		}
+		disks = config.Disks
+	}
+
+	reach, limited := s.diskReach(disks, restricted)
+	return reach, limited, nil
+}
+
+// ensureZonePreferGroups creates and updates the prefer groups of every node of a storage zone
+func (s *Server) ensureZonePreferGroups(reach []string, haGroups []proxmox.ClusterHAGroup) error {
+	zone := zoneName(reach)
+
+	members := make([]proxmox.Node, 0, len(reach))
+	for _, node := range reach {
+		members = append(members, proxmox.Node{Node: node})
+	}
+
+	for _, node := range reach {
+		if err := s.ensurePreferGroup(zonePreferGroupName(node, zone), node, s.generateExpectedPreferNodes(members, node), haGroups); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureGuestZoneGroups creates the prefer groups of the storage zone a guest is placed in.
+// Zones of single storages are set up by SetupVMPrefer, zones of guests using several restricted storages on demand.
+func (s *Server) ensureGuestZoneGroups(guest haGuest, group string) error {
+	zone := preferGroupZone(group)
+	if zone == "" {
+		return nil
+	}
+
+	reach, limited, err := s.guestStorageReach(guest)
+	if err != nil {
+		return err
+	}
+
+	if !limited || zoneName(reach) != zone {
+		return fmt.Errorf("storage of %s %d is no longer in zone %s", guest.Kind, guest.VMID, zone)
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	return s.ensureZonePreferGroups(reach, haGroups)
+}
+
+// storagePlacementGroup returns the HA group of a guest in a prefer or pool group once the reach of its storage was checked again.
+// A guest moves into the zone of the nodes reaching its storage, keeping its node, and back to the groups over all nodes
+// once every node reaches its storage. Pool policies apply as before to guests outside a zone.
+// The zone is kept when the storage cannot be checked.
+func (s *Server) storagePlacementGroup(guest haGuest, current string, nodes []proxmox.Node) string {
+	if !strings.HasPrefix(current, crsPreferGroupPrefix) && !strings.HasPrefix(current, crsPoolGroupPrefix) {
+		return s.poolPlacementGroup(guest, current, nodes)
+	}
+
+	reach, limited, err := s.guestStorageReach(guest)
+	if err != nil {
+		logging.Warnf("Failed to check the storage of %s %d, keeping its storage zone: %v", guest.Kind, guest.VMID, err)
+		return s.poolPlacementGroup(guest, current, nodes)
+	}
+
+	switch {
+	case !limited && preferGroupZone(current) == "":
+		return s.poolPlacementGroup(guest, current, nodes)
+	case !limited:
+		return s.poolPlacementGroup(guest, tools.GetHAVMPreferGroupName(guest.Node), nodes)
+	case len(reach) < 2 || !slices.Contains(reach, guest.Node):
+		return tools.GetHAVMPinGroupName(guest.Node)
+	case preferGroupZone(current) == zoneName(reach):
+		// Keeps the node DRS, affinity or maintenance re-homed the guest to
+		return current
+	default:
+		return zonePreferGroupName(guest.Node, zoneName(reach))
+	}
+}
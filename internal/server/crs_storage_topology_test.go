@@ -0,0 +1,162 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestPreferGroupZone(t *testing.T) {
+	zone := zoneName([]string{"pve2", "pve1"})
+	assert.Equal(t, zone, zoneName([]string{"pve1", "pve2"}), "the zone does not depend on the order of the nodes")
+	assert.NotEqual(t, zone, zoneName([]string{"pve1", "pve2", "pve3"}))
+	assert.Len(t, zone, 8)
+
+	assert.Equal(t, "crs-vm-prefer-pve1_"+zone, zonePreferGroupName("pve1", zone))
+	assert.Equal(t, zone, preferGroupZone("crs-vm-prefer-pve-1_"+zone))
+	assert.Empty(t, preferGroupZone("crs-vm-prefer-pve1"))
+	assert.Empty(t, preferGroupZone("crs-pool-tenant_a"))
+
+	assert.Equal(t, "crs-vm-prefer-pve2_"+zone, preferGroupOnNode("crs-vm-prefer-pve1_"+zone, "pve2"))
+	assert.Equal(t, "crs-vm-prefer-pve2", preferGroupOnNode("crs-vm-prefer-pve1", "pve2"))
+}
+
+func TestRestrictedStorages(t *testing.T) {
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+	storages := []proxmox.Storage{
+		{Storage: "ceph", Shared: 1},
+		{Storage: "nfs-all", Shared: 1, Nodes: "pve1,pve2,pve3"},
+		{Storage: "nfs-rack1", Shared: 1, Nodes: "pve2, pve1,pve9"},
+		{Storage: "iscsi-rack1", Shared: 1, Nodes: "pve1,pve2"},
+		{Storage: "nfs-pve3", Shared: 1, Nodes: "pve3"},
+		{Storage: "local-lvm", Nodes: "pve1"},
+	}
+
+	restricted := restrictedStorages(storages, nodes)
+	assert.Equal(t, map[string][]string{
+		"nfs-rack1":   {"pve1", "pve2"},
+		"iscsi-rack1": {"pve1", "pve2"},
+		"nfs-pve3":    {"pve3"},
+	}, restricted)
+	assert.Equal(t, [][]string{{"pve1", "pve2"}}, storageZones(restricted), "zones need two nodes and are listed once")
+
+	testServer := &Server{config: defaultCRSConfig()}
+
+	reach, limited := testServer.diskReach(map[string]string{"scsi0": "ceph:vm-100-disk-0", "ide2": "none,media=cdrom"}, restricted)
+	assert.False(t, limited)
+	assert.Nil(t, reach)
+
+	reach, limited = testServer.diskReach(map[string]string{"scsi0": "ceph:vm-100-disk-0", "scsi1": "nfs-rack1:100/vm-100-disk-1.qcow2"}, restricted)
+	assert.True(t, limited)
+	assert.Equal(t, []string{"pve1", "pve2"}, reach)
+
+	reach, limited = testServer.diskReach(map[string]string{"scsi0": "nfs-rack1:100/vm-100-disk-0.qcow2", "scsi1": "nfs-pve3:100/vm-100-disk-1.qcow2"}, restricted)
+	assert.True(t, limited)
+	assert.Empty(t, reach, "no node reaches both storages")
+}
+
+// storageTestGuest is VM 600 with its disk on the given storage, in the given HA group or without an HA resource
+func storageTestGuest(storage, group string) *guestFixture {
+	return &guestFixture{
+		vmid:   600,
+		name:   "db",
+		config: map[string]any{"scsi0": storage + ":vm-600-disk-0"},
+		group:  group,
+	}
+}
+
+func TestSetupVMPreferStorageZones(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("ceph", "")})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+
+	require.NoError(t, testServer.SetupVMPrefer())
+
+	zone := zoneName([]string{"pve1", "pve2"})
+	groups := make(map[string]string)
+	for _, action := range testServer.plan.Actions {
+		assert.Equal(t, ActionCreate, action.Kind)
+		groups[action.ID] = action.After
+	}
+
+	assert.Equal(t, map[string]string{
+		"crs-vm-prefer-pve1":         "pve1:1000,pve2:995,pve3:990",
+		"crs-vm-prefer-pve2":         "pve1:990,pve2:1000,pve3:995",
+		"crs-vm-prefer-pve3":         "pve1:995,pve2:990,pve3:1000",
+		"crs-vm-prefer-pve1_" + zone: "pve1:1000,pve2:995",
+		"crs-vm-prefer-pve2_" + zone: "pve1:995,pve2:1000",
+	}, groups)
+}
+
+func TestSetupVMHAResourcesStorageZone(t *testing.T) {
+	zoneGroup := "crs-vm-prefer-pve1_" + zoneName([]string{"pve1", "pve2"})
+
+	t.Run("new resource uses the prefer group of its zone", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("nfs-rack1", "")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		var created []string
+		for _, action := range testServer.plan.Actions {
+			if action.Resource == ResourceHAResource {
+				created = append(created, action.HAResource.Group)
+			}
+		}
+		assert.Equal(t, []string{zoneGroup}, created)
+	})
+
+	t.Run("existing resource moves into its zone", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("nfs-rack1", "crs-vm-prefer-pve1")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		update := testServer.plan.Actions[len(testServer.plan.Actions)-1]
+		assert.Equal(t, ResourceHAResource, update.Resource)
+		assert.Equal(t, "group="+zoneGroup+" max_restart=10 max_relocate=10", update.After)
+		assert.Equal(t, "nodes reaching the storage changed", update.Reason)
+	})
+
+	t.Run("re-homed resource keeps its node in the zone", func(t *testing.T) {
+		rehomed := "crs-vm-prefer-pve2_" + zoneName([]string{"pve1", "pve2"})
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("nfs-rack1", rehomed)})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+		assert.Empty(t, testServer.plan.Actions)
+	})
+
+	t.Run("storage of a single node pins the resource", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("nfs-pve3", "")})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-pin-pve1", testServer.plan.Actions[0].HAResource.Group)
+	})
+
+	t.Run("storage on every node leaves the zone", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{guest: storageTestGuest("ceph", zoneGroup)})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-prefer-pve1", testServer.plan.Actions[0].HAResource.Group)
+	})
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// runningGuestTasks returns the running Proxmox tasks by guest VMID.
+// Guests with a running task or a migration in flight, including migrations that outlived their timeout
+// until they expire, get no new migrations.
+// The cluster task list is only advisory, so a failure to read it is logged and only the migrations in flight are returned.
+func (s *Server) runningGuestTasks() map[int]proxmox.Task {
+	running := make(map[int]proxmox.Task)
+
+	// The hamigrate task of an HA-managed guest ends before HA starts the migration itself
+	for _, state := range s.migrationSnapshot() {
+		taskType := proxmox.TaskTypeQMigrate
+		if state.Type == ctResourceType {
+			taskType = proxmox.TaskTypeVZMigrate
+		}
+		running[state.VMID] = proxmox.Task{UPID: state.UPID, Node: state.Source, Type: taskType, ID: strconv.Itoa(state.VMID)}
+	}
+
+	tasks, err := s.proxmox.GetClusterTasks()
+	if err != nil {
+		logging.Warnf("Failed to get cluster tasks, in-flight tasks are not checked: %v", err)
+		return running
+	}
+
+	for _, task := range tasks {
+		if task.EndTime != 0 || task.Status == proxmox.TaskStatusStopped {
+			continue
+		}
+
+		vmid, err := strconv.Atoi(task.ID)
+		if err != nil {
+			continue
+		}
+
+		running[vmid] = task
+	}
+
+	return running
+}
+
+// loadMigrationStates refreshes the migrations in flight from Consul, keeping the current ones when they fail to load
+func (s *Server) loadMigrationStates() {
+	states, err := s.consul.GetMigrationStates()
+	if err != nil {
+		logging.Errorf("Failed to load migrations in flight, keeping current state: %v", err)
+		return
+	}
+
+	migrations := make(map[int]*consul.MigrationState, len(states))
+	for _, state := range states {
+		migrations[state.VMID] = &state
+	}
+
+	s.migrationMu.Lock()
+	s.migrations = migrations
+	s.migrationMu.Unlock()
+}
+
+// putMigrationState stores a migration in flight in Consul and in memory
+func (s *Server) putMigrationState(state *consul.MigrationState) error {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+
+	if err := s.consul.PutMigrationState(*state); err != nil {
+		return err
+	}
+
+	if s.migrations == nil {
+		s.migrations = make(map[int]*consul.MigrationState)
+	}
+	s.migrations[state.VMID] = state
+
+	return nil
+}
+
+// removeMigrationState stops tracking the migration of a guest
+func (s *Server) removeMigrationState(vmid int) error {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+
+	if err := s.consul.DeleteMigrationState(vmid); err != nil {
+		return err
+	}
+	delete(s.migrations, vmid)
+
+	return nil
+}
+
+// isMigrationInFlight reports whether a migration of the guest is tracked
+func (s *Server) isMigrationInFlight(vmid int) bool {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+
+	_, ok := s.migrations[vmid]
+	return ok
+}
+
+// migrationSnapshot returns a copy of the migrations in flight ordered by VMID
+func (s *Server) migrationSnapshot() []consul.MigrationState {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+
+	states := make([]consul.MigrationState, 0, len(s.migrations))
+	for _, state := range s.migrations {
+		states = append(states, *state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].VMID < states[j].VMID
+	})
+
+	return states
+}
+
+// startMigration applies a migration action without waiting for its task. The task is checked by CheckMigrations
+// on the next cycles, which retries failed migrations and, with rehome set, moves the guest to the prefer group of its new node.
+func (s *Server) startMigration(action Action, rehome bool) error {
+	upid, err := s.applyAction(action)
+	if err != nil {
+		return fmt.Errorf("failed to start migration: %w", err)
+	}
+
+	if s.isPlanning() {
+		return nil
+	}
+
+	metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationStarted).Inc()
+	logging.Debugf("Started migration task %s for %s %d", upid, action.Resource, action.VMID)
+
+	state := &consul.MigrationState{
+		VMID:      action.VMID,
+		Type:      vmResourceType,
+		Source:    action.Node,
+		Target:    action.After,
+		UPID:      upid,
+		Online:    action.Migration.Online,
+		WithDisks: action.Migration.WithDisks,
+		Rehome:    rehome,
+		Reason:    action.Reason,
+		Attempts:  1,
+		StartedAt: time.Now().UTC(),
+	}
+	if action.Resource == ResourceContainer {
+		state.Type = ctResourceType
+	}
+
+	if err := s.putMigrationState(state); err != nil {
+		// The running task still keeps new migrations off the guest, only the retry and the re-homing are lost
+		logging.Errorf("Failed to track migration task %s of %s %d: %v", upid, action.Resource, action.VMID, err)
+	}
+
+	return nil
+}
+
+// CheckMigrations checks the tasks of the migrations started in earlier cycles. Finished migrations are journaled,
+// successful ones re-home the guest when requested, failed ones are retried up to migrationMaxAttempts times.
+func (s *Server) CheckMigrations() error {
+	migrations := s.migrationSnapshot()
+	if len(migrations) == 0 || s.isPlanning() {
+		return nil
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	for _, state := range migrations {
+		s.checkMigration(&state, haResources, haGroups)
+	}
+
+	return nil
+}
+
+// checkMigration checks the task of one migration in flight and retries, re-homes or forgets the migration.
+// A migration still unresolved after migrationExpiry is journaled and forgotten.
+func (s *Server) checkMigration(state *consul.MigrationState, haResources []proxmox.ClusterHAResource, haGroups []proxmox.ClusterHAGroup) {
+	action := trackedMigrationAction(state)
+
+	result, err := s.migrationTaskResult(action, state.UPID, state.StartedAt)
+	if result == nil && time.Since(state.StartedAt) > migrationExpiry {
+		logging.Errorf("Migration of %s %d from %s to %s is unresolved after %s, no longer tracking it, check the guest",
+			action.Resource, state.VMID, state.Source, state.Target, migrationExpiry)
+		s.putEvent(newEvent(action, state.UPID, consul.EventResultTaskExpired, err))
+		s.forgetMigration(state)
+		return
+	}
+
+	if err != nil {
+		logging.Warnf("Failed to check migration task %s of %s %d: %v", state.UPID, action.Resource, state.VMID, err)
+		return
+	}
+
+	if result == nil {
+		// A timed out migration is neither counted as succeeded nor failed, it is still running
+		if timeout := migrationTimeout(state.Type, state.Online); !state.TimedOut && time.Since(state.StartedAt) > timeout {
+			logging.Warnf("Migration of %s %d from %s to %s is still running after %s", action.Resource, state.VMID, state.Source, state.Target, timeout)
+			s.recordTaskEvent(action, state.UPID, &proxmox.TaskResult{UPID: state.UPID, Node: state.Source, Duration: time.Since(state.StartedAt), TimedOut: true}, nil)
+
+			state.TimedOut = true
+			if err := s.putMigrationState(state); err != nil {
+				logging.Errorf("Failed to store migration of %s %d: %v", action.Resource, state.VMID, err)
+			}
+		}
+		return
+	}
+
+	s.recordTaskEvent(action, state.UPID, result, nil)
+
+	if result.Success() {
+		metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationSucceeded).Inc()
+		logging.Infof("Migration of %s %d from %s to %s finished", action.Resource, state.VMID, state.Source, state.Target)
+
+		if state.Rehome {
+			// Without re-homing, HA would treat the old node as the guest's home and a later restore would move it back
+			if _, err := s.rehomeGuest(state.Type, state.VMID, state.Source, state.Target, "guest re-homed to its new node", haResources, haGroups); err != nil {
+				logging.Errorf("Failed to re-home %s %d after its migration: %v", action.Resource, state.VMID, err)
+			}
+		}
+
+		s.forgetMigration(state)
+		return
+	}
+
+	metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationFailed).Inc()
+
+	if state.Attempts >= migrationMaxAttempts {
+		logging.Errorf("Migration of %s %d from %s to %s failed after %d attempts: %v",
+			action.Resource, state.VMID, state.Source, state.Target, state.Attempts, result.Err())
+		s.forgetMigration(state)
+		return
+	}
+
+	logging.Warnf("Migration of %s %d from %s to %s failed (attempt %d/%d), retrying: %v",
+		action.Resource, state.VMID, state.Source, state.Target, state.Attempts, migrationMaxAttempts, result.Err())
+
+	upid, err := s.applyAction(action)
+	if err != nil {
+		logging.Errorf("Failed to retry migration of %s %d: %v", action.Resource, state.VMID, err)
+		s.forgetMigration(state)
+		return
+	}
+
+	metrics.Migrations.WithLabelValues(string(action.Resource), metrics.MigrationStarted).Inc()
+
+	state.UPID = upid
+	state.Attempts++
+	state.TimedOut = false
+	state.StartedAt = time.Now().UTC()
+
+	if err := s.putMigrationState(state); err != nil {
+		logging.Errorf("Failed to track migration task %s of %s %d: %v", upid, action.Resource, state.VMID, err)
+	}
+}
+
+// forgetMigration stops tracking a finished migration, a failure to remove it is logged and retried on the next cycle
+func (s *Server) forgetMigration(state *consul.MigrationState) {
+	if err := s.removeMigrationState(state.VMID); err != nil {
+		logging.Errorf("Failed to remove migration of guest %d: %v", state.VMID, err)
+	}
+}
+
+// migrationTaskResult reads the task of a migration started at startedAt once and returns its result,
+// or nil while the migration runs. The hamigrate task of an HA-managed guest only queued the migration,
+// its result is the one of the migration HA runs.
+func (s *Server) migrationTaskResult(action Action, upid string, startedAt time.Time) (*proxmox.TaskResult, error) {
+	if upid == "" {
+		// The API returned no task to follow, like waitForTaskResult the migration counts as done
+		return &proxmox.TaskResult{Node: action.Node, Status: proxmox.TaskStatusStopped, ExitCode: proxmox.TaskExitOK}, nil
+	}
+
+	// A zero timeout reads the task status once instead of waiting for the task
+	result, err := s.proxmox.WaitForTask(action.Node, upid, 0, taskPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.TimedOut {
+		return nil, nil
+	}
+	result.Duration = time.Since(startedAt)
+
+	if result.Success() && result.Type == proxmox.TaskTypeHAMigrate {
+		return s.haMigrationResult(action, upid)
+	}
+
+	for _, line := range result.Log {
+		logging.Warnf("Task %s: %s", upid, line)
+	}
+
+	return result, nil
+}
+
+// trackedMigrationAction returns the action that starts a migration in flight again
+func trackedMigrationAction(state *consul.MigrationState) Action {
+	action := Action{
+		Kind:     ActionMigrate,
+		Resource: ResourceVM,
+		ID:       strconv.Itoa(state.VMID),
+		Node:     state.Source,
+		VMID:     state.VMID,
+		Before:   state.Source,
+		After:    state.Target,
+		Reason:   state.Reason,
+		Migration: &proxmox.MigrationOptions{
+			Target:    state.Target,
+			Online:    state.Online,
+			WithDisks: state.WithDisks,
+		},
+	}
+
+	if state.Type == ctResourceType {
+		action.Resource = ResourceContainer
+	}
+
+	return action
+}
+
+// migrationTimeout returns how long a migration may run before it is reported
+func migrationTimeout(guestType string, online bool) time.Duration {
+	switch {
+	case guestType == ctResourceType:
+		return migrationContainerTimeout
+	case online:
+		return migrationLiveTimeout
+	default:
+		return migrationOfflineTimeout
+	}
+}
+
+// haMigrationResult returns the result of the migration an hamigrate task queued, or nil while HA is still migrating the guest.
+// The migration succeeded once HA reports the service on the target node, and failed when HA put the service
+// in error state or the migrate task HA ran on the source node failed.
+func (s *Server) haMigrationResult(action Action, upid string) (*proxmox.TaskResult, error) {
+	resourceType, taskType := vmResourceType, proxmox.TaskTypeQMigrate
+	if action.Resource == ResourceContainer {
+		resourceType, taskType = ctResourceType, proxmox.TaskTypeVZMigrate
+	}
+	sid := haResourceSID(resourceType, action.VMID)
+
+	haStatus, err := s.proxmox.GetClusterHAStatus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HA status: %w", err)
+	}
+
+	result := &proxmox.TaskResult{UPID: upid, Node: action.Node, Type: proxmox.TaskTypeHAMigrate, Status: proxmox.TaskStatusStopped}
+	if started := proxmox.UPIDStartTime(upid); !started.IsZero() {
+		result.Duration = time.Since(started)
+	}
+
+	for _, entry := range haStatus {
+		if entry.Type != "service" || entry.ID != "service:"+sid {
+			continue
+		}
+
+		switch {
+		case entry.State == haStateError:
+			result.ExitCode = fmt.Sprintf("HA service %s is in state %s", sid, entry.State)
+			return result, nil
+		case entry.Node == action.After && entry.State != haStateMigrate && entry.State != haStateRelocate:
+			result.ExitCode = proxmox.TaskExitOK
+			return result, nil
+		}
+	}
+
+	// HA puts a service back on the source node when the migration fails, the migrate task tells the two apart
+	tasks, err := s.proxmox.GetClusterTasks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster tasks: %w", err)
+	}
+
+	queued := proxmox.UPIDStartTime(upid).Unix()
+	for _, task := range tasks {
+		if task.Type != taskType || task.ID != strconv.Itoa(action.VMID) || task.StartTime < queued || task.EndTime == 0 {
+			continue
+		}
+
+		// Finished cluster tasks carry their exit status in the status
+		if task.Status != proxmox.TaskExitOK {
+			result.UPID, result.Node, result.Type, result.ExitCode = task.UPID, task.Node, task.Type, task.Status
+			return result, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// migrationTaskHandler answers migrations with a new task each time, the tasks finish with the given exit statuses in order
+func migrationTaskHandler(exitStatuses ...string) scenarioHandler {
+	var started int
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/migrate"):
+			started++
+			fmt.Fprintf(w, `{"data": "UPID:pve1:0000%d:qmigrate:100:root@pam:"}`, started)
+		case strings.HasSuffix(r.URL.Path, "/log"):
+			w.Write([]byte(`{"data": [{"n": 1, "t": "ERROR: migration aborted"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/status"):
+			status := exitStatuses[started-1]
+			if status == "" {
+				w.Write([]byte(`{"data": {"type": "qmigrate", "status": "running"}}`))
+				return true
+			}
+			fmt.Fprintf(w, `{"data": {"type": "qmigrate", "status": "stopped", "exitstatus": %q}}`, status)
+		default:
+			return false
+		}
+
+		return true
+	}
+}
+
+func testMigrationAction() Action {
+	return Action{
+		Kind:      ActionMigrate,
+		Resource:  ResourceVM,
+		ID:        "100",
+		Node:      "pve1",
+		VMID:      100,
+		Before:    "pve1",
+		After:     "pve2",
+		Migration: &proxmox.MigrationOptions{Target: "pve2"},
+	}
+}
+
+func TestCheckMigrations(t *testing.T) {
+	tests := []struct {
+		name       string
+		exits      []string
+		migrations int
+		inFlight   bool
+	}{
+		{name: "succeeds", exits: []string{"OK"}, migrations: 1},
+		{name: "retries a failed task", exits: []string{"migration aborted", "OK"}, migrations: 2},
+		{name: "gives up after the last attempt", exits: []string{"migration aborted", "migration aborted"}, migrations: 2},
+		{name: "does not retry a running task", exits: []string{""}, migrations: 1, inFlight: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &requestRecorder{}
+			testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: migrationTaskHandler(tt.exits...), recorder: recorder})
+			defer mockServer.Close()
+
+			require.NoError(t, testServer.startMigration(testMigrationAction(), false))
+			assert.True(t, testServer.isMigrationInFlight(100), "the migration is tracked without waiting for its task")
+
+			for range migrationMaxAttempts + 1 {
+				require.NoError(t, testServer.CheckMigrations())
+			}
+
+			assert.Equal(t, tt.inFlight, testServer.isMigrationInFlight(100))
+			assert.Len(t, recorder.mutations(), tt.migrations)
+		})
+	}
+}
+
+func TestCheckMigrationsTimeout(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: migrationTaskHandler(""), recorder: recorder})
+	defer mockServer.Close()
+
+	journal := &memoryJournal{}
+	testServer.journal = journal
+
+	require.NoError(t, testServer.startMigration(testMigrationAction(), false))
+	testServer.migrations[100].StartedAt = time.Now().Add(-migrationOfflineTimeout - time.Minute)
+
+	require.NoError(t, testServer.CheckMigrations())
+	require.NoError(t, testServer.CheckMigrations())
+
+	assert.True(t, testServer.isMigrationInFlight(100), "a timed out migration is still tracked")
+	assert.True(t, testServer.migrations[100].TimedOut)
+	assert.Equal(t, consul.EventResultTaskTimedOut, journal.events[len(journal.events)-1].Result)
+	assert.Len(t, journal.events, 2, "the timeout is journaled once")
+
+	t.Run("expires", func(t *testing.T) {
+		testServer.migrations[100].StartedAt = time.Now().Add(-migrationExpiry - time.Minute)
+		require.Contains(t, testServer.runningGuestTasks(), 100)
+
+		require.NoError(t, testServer.CheckMigrations())
+
+		assert.False(t, testServer.isMigrationInFlight(100), "an unresolved migration is forgotten")
+		assert.NotContains(t, testServer.runningGuestTasks(), 100, "the guest can be moved again")
+		assert.Equal(t, consul.EventResultTaskExpired, journal.events[len(journal.events)-1].Result)
+		assert.Len(t, recorder.mutations(), 1, "the expired migration is not retried")
+	})
+}
+
+func TestCheckMigrationsRehome(t *testing.T) {
+	recorder := &requestRecorder{}
+	migrations := migrationTaskHandler("OK")
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		cluster: func(w http.ResponseWriter, r *http.Request) bool {
+			switch r.URL.Path {
+			case "/api2/json/cluster/ha/resources":
+				w.Write([]byte(`{"data": [{"sid": "vm:100", "group": "crs-vm-prefer-pve1"}]}`))
+			case "/api2/json/cluster/ha/groups":
+				w.Write([]byte(`{"data": [{"group": "crs-vm-prefer-pve1"}, {"group": "crs-vm-prefer-pve2"}]}`))
+			default:
+				return migrations(w, r)
+			}
+			return true
+		},
+		recorder: recorder,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.startMigration(testMigrationAction(), true))
+	assert.Equal(t, []string{"POST /api2/json/nodes/pve1/qemu/100/migrate"}, recorder.mutations(), "the guest is re-homed once it arrived")
+
+	require.NoError(t, testServer.CheckMigrations())
+
+	assert.Equal(t, []string{
+		"POST /api2/json/nodes/pve1/qemu/100/migrate",
+		"PUT /api2/json/cluster/ha/resources/vm:100",
+	}, recorder.mutations())
+	assert.False(t, testServer.isMigrationInFlight(100))
+}
+
+// haMigrationUPID is the task that queues the migration of HA-managed VM 100
+const haMigrationUPID = "UPID:pve1:00001234:00005678:6A000000:hamigrate:100:root@pam:"
+
+// haMigrationHandler answers migrations with an hamigrate task that finished right away. Each HA status request
+// reports the next of the given states of service vm:100 as node/state, the last one from then on.
+func haMigrationHandler(tasks string, services ...string) scenarioHandler {
+	var polls int
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/migrate"):
+			fmt.Fprintf(w, `{"data": %q}`, haMigrationUPID)
+		case strings.HasSuffix(r.URL.Path, "/status") && strings.Contains(r.URL.Path, "/tasks/"):
+			w.Write([]byte(`{"data": {"type": "hamigrate", "status": "stopped", "exitstatus": "OK"}}`))
+		case r.URL.Path == "/api2/json/cluster/ha/status/current":
+			node, state, _ := strings.Cut(services[min(polls, len(services)-1)], "/")
+			polls++
+			fmt.Fprintf(w, `{"data": [{"id": "service:vm:100", "type": "service", "node": %q, "state": %q}]}`, node, state)
+		case r.URL.Path == "/api2/json/cluster/tasks":
+			fmt.Fprintf(w, `{"data": [%s]}`, tasks)
+		default:
+			return false
+		}
+
+		return true
+	}
+}
+
+func TestCheckMigrationsHAManaged(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: haMigrationHandler("", "pve1/migrate", "pve2/started"), recorder: recorder})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.startMigration(testMigrationAction(), false))
+
+	require.NoError(t, testServer.CheckMigrations())
+	assert.True(t, testServer.isMigrationInFlight(100), "the migration is in flight until HA reports the guest on its target")
+
+	require.NoError(t, testServer.CheckMigrations())
+	assert.False(t, testServer.isMigrationInFlight(100))
+
+	assert.Contains(t, recorder.requests, "GET /api2/json/cluster/ha/status/current", "the migration is tracked through the HA status")
+	assert.Len(t, recorder.mutations(), 1)
+}
+
+func TestHAMigrationResult(t *testing.T) {
+	// A migrate task of an earlier attempt that failed before the hamigrate task was queued
+	const earlierTask = `{"upid": "UPID:pve1:1:qmigrate", "type": "qmigrate", "id": "100", "starttime": 1700000000, "endtime": 1700000100, "status": "migration aborted"}`
+
+	tests := []struct {
+		name      string
+		tasks     string
+		services  []string
+		succeeded bool
+		running   bool
+		exitCode  string
+	}{
+		{name: "service started on the target", tasks: earlierTask, services: []string{"pve1/started", "pve1/migrate", "pve2/started"}, succeeded: true},
+		{name: "stopped guest", services: []string{"pve2/stopped"}, succeeded: true},
+		{name: "service in error state", services: []string{"pve1/migrate", "pve1/error"}, exitCode: "HA service vm:100 is in state error"},
+		{
+			name:     "migrate task failed",
+			tasks:    earlierTask + `, {"upid": "UPID:pve1:2:qmigrate", "node": "pve1", "type": "qmigrate", "id": "100", "starttime": 1778384896, "endtime": 1778385000, "status": "migration aborted"}`,
+			services: []string{"pve1/started"},
+			exitCode: "migration aborted",
+		},
+		{name: "still migrating", services: []string{"pve1/migrate"}, running: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: haMigrationHandler(tt.tasks, tt.services...)})
+			defer mockServer.Close()
+
+			// Each cycle reads the HA status once
+			var result *proxmox.TaskResult
+			for range len(tt.services) {
+				var err error
+				result, err = testServer.haMigrationResult(testMigrationAction(), haMigrationUPID)
+				require.NoError(t, err)
+				if result != nil {
+					break
+				}
+			}
+
+			if tt.running {
+				assert.Nil(t, result)
+				return
+			}
+
+			require.NotNil(t, result)
+			assert.Equal(t, tt.succeeded, result.Success())
+			if tt.exitCode != "" {
+				assert.Equal(t, tt.exitCode, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestStartMigrationPlan(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: migrationTaskHandler(), recorder: recorder})
+	defer mockServer.Close()
+	testServer.plan = &Plan{}
+
+	require.NoError(t, testServer.startMigration(testMigrationAction(), true))
+
+	assert.Empty(t, recorder.requests)
+	assert.Len(t, testServer.plan.Actions, 1)
+	assert.False(t, testServer.isMigrationInFlight(100), "planned migrations are not tracked")
+}
+
+func TestRunningGuestTasks(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: func(w http.ResponseWriter, r *http.Request) bool {
+		assert.Equal(t, "/api2/json/cluster/tasks", r.URL.Path)
+		w.Write([]byte(`{
+			"data": [
+				{"upid": "UPID:a", "type": "qmigrate", "id": "100", "starttime": 1700000000},
+				{"upid": "UPID:b", "type": "vzmigrate", "id": "200", "starttime": 1700000000, "endtime": 1700000100, "status": "OK"},
+				{"upid": "UPID:c", "type": "vzdump", "id": "", "starttime": 1700000000}
+			]
+		}`))
+		return true
+	}})
+	defer mockServer.Close()
+
+	tasks := testServer.runningGuestTasks()
+
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "qmigrate", tasks[100].Type)
+}
+
+func TestRunningGuestTasksError(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: failingScenario})
+	defer mockServer.Close()
+
+	assert.Empty(t, testServer.runningGuestTasks())
+}
+
+func TestRunningGuestTasksMigrationsInFlight(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{cluster: failingScenario})
+	defer mockServer.Close()
+	testServer.migrations = map[int]*consul.MigrationState{
+		200: {VMID: 200, Type: ctResourceType, Source: "pve1", Target: "pve2", UPID: "UPID:pve1:1:hamigrate"},
+	}
+
+	tasks := testServer.runningGuestTasks()
+
+	require.Len(t, tasks, 1)
+	assert.Equal(t, proxmox.TaskTypeVZMigrate, tasks[200].Type, "a migration in flight keeps the guest busy without a running task")
+}
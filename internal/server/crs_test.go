@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,7 +23,7 @@ func TestSetupCRS(t *testing.T) {
 			testServer, mockServer := createTestServer()
 			defer mockServer.Close()
 
-			err := testServer.SetupCRS()
+			err := testServer.SetupCRS(context.Background())
 
 			if tt.wantErr {
 				assert.Error(t, err)
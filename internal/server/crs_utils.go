@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,7 +13,7 @@ import (
 // rateLimitSleep applies API rate limiting unless disabled for testing
 func (s *Server) rateLimitSleep() {
 	if !s.disableRateLimit {
-		time.Sleep(apiRateLimit)
+		time.Sleep(time.Duration(s.currentConfig().Scheduler.APIRateLimitMillis) * time.Millisecond)
 	}
 }
 
@@ -46,9 +47,86 @@ func (s *Server) hasVMCriticalTag(vmTags string) bool {
 	return false
 }
 
-// RemoveSkippedVMsFromCRSGroups removes VMs with crs-skip tag from CRS HA groups
+// hasVMTag checks if a VM has the given tag
+func (s *Server) hasVMTag(vmTags, tag string) bool {
+	if vmTags == "" {
+		return false
+	}
+
+	for _, vmTag := range strings.Split(vmTags, ";") {
+		if strings.TrimSpace(vmTag) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// haResourceTypeOf returns the HA resource type of a guest, vm for VMs and ct for containers
+func haResourceTypeOf(resourceType string) string {
+	if resourceType == ctResourceType {
+		return haContainerResourceType
+	}
+	return haResourceType
+}
+
+// haResourceSID returns the HA resource ID of a guest, vm:<id> for VMs and ct:<id> for containers
+func haResourceSID(resourceType string, vmid int) string {
+	return fmt.Sprintf("%s:%d", haResourceTypeOf(resourceType), vmid)
+}
+
+// haResourceVMID returns the guest ID of an HA resource ID like vm:100 or ct:101, 0 when it has none
+func haResourceVMID(sid string) int {
+	_, id, _ := strings.Cut(sid, ":")
+	vmid, err := strconv.Atoi(id)
+	if err != nil {
+		return 0
+	}
+	return vmid
+}
+
+// isGuestResource reports whether a cluster resource is a VM or a container
+func isGuestResource(resource proxmox.ClusterResource) bool {
+	return resource.Type == vmResourceType || resource.Type == ctResourceType
+}
+
+// waitForTask waits for a Proxmox task and returns an error unless it ended with OK.
+// Nothing is awaited in plan mode or when the API returned no task.
+func (s *Server) waitForTask(node, upid string, timeout time.Duration) error {
+	result, err := s.waitForTaskResult(node, upid, timeout)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+
+	return result.Err()
+}
+
+// waitForTaskResult waits for a Proxmox task and logs the task log of failed tasks.
+// The result is nil in plan mode or when the API returned no task.
+func (s *Server) waitForTaskResult(node, upid string, timeout time.Duration) (*proxmox.TaskResult, error) {
+	if upid == "" || s.isPlanning() {
+		return nil, nil
+	}
+
+	result, err := s.proxmox.WaitForTask(node, upid, timeout, taskPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Success() && !result.TimedOut {
+		for _, line := range result.Log {
+			logging.Warnf("Task %s: %s", upid, line)
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveSkippedVMsFromCRSGroups removes VMs and containers with crs-skip tag from CRS HA groups
 func (s *Server) RemoveSkippedVMsFromCRSGroups() error {
-	logging.Debug("Checking for VMs with crs-skip tag in CRS HA groups")
+	logging.Debug("Checking for VMs and containers with crs-skip tag in CRS HA groups")
 
 	resources, err := s.proxmox.GetClusterResources()
 	if err != nil {
@@ -67,17 +145,23 @@ func (s *Server) RemoveSkippedVMsFromCRSGroups() error {
 
 		var vmTags string
 		for _, resource := range resources {
-			resourceSID := fmt.Sprintf("vm:%d", resource.VMID)
-			if haResource.SID == resourceSID {
+			if isGuestResource(resource) && haResource.SID == haResourceSID(resource.Type, resource.VMID) {
 				vmTags = resource.Tags
 				break
 			}
 		}
 
 		if s.hasVMSkipTag(vmTags) {
-			logging.Infof("Removing VM %s with crs-skip tag from CRS HA group %s", haResource.SID, haResource.Group)
-			if err := s.proxmox.DeleteClusterHAResource(haResource.SID); err != nil {
-				return fmt.Errorf("failed to remove VM %s from HA group %s: %w", haResource.SID, haResource.Group, err)
+			logging.Infof("Removing %s with crs-skip tag from CRS HA group %s", haResource.SID, haResource.Group)
+			if _, err := s.applyAction(Action{
+				Kind:     ActionDelete,
+				Resource: ResourceHAResource,
+				ID:       haResource.SID,
+				VMID:     haResourceVMID(haResource.SID),
+				Before:   haResource.Group,
+				Reason:   "guest has crs-skip tag",
+			}); err != nil {
+				return fmt.Errorf("failed to remove %s from HA group %s: %w", haResource.SID, haResource.Group, err)
 			}
 
 			s.rateLimitSleep()
@@ -135,7 +219,15 @@ func (s *Server) ensureCRSTagRegistered() error {
 	}
 
 	logging.Debug("Updating cluster options with new registered tags")
-	if err := s.proxmox.UpdateClusterOptions(updateOptions); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:           ActionUpdate,
+		Resource:       ResourceClusterOptions,
+		ID:             "registered-tags",
+		Before:         strings.Join(options.RegisteredTags, ";"),
+		After:          strings.Join(newRegisteredTags, ";"),
+		Reason:         "CRS tags are not registered",
+		ClusterOptions: &updateOptions,
+	}); err != nil {
 		logging.Debugf("Failed to update cluster options (this may be expected on older Proxmox versions or limited permissions): %v", err)
 		return fmt.Errorf("failed to update cluster options with CRS tag: %w", err)
 	}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCRSConstants(t *testing.T) {
@@ -12,6 +13,8 @@ func TestCRSConstants(t *testing.T) {
 	assert.Equal(t, "crs-skip", crsSkipTag)
 	assert.Equal(t, "crs-critical", crsCriticalTag)
 	assert.Equal(t, "crs-", crsGroupPrefix)
+	assert.Equal(t, "crs-vm-pin-", crsPinGroupPrefix)
+	assert.Equal(t, "crs-vm-prefer-", crsPreferGroupPrefix)
 	assert.Equal(t, "error", haStateError)
 	assert.Equal(t, "disabled", haStateDisabled)
 	assert.Equal(t, "started", haStateStarted)
@@ -203,3 +206,22 @@ func TestEnsureCRSTagRegistered(t *testing.T) {
 		})
 	}
 }
+
+func TestHAResourceSID(t *testing.T) {
+	assert.Equal(t, "vm:100", haResourceSID("qemu", 100))
+	assert.Equal(t, "ct:200", haResourceSID("lxc", 200))
+	assert.Equal(t, "ct", haResourceTypeOf("lxc"))
+}
+
+func TestRemoveSkippedContainersFromCRSGroups(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeContainers: true,
+		recorder:          recorder,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.RemoveSkippedVMsFromCRSGroups())
+
+	assert.Equal(t, []string{"DELETE /api2/json/cluster/ha/resources/ct:602"}, recorder.mutations())
+}
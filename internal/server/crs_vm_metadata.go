@@ -5,10 +5,11 @@ import (
 	"strings"
 
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
 
-// UpdateVMMeta updates VM metadata for critical VMs and handles CD-ROM detachment
+// UpdateVMMeta keeps the startup setting of VMs and containers on their tier and handles CD-ROM detachment
 func (s *Server) UpdateVMMeta() error {
 	logging.Debug("Checking VMs for metadata updates")
 
@@ -18,10 +19,31 @@ func (s *Server) UpdateVMMeta() error {
 		return fmt.Errorf("failed to get cluster resources: %w", err)
 	}
 
-	var criticalVMs []proxmox.ClusterResource
+	var startupGuests []startupGuest
+	var skippedStartupGuests []startupGuest
 	var longRunningVMs []proxmox.ClusterResource
+	diverged := make(map[string]int)
 
 	for _, resource := range resources {
+		if isGuestResource(resource) && s.hasVMSkipTag(resource.Tags) {
+			// CRS leaves the startup setting of skipped guests alone, but still reports when it diverges from their tier
+			if startup, reason := s.guestStartup(resource, nil); startup != "" {
+				skippedStartupGuests = append(skippedStartupGuests, startupGuest{Resource: resource, Startup: startup, Reason: reason})
+			}
+		}
+
+		if resource.Type == ctResourceType && !s.hasVMSkipTag(resource.Tags) {
+			if s.startupTierUndefined(resource, nil) {
+				diverged[startupDivergedUndefinedTier]++
+			}
+
+			// Containers have no CD-ROM drives and no policy, only their startup setting is managed
+			if startup, reason := s.guestStartup(resource, nil); startup != "" {
+				startupGuests = append(startupGuests, startupGuest{Resource: resource, Startup: startup, Reason: reason})
+			}
+			continue
+		}
+
 		// Only check VMs (type=qemu)
 		if resource.Type == vmResourceType {
 			// Skip VMs with crs-skip tag
@@ -30,15 +52,25 @@ func (s *Server) UpdateVMMeta() error {
 				continue
 			}
 
-			// Check if VM has critical tag
-			if s.hasVMCriticalTag(resource.Tags) {
-				criticalVMs = append(criticalVMs, resource)
-				logging.Debugf("VM %d with critical tag detected: name=%s, node=%s, tags=%s",
-					resource.VMID, resource.Name, resource.Node, resource.Tags)
+			policy := s.getVMPolicy(resource.Node, resource.VMID)
+
+			if s.startupTierUndefined(resource, policy) {
+				diverged[startupDivergedUndefinedTier]++
+			}
+
+			if startup, reason := s.guestStartup(resource, policy); startup != "" {
+				startupGuests = append(startupGuests, startupGuest{Resource: resource, Startup: startup, Reason: reason})
+				logging.Debugf("VM %d keeps startup %s: name=%s, node=%s, %s",
+					resource.VMID, startup, resource.Name, resource.Node, reason)
 			}
 
-			// Check if VM is running and has uptime > 24 hours (86400 seconds)
-			if resource.Status == vmStatusRunning && resource.Uptime > 86400 {
+			if !policy.detachCDROM() {
+				logging.Debugf("VM %d (%s) policy disables CD-ROM detachment", resource.VMID, resource.Name)
+				continue
+			}
+
+			// Check if VM is running longer than the CD-ROM detach threshold (24 hours by default)
+			if resource.Status == vmStatusRunning && resource.Uptime > s.currentConfig().Scheduler.CDROMDetachUptimeHours*3600 {
 				longRunningVMs = append(longRunningVMs, resource)
 				logging.Debugf("VM %d is long-running: name=%s, node=%s, uptime=%ds",
 					resource.VMID, resource.Name, resource.Node, resource.Uptime)
@@ -48,27 +80,43 @@ func (s *Server) UpdateVMMeta() error {
 
 	var totalUpdatedCount int
 
-	// Handle critical VM startup order updates
-	if len(criticalVMs) > 0 {
-		logging.Debugf("Found %d critical VMs that need metadata updates: %v", len(criticalVMs), s.extractVMIDs(criticalVMs))
+	// Handle startup order updates of tiered, critical and policy guests
+	if len(startupGuests) > 0 {
+		var startupUpdatedCount int
+		for _, guest := range startupGuests {
+			var updated, failed bool
+			if guest.Resource.Type == ctResourceType {
+				updated, failed = s.updateContainerStartOrder(guest.Resource.Node, guest.Resource.VMID, guest.Startup, guest.Reason)
+			} else {
+				updated, failed = s.updateVMStartOrder(guest.Resource.Node, guest.Resource.VMID, guest.Startup, guest.Reason)
+			}
 
-		var criticalUpdatedCount int
-		for _, vm := range criticalVMs {
-			if s.updateCriticalVMStartOrder(vm.Node, vm.VMID) {
-				criticalUpdatedCount++
+			if updated {
+				startupUpdatedCount++
+			}
+			if failed {
+				diverged[startupDivergedUpdateFailed]++
 			}
 		}
 
-		if criticalUpdatedCount > 0 {
-			logging.Infof("Updated startup order for %d critical VMs", criticalUpdatedCount)
-			totalUpdatedCount += criticalUpdatedCount
+		if startupUpdatedCount > 0 {
+			logging.Infof("Updated startup order for %d guests", startupUpdatedCount)
+			totalUpdatedCount += startupUpdatedCount
 		} else {
-			logging.Debug("No critical VMs required startup order updates")
+			logging.Debug("No guests required startup order updates")
 		}
 	} else {
-		logging.Debug("No critical VMs found that need metadata updates")
+		logging.Debug("No guests with a startup tier found")
 	}
 
+	for _, guest := range skippedStartupGuests {
+		if s.startupDiverges(guest) {
+			diverged[startupDivergedSkipped]++
+		}
+	}
+
+	s.reportStartupDivergence(diverged)
+
 	// Handle CD-ROM detachment for long-running VMs
 	if len(longRunningVMs) > 0 {
 		logging.Debugf("Found %d long-running VMs that may need CD-ROM detachment: %v", len(longRunningVMs), s.extractVMIDs(longRunningVMs))
@@ -106,37 +154,138 @@ func (s *Server) extractVMIDs(vms []proxmox.ClusterResource) []int {
 	return vmids
 }
 
-// updateCriticalVMStartOrder updates the startup order for critical VMs to order=1
-func (s *Server) updateCriticalVMStartOrder(node string, vmid int) bool {
-	logging.Debugf("Checking startup order for critical VM %d on node %s", vmid, node)
+// startupGuest is a VM or container with the startup setting CRS keeps on it
+type startupGuest struct {
+	Resource proxmox.ClusterResource
+	Startup  string
+	Reason   string
+}
+
+// startupDiverges reports whether the startup setting of a guest CRS does not update differs from the one of its tier
+func (s *Server) startupDiverges(guest startupGuest) bool {
+	resource := guest.Resource
+
+	var current string
+	if resource.Type == ctResourceType {
+		config, err := s.proxmox.GetContainerConfig(resource.Node, resource.VMID)
+		if err != nil {
+			logging.Errorf("Failed to get container %d config on node %s: %v", resource.VMID, resource.Node, err)
+			return false
+		}
+		current = config.Startup
+	} else {
+		config, err := s.proxmox.GetVMConfig(resource.Node, resource.VMID)
+		if err != nil {
+			logging.Errorf("Failed to get VM %d config on node %s: %v", resource.VMID, resource.Node, err)
+			return false
+		}
+		current = config.Startup
+	}
+
+	if sameStartup(current, guest.Startup) {
+		return false
+	}
+
+	logging.Warnf("Guest %d (%s) has tag %s, its startup '%s' diverges from '%s' (%s)", resource.VMID, resource.Name, crsSkipTag, current, guest.Startup, guest.Reason)
+	return true
+}
+
+// reportStartupDivergence logs and exports the guests whose startup setting still diverges from their tier by cause, planning changes nothing
+func (s *Server) reportStartupDivergence(diverged map[string]int) {
+	total := 0
+	for _, count := range diverged {
+		total += count
+	}
+
+	if total > 0 {
+		logging.Warnf("Startup order of %d guests still diverges from their tier: %d failed updates, %d skipped, %d undefined tiers",
+			total, diverged[startupDivergedUpdateFailed], diverged[startupDivergedSkipped], diverged[startupDivergedUndefinedTier])
+	}
+
+	if s.isPlanning() {
+		return
+	}
+
+	for _, cause := range []string{startupDivergedUpdateFailed, startupDivergedSkipped, startupDivergedUndefinedTier} {
+		metrics.StartupDiverged.WithLabelValues(cause).Set(float64(diverged[cause]))
+	}
+}
+
+// updateVMStartOrder sets the startup configuration of a VM.
+// It reports whether the VM was updated and whether the update failed, leaving its startup configuration diverged.
+func (s *Server) updateVMStartOrder(node string, vmid int, startup, reason string) (bool, bool) {
+	logging.Debugf("Checking startup order for VM %d on node %s", vmid, node)
 
 	// Get current VM configuration
 	config, err := s.proxmox.GetVMConfig(node, vmid)
 	if err != nil {
 		logging.Errorf("Failed to get VM %d config on node %s: %v", vmid, node, err)
-		return false
+		return false, false
 	}
 
-	// Check if startup order is already set to order=1
-	if config.Startup == vmStartupCriticalOrder {
+	if sameStartup(config.Startup, startup) {
 		logging.Debugf("VM %d already has correct startup order: %s", vmid, config.Startup)
-		return false
+		return false, false
 	}
 
-	logging.Infof("Updating critical VM %d startup order from '%s' to '%s'", vmid, config.Startup, vmStartupCriticalOrder)
+	logging.Infof("Updating VM %d startup order from '%s' to '%s' (%s)", vmid, config.Startup, startup, reason)
 
 	// Update only the startup configuration
 	updateConfig := proxmox.VMConfig{
-		Startup: vmStartupCriticalOrder,
+		Startup: startup,
 	}
 
-	if err := s.proxmox.UpdateVMConfig(node, vmid, updateConfig); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:     ActionUpdate,
+		Resource: ResourceVMConfig,
+		ID:       fmt.Sprintf("%d", vmid),
+		Node:     node,
+		VMID:     vmid,
+		Before:   "startup=" + config.Startup,
+		After:    "startup=" + startup,
+		Reason:   reason,
+		VMConfig: &updateConfig,
+	}); err != nil {
 		logging.Errorf("Failed to update VM %d startup order on node %s: %v", vmid, node, err)
-		return false
+		return false, true
 	}
 
-	logging.Infof("Successfully updated startup order for critical VM %d", vmid)
-	return true
+	logging.Infof("Successfully updated startup order for VM %d", vmid)
+	return true, false
+}
+
+// updateContainerStartOrder sets the startup configuration of a container.
+// It reports whether the container was updated and whether the update failed, leaving its startup configuration diverged.
+func (s *Server) updateContainerStartOrder(node string, vmid int, startup, reason string) (bool, bool) {
+	config, err := s.proxmox.GetContainerConfig(node, vmid)
+	if err != nil {
+		logging.Errorf("Failed to get container %d config on node %s: %v", vmid, node, err)
+		return false, false
+	}
+
+	if sameStartup(config.Startup, startup) {
+		logging.Debugf("Container %d already has correct startup order: %s", vmid, config.Startup)
+		return false, false
+	}
+
+	logging.Infof("Updating container %d startup order from '%s' to '%s' (%s)", vmid, config.Startup, startup, reason)
+
+	if _, err := s.applyAction(Action{
+		Kind:     ActionUpdate,
+		Resource: ResourceCTConfig,
+		ID:       fmt.Sprintf("%d", vmid),
+		Node:     node,
+		VMID:     vmid,
+		Before:   "startup=" + config.Startup,
+		After:    "startup=" + startup,
+		Reason:   reason,
+		CTConfig: &proxmox.ContainerConfig{Startup: startup},
+	}); err != nil {
+		logging.Errorf("Failed to update container %d startup order on node %s: %v", vmid, node, err)
+		return false, true
+	}
+
+	return true, false
 }
 
 // detachNonSharedCDROMs detaches CD-ROM drives that are on non-shared storage
@@ -216,7 +365,17 @@ func (s *Server) detachNonSharedCDROMs(node string, vmid int) bool {
 		logging.Debugf("Removing CD-ROM drive %s from VM %d", cdromKey, vmid)
 	}
 
-	if err := s.proxmox.UpdateVMConfig(node, vmid, updateConfig); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:     ActionUpdate,
+		Resource: ResourceVMConfig,
+		ID:       fmt.Sprintf("%d", vmid),
+		Node:     node,
+		VMID:     vmid,
+		Before:   strings.Join(cdromsToDetach, ","),
+		After:    "detached",
+		Reason:   "CD-ROM on non-shared storage attached to long-running VM",
+		VMConfig: &updateConfig,
+	}); err != nil {
 		logging.Errorf("Failed to detach CD-ROM drives from VM %d on node %s: %v", vmid, node, err)
 		return false
 	}
@@ -302,7 +461,17 @@ func (s *Server) reevaluateVMHAGroupAfterDetachment(node string, vmid int) error
 	updatedResource := *currentResource
 	updatedResource.Group = newHAGroup
 
-	if err := s.proxmox.UpdateClusterHAResource(updatedResource); err != nil {
+	if _, err := s.applyAction(Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         sid,
+		Node:       node,
+		VMID:       vmid,
+		Before:     currentResource.Group,
+		After:      newHAGroup,
+		Reason:     "storage changed after CD-ROM detachment",
+		HAResource: &updatedResource,
+	}); err != nil {
 		return fmt.Errorf("failed to update HA resource group: %w", err)
 	}
 
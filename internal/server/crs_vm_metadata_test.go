@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
 
@@ -94,8 +95,9 @@ func TestUpdateCriticalVMStartOrder(t *testing.T) {
 			testServer, mockServer := createTestServerWithConfig(config)
 			defer mockServer.Close()
 
-			result := testServer.updateCriticalVMStartOrder("pve1", 106)
+			result, failed := testServer.updateVMStartOrder("pve1", 106, vmStartupCriticalOrder, "VM has crs-critical tag")
 			assert.Equal(t, tt.expectedResult, result)
+			assert.False(t, failed)
 		})
 	}
 }
@@ -363,3 +365,16 @@ func TestReevaluateVMHAGroupAfterDetachment(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestUpdateVMMetaContainers(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeContainers: true,
+		recorder:          recorder,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.UpdateVMMeta())
+
+	assert.Equal(t, []string{"PUT /api2/json/nodes/pve1/lxc/603/config"}, recorder.mutations())
+}
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+	"gopkg.in/yaml.v3"
+)
+
+// policyBlockRe matches fenced code blocks in a VM description, the info string (like yaml) is optional
+var policyBlockRe = regexp.MustCompile("(?ms)^```[A-Za-z]*[ \t]*\r?\n(.*?)^```")
+
+// vmPolicy holds per-VM overrides from a fenced block in the VM description:
+//
+//	```yaml
+//	crs:
+//	  preferred_node: pve1
+//	  allowed_nodes: [pve1, pve2]
+//	  max_restart: 3
+//	  max_relocate: 2
+//	  startup_order: 5
+//	  startup_tier: 2
+//	  detach_cdrom: false
+//	```
+type vmPolicy struct {
+	PreferredNode string   `yaml:"preferred_node"`
+	AllowedNodes  []string `yaml:"allowed_nodes"`
+	MaxRestart    *int     `yaml:"max_restart"`
+	MaxRelocate   *int     `yaml:"max_relocate"`
+	StartupOrder  *int     `yaml:"startup_order"`
+	StartupTier   string   `yaml:"startup_tier"`
+	DetachCDROM   *bool    `yaml:"detach_cdrom"`
+}
+
+// parseVMPolicy returns the policy of the first fenced block with a top-level crs key, or nil without one
+func parseVMPolicy(description string) (*vmPolicy, error) {
+	for _, match := range policyBlockRe.FindAllStringSubmatch(description, -1) {
+		block := match[1]
+		if !strings.HasPrefix(strings.TrimSpace(block), "crs:") {
+			continue
+		}
+
+		var document struct {
+			CRS *vmPolicy `yaml:"crs"`
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewBufferString(block))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("invalid crs block: %w", err)
+		}
+
+		if document.CRS == nil {
+			return &vmPolicy{}, nil
+		}
+
+		return document.CRS, document.CRS.validate()
+	}
+
+	return nil, nil
+}
+
+// validate checks the policy values that do not depend on the cluster
+func (p *vmPolicy) validate() error {
+	for name, value := range map[string]*int{"max_restart": p.MaxRestart, "max_relocate": p.MaxRelocate} {
+		if value != nil && (*value < 0 || *value > vmPolicyMaxHAAttempts) {
+			return fmt.Errorf("%s must be between 0 and %d, got %d", name, vmPolicyMaxHAAttempts, *value)
+		}
+	}
+
+	if p.StartupOrder != nil && *p.StartupOrder < 0 {
+		return fmt.Errorf("startup_order must not be negative, got %d", *p.StartupOrder)
+	}
+
+	if p.StartupOrder != nil && p.StartupTier != "" {
+		return fmt.Errorf("startup_order and startup_tier must not both be set")
+	}
+
+	seen := make(map[string]bool, len(p.AllowedNodes))
+	for _, node := range p.AllowedNodes {
+		if seen[node] {
+			return fmt.Errorf("allowed_nodes lists %s twice", node)
+		}
+		seen[node] = true
+	}
+
+	if p.PreferredNode != "" && len(p.AllowedNodes) > 0 && !seen[p.PreferredNode] {
+		return fmt.Errorf("preferred_node %s is not in allowed_nodes", p.PreferredNode)
+	}
+
+	return nil
+}
+
+// validateNodes checks that the policy only names cluster nodes
+func (p *vmPolicy) validateNodes(nodes []proxmox.Node) error {
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		known[node.Node] = true
+	}
+
+	for _, node := range append([]string{p.PreferredNode}, p.AllowedNodes...) {
+		if node != "" && !known[node] {
+			return fmt.Errorf("node %s does not exist", node)
+		}
+	}
+
+	return nil
+}
+
+// hasPlacement reports whether the policy restricts the nodes of the VM
+func (p *vmPolicy) hasPlacement() bool {
+	return p != nil && (p.PreferredNode != "" || len(p.AllowedNodes) > 0)
+}
+
+// startup returns the Proxmox startup setting of the policy, or an empty string when it sets none
+func (p *vmPolicy) startup() string {
+	if p == nil || p.StartupOrder == nil {
+		return ""
+	}
+	return "order=" + strconv.Itoa(*p.StartupOrder)
+}
+
+// detachCDROM reports whether CD-ROMs of the VM may be detached, the default when the policy does not say
+func (p *vmPolicy) detachCDROM() bool {
+	return p == nil || p.DetachCDROM == nil || *p.DetachCDROM
+}
+
+// policyGroupName returns the HA group of a VM with a placement policy
+func policyGroupName(vmid int) string {
+	return fmt.Sprintf("%s%d", crsPolicyGroupPrefix, vmid)
+}
+
+// policyFromConfig parses and validates the policy of a VM config.
+// The cluster nodes are only fetched for policies that name nodes.
+func (s *Server) policyFromConfig(config *proxmox.VMConfigRead) (*vmPolicy, error) {
+	policy, err := parseVMPolicy(config.Description)
+	if err != nil || policy == nil {
+		return policy, err
+	}
+
+	if _, ok := s.currentConfig().StartupTiers[policy.StartupTier]; policy.StartupTier != "" && !ok {
+		return policy, fmt.Errorf("startup tier %s is not defined", policy.StartupTier)
+	}
+
+	if !policy.hasPlacement() {
+		return policy, nil
+	}
+
+	nodes, err := s.proxmox.GetNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	}
+
+	return policy, policy.validateNodes(nodes)
+}
+
+// getVMPolicy returns the valid policy of a VM, or nil. Policies are read once per cycle,
+// an invalid policy is logged and recorded in the event journal once and then ignored.
+func (s *Server) getVMPolicy(node string, vmid int) *vmPolicy {
+	if policy, ok := s.policyCache[vmid]; ok {
+		return policy
+	}
+
+	config, err := s.proxmox.GetVMConfig(node, vmid)
+	if err != nil {
+		// Not cached, the next caller tries again
+		logging.Warnf("Failed to get VM %d config for its policy: %v", vmid, err)
+		return nil
+	}
+
+	policy, err := s.policyFromConfig(config)
+	if err != nil {
+		s.reportPolicyError(node, vmid, err)
+		policy = nil
+	} else {
+		delete(s.policyErrors, vmid)
+	}
+
+	if s.policyCache == nil {
+		s.policyCache = make(map[int]*vmPolicy)
+	}
+	s.policyCache[vmid] = policy
+
+	return policy
+}
+
+// resetPolicyCache makes the next cycle read the VM policies again
+func (s *Server) resetPolicyCache() {
+	s.policyCache = nil
+}
+
+// reportPolicyError logs an invalid policy and records it in the event journal, repeated errors are only logged at debug level
+func (s *Server) reportPolicyError(node string, vmid int, err error) {
+	if s.policyErrors == nil {
+		s.policyErrors = make(map[int]string)
+	}
+
+	if s.policyErrors[vmid] == err.Error() {
+		logging.Debugf("Ignoring invalid policy of VM %d: %v", vmid, err)
+		return
+	}
+	s.policyErrors[vmid] = err.Error()
+
+	logging.Warnf("Ignoring invalid policy in the description of VM %d: %v", vmid, err)
+
+	if s.isPlanning() {
+		return
+	}
+
+	s.putEvent(consul.Event{
+		Time:     time.Now().UTC(),
+		Kind:     eventKindValidate,
+		Resource: string(ResourceVMConfig),
+		ID:       strconv.Itoa(vmid),
+		Node:     node,
+		VMID:     vmid,
+		Reason:   "policy in VM description",
+		Result:   consul.EventResultInvalid,
+		Error:    err.Error(),
+	})
+}
+
+// expectedPolicyGroup returns the HA group of a VM with a placement policy
+func (s *Server) expectedPolicyGroup(vmid int, policy *vmPolicy, nodes []proxmox.Node) proxmox.ClusterHAGroup {
+	// Excluded nodes only take VMs whose policy names them
+	members := s.placementNodes(nodes, policy.PreferredNode)
+	if len(policy.AllowedNodes) > 0 {
+		members = make([]proxmox.Node, 0, len(policy.AllowedNodes))
+		for _, node := range nodes {
+			if slices.Contains(policy.AllowedNodes, node.Node) {
+				members = append(members, node)
+			}
+		}
+	}
+
+	group := proxmox.ClusterHAGroup{
+		Group:      policyGroupName(vmid),
+		NoFailback: 1,
+	}
+
+	if len(policy.AllowedNodes) > 0 {
+		group.Restricted = 1
+	}
+
+	if policy.PreferredNode != "" {
+		// HA moves the VM back to its preferred node once it is available again
+		group.Nodes = s.generateExpectedPreferNodes(members, policy.PreferredNode)
+		group.NoFailback = 0
+		return group
+	}
+
+	entries := make([]string, 0, len(members))
+	for _, node := range members {
+		entries = append(entries, fmt.Sprintf("%s:%d", node.Node, s.currentConfig().Scheduler.MaxNodePriority))
+	}
+	slices.Sort(entries)
+	group.Nodes = strings.Join(entries, ",")
+
+	return group
+}
+
+// ensurePolicyGroup creates or updates the HA group of a VM with a placement policy
+func (s *Server) ensurePolicyGroup(guest haGuest, nodes []proxmox.Node) error {
+	if !guest.Policy.hasPlacement() {
+		return nil
+	}
+
+	haGroups, err := s.proxmox.GetClusterHAGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get HA groups: %w", err)
+	}
+
+	// The group never holds nodes that do not reach the VM's storage
+	reach, limited, err := s.guestStorageReach(guest)
+	if err != nil {
+		return err
+	}
+
+	if limited {
+		nodes = slices.DeleteFunc(slices.Clone(nodes), func(node proxmox.Node) bool {
+			return !slices.Contains(reach, node.Node)
+		})
+	}
+
+	expected := s.expectedPolicyGroup(guest.VMID, guest.Policy, nodes)
+	if limited {
+		expected.Restricted = 1
+	}
+
+	var existing *proxmox.ClusterHAGroup
+	for _, group := range haGroups {
+		if group.Group == expected.Group {
+			existing = &group
+			break
+		}
+	}
+
+	action := Action{
+		Resource: ResourceHAGroup,
+		ID:       expected.Group,
+		VMID:     guest.VMID,
+		After:    expected.Nodes,
+		HAGroup:  &expected,
+	}
+
+	switch {
+	case existing == nil:
+		action.Kind = ActionCreate
+		action.Reason = "VM has a placement policy"
+	case !s.compareNodeConfiguration(existing.Nodes, expected.Nodes) || existing.Restricted != expected.Restricted || existing.NoFailback != expected.NoFailback:
+		action.Kind = ActionUpdate
+		action.Before = existing.Nodes
+		action.Reason = "placement policy changed"
+	default:
+		return nil
+	}
+
+	logging.Infof("%s HA group %s for the policy of VM %d: nodes=%s", action.Kind, expected.Group, guest.VMID, expected.Nodes)
+
+	if _, err := s.applyAction(action); err != nil {
+		return fmt.Errorf("failed to %s HA group %s: %w", action.Kind, expected.Group, err)
+	}
+
+	s.rateLimitSleep()
+
+	return nil
+}
+
+// enforceHAResourcePolicy moves an existing HA resource into or out of its policy or pool group
+// and applies max_restart and max_relocate from the VM policy, HA class tag or pool policy.
+// Pinned VMs keep their pin group unless all their hostpci devices come from PCI resource mappings. Resources created by CRS return to the default limits without a policy or class.
+func (s *Server) enforceHAResourcePolicy(guest haGuest, resources []proxmox.ClusterHAResource, nodes []proxmox.Node) error {
+	sid := haResourceSID(guest.Type, guest.VMID)
+	current := s.findHAResource(sid, resources)
+	if current == nil {
+		return nil
+	}
+
+	updated := *current
+	policy := guest.Policy
+	reason := "policy in VM description"
+
+	switch {
+	case guest.Type == vmResourceType && (strings.HasPrefix(current.Group, crsPinGroupPrefix) || strings.HasPrefix(current.Group, crsPCIGroupPrefix)):
+		if policy.hasPlacement() {
+			logging.Debugf("VM %d is pinned to its node or PCI devices, ignoring the placement of its policy", guest.VMID)
+		}
+
+		group, err := s.pciPlacementGroup(guest, current.Group, nodes)
+		if err != nil {
+			return err
+		}
+		updated.Group = group
+
+		switch {
+		case updated.Group == current.Group:
+		case strings.HasPrefix(updated.Group, crsPCIGroupPrefix):
+			reason = "hostpci devices from PCI resource mappings"
+		default:
+			reason = "hostpci devices no longer from PCI resource mappings"
+		}
+	case policy.hasPlacement():
+		// Keeps the group in line with the policy before the resource is moved into it
+		if err := s.ensurePolicyGroup(guest, nodes); err != nil {
+			return err
+		}
+		updated.Group = policyGroupName(guest.VMID)
+	case guest.Type == vmResourceType && strings.HasPrefix(current.Group, crsPolicyGroupPrefix):
+		group, err := s.determineVMHAGroup(guest.Node, guest.VMID)
+		if err != nil {
+			return fmt.Errorf("failed to determine HA group of VM %d: %w", guest.VMID, err)
+		}
+		updated.Group = s.poolPlacementGroup(guest, group, nodes)
+	default:
+		updated.Group = s.storagePlacementGroup(guest, current.Group, nodes)
+
+		switch {
+		case updated.Group == current.Group:
+		case preferGroupZone(updated.Group) != preferGroupZone(current.Group) || strings.HasPrefix(updated.Group, crsPinGroupPrefix):
+			reason = "nodes reaching the storage changed"
+		case strings.HasPrefix(updated.Group, crsPoolGroupPrefix):
+			reason = "policy of pool " + guest.Pool
+		default:
+			reason = "pool policy no longer applies"
+		}
+	}
+
+	if maxRestart, maxRelocate, source := s.haLimits(guest); source != "" || current.Comment == haResourceComment {
+		updated.MaxRestart, updated.MaxRelocate = maxRestart, maxRelocate
+		// Limits of 0, like the ones of the conservative class, are only sent when set explicitly
+		updated.LimitsSet = true
+
+		// A group change keeps the policy as the reason
+		if updated.Group == current.Group {
+			reason = source
+			if reason == "" {
+				reason = "default HA limits"
+			}
+		}
+	}
+
+	if updated.Group == current.Group && updated.MaxRestart == current.MaxRestart && updated.MaxRelocate == current.MaxRelocate {
+		return nil
+	}
+
+	if updated.Group != current.Group {
+		if err := s.ensureGuestZoneGroups(guest, updated.Group); err != nil {
+			return err
+		}
+	}
+
+	before := fmt.Sprintf("group=%s max_restart=%d max_relocate=%d", current.Group, current.MaxRestart, current.MaxRelocate)
+	after := fmt.Sprintf("group=%s max_restart=%d max_relocate=%d", updated.Group, updated.MaxRestart, updated.MaxRelocate)
+	logging.Infof("Updating HA resource %s of %s %d (%s): %s -> %s", sid, guest.Kind, guest.VMID, reason, before, after)
+
+	if _, err := s.applyAction(Action{
+		Kind:       ActionUpdate,
+		Resource:   ResourceHAResource,
+		ID:         sid,
+		Node:       guest.Node,
+		VMID:       guest.VMID,
+		Before:     before,
+		After:      after,
+		Reason:     reason,
+		HAResource: &updated,
+	}); err != nil {
+		return fmt.Errorf("failed to update HA resource %s: %w", sid, err)
+	}
+
+	s.rateLimitSleep()
+
+	return nil
+}
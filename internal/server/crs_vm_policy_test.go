@@ -0,0 +1,290 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+func TestParseVMPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		expected    *vmPolicy
+		wantErr     string
+	}{
+		{
+			name:        "no description",
+			description: "",
+		},
+		{
+			name:        "unrelated code block",
+			description: "Web server\n```\nnginx -t\n```\n",
+		},
+		{
+			name:        "full policy",
+			description: "Database\n\n```yaml\ncrs:\n  preferred_node: pve1\n  allowed_nodes: [pve1, pve2]\n  max_restart: 3\n  max_relocate: 0\n  startup_order: 5\n  detach_cdrom: false\n```\n",
+			expected: &vmPolicy{
+				PreferredNode: "pve1",
+				AllowedNodes:  []string{"pve1", "pve2"},
+				MaxRestart:    intValue(3),
+				MaxRelocate:   intValue(0),
+				StartupOrder:  intValue(5),
+				DetachCDROM:   boolPtr(false),
+			},
+		},
+		{
+			name:        "first crs block wins",
+			description: "```\necho hello\n```\n```\ncrs:\n  startup_order: 2\n```\n```\ncrs:\n  startup_order: 3\n```\n",
+			expected:    &vmPolicy{StartupOrder: intValue(2)},
+		},
+		{
+			name:        "empty crs block",
+			description: "```\ncrs:\n```\n",
+			expected:    &vmPolicy{},
+		},
+		{
+			name:        "unknown key",
+			description: "```\ncrs:\n  prefered_node: pve1\n```\n",
+			wantErr:     "field prefered_node not found",
+		},
+		{
+			name:        "max_restart out of range",
+			description: "```\ncrs:\n  max_restart: 11\n```\n",
+			wantErr:     "max_restart must be between 0 and 10",
+		},
+		{
+			name:        "negative startup order",
+			description: "```\ncrs:\n  startup_order: -1\n```\n",
+			wantErr:     "startup_order must not be negative",
+		},
+		{
+			name:        "startup order and tier",
+			description: "```\ncrs:\n  startup_order: 2\n  startup_tier: infra\n```\n",
+			wantErr:     "startup_order and startup_tier must not both be set",
+		},
+		{
+			name:        "preferred node not allowed",
+			description: "```\ncrs:\n  preferred_node: pve3\n  allowed_nodes: [pve1, pve2]\n```\n",
+			wantErr:     "preferred_node pve3 is not in allowed_nodes",
+		},
+		{
+			name:        "duplicate allowed node",
+			description: "```\ncrs:\n  allowed_nodes: [pve1, pve1]\n```\n",
+			wantErr:     "allowed_nodes lists pve1 twice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseVMPolicy(tt.description)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+		})
+	}
+}
+
+func TestVMPolicyDefaults(t *testing.T) {
+	var policy *vmPolicy
+	assert.False(t, policy.hasPlacement())
+	assert.Empty(t, policy.startup())
+	assert.True(t, policy.detachCDROM())
+
+	policy = &vmPolicy{StartupOrder: intValue(0), DetachCDROM: boolPtr(true)}
+	assert.False(t, policy.hasPlacement())
+	assert.Equal(t, "order=0", policy.startup())
+	assert.True(t, policy.detachCDROM())
+
+	policy = &vmPolicy{AllowedNodes: []string{"pve2"}}
+	assert.True(t, policy.hasPlacement())
+	assert.Error(t, policy.validateNodes([]proxmox.Node{{Node: "pve1"}}))
+	assert.NoError(t, policy.validateNodes([]proxmox.Node{{Node: "pve1"}, {Node: "pve2"}}))
+}
+
+func TestExpectedPolicyGroup(t *testing.T) {
+	testServer := &Server{config: defaultCRSConfig()}
+	nodes := []proxmox.Node{{Node: "pve1"}, {Node: "pve2"}, {Node: "pve3"}}
+
+	tests := []struct {
+		name     string
+		policy   *vmPolicy
+		expected proxmox.ClusterHAGroup
+	}{
+		{
+			name:     "preferred node only",
+			policy:   &vmPolicy{PreferredNode: "pve2"},
+			expected: proxmox.ClusterHAGroup{Group: "crs-vm-policy-300", Nodes: "pve1:990,pve2:1000,pve3:995"},
+		},
+		{
+			name:     "allowed nodes only",
+			policy:   &vmPolicy{AllowedNodes: []string{"pve3", "pve1"}},
+			expected: proxmox.ClusterHAGroup{Group: "crs-vm-policy-300", Nodes: "pve1:1000,pve3:1000", Restricted: 1, NoFailback: 1},
+		},
+		{
+			name:     "preferred and allowed nodes",
+			policy:   &vmPolicy{PreferredNode: "pve2", AllowedNodes: []string{"pve1", "pve2"}},
+			expected: proxmox.ClusterHAGroup{Group: "crs-vm-policy-300", Nodes: "pve1:995,pve2:1000", Restricted: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, testServer.expectedPolicyGroup(300, tt.policy, nodes))
+		})
+	}
+}
+
+// policyTestGuest is the critical VM 300 with the given description, in the given HA group or without an HA resource
+func policyTestGuest(description, group string) *guestFixture {
+	return &guestFixture{
+		vmid:   300,
+		name:   "db",
+		tags:   "crs-critical",
+		config: map[string]any{"description": description, "startup": "order=1", "scsi0": "ceph:vm-300-disk-0"},
+		group:  group,
+	}
+}
+
+func TestSetupVMHAResourcesWithPolicy(t *testing.T) {
+	const description = "```yaml\ncrs:\n  preferred_node: pve2\n  max_restart: 2\n```\n"
+
+	t.Run("existing resource moves into the policy group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			guest: policyTestGuest(description, "crs-vm-prefer-pve1"),
+		})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 2)
+		assert.Equal(t, ActionCreate, testServer.plan.Actions[0].Kind)
+		assert.Equal(t, "crs-vm-policy-300", testServer.plan.Actions[0].ID)
+		assert.Equal(t, "pve1:990,pve2:1000,pve3:995", testServer.plan.Actions[0].After)
+
+		update := testServer.plan.Actions[1]
+		assert.Equal(t, ResourceHAResource, update.Resource)
+		assert.Equal(t, "group=crs-vm-policy-300 max_restart=2 max_relocate=10", update.After)
+		assert.Equal(t, "crs-vm-policy-300", update.HAResource.Group)
+	})
+
+	t.Run("new resource uses the policy group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			guest: policyTestGuest(description, ""),
+		})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 2)
+		assert.Equal(t, "crs-vm-policy-300", testServer.plan.Actions[0].ID)
+
+		create := testServer.plan.Actions[1]
+		assert.Equal(t, ActionCreate, create.Kind)
+		assert.Equal(t, "crs-vm-policy-300", create.HAResource.Group)
+		assert.Equal(t, 2, create.HAResource.MaxRestart)
+		assert.Equal(t, proxmox.HAMaxRelocate, create.HAResource.MaxRelocate)
+	})
+
+	t.Run("removed policy returns the resource to its prefer group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			guest: policyTestGuest("", "crs-vm-policy-300"),
+		})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-prefer-pve1", testServer.plan.Actions[0].HAResource.Group)
+	})
+
+	t.Run("pinned resource keeps its group", func(t *testing.T) {
+		testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+			guest: policyTestGuest(description, "crs-vm-pin-pve1"),
+		})
+		defer mockServer.Close()
+
+		testServer.plan = &Plan{}
+
+		require.NoError(t, testServer.SetupVMHAResources())
+
+		require.Len(t, testServer.plan.Actions, 1)
+		assert.Equal(t, "crs-vm-pin-pve1", testServer.plan.Actions[0].HAResource.Group)
+		assert.Equal(t, 2, testServer.plan.Actions[0].HAResource.MaxRestart)
+	})
+}
+
+func TestInvalidVMPolicyIsReported(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		guest:    policyTestGuest("```\ncrs:\n  preferred_node: pve9\n```\n", "crs-vm-prefer-pve1"),
+		recorder: recorder,
+	})
+	defer mockServer.Close()
+	journal := &memoryJournal{}
+	testServer.journal = journal
+
+	assert.Nil(t, testServer.getVMPolicy("pve1", 300))
+
+	// Cached for the cycle, and reported only once across cycles
+	assert.Nil(t, testServer.getVMPolicy("pve1", 300))
+	testServer.resetPolicyCache()
+	assert.Nil(t, testServer.getVMPolicy("pve1", 300))
+
+	require.Len(t, journal.events, 1)
+	event := journal.events[0]
+	assert.Equal(t, eventKindValidate, event.Kind)
+	assert.Equal(t, 300, event.VMID)
+	assert.Equal(t, consul.EventResultInvalid, event.Result)
+	assert.Contains(t, event.Error, "node pve9 does not exist")
+
+	configReads := 0
+	for _, request := range recorder.requests {
+		if strings.HasSuffix(request, "/qemu/300/config") {
+			configReads++
+		}
+	}
+	assert.Equal(t, 2, configReads)
+
+	// An invalid policy is ignored, the VM keeps its prefer group
+	testServer.plan = &Plan{}
+	testServer.resetPolicyCache()
+	require.NoError(t, testServer.SetupVMHAResources())
+	assert.Empty(t, testServer.plan.Actions)
+}
+
+func TestUpdateVMMetaWithPolicy(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		guest: policyTestGuest("```\ncrs:\n  startup_order: 4\n```\n", ""),
+	})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+
+	require.NoError(t, testServer.UpdateVMMeta())
+
+	require.Len(t, testServer.plan.Actions, 1)
+	action := testServer.plan.Actions[0]
+	assert.Equal(t, "startup=order=1", action.Before)
+	assert.Equal(t, "startup=order=4", action.After, "the policy overrides the order of crs-critical")
+	assert.Equal(t, "startup_order in VM policy", action.Reason)
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
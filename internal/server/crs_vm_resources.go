@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
@@ -9,9 +10,22 @@ import (
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/tools"
 )
 
-// SetupVMHAResources creates HA resources for VMs that don't have them
+// haGuest is a VM or container that CRS manages through an HA resource
+type haGuest struct {
+	Type   string // Cluster resource type, qemu or lxc
+	Kind   string // "VM" or "container", used in log messages
+	VMID   int
+	Name   string
+	Node   string
+	Status string
+	Tags   string
+	Pool   string    // Proxmox pool, empty outside a pool
+	Policy *vmPolicy // Policy from the VM description, nil for containers and VMs without one
+}
+
+// SetupVMHAResources creates HA resources for VMs and containers that don't have them
 func (s *Server) SetupVMHAResources() error {
-	logging.Debug("Setting up HA resources for VMs")
+	logging.Debug("Setting up HA resources for VMs and containers")
 
 	// Get existing HA resources to avoid duplicates
 	resources, err := s.proxmox.GetClusterHAResources()
@@ -25,11 +39,16 @@ func (s *Server) SetupVMHAResources() error {
 		return fmt.Errorf("failed to get nodes: %w", err)
 	}
 
+	clusterResources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+	rules := s.buildAffinityRules(clusterResources)
+	pools := guestPools(clusterResources)
+
 	var createdCount int
 
 	for _, node := range nodeList {
-		haGroupPin := tools.GetHAVMPinGroupName(node.Node)
-
 		vmList, err := s.proxmox.GetNodeVMs(node.Node)
 		if err != nil {
 			return fmt.Errorf("failed to get VMs for node %s: %w", node.Node, err)
@@ -48,123 +67,323 @@ func (s *Server) SetupVMHAResources() error {
 				continue
 			}
 
-			sid := fmt.Sprintf("%s:%d", haResourceType, vm.VMID)
-
-			// Check if VM already has HA resource
-			var existingResource *proxmox.ClusterHAResource
-			for _, resource := range resources {
-				if resource.SID == sid {
-					existingResource = &resource
-					break
-				}
+			guest := haGuest{
+				Type:   vmResourceType,
+				Kind:   "VM",
+				VMID:   vm.VMID,
+				Name:   vm.Name,
+				Node:   node.Node,
+				Status: vm.Status,
+				Tags:   vm.Tags,
+				Pool:   pools[vm.VMID],
+				Policy: s.getVMPolicy(node.Node, vm.VMID),
 			}
 
-			if existingResource != nil {
-				// Check if existing resource is disabled and needs to be started
-				if existingResource.State == haStateDisabled {
-					// Always switch disabled HA resources to 'started' state
-					// Proxmox stops VMs when HA resource is disabled, so we want to re-enable them
-					newState := haStateStarted
+			changed, err := s.ensureGuestHAResource(guest, resources, nodeList, rules, s.determineVMHAGroup)
+			if err != nil {
+				return err
+			}
+			if changed {
+				createdCount++
+			}
 
-					logging.Infof("Updating disabled HA resource for VM %d (%s) from 'disabled' to '%s'", vm.VMID, vm.Name, newState)
+			if err := s.enforceHAResourcePolicy(guest, resources, nodeList); err != nil {
+				return err
+			}
+		}
 
-					// Update the HA resource state
-					updatedResource := *existingResource
-					updatedResource.State = newState
+		containers, err := s.proxmox.GetNodeContainers(node.Node)
+		if err != nil {
+			return fmt.Errorf("failed to get containers for node %s: %w", node.Node, err)
+		}
 
-					if err := s.proxmox.UpdateClusterHAResource(updatedResource); err != nil {
-						return fmt.Errorf("failed to update HA resource state for %s (%s): %w", sid, vm.Name, err)
-					}
+		for _, ct := range containers {
+			if ct.Template == vmTemplateFlag {
+				logging.Debugf("Skipping template container %d (%s) on node %s", ct.VMID, ct.Name, node.Node)
+				continue
+			}
 
-					logging.Infof("Successfully updated HA resource for VM %d (%s) from 'disabled' to '%s'", vm.VMID, vm.Name, newState)
-					createdCount++ // Count as a change
-				} else {
-					logging.Debugf("VM %d (%s) already has HA resource in state '%s', skipping", vm.VMID, vm.Name, existingResource.State)
-				}
+			if s.hasVMSkipTag(ct.Tags) {
+				logging.Debugf("Skipping container %d (%s) with crs-skip tag on node %s", ct.VMID, ct.Name, node.Node)
 				continue
 			}
 
-			// Determine initial HA state based on VM status
-			var haState string
-			switch vm.Status {
-			case vmStatusRunning:
-				haState = haStateStarted
-			case vmStatusStopped:
-				haState = haStateStopped
-			default:
-				haState = haStateIgnored
+			guest := haGuest{
+				Type:   ctResourceType,
+				Kind:   "container",
+				VMID:   ct.VMID,
+				Name:   ct.Name,
+				Node:   node.Node,
+				Status: ct.Status,
+				Tags:   ct.Tags,
+				Pool:   pools[ct.VMID],
 			}
 
-			// Determine appropriate HA group based on VM storage configuration
-			haGroup, err := s.determineVMHAGroup(node.Node, vm.VMID)
+			changed, err := s.ensureGuestHAResource(guest, resources, nodeList, rules, s.determineContainerHAGroup)
 			if err != nil {
-				logging.Warnf("Failed to determine HA group for VM %d (%s), using pin group: %v", vm.VMID, vm.Name, err)
-				haGroup = haGroupPin
+				return err
 			}
-
-			// Create HA resource
-			data := proxmox.ClusterHAResource{
-				SID:         sid,
-				Type:        haResourceType,
-				Comment:     haResourceComment,
-				MaxRelocate: proxmox.HAMaxRelocate,
-				MaxRestart:  proxmox.HAMaxRestart,
-				Group:       haGroup,
-				State:       haState,
+			if changed {
+				createdCount++
 			}
 
-			if _, err := s.proxmox.CreateClusterHAResource(data); err != nil {
-				return fmt.Errorf("failed to create HA resource for %s (%s): %w", sid, vm.Name, err)
+			if err := s.enforceHAResourcePolicy(guest, resources, nodeList); err != nil {
+				return err
 			}
-
-			logging.Infof("Created HA resource for VM %d (%s) on node %s with state %s and assigned to HA group %s", vm.VMID, vm.Name, node.Node, haState, haGroup)
-			createdCount++
-
-			// Sleep to avoid overwhelming the Proxmox API
-			s.rateLimitSleep()
 		}
 	}
 
 	if createdCount > 0 {
-		logging.Infof("Created or updated %d HA resources for VMs", createdCount)
+		logging.Infof("Created or updated %d HA resources for VMs and containers", createdCount)
 	} else {
-		logging.Debug("No HA resource changes needed for VMs")
+		logging.Debug("No HA resource changes needed for VMs and containers")
 	}
 
 	return nil
 }
 
+// ensureGuestHAResource creates the HA resource of a guest, or re-enables a disabled one.
+// determineGroup returns the pin or prefer group for the guest's storage configuration.
+// It reports whether anything was changed.
+func (s *Server) ensureGuestHAResource(guest haGuest, resources []proxmox.ClusterHAResource, nodeList []proxmox.Node, rules *affinityRules, determineGroup func(string, int) (string, error)) (bool, error) {
+	sid := haResourceSID(guest.Type, guest.VMID)
+
+	// Check if guest already has HA resource
+	var existingResource *proxmox.ClusterHAResource
+	for _, resource := range resources {
+		if resource.SID == sid {
+			existingResource = &resource
+			break
+		}
+	}
+
+	if existingResource != nil {
+		// Check if existing resource is disabled and needs to be started
+		if existingResource.State != haStateDisabled {
+			logging.Debugf("%s %d (%s) already has HA resource in state '%s', skipping", guest.Kind, guest.VMID, guest.Name, existingResource.State)
+			return false, nil
+		}
+
+		// Always switch disabled HA resources to 'started' state
+		// Proxmox stops guests when HA resource is disabled, so we want to re-enable them
+		newState := haStateStarted
+
+		logging.Infof("Updating disabled HA resource for %s %d (%s) from 'disabled' to '%s'", guest.Kind, guest.VMID, guest.Name, newState)
+
+		// Update the HA resource state
+		updatedResource := *existingResource
+		updatedResource.State = newState
+
+		if _, err := s.applyAction(Action{
+			Kind:       ActionUpdate,
+			Resource:   ResourceHAResource,
+			ID:         sid,
+			Node:       guest.Node,
+			VMID:       guest.VMID,
+			Before:     existingResource.State,
+			After:      newState,
+			Reason:     "HA resource is disabled",
+			HAResource: &updatedResource,
+		}); err != nil {
+			return false, fmt.Errorf("failed to update HA resource state for %s (%s): %w", sid, guest.Name, err)
+		}
+
+		logging.Infof("Successfully updated HA resource for %s %d (%s) from 'disabled' to '%s'", guest.Kind, guest.VMID, guest.Name, newState)
+		return true, nil
+	}
+
+	// Determine initial HA state based on guest status
+	var haState string
+	switch guest.Status {
+	case vmStatusRunning:
+		haState = haStateStarted
+	case vmStatusStopped:
+		haState = haStateStopped
+	default:
+		haState = haStateIgnored
+	}
+
+	// Determine appropriate HA group based on guest storage configuration
+	haGroup, err := determineGroup(guest.Node, guest.VMID)
+	if err != nil {
+		logging.Warnf("Failed to determine HA group for %s %d (%s), using pin group: %v", guest.Kind, guest.VMID, guest.Name, err)
+		haGroup = tools.GetHAVMPinGroupName(guest.Node)
+	}
+
+	// A pool policy replaces the prefer group, affinity rules pick among the prefer groups over all nodes
+	haGroup = s.poolPlacementGroup(guest, haGroup, nodeList)
+
+	if strings.HasPrefix(haGroup, crsPreferGroupPrefix) && preferGroupZone(haGroup) == "" {
+		haGroup = s.affinityPreferGroup(guest.VMID, guest.Node, nodeList, rules)
+	}
+
+	if err := s.ensureGuestZoneGroups(guest, haGroup); err != nil {
+		return false, err
+	}
+
+	if strings.HasPrefix(haGroup, crsPolicyGroupPrefix) {
+		if err := s.ensurePolicyGroup(guest, nodeList); err != nil {
+			return false, err
+		}
+	}
+
+	if strings.HasPrefix(haGroup, crsPCIGroupPrefix) {
+		if err := s.ensurePCIGroup(guest); err != nil {
+			return false, err
+		}
+	}
+
+	maxRestart, maxRelocate, _ := s.haLimits(guest)
+
+	// Create HA resource
+	data := proxmox.ClusterHAResource{
+		SID:         sid,
+		Type:        haResourceTypeOf(guest.Type),
+		Comment:     haResourceComment,
+		MaxRelocate: maxRelocate,
+		MaxRestart:  maxRestart,
+		Group:       haGroup,
+		State:       haState,
+		LimitsSet:   true,
+	}
+
+	if _, err := s.applyAction(Action{
+		Kind:       ActionCreate,
+		Resource:   ResourceHAResource,
+		ID:         sid,
+		Node:       guest.Node,
+		VMID:       guest.VMID,
+		After:      fmt.Sprintf("group=%s state=%s", haGroup, haState),
+		Reason:     fmt.Sprintf("%s has no HA resource", guest.Kind),
+		HAResource: &data,
+	}); err != nil {
+		return false, fmt.Errorf("failed to create HA resource for %s (%s): %w", sid, guest.Name, err)
+	}
+
+	logging.Infof("Created HA resource for %s %d (%s) on node %s with state %s and assigned to HA group %s", guest.Kind, guest.VMID, guest.Name, guest.Node, haState, haGroup)
+
+	// Sleep to avoid overwhelming the Proxmox API
+	s.rateLimitSleep()
+
+	return true, nil
+}
+
 // determineVMHAGroup determines the appropriate HA group for a VM based on its storage configuration and hardware devices
 func (s *Server) determineVMHAGroup(nodeName string, vmid int) (string, error) {
+	group, reason, err := s.vmPlacement(nodeName, vmid)
+	if err != nil {
+		return "", err
+	}
+
+	logging.Debugf("VM %d %s, assigning to HA group: %s", vmid, reason, group)
+	return group, nil
+}
+
+// vmPlacement returns the HA group for a VM and why it was chosen
+func (s *Server) vmPlacement(nodeName string, vmid int) (string, string, error) {
 	// Get VM configuration to analyze disk storage and hardware devices
 	vmConfig, err := s.proxmox.GetVMConfig(nodeName, vmid)
 	if err != nil {
-		return "", fmt.Errorf("failed to get VM config: %w", err)
+		return "", "", fmt.Errorf("failed to get VM config: %w", err)
 	}
 
-	// Check if VM has hostpci devices (PCIe passthrough) - these require pinning to specific node
+	// Check if VM has hostpci devices (PCIe passthrough) - these require pinning to specific node,
+	// unless every device comes from a resource mapping that other nodes provide as well
 	if len(vmConfig.HostPCI) > 0 {
-		pinGroup := tools.GetHAVMPinGroupName(nodeName)
-		logging.Debugf("VM %d has hostpci devices (%v), must use pin group: %s", vmid, getHostPCIDeviceList(vmConfig.HostPCI), pinGroup)
-		return pinGroup, nil
+		group, err := s.pciMappingGroup(nodeName, vmid, vmConfig)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to check PCI resource mappings: %w", err)
+		}
+
+		if group != nil {
+			ids, _ := pciMappingIDs(vmConfig.HostPCI)
+			return group.Group, fmt.Sprintf("has hostpci devices from PCI resource mappings (%s)", strings.Join(ids, ", ")), nil
+		}
+
+		devices := getHostPCIDeviceList(vmConfig.HostPCI)
+		sort.Strings(devices)
+		return tools.GetHAVMPinGroupName(nodeName), fmt.Sprintf("has hostpci devices (%s)", strings.Join(devices, ", ")), nil
 	}
 
 	// Check if all VM disks are on shared storage
 	allDisksShared, err := s.areAllVMDisksShared(vmConfig.Disks)
 	if err != nil {
-		return "", fmt.Errorf("failed to analyze VM storage: %w", err)
+		return "", "", fmt.Errorf("failed to analyze VM storage: %w", err)
 	}
 
 	if allDisksShared {
 		// All disks are on shared storage - use prefer group for better load distribution
-		preferGroup := tools.GetHAVMPreferGroupName(nodeName)
-		logging.Debugf("VM %d has all disks on shared storage, assigning to prefer group: %s", vmid, preferGroup)
-		return preferGroup, nil
+		group, reason, err := s.sharedStorageGroup(nodeName, vmConfig.Disks, "has all disks on shared storage")
+		if err != nil || !strings.HasPrefix(group, crsPreferGroupPrefix) {
+			return group, reason, err
+		}
+
+		// A valid placement policy replaces the prefer group, invalid policies are reported by the reconcile cycle
+		if policy, err := s.policyFromConfig(vmConfig); err == nil && policy.hasPlacement() {
+			return policyGroupName(vmid), reason + " and a placement policy", nil
+		}
+
+		return group, reason, nil
 	}
+
 	// At least one disk is on local/non-shared storage - use pin group to keep VM on this node
+	return tools.GetHAVMPinGroupName(nodeName), "has local storage", nil
+}
+
+// determineContainerHAGroup determines the HA group for a container based on its rootfs, mount points and devices
+func (s *Server) determineContainerHAGroup(nodeName string, vmid int) (string, error) {
+	group, reason, err := s.containerPlacement(nodeName, vmid)
+	if err != nil {
+		return "", err
+	}
+
+	logging.Debugf("Container %d %s, assigning to HA group: %s", vmid, reason, group)
+	return group, nil
+}
+
+// containerPlacement returns the HA group for a container and why it was chosen
+func (s *Server) containerPlacement(nodeName string, vmid int) (string, string, error) {
+	ctConfig, err := s.proxmox.GetContainerConfig(nodeName, vmid)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get container config: %w", err)
+	}
+
 	pinGroup := tools.GetHAVMPinGroupName(nodeName)
-	logging.Debugf("VM %d has local storage, assigning to pin group: %s", vmid, pinGroup)
-	return pinGroup, nil
+
+	// Passed-through devices and bind mounts of host paths only exist on this node
+	if len(ctConfig.Devices) > 0 {
+		return pinGroup, "has passed-through devices", nil
+	}
+
+	for key, value := range ctConfig.Disks {
+		if isContainerBindMount(value) {
+			return pinGroup, fmt.Sprintf("has bind mount %s=%s", key, value), nil
+		}
+	}
+
+	allDisksShared, err := s.areAllVMDisksShared(ctConfig.Disks)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to analyze container storage: %w", err)
+	}
+
+	if allDisksShared {
+		return s.sharedStorageGroup(nodeName, ctConfig.Disks, "has rootfs and mount points on shared storage")
+	}
+
+	return pinGroup, "has local storage", nil
+}
+
+// guestPlacement returns the HA group for a VM or container and why it was chosen
+func (s *Server) guestPlacement(resourceType, nodeName string, vmid int) (string, string, error) {
+	if resourceType == ctResourceType {
+		return s.containerPlacement(nodeName, vmid)
+	}
+	return s.vmPlacement(nodeName, vmid)
+}
+
+// isContainerBindMount checks if a container mount point is a host path instead of a storage volume
+func isContainerBindMount(value string) bool {
+	return strings.HasPrefix(value, "/")
 }
 
 // getHostPCIDeviceList returns a list of hostpci device keys for logging
@@ -236,8 +455,8 @@ func (s *Server) areAllVMDisksShared(disks map[string]string) (bool, error) {
 // isDiskEntry checks if a configuration key represents any storage device
 func (s *Server) isDiskEntry(key string) bool {
 	// Proxmox storage device keys: virtio0, virtio1, sata0, sata1, scsi0, scsi1, ide0, ide1, ide2, etc.
-	// Include ALL storage devices including CD-ROM drives
-	storageDevicePrefixes := []string{"virtio", "sata", "scsi", "ide"}
+	// Include ALL storage devices including CD-ROM drives, and container rootfs and mount points (mp0, mp1, etc.)
+	storageDevicePrefixes := []string{"virtio", "sata", "scsi", "ide", "rootfs", "mp"}
 
 	for _, prefix := range storageDevicePrefixes {
 		if strings.HasPrefix(key, prefix) {
@@ -268,3 +487,18 @@ func (s *Server) extractStorageFromDiskConfig(diskConfig string) string {
 
 	return ""
 }
+
+// isStorageAvailableOnNode checks the storage node restriction, an empty list means all nodes
+func isStorageAvailableOnNode(storage proxmox.Storage, node string) bool {
+	if storage.Nodes == "" {
+		return true
+	}
+
+	for _, name := range strings.Split(storage.Nodes, ",") {
+		if strings.TrimSpace(name) == node {
+			return true
+		}
+	}
+
+	return false
+}
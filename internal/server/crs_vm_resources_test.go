@@ -229,6 +229,8 @@ func TestIsDiskEntry(t *testing.T) {
 		{"ide disk 1", "ide1", true},
 		{"ide2 cd-rom should be included", "ide2", true},
 		{"ide3 disk", "ide3", true},
+		{"container rootfs", "rootfs", true},
+		{"container mount point", "mp0", true},
 		{"network interface", "net0", false},
 		{"cpu setting", "cores", false},
 		{"memory setting", "memory", false},
@@ -609,3 +611,31 @@ func TestDetermineVMHAGroupWithSCSIHW(t *testing.T) {
 		})
 	}
 }
+
+func TestSetupVMHAResourcesContainers(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.plan = &Plan{}
+	require.NoError(t, testServer.SetupVMHAResources())
+
+	require.Len(t, testServer.plan.Actions, 1, "skipped containers and containers with HA resources are left alone")
+	action := testServer.plan.Actions[0]
+	assert.Equal(t, "ct:600", action.ID)
+	assert.Equal(t, "ct", action.HAResource.Type)
+	assert.Equal(t, "crs-vm-prefer-pve1", action.HAResource.Group, "rootfs and mount points on shared storage")
+	assert.Equal(t, "started", action.HAResource.State)
+}
+
+func TestDetermineContainerHAGroup(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	group, err := testServer.determineContainerHAGroup("pve1", 600)
+	require.NoError(t, err)
+	assert.Equal(t, "crs-vm-prefer-pve1", group)
+
+	group, err = testServer.determineContainerHAGroup("pve1", 603)
+	require.NoError(t, err)
+	assert.Equal(t, "crs-vm-pin-pve1", group, "local rootfs")
+}
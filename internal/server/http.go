@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/metrics"
+)
+
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpShutdownTimeout   = 5 * time.Second
+)
+
+// newRouter returns the HTTP routes served by every CRS instance, leader or standby
+func (s *Server) newRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	if s.apiToken == "" {
+		logging.Warn("No admin API token configured in Consul, the admin API is disabled")
+	} else {
+		s.addAdminRoutes(router)
+	}
+
+	return router
+}
+
+// runHTTPServer serves the HTTP routes on listenAddress until ctx is cancelled
+func (s *Server) runHTTPServer(ctx context.Context, listenAddress string) error {
+	httpServer := &http.Server{
+		Addr:              listenAddress,
+		Handler:           s.newRouter(),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+
+	serveResult := make(chan error, 1)
+	go func() {
+		logging.Infof("Serving HTTP on %s", listenAddress)
+		serveResult <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveResult:
+		return fmt.Errorf("http server: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server shutdown: %w", err)
+	}
+
+	return nil
+}
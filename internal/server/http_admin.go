@@ -0,0 +1,408 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
+)
+
+// errGuestNotFound is returned for a VMID that is neither a VM nor a container in the cluster
+var errGuestNotFound = errors.New("guest not found")
+
+// apiStatus is the response of GET /status
+type apiStatus struct {
+	Leader       bool           `json:"leader"`
+	Paused       bool           `json:"paused"`
+	LastCycle    *cycleStatus   `json:"last_cycle"`
+	Managed      *managedGuests `json:"managed,omitempty"`
+	ManagedError string         `json:"managed_error,omitempty"`
+
+	MaintenanceWindows []windowStatus       `json:"maintenance_windows"`
+	Power              []consul.PowerState  `json:"power"`
+	Breaker            *consul.BreakerState `json:"breaker"`
+}
+
+// apiGuest is CRS's view of one VM or container, the response of GET /vms/{vmid}
+type apiGuest struct {
+	VMID          int      `json:"vmid"`
+	Type          string   `json:"type"`
+	Name          string   `json:"name"`
+	Node          string   `json:"node"`
+	Status        string   `json:"status"`
+	Tags          []string `json:"tags"`
+	Pool          string   `json:"pool,omitempty"`
+	Managed       bool     `json:"managed"`
+	Critical      bool     `json:"critical"`
+	Group         string   `json:"group,omitempty"`
+	HAState       string   `json:"ha_state,omitempty"`
+	ExpectedGroup string   `json:"expected_group,omitempty"`
+	Reason        string   `json:"reason"`
+
+	Startup         string `json:"startup,omitempty"`
+	ExpectedStartup string `json:"expected_startup,omitempty"`
+	StartupReason   string `json:"startup_reason,omitempty"`
+}
+
+// addAdminRoutes registers the admin API on router, every route requires the API token
+func (s *Server) addAdminRoutes(router *mux.Router) {
+	admin := router.NewRoute().Subrouter()
+	admin.Use(s.requireAPIToken)
+
+	admin.HandleFunc("/status", s.httpStatusGet).Methods(http.MethodGet)
+	admin.HandleFunc("/reconcile", s.httpReconcilePost).Methods(http.MethodPost)
+	admin.HandleFunc("/pause", s.httpPausePost).Methods(http.MethodPost)
+	admin.HandleFunc("/resume", s.httpResumePost).Methods(http.MethodPost)
+	admin.HandleFunc("/breaker/acknowledge", s.httpBreakerAcknowledgePost).Methods(http.MethodPost)
+	admin.HandleFunc("/vms/{vmid:[0-9]+}", s.httpVMGet).Methods(http.MethodGet)
+	admin.HandleFunc("/events", s.httpEventsGet).Methods(http.MethodGet)
+	admin.HandleFunc("/drains", s.httpDrainsGet).Methods(http.MethodGet)
+	admin.HandleFunc("/nodes/{node}/drain", s.httpNodeDrainPost).Methods(http.MethodPost)
+	admin.HandleFunc("/nodes/{node}/drain", s.httpNodeDrainDelete).Methods(http.MethodDelete)
+}
+
+// requireAPIToken rejects requests without the admin API bearer token
+func (s *Server) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) httpStatusGet(w http.ResponseWriter, _ *http.Request) {
+	status := apiStatus{
+		Leader:    s.IsLeader(),
+		Paused:    s.IsPaused(),
+		LastCycle: s.getLastCycle(),
+
+		MaintenanceWindows: s.getWindowStatuses(),
+		Power:              s.getPowerStates(),
+		Breaker:            s.getBreakerState(),
+	}
+
+	// Proxmox being unreachable must not hide the leader and pause state
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		status.ManagedError = err.Error()
+	} else {
+		managed := countManagedGuests(haResources)
+		status.Managed = &managed
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) httpReconcilePost(w http.ResponseWriter, _ *http.Request) {
+	switch {
+	case !s.IsLeader():
+		writeJSONError(w, http.StatusConflict, "this instance is not the CRS leader")
+		return
+	case s.IsPaused():
+		writeJSONError(w, http.StatusConflict, "reconciliation is paused")
+		return
+	}
+
+	if s.requestReconcile() {
+		logging.Info("Reconciliation requested through the admin API")
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "reconcile requested"})
+}
+
+func (s *Server) httpPausePost(w http.ResponseWriter, _ *http.Request) {
+	s.httpSetPaused(w, true)
+}
+
+func (s *Server) httpResumePost(w http.ResponseWriter, _ *http.Request) {
+	s.httpSetPaused(w, false)
+}
+
+func (s *Server) httpSetPaused(w http.ResponseWriter, paused bool) {
+	if err := s.setPaused(paused); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to store pause state: %v", err))
+		return
+	}
+
+	if paused {
+		logging.Warn("Reconciliation paused through the admin API")
+	} else {
+		logging.Info("Reconciliation resumed through the admin API")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": paused})
+}
+
+// httpBreakerAcknowledgePost resets the tripped circuit breaker, automation resumes with the next cycle
+func (s *Server) httpBreakerAcknowledgePost(w http.ResponseWriter, _ *http.Request) {
+	acknowledged, err := s.acknowledgeBreaker()
+	switch {
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to reset circuit breaker: %v", err))
+		return
+	case !acknowledged:
+		writeJSONError(w, http.StatusConflict, "circuit breaker is not tripped")
+		return
+	}
+
+	if s.IsLeader() && !s.IsPaused() {
+		s.requestReconcile()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"breaker": "acknowledged"})
+}
+
+func (s *Server) httpVMGet(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(mux.Vars(r)["vmid"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid VMID")
+		return
+	}
+
+	guest, err := s.describeGuest(vmid)
+	switch {
+	case errors.Is(err, errGuestNotFound):
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("VM %d not found", vmid))
+	case err != nil:
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, guest)
+	}
+}
+
+// httpEventsGet lists journal entries, newest first, filtered by the vmid, resource and kind query parameters
+func (s *Server) httpEventsGet(w http.ResponseWriter, r *http.Request) {
+	if s.journal == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "event journal is not available")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := consul.EventFilter{
+		Resource: query.Get("resource"),
+		Kind:     query.Get("kind"),
+		Limit:    eventsDefaultListLimit,
+	}
+
+	for name, target := range map[string]*int{"vmid": &filter.VMID, "limit": &filter.Limit} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", name, value))
+			return
+		}
+		*target = number
+	}
+
+	events, err := s.journal.ListEvents(filter)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]consul.Event{"events": events})
+}
+
+func (s *Server) httpDrainsGet(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]consul.DrainState{"drains": s.drainStates()})
+}
+
+// httpNodeDrainPost starts draining a node, the leader migrates its guests in the next cycles
+func (s *Server) httpNodeDrainPost(w http.ResponseWriter, r *http.Request) {
+	if !s.IsLeader() {
+		writeJSONError(w, http.StatusConflict, "this instance is not the CRS leader")
+		return
+	}
+
+	node := mux.Vars(r)["node"]
+
+	state, created, err := s.startDrain(node)
+	switch {
+	case errors.Is(err, errNodeNotFound):
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("node %s not found", node))
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	if !created {
+		writeJSON(w, http.StatusOK, state)
+		return
+	}
+
+	s.requestReconcile()
+	writeJSON(w, http.StatusAccepted, state)
+}
+
+// httpNodeDrainDelete ends the drain of a node, migrations in flight keep running
+func (s *Server) httpNodeDrainDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.IsLeader() {
+		writeJSONError(w, http.StatusConflict, "this instance is not the CRS leader")
+		return
+	}
+
+	node := mux.Vars(r)["node"]
+
+	found, err := s.cancelDrain(node)
+	switch {
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to remove drain state: %v", err))
+	case !found:
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("node %s is not drained", node))
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"node": node, "status": "cancelled"})
+	}
+}
+
+// describeGuest returns CRS's view of a VM or container: its HA group, tags and why CRS treats it that way
+func (s *Server) describeGuest(vmid int) (*apiGuest, error) {
+	resources, err := s.proxmox.GetClusterResources()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	var guest *apiGuest
+	for _, resource := range resources {
+		if !isGuestResource(resource) || resource.VMID != vmid {
+			continue
+		}
+
+		guest = &apiGuest{
+			VMID:     resource.VMID,
+			Type:     resource.Type,
+			Name:     resource.Name,
+			Node:     resource.Node,
+			Status:   resource.Status,
+			Tags:     []string{},
+			Critical: s.hasVMCriticalTag(resource.Tags),
+			Pool:     resource.Pool,
+		}
+
+		for _, tag := range strings.Split(resource.Tags, ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				guest.Tags = append(guest.Tags, tag)
+			}
+		}
+
+		switch {
+		case resource.Template == vmTemplateFlag:
+			guest.Reason = "templates are not managed"
+			return guest, nil
+		case s.hasVMSkipTag(resource.Tags):
+			guest.Reason = "tagged " + crsSkipTag + ", not managed"
+			return guest, nil
+		}
+
+		break
+	}
+
+	if guest == nil {
+		return nil, errGuestNotFound
+	}
+
+	if err := s.describeStartup(guest); err != nil {
+		return nil, err
+	}
+
+	haResources, err := s.proxmox.GetClusterHAResources()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HA resources: %w", err)
+	}
+
+	group, reason, err := s.guestPlacement(guest.Type, guest.Node, guest.VMID)
+	if err != nil {
+		return nil, err
+	}
+
+	if guest.Pool != "" && strings.HasPrefix(group, crsPreferGroupPrefix) && preferGroupZone(group) == "" {
+		nodes, err := s.proxmox.GetNodes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get nodes: %w", err)
+		}
+
+		if poolGroup, ok := s.poolGroup(guest.Pool, nodes); ok {
+			group = poolGroup
+			reason += " and is in pool " + guest.Pool + " with a policy"
+		}
+	}
+
+	guest.Managed = true
+	guest.ExpectedGroup = group
+	guest.Reason = reason
+
+	haResource := s.findHAResource(haResourceSID(guest.Type, guest.VMID), haResources)
+	if haResource == nil {
+		guest.Reason += ", the HA resource is created in the next cycle"
+		return guest, nil
+	}
+
+	guest.Group = haResource.Group
+	guest.HAState = haResource.State
+
+	if haResource.Group != group && strings.HasPrefix(group, crsPreferGroupPrefix) && strings.HasPrefix(haResource.Group, crsPreferGroupPrefix) {
+		// Affinity rules, DRS and maintenance re-home prefer-group guests
+		guest.Reason += ", prefer group follows the node chosen by affinity, DRS or maintenance"
+	}
+
+	return guest, nil
+}
+
+// describeStartup adds the startup setting of a guest and the one CRS keeps on it, they differ until the next cycle
+func (s *Server) describeStartup(guest *apiGuest) error {
+	resource := proxmox.ClusterResource{Type: guest.Type, VMID: guest.VMID, Name: guest.Name, Tags: strings.Join(guest.Tags, ";")}
+
+	var policy *vmPolicy
+	if guest.Type == ctResourceType {
+		config, err := s.proxmox.GetContainerConfig(guest.Node, guest.VMID)
+		if err != nil {
+			return fmt.Errorf("failed to get container config: %w", err)
+		}
+		guest.Startup = config.Startup
+	} else {
+		config, err := s.proxmox.GetVMConfig(guest.Node, guest.VMID)
+		if err != nil {
+			return fmt.Errorf("failed to get VM config: %w", err)
+		}
+		guest.Startup = config.Startup
+
+		// An invalid policy is ignored like in the reconciliation cycle
+		if valid, err := s.policyFromConfig(config); err == nil {
+			policy = valid
+		}
+	}
+
+	guest.ExpectedStartup, guest.StartupReason = s.guestStartup(resource, policy)
+	return nil
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Warnf("Failed to write HTTP response: %v", err)
+	}
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
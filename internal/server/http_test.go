@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeNodes:       true,
+		includeHAResources: true,
+		includeHAGroups:    true,
+	})
+	defer mockServer.Close()
+
+	require.NoError(t, testServer.SetupCRS(context.Background()))
+
+	recorder := httptest.NewRecorder()
+	testServer.newRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `crs_step_runs_total{result="success",step="setup_vm_pin"}`)
+	assert.Contains(t, body, "crs_cycle_duration_seconds_count")
+	assert.Contains(t, body, `crs_proxmox_request_duration_seconds_count{endpoint="cluster/ha/groups",method="GET"}`)
+	assert.Contains(t, body, `crs_group_guests{group="crs-vm-prefer-pve1"} 1`)
+}
+
+func serveAdmin(t *testing.T, testServer *Server, method, path, token string) (int, map[string]interface{}) {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	testServer.newRouter().ServeHTTP(recorder, request)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+
+	return recorder.Code, body
+}
+
+func TestAdminAPIAuthentication(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	recorder := httptest.NewRecorder()
+	testServer.newRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "the admin API is disabled without a token")
+
+	testServer.apiToken = "secret"
+
+	code, _ := serveAdmin(t, testServer, http.MethodGet, "/status", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = serveAdmin(t, testServer, http.MethodGet, "/status", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = serveAdmin(t, testServer, http.MethodGet, "/status", "secret")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestAdminAPIStatus(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+
+	code, body := serveAdmin(t, testServer, http.MethodGet, "/status", "secret")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["leader"])
+	assert.Nil(t, body["last_cycle"], "no cycle has run yet")
+	assert.Equal(t, map[string]interface{}{
+		"vms":        float64(0),
+		"containers": float64(3),
+		"groups":     map[string]interface{}{"crs-vm-pin-pve1": float64(2), "crs-vm-prefer-pve2": float64(1)},
+	}, body["managed"])
+
+	testServer.setLastCycle(cycleStatus{
+		Error: "setup VM pin: boom",
+		Steps: []stepStatus{{Name: "setup_vm_pin", Result: "failure", Error: "boom"}},
+	})
+
+	_, body = serveAdmin(t, testServer, http.MethodGet, "/status", "secret")
+	lastCycle := body["last_cycle"].(map[string]interface{})
+	assert.Equal(t, "setup VM pin: boom", lastCycle["error"])
+	assert.Len(t, lastCycle["steps"], 1)
+}
+
+func TestAdminAPIPauseAndReconcile(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+
+	code, _ := serveAdmin(t, testServer, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusConflict, code, "standby instances do not reconcile")
+
+	testServer.leader.Store(true)
+
+	code, _ = serveAdmin(t, testServer, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Len(t, testServer.reconcileRequests, 1)
+
+	code, _ = serveAdmin(t, testServer, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Len(t, testServer.reconcileRequests, 1, "pending requests are coalesced")
+
+	code, body := serveAdmin(t, testServer, http.MethodPost, "/pause", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["paused"])
+	assert.True(t, testServer.IsPaused())
+
+	code, _ = serveAdmin(t, testServer, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusConflict, code)
+
+	recorder := &requestRecorder{}
+	pausedServer, pausedMock := createTestServerWithConfig(testHandlerConfig{includeContainers: true, recorder: recorder})
+	defer pausedMock.Close()
+	require.NoError(t, pausedServer.setPaused(true))
+	require.NoError(t, pausedServer.runPeriodic(context.Background()))
+	assert.Empty(t, recorder.requests, "a paused instance does not touch the cluster")
+
+	code, body = serveAdmin(t, testServer, http.MethodPost, "/resume", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["paused"])
+	assert.False(t, testServer.IsPaused())
+}
+
+func TestAdminAPIVM(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeContainers: true})
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+
+	tests := []struct {
+		name     string
+		path     string
+		code     int
+		expected map[string]interface{}
+	}{
+		{
+			name: "managed without HA resource",
+			path: "/vms/600",
+			code: http.StatusOK,
+			expected: map[string]interface{}{
+				"managed":        true,
+				"expected_group": "crs-vm-prefer-pve1",
+				"reason":         "has rootfs and mount points on shared storage, the HA resource is created in the next cycle",
+			},
+		},
+		{
+			name: "pinned critical container",
+			path: "/vms/603",
+			code: http.StatusOK,
+			expected: map[string]interface{}{
+				"managed":  true,
+				"critical": true,
+				"group":    "crs-vm-pin-pve1",
+				"ha_state": "stopped",
+				"reason":   "has local storage",
+				"tags":     []interface{}{"crs-critical"},
+
+				"expected_startup": "order=1",
+				"startup_reason":   "container has crs-critical tag",
+			},
+		},
+		{
+			name: "skipped",
+			path: "/vms/602",
+			code: http.StatusOK,
+			expected: map[string]interface{}{
+				"managed": false,
+				"reason":  "tagged crs-skip, not managed",
+			},
+		},
+		{
+			name:     "unknown",
+			path:     "/vms/999",
+			code:     http.StatusNotFound,
+			expected: map[string]interface{}{"error": "VM 999 not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := serveAdmin(t, testServer, http.MethodGet, tt.path, "secret")
+			require.Equal(t, tt.code, code)
+
+			for key, value := range tt.expected {
+				assert.Equal(t, value, body[key], key)
+			}
+		})
+	}
+}
+
+func TestAdminAPINodeDrain(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{includeNodes: true, includeMultipleNodes: true})
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+
+	code, _ := serveAdmin(t, testServer, http.MethodPost, "/nodes/pve2/drain", "secret")
+	assert.Equal(t, http.StatusConflict, code, "standby instances do not drain nodes")
+
+	testServer.leader.Store(true)
+
+	code, body := serveAdmin(t, testServer, http.MethodPost, "/nodes/pve9/drain", "secret")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "node pve9 not found", body["error"])
+
+	code, body = serveAdmin(t, testServer, http.MethodPost, "/nodes/pve2/drain", "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "pve2", body["node"])
+	assert.Equal(t, "draining", body["status"])
+	assert.Len(t, testServer.reconcileRequests, 1)
+	assert.True(t, testServer.isDrainingNode("pve2"))
+
+	code, _ = serveAdmin(t, testServer, http.MethodPost, "/nodes/pve2/drain", "secret")
+	assert.Equal(t, http.StatusOK, code, "a running drain is returned as it is")
+
+	code, body = serveAdmin(t, testServer, http.MethodGet, "/drains", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, body["drains"], 1)
+
+	code, _ = serveAdmin(t, testServer, http.MethodDelete, "/nodes/pve2/drain", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, testServer.isDrainingNode("pve2"))
+
+	code, _ = serveAdmin(t, testServer, http.MethodDelete, "/nodes/pve2/drain", "secret")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAdminAPIBreaker(t *testing.T) {
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{})
+	defer mockServer.Close()
+
+	testServer.apiToken = "secret"
+	testServer.leader.Store(true)
+
+	code, _ := serveAdmin(t, testServer, http.MethodPost, "/breaker/acknowledge", "secret")
+	assert.Equal(t, http.StatusConflict, code, "the breaker is not tripped")
+
+	testServer.breaker = &consul.BreakerState{Reason: "more than 50 changes in one cycle"}
+
+	code, body := serveAdmin(t, testServer, http.MethodGet, "/status", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "more than 50 changes in one cycle", body["breaker"].(map[string]interface{})["reason"])
+
+	code, body = serveAdmin(t, testServer, http.MethodPost, "/breaker/acknowledge", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "acknowledged", body["breaker"])
+	assert.Nil(t, testServer.getBreakerState())
+	assert.Len(t, testServer.reconcileRequests, 1)
+}
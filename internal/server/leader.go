@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+)
+
+const (
+	// leaderKey is the suffix of the crs/_internal/leader/ key shared by all CRS instances
+	leaderKey = "scheduler"
+
+	// leaderSessionTTL is the leader session TTL in seconds (consul does not accept less than 10s)
+	leaderSessionTTL = 15
+
+	// leaderRetryInterval is how often standby instances try to take over leadership
+	leaderRetryInterval = 10 * time.Second
+)
+
+// errLeadershipLost refuses changes of a cycle or one-shot operation whose leader session is gone
+var errLeadershipLost = errors.New("CRS leadership lost")
+
+// IsLeader reports whether this instance currently holds CRS leadership
+func (s *Server) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// RunExclusive acquires CRS leadership, runs fn and releases leadership again.
+// It fails when another instance is the leader, so one-shot operations never race a running reconcile loop,
+// and the changes of fn are refused once the leader session is lost.
+func (s *Server) RunExclusive(fn func() error) error {
+	isLeader, sessionID, err := s.consul.GetLeader(leaderKey, leaderSessionTTL)
+	if err != nil {
+		return fmt.Errorf("failed to acquire CRS leadership: %w", err)
+	}
+
+	if !isLeader {
+		return fmt.Errorf("another CRS instance holds leadership, stop it before running this operation")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	renewDone := make(chan struct{})
+	renewResult := make(chan error, 1)
+
+	go func() {
+		err := s.consul.RenewLeader(sessionID, leaderSessionTTL, renewDone)
+		if err != nil {
+			logging.Errorf("Lost CRS leader session %s: %v", sessionID, err)
+		}
+		cancel()
+		renewResult <- err
+	}()
+
+	defer func() {
+		close(renewDone)
+		<-renewResult
+	}()
+
+	s.setLeaderContext(ctx)
+	defer s.setLeaderContext(nil)
+
+	return fn()
+}
+
+// runLeaderElection campaigns for leadership and runs CRS reconciliation only while holding it
+func (s *Server) runLeaderElection(ctx context.Context) error {
+	for {
+		isLeader, sessionID, err := s.consul.GetLeader(leaderKey, leaderSessionTTL)
+		switch {
+		case err != nil:
+			logging.Warnf("Failed to campaign for CRS leadership: %v", err)
+		case isLeader:
+			logging.Infof("Acquired CRS leadership with session %s", sessionID)
+			s.lead(ctx, sessionID)
+			logging.Infof("Stepped down from CRS leadership (session %s)", sessionID)
+		default:
+			logging.Debug("CRS leadership is held by another instance, staying in standby")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(leaderRetryInterval):
+		}
+	}
+}
+
+// lead runs periodic reconciliation until the leader session is lost or ctx is cancelled
+func (s *Server) lead(ctx context.Context, sessionID string) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Cycles only stop with the session, so a shutdown lets the running cycle finish
+	sessionCtx, sessionLost := context.WithCancel(context.Background())
+	defer sessionLost()
+
+	renewDone := make(chan struct{})
+	renewResult := make(chan error, 1)
+
+	go func() {
+		// RenewPeriodic only returns when the session is gone (consul lost, session invalidated) or renewDone is closed
+		err := s.consul.RenewLeader(sessionID, leaderSessionTTL, renewDone)
+		if err != nil {
+			logging.Errorf("Lost CRS leader session %s: %v", sessionID, err)
+		}
+		sessionLost()
+		cancel()
+		renewResult <- err
+	}()
+
+	// Scheduler tunables apply without waiting for the next cycle
+	go s.consul.WatchKey(leaderCtx, consul.SchedulerConfigKey, leaderRetryInterval, s.notifyConfigChange)
+
+	s.leader.Store(true)
+	s.runReconcileLoop(leaderCtx, sessionCtx)
+	s.leader.Store(false)
+
+	// Destroy the session (if it still exists) so a standby instance can take over without waiting for the TTL
+	close(renewDone)
+	<-renewResult
+}
+
+// setLeaderContext sets the context of the leader session changes are made in, nil outside of a leader session
+func (s *Server) setLeaderContext(ctx context.Context) {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+
+	s.leaderCtx = ctx
+}
+
+// leadershipErr returns an error wrapping errLeadershipLost once the leader session of the running cycle is lost
+func (s *Server) leadershipErr() error {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+
+	if s.leaderCtx == nil || s.leaderCtx.Err() == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", errLeadershipLost, context.Cause(s.leaderCtx))
+}
+
+// runReconcileLoop runs SetupCRS immediately, then at the configured interval and whenever a cycle is
+// requested through the admin API, until ctx is cancelled. A cycle stops early once sessionCtx, the leader session, is gone.
+func (s *Server) runReconcileLoop(ctx, sessionCtx context.Context) {
+	interval := s.periodicInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// A failed cycle must not stop the loop, otherwise this instance would keep leadership without reconciling
+		// The cycle stops and refuses changes as soon as the leader session is lost
+		if err := s.runPeriodic(sessionCtx); err != nil {
+			log.Println(err)
+		}
+
+		if !s.waitForCycle(ctx, ticker, &interval) {
+			return
+		}
+	}
+}
+
+// waitForCycle blocks until the next cycle is due and returns false when ctx is cancelled.
+// Scheduler config changes are loaded while waiting, a changed interval restarts the ticker.
+func (s *Server) waitForCycle(ctx context.Context, ticker *time.Ticker, interval *time.Duration) bool {
+	for {
+		if current := s.periodicInterval(); current != *interval {
+			logging.Infof("Reconcile interval changed from %v to %v", *interval, current)
+			*interval = current
+			ticker.Reset(current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return true
+		case <-s.reconcileRequests:
+			logging.Info("Running reconciliation requested through the admin API")
+			return true
+		case <-s.configChanges:
+			logging.Infof("%s changed, reloading config", consul.SchedulerConfigKey)
+			s.loadConfig()
+		}
+	}
+}
+
+// notifyConfigChange wakes the reconcile loop to reload the config, pending notifications are coalesced
+func (s *Server) notifyConfigChange() {
+	select {
+	case s.configChanges <- struct{}{}:
+	default:
+	}
+}
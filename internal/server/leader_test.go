@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+)
+
+func TestLeaderConstants(t *testing.T) {
+	assert.Equal(t, "scheduler", leaderKey)
+	// Consul rejects session TTLs below 10 seconds
+	assert.GreaterOrEqual(t, leaderSessionTTL, 10)
+}
+
+func TestIsLeader(t *testing.T) {
+	testServer, mockServer := createTestServer()
+	defer mockServer.Close()
+
+	assert.False(t, testServer.IsLeader(), "a fresh server must start in standby")
+
+	testServer.leader.Store(true)
+	assert.True(t, testServer.IsLeader())
+}
+
+// newLeaderTestServer returns a server in standby using the Consul agent at CONSUL_HTTP_ADDR
+func newLeaderTestServer(t *testing.T) *Server {
+	testServer, mockServer := createTestServer()
+	t.Cleanup(mockServer.Close)
+
+	client, err := consul.New()
+	require.NoError(t, err)
+
+	testServer.consul = client
+	testServer.apiToken = "secret"
+
+	return testServer
+}
+
+func TestLeaderElection(t *testing.T) {
+	fake, consulServer := newFakeConsul()
+	defer consulServer.Close()
+	t.Setenv("CONSUL_HTTP_ADDR", consulServer.URL)
+
+	key := "crs/_internal/leader/" + leaderKey
+	leader, standby := newLeaderTestServer(t), newLeaderTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- leader.runLeaderElection(ctx)
+	}()
+
+	require.Eventually(t, leader.IsLeader, 5*time.Second, 10*time.Millisecond)
+	assert.NotEmpty(t, fake.lockHolder(key))
+	assert.False(t, standby.IsLeader())
+
+	code, body := serveAdmin(t, standby, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusConflict, code, "a standby instance must not reconcile")
+	assert.Equal(t, "this instance is not the CRS leader", body["error"])
+
+	code, _ = serveAdmin(t, leader, http.MethodPost, "/reconcile", "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+
+	err := standby.RunExclusive(func() error {
+		t.Error("a one-shot operation must not run while another instance leads")
+		return nil
+	})
+	assert.ErrorContains(t, err, "another CRS instance holds leadership")
+
+	// Stepping down stops the reconcile loop and releases the leader key at once
+	cancel()
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the leader did not step down")
+	}
+	assert.False(t, leader.IsLeader())
+	assert.Empty(t, fake.lockHolder(key))
+
+	// The standby takes over without waiting for the session TTL
+	ran := false
+	err = standby.RunExclusive(func() error {
+		ran = true
+		assert.NotEmpty(t, fake.lockHolder(key))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, ran)
+	assert.Empty(t, fake.lockHolder(key), "the leader key is released after the operation")
+}
+
+func TestGetLeaderReleasesLostCampaign(t *testing.T) {
+	fake, consulServer := newFakeConsul()
+	defer consulServer.Close()
+	t.Setenv("CONSUL_HTTP_ADDR", consulServer.URL)
+
+	client, err := consul.New()
+	require.NoError(t, err)
+
+	isLeader, sessionID, err := client.GetLeader(leaderKey, leaderSessionTTL)
+	require.NoError(t, err)
+	require.True(t, isLeader)
+
+	isLeader, _, err = client.GetLeader(leaderKey, leaderSessionTTL)
+	require.NoError(t, err)
+	assert.False(t, isLeader, "the key is held by the first session")
+	assert.Len(t, fake.sessions, 1, "the losing session is destroyed")
+
+	// A lost session frees the key for the next campaign
+	fake.invalidate(sessionID)
+
+	isLeader, _, err = client.GetLeader(leaderKey, leaderSessionTTL)
+	require.NoError(t, err)
+	assert.True(t, isLeader)
+}
+
+func TestLeadershipLost(t *testing.T) {
+	recorder := &requestRecorder{}
+	testServer, mockServer := createTestServerWithConfig(testHandlerConfig{
+		includeNodes:       true,
+		includeHAResources: true,
+		includeHAGroups:    true,
+		recorder:           recorder,
+	})
+	defer mockServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := testServer.SetupCRS(ctx)
+	assert.ErrorIs(t, err, errLeadershipLost)
+	assert.Empty(t, testServer.getLastCycle().Steps, "no step runs once the leader session is gone")
+	assert.Empty(t, recorder.mutations())
+
+	action := Action{Kind: ActionDelete, Resource: ResourceHAGroup, ID: "crs-vm-prefer-pve1"}
+
+	// A step still running when the session is lost makes no further changes
+	testServer.setLeaderContext(ctx)
+	_, err = testServer.applyAction(action)
+	assert.ErrorIs(t, err, errLeadershipLost)
+	assert.Empty(t, recorder.mutations())
+
+	testServer.plan = &Plan{}
+	_, err = testServer.applyAction(action)
+	require.NoError(t, err, "plan mode makes no changes")
+	assert.Len(t, testServer.plan.Actions, 1)
+
+	testServer.plan = nil
+	testServer.setLeaderContext(nil)
+	_, err = testServer.applyAction(action)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DELETE /api2/json/cluster/ha/groups/crs-vm-prefer-pve1"}, recorder.mutations())
+}
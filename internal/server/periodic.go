@@ -1,13 +1,24 @@
 package server
 
 import (
+	"context"
 	"fmt"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
 )
 
+// periodicTime is the default time between reconciliation cycles in seconds, see crs/config/scheduler
 const periodicTime = 30
 
-func (s *Server) runPeriodic() error {
-	if err := s.SetupCRS(); err != nil {
+func (s *Server) runPeriodic(ctx context.Context) error {
+	s.loadConfig()
+
+	if s.IsPaused() {
+		logging.Info("Reconciliation is paused, skipping cycle")
+		return nil
+	}
+
+	if err := s.SetupCRS(ctx); err != nil {
 		return fmt.Errorf("setup CRS: %w", err)
 	}
 
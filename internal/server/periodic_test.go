@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -32,7 +33,7 @@ func TestRunPeriodic(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			server := tt.setupServer()
-			err := server.runPeriodic()
+			err := server.runPeriodic(context.Background())
 
 			if tt.expectedError {
 				assert.Error(t, err)
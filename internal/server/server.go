@@ -3,7 +3,8 @@ package server
 import (
 	"context"
 	"log"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vitalvas/gokit/xcmd"
@@ -16,6 +17,47 @@ type Server struct {
 	proxmox          *proxmox.Client
 	consul           *consul.Consul
 	disableRateLimit bool // For testing purposes
+	leader           atomic.Bool
+	leaderMu         sync.Mutex
+	leaderCtx        context.Context // Lives as long as the leader session of the running cycle, lead and RunExclusive cancel it once the session is lost, see applyAction
+	plan             *Plan           // Collects actions instead of executing them while set
+	configMu         sync.RWMutex
+	config           *crsConfig   // Replaced as a whole by loadConfig, read through currentConfig
+	journal          eventJournal // Records executed actions, nil disables the journal
+
+	paused            atomic.Bool   // Reconciliation is skipped while set
+	reconcileRequests chan struct{} // Wakes the reconcile loop for an immediate cycle
+	configChanges     chan struct{} // Wakes the reconcile loop to reload the config
+	apiToken          string        // Bearer token of the admin API, the API is disabled without one
+
+	statusMu       sync.Mutex
+	lastCycle      *cycleStatus
+	windowStatuses []windowStatus // Maintenance windows at the last cycle
+
+	// VM description policies, only used by the reconcile cycle
+	policyCache  map[int]*vmPolicy // Policies read in the current cycle, nil for VMs without a valid one
+	policyErrors map[int]string    // Last reported validation error per VM
+
+	drainMu     sync.Mutex
+	drains      map[string]*consul.DrainState // Node drains by node name, see crs_node_drain.go
+	drainStepMu sync.Mutex                    // Runs one DrainNodes step at a time, held without drainMu during its API calls
+
+	// Migrations in flight outside of node drains by VMID, see crs_tasks.go
+	migrationMu sync.Mutex
+	migrations  map[int]*consul.MigrationState
+
+	// Reason the health gate holds the reconcile cycle, empty while the cluster is healthy, guarded by statusMu, see crs_health.go
+	healthProblem string
+
+	// Nodes shut down by power management by node name, guarded by drainMu, see crs_power.go
+	powerStates    map[string]*consul.PowerState
+	powerChangedAt time.Time // End of the last power change, starts the cooldown, only used by the power management step
+
+	// Change budget and circuit breaker, see crs_budget.go
+	budgetMu      sync.Mutex
+	breaker       *consul.BreakerState // Set while the circuit breaker halts automation
+	cycleChanges  int                  // Budgeted changes in the current cycle
+	windowChanges []time.Time          // Times of the budgeted changes within the sliding window
 }
 
 func New() (*Server, error) {
@@ -47,38 +89,49 @@ func New() (*Server, error) {
 		},
 	}
 
+	apiToken, err := consul.GetAPIToken()
+	if err != nil {
+		return nil, err
+	}
+
 	pveClient := proxmox.NewClient(config)
 
 	return &Server{
-		consul:  consul,
-		proxmox: pveClient,
+		consul:            consul,
+		journal:           consul,
+		proxmox:           pveClient,
+		config:            defaultCRSConfig(),
+		reconcileRequests: make(chan struct{}, 1),
+		configChanges:     make(chan struct{}, 1),
+		apiToken:          apiToken,
 	}, nil
 }
 
-func (s *Server) Run(ctx context.Context) error {
+// Run campaigns for leadership and reconciles while leading. The HTTP endpoints are served on
+// listenAddress by every instance, an empty address disables them.
+func (s *Server) Run(ctx context.Context, listenAddress string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	group, groupCtx := errgroup.WithContext(ctx)
 
-	if err := s.runPeriodic(); err != nil {
-		return err
+	if listenAddress != "" {
+		group.Go(func() error {
+			return s.runHTTPServer(groupCtx, listenAddress)
+		})
 	}
 
 	group.Go(func() error {
-		run := func(_ context.Context) error {
-			return s.runPeriodic()
-		}
-
-		err := xcmd.PeriodicRun(groupCtx, run, time.Duration(periodicTime)*time.Second)
-		if err != nil {
-			log.Println(err)
-		}
-
-		return err
+		// Only the instance holding the leader lock reconciles, the others wait in standby
+		return s.runLeaderElection(groupCtx)
 	})
 
 	group.Go(func() error {
 		xcmd.WaitInterrupted(groupCtx)
 		log.Println("shutting down...")
-		os.Exit(0)
+
+		// Cancelling lets the leader finish its cycle and release the lock for a standby instance
+		cancel()
 
 		return nil
 	})
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"time"
+
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
+	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/logging"
+)
+
+// stepStatus is the outcome of one SetupCRS step
+type stepStatus struct {
+	Name     string  `json:"name"`
+	Result   string  `json:"result"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// cycleStatus is the outcome of a SetupCRS cycle
+type cycleStatus struct {
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Error      string       `json:"error,omitempty"`
+	HealthGate string       `json:"health_gate,omitempty"` // Why the cycle made no changes
+	Breaker    string       `json:"breaker,omitempty"`     // Why the tripped circuit breaker stopped the changes
+	Steps      []stepStatus `json:"steps"`
+}
+
+// setLastCycle stores the outcome of the most recent cycle
+func (s *Server) setLastCycle(cycle cycleStatus) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	s.lastCycle = &cycle
+}
+
+// getLastCycle returns a copy of the most recent cycle, or nil before the first cycle
+func (s *Server) getLastCycle() *cycleStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	if s.lastCycle == nil {
+		return nil
+	}
+
+	cycle := *s.lastCycle
+	cycle.Steps = append([]stepStatus(nil), s.lastCycle.Steps...)
+
+	return &cycle
+}
+
+// IsPaused reports whether reconciliation is paused
+func (s *Server) IsPaused() bool {
+	return s.paused.Load()
+}
+
+// setPaused pauses or resumes reconciliation. The state is stored in Consul,
+// so it survives restarts and a standby instance taking over leadership.
+func (s *Server) setPaused(paused bool) error {
+	if err := s.consul.PutPauseState(consul.PauseState{Paused: paused, Since: time.Now()}); err != nil {
+		return err
+	}
+
+	s.paused.Store(paused)
+
+	return nil
+}
+
+// loadPauseState refreshes the pause state from Consul, keeping the current state when it fails to load
+func (s *Server) loadPauseState() {
+	state, err := s.consul.GetPauseState()
+	if err != nil {
+		logging.Errorf("Failed to load pause state, keeping current state: %v", err)
+		return
+	}
+
+	s.paused.Store(state != nil && state.Paused)
+}
+
+// requestReconcile wakes the reconcile loop for an immediate cycle.
+// It returns false when a cycle is already requested.
+func (s *Server) requestReconcile() bool {
+	select {
+	case s.reconcileRequests <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
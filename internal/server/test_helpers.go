@@ -1,11 +1,17 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/consul"
 	"github.com/vitalvas/proxmox-cloud-resource-scheduler/internal/proxmox"
 )
@@ -28,27 +34,311 @@ type testHandlerConfig struct {
 	includeMultipleNodes            bool
 	includeOutdatedHAGroups         bool
 	includeCorrectHAGroups          bool
-	includeSharedStorageVM          bool // Include VM 200 with shared storage config
-	includeRunningDisabledVM        bool // Include VM 106 with running status but disabled HA
-	includeLongRunningVMs           bool // Include VMs with uptime > 24h
-	includeLongRunningVMHARes       bool // Include HA resource for VM 111 (long-running)
-	includeLongRunningVMInPin       bool // Include VM 111 in pin group (vs prefer)
-	includeNodeMaintenanceMode      bool // Include pve2 in maintenance mode
-	includeMaintenanceVMs           bool // Include VMs that need migration from maintenance node
-	includeAllNodesInMaintenance    bool // All nodes in maintenance mode
-	includeVMWithHostPCI            bool // Include VM with hostpci devices
-	includeVMWithEmptyCDROM         bool // Include VM with CD-ROM that has no media (none)
-	includeVMWithSCSIHW             bool // Include VM with scsihw controller type
-	includeCriticalVMInMigrateState bool // Include critical VM in migrate state
+	includeSharedStorageVM          bool              // Include VM 200 with shared storage config
+	includeRunningDisabledVM        bool              // Include VM 106 with running status but disabled HA
+	includeLongRunningVMs           bool              // Include VMs with uptime > 24h
+	includeLongRunningVMHARes       bool              // Include HA resource for VM 111 (long-running)
+	includeLongRunningVMInPin       bool              // Include VM 111 in pin group (vs prefer)
+	includeNodeMaintenanceMode      bool              // Include pve2 in maintenance mode
+	includeMaintenanceVMs           bool              // Include VMs that need migration from maintenance node
+	includeAllNodesInMaintenance    bool              // All nodes in maintenance mode
+	includeVMWithHostPCI            bool              // Include VM with hostpci devices
+	includeVMWithEmptyCDROM         bool              // Include VM with CD-ROM that has no media (none)
+	includeVMWithSCSIHW             bool              // Include VM with scsihw controller type
+	includeCriticalVMInMigrateState bool              // Include critical VM in migrate state
+	includeAffinityVMs              bool              // Serve the anti-affinity fixture for cluster resources, HA resources and HA groups
+	includeContainers               bool              // Serve the container fixture, pve2 is in maintenance
+	recorder                        *requestRecorder  // Records every request received by the mock API
+	consulKV                        map[string]string // Keys the fake Consul agent of the server starts with, like crs/config/budget
+
+	// Scenarios replace the default mock cluster: mutations they do not cover succeed and other reads are empty
+	guest   *guestFixture   // A single guest on pve1
+	health  *healthFixture  // Cluster quorum and HA manager status
+	cluster scenarioHandler // Hand-written scenario, for state that changes with the requests such as migrations
+}
+
+// scenarioHandler answers the mock API requests it covers and reports whether it did
+type scenarioHandler func(w http.ResponseWriter, r *http.Request) bool
+
+// scenario returns the scenario to serve, nil for the default mock cluster
+func (c testHandlerConfig) scenario() scenarioHandler {
+	switch {
+	case c.cluster != nil:
+		return c.cluster
+	case c.guest != nil:
+		return c.guest.write
+	case c.health != nil:
+		return c.health.write
+	default:
+		return nil
+	}
+}
+
+// failingScenario answers every request with an internal server error
+func failingScenario(w http.ResponseWriter, _ *http.Request) bool {
+	w.WriteHeader(http.StatusInternalServerError)
+	return true
+}
+
+// requestRecorder collects "METHOD path" entries of requests received by the mock API
+type requestRecorder struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (r *requestRecorder) record(req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+}
+
+// mutations returns all recorded requests that are not GET requests
+func (r *requestRecorder) mutations() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []string
+	for _, request := range r.requests {
+		if !strings.HasPrefix(request, http.MethodGet+" ") {
+			result = append(result, request)
+		}
+	}
+	return result
+}
+
+// writeAffinityFixture serves two anti-affinity replicas (500, 501) sharing pve1 while pve2 is idle.
+// It returns false for paths it does not cover.
+func writeAffinityFixture(w http.ResponseWriter, path string) bool {
+	switch path {
+	case "/api2/json/cluster/resources":
+		w.Write([]byte(`{
+			"data": [
+				{"type": "node", "node": "pve1", "status": "online", "cpu": 0.5, "maxcpu": 8, "mem": 4294967296, "maxmem": 17179869184},
+				{"type": "node", "node": "pve2", "status": "online", "cpu": 0.1, "maxcpu": 8, "mem": 1073741824, "maxmem": 17179869184},
+				{"type": "qemu", "vmid": 500, "name": "db-1", "node": "pve1", "status": "running", "hastate": "started", "tags": "crs-anti-affinity-db"},
+				{"type": "qemu", "vmid": 501, "name": "db-2", "node": "pve1", "status": "running", "hastate": "started", "tags": "crs-anti-affinity-db"}
+			]
+		}`))
+	case "/api2/json/cluster/ha/resources":
+		w.Write([]byte(`{
+			"data": [
+				{"sid": "vm:500", "state": "started", "group": "crs-vm-prefer-pve1", "type": "vm"},
+				{"sid": "vm:501", "state": "started", "group": "crs-vm-prefer-pve1", "type": "vm"}
+			]
+		}`))
+	case "/api2/json/cluster/ha/groups":
+		w.Write([]byte(`{
+			"data": [
+				{"group": "crs-vm-prefer-pve1", "nodes": "pve1:1000,pve2:995", "restricted": 1, "nofailback": 1},
+				{"group": "crs-vm-prefer-pve2", "nodes": "pve1:995,pve2:1000", "restricted": 1, "nofailback": 1}
+			]
+		}`))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// writeContainerFixture serves containers on pve1 and a maintenance node pve2 without VMs:
+// 600 running on shared storage without HA, 601 stopped in a prefer group on pve2,
+// 602 skipped but still in a CRS group, 603 critical with local rootfs and a stopped HA resource.
+// It returns false for paths it does not cover.
+func writeContainerFixture(w http.ResponseWriter, path string) bool {
+	switch path {
+	case "/api2/json/cluster/resources":
+		w.Write([]byte(`{
+			"data": [
+				{"type": "node", "node": "pve1", "status": "online", "cpu": 0.1, "maxcpu": 8, "mem": 4294967296, "maxmem": 17179869184},
+				{"type": "node", "node": "pve2", "status": "online", "hastate": "maintenance", "maxcpu": 8, "maxmem": 17179869184},
+				{"type": "lxc", "vmid": 600, "name": "web", "node": "pve1", "status": "running"},
+				{"type": "lxc", "vmid": 601, "name": "worker", "node": "pve2", "status": "stopped", "hastate": "stopped"},
+				{"type": "lxc", "vmid": 602, "name": "scratch", "node": "pve1", "status": "running", "hastate": "started", "tags": "crs-skip"},
+				{"type": "lxc", "vmid": 603, "name": "dns", "node": "pve1", "status": "stopped", "hastate": "stopped", "tags": "crs-critical"}
+			]
+		}`))
+	case "/api2/json/cluster/ha/resources":
+		w.Write([]byte(`{
+			"data": [
+				{"sid": "ct:601", "state": "stopped", "group": "crs-vm-prefer-pve2", "type": "ct"},
+				{"sid": "ct:602", "state": "started", "group": "crs-vm-pin-pve1", "type": "ct"},
+				{"sid": "ct:603", "state": "stopped", "group": "crs-vm-pin-pve1", "type": "ct"}
+			]
+		}`))
+	case "/api2/json/nodes":
+		w.Write([]byte(`{"data": [{"node": "pve1", "status": "online"}, {"node": "pve2", "status": "online"}]}`))
+	case "/api2/json/nodes/pve1/qemu", "/api2/json/nodes/pve2/qemu":
+		w.Write([]byte(`{"data": []}`))
+	case "/api2/json/nodes/pve1/lxc":
+		w.Write([]byte(`{
+			"data": [
+				{"vmid": 600, "name": "web", "status": "running"},
+				{"vmid": 602, "name": "scratch", "status": "running", "tags": "crs-skip"},
+				{"vmid": 603, "name": "dns", "status": "stopped", "tags": "crs-critical"}
+			]
+		}`))
+	case "/api2/json/nodes/pve2/lxc":
+		w.Write([]byte(`{"data": [{"vmid": 601, "name": "worker", "status": "stopped"}]}`))
+	case "/api2/json/nodes/pve1/lxc/600/config", "/api2/json/nodes/pve2/lxc/601/config":
+		w.Write([]byte(`{"data": {"memory": 1024, "cores": 2, "rootfs": "ceph-storage:vm-600-disk-0,size=8G", "mp0": "ceph-storage:vm-600-disk-1,mp=/data,size=8G"}}`))
+	case "/api2/json/nodes/pve1/lxc/603/config":
+		w.Write([]byte(`{"data": {"memory": 512, "rootfs": "local-lvm:vm-603-disk-0,size=4G"}}`))
+	case "/api2/json/storage":
+		w.Write([]byte(`{
+			"data": [
+				{"storage": "local-lvm", "type": "lvmthin", "shared": 0},
+				{"storage": "ceph-storage", "type": "rbd", "shared": 1}
+			]
+		}`))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// healthFixture serves the cluster status with the given quorum and the HA manager status entries.
+// Changing the fields between requests changes the health of the cluster.
+type healthFixture struct {
+	quorate  int
+	haStatus string // Comma separated JSON entries
+}
+
+func (f *healthFixture) write(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	switch r.URL.Path {
+	case "/api2/json/cluster/status":
+		fmt.Fprintf(w, `{"data": [{"id": "cluster", "type": "cluster", "name": "lab", "nodes": 3, "quorate": %d}]}`, f.quorate)
+	case "/api2/json/cluster/ha/status/current":
+		fmt.Fprintf(w, `{"data": [%s]}`, f.haStatus)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// guestFixture serves a single running guest on pve1 of the nodes pve1, pve2 and pve3. The guest is a VM unless
+// its type is lxc, and it has a CRS-managed HA resource in group unless group is empty.
+// The storages are ceph on every node, nfs-rack1 on pve1 and pve2 and nfs-pve3 on pve3 only. The gpu PCI mapping
+// is provided by pve1 and pve2, the nic mapping by pve2 and pve3.
+type guestFixture struct {
+	vmid      int
+	guestType string
+	name      string
+	tags      string
+	pool      string
+	config    map[string]any // Config keys besides the name, such as description, startup, scsi0 or hostpci0
+	group     string
+}
+
+func (f *guestFixture) resourceType() string {
+	if f.guestType == "" {
+		return vmResourceType
+	}
+	return f.guestType
+}
+
+func (f *guestFixture) write(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	guestType := f.resourceType()
+	switch r.URL.Path {
+	case "/api2/json/nodes":
+		w.Write([]byte(`{"data": [{"node": "pve1", "status": "online"}, {"node": "pve2", "status": "online"}, {"node": "pve3", "status": "online"}]}`))
+	case "/api2/json/nodes/pve1/" + guestType:
+		fmt.Fprintf(w, `{"data": [{"vmid": %d, "name": %q, "status": "running", "tags": %q}]}`, f.vmid, f.name, f.tags)
+	case fmt.Sprintf("/api2/json/nodes/pve1/%s/%d/config", guestType, f.vmid):
+		config := map[string]any{"name": f.name}
+		for key, value := range f.config {
+			config[key] = value
+		}
+		payload, _ := json.Marshal(config)
+		fmt.Fprintf(w, `{"data": %s}`, payload)
+	case "/api2/json/cluster/resources":
+		fmt.Fprintf(w, `{"data": [{"type": %q, "vmid": %d, "node": "pve1", "name": %q, "status": "running", "tags": %q, "pool": %q}]}`,
+			guestType, f.vmid, f.name, f.tags, f.pool)
+	case "/api2/json/cluster/ha/resources":
+		if f.group == "" {
+			return false
+		}
+		sid := fmt.Sprintf("%s:%d", haResourceType, f.vmid)
+		if guestType == ctResourceType {
+			sid = fmt.Sprintf("%s:%d", haContainerResourceType, f.vmid)
+		}
+		fmt.Fprintf(w, `{"data": [{"sid": %q, "state": "started", "group": %q, "comment": %q, "max_restart": 10, "max_relocate": 10}]}`,
+			sid, f.group, haResourceComment)
+	case "/api2/json/cluster/mapping/pci":
+		w.Write([]byte(`{"data": [
+			{"id": "gpu", "map": ["node=pve1,path=0000:01:00.0", "node=pve2,path=0000:01:00.0"]},
+			{"id": "nic", "map": ["node=pve2,path=0000:02:00.0", "node=pve3,path=0000:02:00.0"]}
+		]}`))
+	case "/api2/json/storage":
+		w.Write([]byte(`{"data": [
+			{"storage": "ceph", "content": "images,rootdir", "shared": 1},
+			{"storage": "nfs-rack1", "content": "images,rootdir", "shared": 1, "nodes": "pve1,pve2"},
+			{"storage": "nfs-pve3", "content": "images,rootdir", "shared": 1, "nodes": "pve3"}
+		]}`))
+	default:
+		return false
+	}
+
+	return true
 }
 
 //nolint:gocyclo // Test helper function with many mock scenarios is acceptable
 func createTestServerWithConfig(config testHandlerConfig) (*Server, *httptest.Server) {
+	consulAgent := newFakeConsulAgent()
+	for key, value := range config.consulKV {
+		consulAgent.put(key, []byte(value))
+	}
+
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The Consul API is served next to the Proxmox API, it is neither recorded nor part of the scenarios
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			consulAgent.ServeHTTP(w, r)
+			return
+		}
+
+		if config.recorder != nil {
+			config.recorder.record(r)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
+
+		if serve := config.scenario(); serve != nil {
+			switch {
+			case serve(w, r):
+			case r.Method != http.MethodGet:
+				w.Write([]byte(`{"data": null}`))
+			default:
+				w.Write([]byte(`{"data": []}`))
+			}
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 
+		if config.includeAffinityVMs && r.Method == http.MethodGet && writeAffinityFixture(w, r.URL.Path) {
+			return
+		}
+
+		if config.includeContainers && r.Method == http.MethodGet && writeContainerFixture(w, r.URL.Path) {
+			return
+		}
+
 		switch r.URL.Path {
+		case "/api2/json/cluster/status":
+			w.Write([]byte(`{"data": [{"id": "cluster", "type": "cluster", "name": "test", "nodes": 2, "quorate": 1}]}`))
+
+		case "/api2/json/cluster/ha/status/current":
+			w.Write([]byte(`{"data": [{"id": "quorum", "type": "quorum", "status": "OK", "quorate": 1}]}`))
+
 		case "/api2/json/cluster/ha/groups":
 			switch r.Method {
 			case http.MethodGet:
@@ -511,6 +801,9 @@ func createTestServerWithConfig(config testHandlerConfig) (*Server, *httptest.Se
 				w.Write([]byte(`{"data": null}`))
 			}
 
+		case "/api2/json/cluster/nextid":
+			w.Write([]byte(`{"data": "903"}`))
+
 		case "/api2/json/cluster/resources":
 			if config.includeClusterResources || config.includeErrorHAResources || config.includeDisabledHAResources || config.includeCriticalVMResources || config.includeRunningDisabledVM || config.includeLongRunningVMs || config.includeLongRunningVMHARes || config.includeCriticalVMInMigrateState {
 				var resources []string
@@ -1072,13 +1365,22 @@ func createTestServerWithConfig(config testHandlerConfig) (*Server, *httptest.Se
 		},
 	}
 
-	pveClient := proxmox.NewClient(proxmoxConfig)
-	consul := &consul.Consul{}
+	consulConfig := api.DefaultConfig()
+	consulConfig.Address = strings.TrimPrefix(server.URL, "http://")
+
+	consulClient, err := consul.NewWithConfig(consulConfig)
+	if err != nil {
+		panic(err)
+	}
 
+	pveClient := proxmox.NewClient(proxmoxConfig)
 	testServer := &Server{
-		proxmox:          pveClient,
-		consul:           consul,
-		disableRateLimit: true, // Disable rate limiting for faster tests
+		proxmox:           pveClient,
+		consul:            consulClient,
+		disableRateLimit:  true, // Disable rate limiting for faster tests
+		config:            defaultCRSConfig(),
+		reconcileRequests: make(chan struct{}, 1),
+		configChanges:     make(chan struct{}, 1),
 	}
 
 	return testServer, server
@@ -1087,3 +1389,249 @@ func createTestServerWithConfig(config testHandlerConfig) (*Server, *httptest.Se
 func createTestServer() (*Server, *httptest.Server) {
 	return createTestServerWithConfig(testHandlerConfig{})
 }
+
+// fakeConsul serves the parts of the Consul KV and session HTTP API used by the consul package from memory.
+// Sessions never expire on their own, invalidate drops one like an expired TTL.
+type fakeConsul struct {
+	mu       sync.Mutex
+	kv       map[string]fakeConsulPair
+	sessions map[string]string // Behavior by session ID
+	index    uint64
+	changed  chan struct{} // Closed and replaced on every write, wakes blocking queries
+	nextID   int
+}
+
+type fakeConsulPair struct {
+	value   []byte
+	session string // Session holding the lock of the key
+}
+
+// newFakeConsul starts a fake Consul agent, point CONSUL_HTTP_ADDR at its URL before calling consul.New
+func newFakeConsul() (*fakeConsul, *httptest.Server) {
+	fake := newFakeConsulAgent()
+	return fake, httptest.NewServer(fake)
+}
+
+// newFakeConsulAgent returns an empty fake Consul agent to serve through a test server
+func newFakeConsulAgent() *fakeConsul {
+	return &fakeConsul{
+		kv:       make(map[string]fakeConsulPair),
+		sessions: make(map[string]string),
+		index:    1,
+		changed:  make(chan struct{}),
+	}
+}
+
+// put stores value at key, like a write through the Consul API
+func (f *fakeConsul) put(key string, value []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.kv[key] = fakeConsulPair{value: value, session: f.kv[key].session}
+	f.bump()
+}
+
+// lockHolder returns the session holding the lock of key, empty when the key is not locked
+func (f *fakeConsul) lockHolder(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.kv[key].session
+}
+
+// invalidate drops a session like Consul does when its TTL expires
+func (f *fakeConsul) invalidate(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.destroySession(id)
+}
+
+func (f *fakeConsul) bump() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if key, ok := strings.CutPrefix(r.URL.Path, "/v1/kv/"); ok {
+		if r.Method == http.MethodGet {
+			f.waitForChange(r)
+		}
+		f.serveKV(w, r, key)
+		return
+	}
+
+	if action, ok := strings.CutPrefix(r.URL.Path, "/v1/session/"); ok && r.Method == http.MethodPut {
+		f.serveSession(w, r, action)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// waitForChange blocks a query with an index until the data changes or the client gives up
+func (f *fakeConsul) waitForChange(r *http.Request) {
+	index, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	current, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if index < current {
+		return
+	}
+
+	select {
+	case <-changed:
+	case <-r.Context().Done():
+	}
+}
+
+func (f *fakeConsul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+
+	switch r.Method {
+	case http.MethodGet:
+		f.get(w, r, key)
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.putKV(w, r, key, value)
+	case http.MethodDelete:
+		delete(f.kv, key)
+		f.bump()
+		fmt.Fprint(w, "true")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// putKV stores a value, taking the lock of key for the session of ?acquire or giving it up for ?release
+func (f *fakeConsul) putKV(w http.ResponseWriter, r *http.Request, key string, value []byte) {
+	query := r.URL.Query()
+	pair := f.kv[key]
+
+	switch {
+	case query.Has("acquire"):
+		session := query.Get("acquire")
+		if _, ok := f.sessions[session]; !ok {
+			http.Error(w, "invalid session "+session, http.StatusInternalServerError)
+			return
+		}
+		if pair.session != "" && pair.session != session {
+			fmt.Fprint(w, "false")
+			return
+		}
+		pair.session = session
+	case query.Has("release"):
+		if pair.session != query.Get("release") {
+			fmt.Fprint(w, "false")
+			return
+		}
+		pair.session = ""
+	}
+
+	pair.value = value
+	f.kv[key] = pair
+	f.bump()
+	fmt.Fprint(w, "true")
+}
+
+// get serves a single key, the keys under a prefix (?keys) or the pairs under a prefix (?recurse)
+func (f *fakeConsul) get(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	prefix := query.Has("keys") || query.Has("recurse")
+
+	var keys []string
+	for stored := range f.kv {
+		if stored == key || (prefix && strings.HasPrefix(stored, key)) {
+			keys = append(keys, stored)
+		}
+	}
+	slices.Sort(keys)
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if query.Has("keys") {
+		_ = json.NewEncoder(w).Encode(keys)
+		return
+	}
+
+	pairs := make([]map[string]interface{}, 0, len(keys))
+	for _, stored := range keys {
+		pairs = append(pairs, map[string]interface{}{
+			"Key":         stored,
+			"Value":       f.kv[stored].value, // Encoded as base64 like Consul does
+			"Session":     f.kv[stored].session,
+			"CreateIndex": f.index,
+			"ModifyIndex": f.index,
+		})
+	}
+	_ = json.NewEncoder(w).Encode(pairs)
+}
+
+// serveSession creates, renews and destroys sessions
+func (f *fakeConsul) serveSession(w http.ResponseWriter, r *http.Request, action string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case action == "create":
+		var entry struct{ Behavior string }
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.nextID++
+		id := fmt.Sprintf("session-%d", f.nextID)
+		f.sessions[id] = entry.Behavior
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(action, "renew/"):
+		id := strings.TrimPrefix(action, "renew/")
+		if _, ok := f.sessions[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"ID": id, "TTL": "15s"}})
+	case strings.HasPrefix(action, "destroy/"):
+		f.destroySession(strings.TrimPrefix(action, "destroy/"))
+		fmt.Fprint(w, "true")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// destroySession drops a session and the locks it holds, deleting the keys of a session with the delete behavior
+func (f *fakeConsul) destroySession(id string) {
+	behavior, ok := f.sessions[id]
+	if !ok {
+		return
+	}
+	delete(f.sessions, id)
+
+	for key, pair := range f.kv {
+		switch {
+		case pair.session != id:
+			continue
+		case behavior == "delete":
+			delete(f.kv, key)
+		default:
+			pair.session = ""
+			f.kv[key] = pair
+		}
+	}
+	f.bump()
+}